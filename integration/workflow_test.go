@@ -146,7 +146,7 @@ func TestWorkflow(t *testing.T) {
 		// TODO: assert on output
 	})
 
-	t.Run("container can execute commands with non-zero exit", func(t *testing.T) {
+	t.Run("container exit code becomes the process exit code", func(t *testing.T) {
 		identifiers := setup(t)
 
 		cmd := exec.Command(settings.Path, //nolint:gosec // G204: Test with controlled input
@@ -159,9 +159,58 @@ func TestWorkflow(t *testing.T) {
 			"ANTHROPIC_API_KEY=",
 		)
 		output, err := cmd.CombinedOutput()
-		require.NoError(t, err, string(output))
+		require.ErrorContains(t, err, "exit status 42", string(output))
+		require.Contains(t, string(output), "Container exited with status: 42")
+	})
 
-		// TODO: assert on output
+	t.Run("with --auto-push", func(t *testing.T) {
+		t.Run("fetches commits made in the container onto the host branch", func(t *testing.T) {
+			identifiers := setup(t)
+
+			cmd := exec.Command(settings.Path, //nolint:gosec // G204: Test with controlled input
+				"--dockerfile", identifiers.Dockerfile,
+				"--auto-push",
+				"bash", "-c", "cd /app && git commit --allow-empty -m 'from container'")
+			cmd.Dir = identifiers.RepositoryPath
+			cmd.Env = append(cleanEnv(t),
+				"TERM=xterm-256color",
+				"COLORTERM=truecolor",
+				"ANTHROPIC_API_KEY=",
+			)
+			output, err := cmd.CombinedOutput()
+			require.NoError(t, err, string(output))
+			require.Contains(t, string(output), "Fetched branch contagent/")
+
+			cmd = exec.Command("git", "log", "--format=%s", "--branches=contagent/*")
+			cmd.Dir = identifiers.RepositoryPath
+			output, err = cmd.CombinedOutput()
+			require.NoError(t, err, string(output))
+			require.Contains(t, string(output), "from container")
+		})
+
+		t.Run("reports when no commits were made", func(t *testing.T) {
+			identifiers := setup(t)
+
+			cmd := exec.Command(settings.Path, //nolint:gosec // G204: Test with controlled input
+				"--dockerfile", identifiers.Dockerfile,
+				"--auto-push",
+				"bash", "-c", "true")
+			cmd.Dir = identifiers.RepositoryPath
+			cmd.Env = append(cleanEnv(t),
+				"TERM=xterm-256color",
+				"COLORTERM=truecolor",
+				"ANTHROPIC_API_KEY=",
+			)
+			output, err := cmd.CombinedOutput()
+			require.NoError(t, err, string(output))
+			require.Contains(t, string(output), "No new commits on branch contagent/")
+
+			cmd = exec.Command("git", "branch", "--list", "contagent/*")
+			cmd.Dir = identifiers.RepositoryPath
+			output, err = cmd.CombinedOutput()
+			require.NoError(t, err, string(output))
+			require.Empty(t, string(output))
+		})
 	})
 
 	t.Run("with volumes", func(t *testing.T) {
@@ -250,7 +299,7 @@ func TestWorkflow(t *testing.T) {
 			"ANTHROPIC_API_KEY=",
 		)
 		output, err := cmd.CombinedOutput()
-		require.NoError(t, err, string(output))
+		require.ErrorContains(t, err, "exit status 42", string(output))
 
 		// Give cleanup a moment to complete
 		time.Sleep(500 * time.Millisecond)
@@ -268,6 +317,24 @@ func TestWorkflow(t *testing.T) {
 		}
 	})
 
+	t.Run("builds the default image when no dockerfile is specified", func(t *testing.T) {
+		identifiers := setup(t)
+
+		cmd := exec.Command(settings.Path, //nolint:gosec // G204: Test with controlled input
+			"bash", "-c", "echo integration_test",
+		)
+		cmd.Dir = identifiers.RepositoryPath
+		cmd.Env = append(cleanEnv(t),
+			"TERM=xterm-256color",
+			"COLORTERM=truecolor",
+			"ANTHROPIC_API_KEY=",
+		)
+		output, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(output))
+		require.Contains(t, string(output), "No Dockerfile configured, building the default image")
+		require.Contains(t, string(output), "integration_test")
+	})
+
 	t.Run("failure cases", func(t *testing.T) {
 		t.Run("when not in a git repository", func(t *testing.T) {
 			identifiers := setup(t)
@@ -294,24 +361,5 @@ func TestWorkflow(t *testing.T) {
 			require.ErrorContains(t, err, "exit status 1")
 			require.Contains(t, string(output), "not a git repository")
 		})
-
-		t.Run("when dockerfile is not specified", func(t *testing.T) {
-			identifiers := setup(t)
-
-			cmd := exec.Command(settings.Path, //nolint:gosec // G204: Test with controlled input
-				"bash", "-c", "echo test",
-			)
-			cmd.Dir = identifiers.RepositoryPath
-			cmd.Env = append(cleanEnv(t),
-				"TERM=xterm-256color",
-				"COLORTERM=truecolor",
-				"ANTHROPIC_API_KEY=",
-			)
-			output, err := cmd.CombinedOutput()
-			require.ErrorContains(t, err, "exit status 1")
-			require.Contains(t, string(output), "dockerfile path is required")
-			require.Contains(t, string(output), "--dockerfile")
-			require.Contains(t, string(output), ".contagent.yaml")
-		})
 	})
 }
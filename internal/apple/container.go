@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path"
 	"strconv"
 	"strings"
 	"time"
@@ -28,7 +29,9 @@ type Container struct {
 	cmd            []string
 	env            []string
 	workingDir     string
+	tty            bool
 	stopTimeout    int
+	timeout        time.Duration
 	runner         CommandRunner
 	started        bool
 	process        Process
@@ -119,6 +122,29 @@ func (c *Container) CopyTo(ctx context.Context, content io.Reader, path string)
 	return nil
 }
 
+// CopyFrom copies the file or directory at srcPath out of the container via
+// `container exec tar`. The content is streamed back as a tar archive, rooted at the
+// final path component of srcPath, which the caller must close. The container keeps
+// running `sleep infinity` after the command exits, so this works until it is removed.
+// Errors from the exec are returned when reading the archive.
+func (c *Container) CopyFrom(ctx context.Context, srcPath string) (io.ReadCloser, error) {
+	pr, pw := io.Pipe()
+
+	go func() {
+		err := c.runner.Run(ctx, nil, pw, os.Stderr,
+			"container", "exec", c.name,
+			"tar", "cf", "-", "-C", path.Dir(srcPath), path.Base(srcPath),
+		)
+		if err != nil {
+			pw.CloseWithError(fmt.Errorf("failed to copy content from container %q at path %q: %w", c.name, srcPath, err))
+			return
+		}
+		pw.Close()
+	}()
+
+	return pr, nil
+}
+
 // Start is a no-op for Apple Container — the container was already started in CopyTo.
 func (c *Container) Start(ctx context.Context) error {
 	return nil
@@ -126,8 +152,14 @@ func (c *Container) Start(ctx context.Context) error {
 
 // Attach runs the actual user command inside the container using
 // `container exec --tty --interactive`. Apple Container handles TTY natively.
-func (c *Container) Attach(ctx context.Context, cancel context.CancelFunc, w internal.Writer) error {
-	args := []string{"exec", "--tty", "--interactive"}
+// The --tty flag is omitted when the container was created without a TTY. The exec
+// process writes to stdout directly, so the returned Attachment has nothing to wait for.
+func (c *Container) Attach(ctx context.Context, cancel context.CancelFunc, w internal.Writer) (runtime.Attachment, error) {
+	args := []string{"exec"}
+	if c.tty {
+		args = append(args, "--tty")
+	}
+	args = append(args, "--interactive")
 
 	if c.workingDir != "" {
 		args = append(args, "--workdir", c.workingDir)
@@ -156,19 +188,22 @@ func (c *Container) Attach(ctx context.Context, cancel context.CancelFunc, w int
 
 	proc, err := c.runner.Start(ctx, os.Stdin, os.Stdout, os.Stderr, "container", args...)
 	if err != nil {
-		return fmt.Errorf("failed to exec in container %q: %w", c.name, err)
+		return runtime.Attachment{}, fmt.Errorf("failed to exec in container %q: %w", c.name, err)
 	}
 	c.process = proc
 
-	return nil
+	return runtime.Attachment{}, nil
 }
 
-// Wait waits for the exec process (started in Attach) to exit.
+// Wait waits for the exec process (started in Attach) to exit and returns its exit code.
 // It handles context cancellation (e.g. from SIGINT/SIGTERM) by stopping the
-// container gracefully before waiting for the exec process to exit.
-func (c *Container) Wait(ctx context.Context, w internal.Writer) error {
+// container gracefully before waiting for the exec process to exit, in which case
+// runtime.ExitCodeInterrupted is returned. If the process is still running after the
+// configured timeout, the container is stopped the same way and an error wrapping
+// runtime.ErrTimeout is returned.
+func (c *Container) Wait(ctx context.Context, w internal.Writer) (int, error) {
 	if c.process == nil {
-		return nil
+		return 0, nil
 	}
 
 	type result struct {
@@ -182,26 +217,40 @@ func (c *Container) Wait(ctx context.Context, w internal.Writer) error {
 		done <- result{code, err}
 	}()
 
+	var timeout <-chan time.Time
+	if c.timeout > 0 {
+		timeout = time.After(c.timeout)
+	}
+
 	select {
 	case r := <-done:
 		if r.err != nil {
-			return fmt.Errorf("process error in container %q: %w", c.name, r.err)
+			return 0, fmt.Errorf("process error in container %q: %w", c.name, r.err)
 		}
 		w.Printf("\nContainer exited with status: %d\n", r.exitCode)
+		return r.exitCode, nil
 	case <-ctx.Done():
 		w.Println("\nReceived signal, stopping container...")
-		stopCtx := context.Background()
-		if err := c.runner.Run(stopCtx, nil, nil, nil,
-			"container", "stop",
-			"--time", strconv.Itoa(c.stopTimeout),
-			c.name,
-		); err != nil {
-			w.Warningf("failed to stop container: %v", err)
-		}
+		c.stop(w)
+		<-done
+		return runtime.ExitCodeInterrupted, nil
+	case <-timeout:
+		w.Printf("\nContainer did not exit within %s, stopping container...\n", c.timeout)
+		c.stop(w)
 		<-done
+		return 0, fmt.Errorf("container %q did not exit within %s: %w", c.name, c.timeout, runtime.ErrTimeout)
 	}
+}
 
-	return nil
+// stop stops the container, giving it stopTimeout seconds to exit before it is killed.
+func (c *Container) stop(w internal.Writer) {
+	if err := c.runner.Run(context.Background(), nil, nil, nil,
+		"container", "stop",
+		"--time", strconv.Itoa(c.stopTimeout),
+		c.name,
+	); err != nil {
+		w.Warningf("failed to stop container: %v", err)
+	}
 }
 
 // waitForRunning polls the container until it is ready to accept exec commands.
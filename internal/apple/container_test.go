@@ -10,6 +10,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/ryanmoran/contagent/internal"
 	"github.com/ryanmoran/contagent/internal/apple"
 	"github.com/ryanmoran/contagent/internal/runtime"
 	"github.com/stretchr/testify/require"
@@ -24,6 +25,7 @@ func createTestContainer(t *testing.T, runner *mockRunner) runtime.Container {
 		Args:        []string{"echo", "hello"},
 		Env:         []string{"FOO=bar"},
 		WorkingDir:  "/app",
+		TTY:         true,
 		StopTimeout: 10,
 	})
 	require.NoError(t, err)
@@ -197,6 +199,44 @@ func TestContainerCopyTo(t *testing.T) {
 	})
 }
 
+func TestContainerCopyFrom(t *testing.T) {
+	t.Run("streams tar output from exec", func(t *testing.T) {
+		runner := &mockRunner{}
+		container := createTestContainer(t, runner)
+		runner.runFunc = func(ctx context.Context, stdin io.Reader, stdout, stderr io.Writer, name string, args ...string) error {
+			_, err := stdout.Write([]byte("fake tar data"))
+			return err
+		}
+
+		content, err := container.CopyFrom(context.Background(), "/app/.git")
+		require.NoError(t, err)
+		defer content.Close()
+
+		data, err := io.ReadAll(content)
+		require.NoError(t, err)
+		require.Equal(t, "fake tar data", string(data))
+
+		require.Len(t, runner.calls, 1)
+		require.Equal(t, "container", runner.calls[0].Name)
+		require.Equal(t, []string{"exec", "test-session", "tar", "cf", "-", "-C", "/app", ".git"}, runner.calls[0].Args)
+	})
+
+	t.Run("returns error from reading on exec tar failure", func(t *testing.T) {
+		runner := &mockRunner{}
+		container := createTestContainer(t, runner)
+		runner.runFunc = func(ctx context.Context, stdin io.Reader, stdout, stderr io.Writer, name string, args ...string) error {
+			return errors.New("tar failed")
+		}
+
+		content, err := container.CopyFrom(context.Background(), "/app/.git")
+		require.NoError(t, err)
+		defer content.Close()
+
+		_, err = io.ReadAll(content)
+		require.ErrorContains(t, err, `failed to copy content from container "test-session" at path "/app/.git"`)
+	})
+}
+
 func TestContainerStart(t *testing.T) {
 	t.Run("is a no-op", func(t *testing.T) {
 		runner := &mockRunner{}
@@ -212,9 +252,9 @@ func TestContainerAttach(t *testing.T) {
 	t.Run("starts exec with correct args", func(t *testing.T) {
 		runner := &mockRunner{}
 		container := createTestContainer(t, runner)
-		w := &mockWriter{}
+		w := internal.NewDiscardWriter()
 
-		err := container.Attach(context.Background(), func() {}, w)
+		_, err := container.Attach(context.Background(), func() {}, w)
 		require.NoError(t, err)
 
 		require.Len(t, runner.calls, 1)
@@ -239,6 +279,26 @@ func TestContainerAttach(t *testing.T) {
 		require.Contains(t, argsStr, "exec 'echo' 'hello'")
 	})
 
+	t.Run("omits --tty when created without a TTY", func(t *testing.T) {
+		runner := &mockRunner{}
+		rt := apple.NewRuntimeWithRunner(runner)
+		container, err := rt.CreateContainer(context.Background(), runtime.CreateContainerOptions{
+			SessionID:   "test-session",
+			Image:       runtime.Image{Name: "myimage:latest"},
+			Args:        []string{"echo", "hello"},
+			StopTimeout: 10,
+		})
+		require.NoError(t, err)
+		runner.calls = nil
+
+		_, err = container.Attach(context.Background(), func() {}, internal.NewDiscardWriter())
+		require.NoError(t, err)
+
+		require.Len(t, runner.calls, 1)
+		require.NotContains(t, runner.calls[0].Args, "--tty")
+		require.Contains(t, runner.calls[0].Args, "--interactive")
+	})
+
 	t.Run("returns error on exec failure", func(t *testing.T) {
 		runner := &mockRunner{
 			startFunc: func(ctx context.Context, stdin io.Reader, stdout, stderr io.Writer, name string, args ...string) (apple.Process, error) {
@@ -246,9 +306,9 @@ func TestContainerAttach(t *testing.T) {
 			},
 		}
 		container := createTestContainer(t, runner)
-		w := &mockWriter{}
+		w := internal.NewDiscardWriter()
 
-		err := container.Attach(context.Background(), func() {}, w)
+		_, err := container.Attach(context.Background(), func() {}, w)
 		require.Error(t, err)
 		require.Contains(t, err.Error(), "failed to exec in container")
 	})
@@ -262,15 +322,16 @@ func TestContainerWait(t *testing.T) {
 			},
 		}
 		container := createTestContainer(t, runner)
-		w := &mockWriter{}
+		w := internal.NewDiscardWriter()
 
-		err := container.Attach(context.Background(), func() {}, w)
+		_, err := container.Attach(context.Background(), func() {}, w)
 		require.NoError(t, err)
 
 		// Use a writer that captures output
 		outWriter := &capturingWriter{}
-		err = container.Wait(context.Background(), outWriter)
+		code, err := container.Wait(context.Background(), outWriter)
 		require.NoError(t, err)
+		require.Equal(t, 0, code)
 		require.Contains(t, outWriter.output, "Container exited with status: 0")
 	})
 
@@ -281,14 +342,15 @@ func TestContainerWait(t *testing.T) {
 			},
 		}
 		container := createTestContainer(t, runner)
-		w := &mockWriter{}
+		w := internal.NewDiscardWriter()
 
-		err := container.Attach(context.Background(), func() {}, w)
+		_, err := container.Attach(context.Background(), func() {}, w)
 		require.NoError(t, err)
 
 		outWriter := &capturingWriter{}
-		err = container.Wait(context.Background(), outWriter)
+		code, err := container.Wait(context.Background(), outWriter)
 		require.NoError(t, err)
+		require.Equal(t, 42, code)
 		require.Contains(t, outWriter.output, "Container exited with status: 42")
 	})
 
@@ -297,8 +359,9 @@ func TestContainerWait(t *testing.T) {
 		container := createTestContainer(t, runner)
 		outWriter := &capturingWriter{}
 
-		err := container.Wait(context.Background(), outWriter)
+		code, err := container.Wait(context.Background(), outWriter)
 		require.NoError(t, err)
+		require.Equal(t, 0, code)
 	})
 
 	t.Run("returns error on process error", func(t *testing.T) {
@@ -308,16 +371,54 @@ func TestContainerWait(t *testing.T) {
 			},
 		}
 		container := createTestContainer(t, runner)
-		w := &mockWriter{}
+		w := internal.NewDiscardWriter()
 
-		err := container.Attach(context.Background(), func() {}, w)
+		_, err := container.Attach(context.Background(), func() {}, w)
 		require.NoError(t, err)
 
 		outWriter := &capturingWriter{}
-		err = container.Wait(context.Background(), outWriter)
+		_, err = container.Wait(context.Background(), outWriter)
 		require.Error(t, err)
 		require.Contains(t, err.Error(), "process error in container")
 	})
+
+	t.Run("stops container and returns a timeout error when the timeout elapses", func(t *testing.T) {
+		process := &mockProcess{exitCode: 143, waitCh: make(chan struct{})}
+		runner := &mockRunner{
+			startFunc: func(ctx context.Context, stdin io.Reader, stdout, stderr io.Writer, name string, args ...string) (apple.Process, error) {
+				return process, nil
+			},
+		}
+		rt := apple.NewRuntimeWithRunner(runner)
+		container, err := rt.CreateContainer(context.Background(), runtime.CreateContainerOptions{
+			SessionID:   "test-session",
+			Image:       runtime.Image{Name: "myimage:latest"},
+			Args:        []string{"sleep", "infinity"},
+			StopTimeout: 10,
+			Timeout:     10 * time.Millisecond,
+		})
+		require.NoError(t, err)
+
+		runner.runFunc = func(ctx context.Context, stdin io.Reader, stdout, stderr io.Writer, name string, args ...string) error {
+			// Stopping the container ends the exec process
+			if args[0] == "stop" {
+				close(process.waitCh)
+			}
+			return nil
+		}
+
+		_, err = container.Attach(context.Background(), func() {}, internal.NewDiscardWriter())
+		require.NoError(t, err)
+
+		outWriter := &capturingWriter{}
+		_, err = container.Wait(context.Background(), outWriter)
+		require.ErrorIs(t, err, runtime.ErrTimeout)
+		require.ErrorContains(t, err, `container "test-session" did not exit within 10ms`)
+		require.Contains(t, outWriter.output, "Container did not exit within 10ms, stopping container...")
+
+		last := runner.calls[len(runner.calls)-1]
+		require.Equal(t, []string{"stop", "--time", "10", "test-session"}, last.Args)
+	})
 }
 
 func TestContainerForceRemove(t *testing.T) {
@@ -364,8 +465,10 @@ func (w *capturingWriter) Print(v ...interface{}) {}
 func (w *capturingWriter) Printf(format string, v ...interface{}) {
 	w.output += fmt.Sprintf(format, v...)
 }
-func (w *capturingWriter) Println(v ...interface{}) {}
-func (w *capturingWriter) Warning(v ...interface{}) {}
+func (w *capturingWriter) Println(v ...interface{})               {}
+func (w *capturingWriter) Debug(v ...interface{})                 {}
+func (w *capturingWriter) Debugf(format string, v ...interface{}) {}
+func (w *capturingWriter) Warning(v ...interface{})               {}
 func (w *capturingWriter) Warningf(format string, v ...interface{}) {
 }
 func (w *capturingWriter) Fatal(v ...interface{})                 {}
@@ -3,7 +3,11 @@ package apple
 import (
 	"context"
 	"fmt"
+	"math"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
 
 	"github.com/ryanmoran/contagent/internal"
 	"github.com/ryanmoran/contagent/internal/runtime"
@@ -28,18 +32,58 @@ func NewRuntimeWithRunner(runner CommandRunner) *Runtime {
 	return &Runtime{runner: runner}
 }
 
-// BuildImage builds a container image using `container build`.
-func (r *Runtime) BuildImage(ctx context.Context, dockerfilePath string, imageName internal.ImageName, w internal.Writer) (runtime.Image, error) {
-	err := r.runner.Run(ctx, nil, w.GetWriter(), os.Stderr,
-		"container", "build",
-		"--tag", string(imageName),
-		"--file", dockerfilePath,
-		".",
-	)
+// BuildImage builds a container image using `container build`. The build context
+// defaults to the directory containing the Dockerfile. Base images are pulled by the
+// builder when missing; other pull policies are not supported and produce a warning.
+// The builder runs no intermediate containers, so KeepIntermediate has no effect.
+func (r *Runtime) BuildImage(ctx context.Context, opts runtime.BuildImageOptions, w internal.Writer) (runtime.Image, error) {
+	if opts.PullPolicy != "" && opts.PullPolicy != internal.PullPolicyMissing {
+		w.Warningf("pull policy %q is not supported by the apple runtime, base images are pulled when missing", opts.PullPolicy)
+	}
+
+	args := []string{"build", "--tag", string(opts.ImageName), "--file", opts.DockerfilePath}
+
+	keys := make([]string, 0, len(opts.BuildArgs))
+	for key := range opts.BuildArgs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		args = append(args, "--build-arg", key+"="+opts.BuildArgs[key])
+	}
+
+	keys = keys[:0]
+	for key := range opts.Labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		args = append(args, "--label", key+"="+opts.Labels[key])
+	}
+
+	if opts.Target != "" {
+		args = append(args, "--target", opts.Target)
+	}
+
+	if opts.Platform != "" {
+		args = append(args, "--platform", opts.Platform)
+	}
+
+	if opts.NoCache {
+		args = append(args, "--no-cache")
+	}
+
+	contextDir := opts.ContextDir
+	if contextDir == "" {
+		contextDir = filepath.Dir(opts.DockerfilePath)
+	}
+	args = append(args, contextDir)
+
+	err := r.runner.Run(ctx, nil, w.GetWriter(), os.Stderr, "container", args...)
 	if err != nil {
-		return runtime.Image{}, fmt.Errorf("failed to build image %q: %w", imageName, err)
+		return runtime.Image{}, fmt.Errorf("failed to build image %q: %w", opts.ImageName, err)
 	}
-	return runtime.Image{Name: string(imageName)}, nil
+	return runtime.Image{Name: string(opts.ImageName)}, nil
 }
 
 // CreateContainer creates a container using `container create` with `sleep infinity`
@@ -55,10 +99,42 @@ func (r *Runtime) CreateContainer(ctx context.Context, opts runtime.CreateContai
 		args = append(args, "--volume", vol)
 	}
 
+	// The session label always carries the session ID
+	labels := make(map[string]string, len(opts.Labels)+1)
+	for key, value := range opts.Labels {
+		labels[key] = value
+	}
+	labels[internal.SessionLabel] = string(opts.SessionID)
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		args = append(args, "--label", key+"="+labels[key])
+	}
+
+	for _, port := range opts.Ports {
+		args = append(args, "--publish", port.String())
+	}
+
 	if opts.WorkingDir != "" {
 		args = append(args, "--workdir", opts.WorkingDir)
 	}
 
+	if opts.Platform != "" {
+		args = append(args, "--platform", opts.Platform)
+	}
+
+	if opts.Memory > 0 {
+		args = append(args, "--memory", strconv.FormatInt(opts.Memory, 10))
+	}
+
+	// Apple Container only allocates whole CPUs, so fractional limits round up.
+	if opts.CPUs > 0 {
+		args = append(args, "--cpus", strconv.Itoa(int(math.Ceil(opts.CPUs))))
+	}
+
 	args = append(args, opts.Image.Name, "sleep", "infinity")
 
 	err := r.runner.Run(ctx, nil, os.Stdout, os.Stderr, "container", args...)
@@ -71,14 +147,19 @@ func (r *Runtime) CreateContainer(ctx context.Context, opts runtime.CreateContai
 		cmd:         []string(opts.Args),
 		env:         []string(opts.Env),
 		workingDir:  opts.WorkingDir,
+		tty:         opts.TTY,
 		stopTimeout: opts.StopTimeout,
+		timeout:     opts.Timeout,
 		runner:      r.runner,
 	}, nil
 }
 
+// HostAlias is the hostname that Apple containers use to reach the host.
+const HostAlias = "host.container.internal"
+
 // HostAddress returns the hostname that Apple containers use to reach the host.
 func (r *Runtime) HostAddress() string {
-	return "host.container.internal"
+	return HostAlias
 }
 
 // Close is a no-op for Apple Container runtime (no persistent client).
@@ -1,12 +1,12 @@
 package apple_test
 
 import (
-	"bytes"
 	"context"
 	"errors"
 	"io"
 	"testing"
 
+	"github.com/ryanmoran/contagent/internal"
 	"github.com/ryanmoran/contagent/internal/apple"
 	"github.com/ryanmoran/contagent/internal/runtime"
 	"github.com/stretchr/testify/require"
@@ -54,29 +54,13 @@ func (p *mockProcess) Wait() (int, error) {
 	return p.exitCode, p.err
 }
 
-// mockWriter implements internal.Writer for testing.
-type mockWriter struct {
-	buf bytes.Buffer
-}
-
-func (w *mockWriter) Print(v ...interface{})                 { /* no-op */ }
-func (w *mockWriter) Printf(format string, v ...interface{}) { /* no-op */ }
-func (w *mockWriter) Println(v ...interface{})               { /* no-op */ }
-func (w *mockWriter) Warning(v ...interface{})               { /* no-op */ }
-func (w *mockWriter) Warningf(format string, v ...interface{}) {
-	/* no-op */
-}
-func (w *mockWriter) Fatal(v ...interface{})                 { /* no-op */ }
-func (w *mockWriter) Fatalf(format string, v ...interface{}) { /* no-op */ }
-func (w *mockWriter) GetWriter() io.Writer                   { return &w.buf }
-
 func TestRuntimeBuildImage(t *testing.T) {
 	t.Run("builds image successfully", func(t *testing.T) {
 		runner := &mockRunner{}
 		rt := apple.NewRuntimeWithRunner(runner)
-		w := &mockWriter{}
+		w := internal.NewDiscardWriter()
 
-		image, err := rt.BuildImage(context.Background(), "./Dockerfile", "myimage:latest", w)
+		image, err := rt.BuildImage(context.Background(), runtime.BuildImageOptions{DockerfilePath: "./Dockerfile", ImageName: "myimage:latest"}, w)
 		require.NoError(t, err)
 		require.Equal(t, "myimage:latest", image.Name)
 		require.Len(t, runner.calls, 1)
@@ -88,6 +72,108 @@ func TestRuntimeBuildImage(t *testing.T) {
 		require.Contains(t, runner.calls[0].Args, "./Dockerfile")
 	})
 
+	t.Run("passes build args in sorted order", func(t *testing.T) {
+		runner := &mockRunner{}
+		rt := apple.NewRuntimeWithRunner(runner)
+		w := internal.NewDiscardWriter()
+
+		_, err := rt.BuildImage(context.Background(), runtime.BuildImageOptions{
+			DockerfilePath: "./Dockerfile",
+			ImageName:      "myimage:latest",
+			BuildArgs: map[string]string{
+				"ZETA":         "last",
+				"BASE_VERSION": "1.0",
+			},
+		}, w)
+		require.NoError(t, err)
+		require.Len(t, runner.calls, 1)
+		require.Equal(t, []string{
+			"build",
+			"--tag", "myimage:latest",
+			"--file", "./Dockerfile",
+			"--build-arg", "BASE_VERSION=1.0",
+			"--build-arg", "ZETA=last",
+			".",
+		}, runner.calls[0].Args)
+	})
+
+	t.Run("passes the platform", func(t *testing.T) {
+		runner := &mockRunner{}
+		rt := apple.NewRuntimeWithRunner(runner)
+
+		_, err := rt.BuildImage(context.Background(), runtime.BuildImageOptions{
+			DockerfilePath: "/path/to/Dockerfile",
+			ImageName:      "myimage:latest",
+			Platform:       "linux/amd64",
+		}, internal.NewDiscardWriter())
+		require.NoError(t, err)
+
+		require.Len(t, runner.calls, 1)
+		require.Equal(t, []string{
+			"build", "--tag", "myimage:latest", "--file", "/path/to/Dockerfile",
+			"--platform", "linux/amd64",
+			"/path/to",
+		}, runner.calls[0].Args)
+	})
+
+	t.Run("passes the target", func(t *testing.T) {
+		runner := &mockRunner{}
+		rt := apple.NewRuntimeWithRunner(runner)
+
+		_, err := rt.BuildImage(context.Background(), runtime.BuildImageOptions{
+			DockerfilePath: "/path/to/Dockerfile",
+			ImageName:      "myimage:latest",
+			Target:         "dev",
+		}, internal.NewDiscardWriter())
+		require.NoError(t, err)
+
+		require.Len(t, runner.calls, 1)
+		require.Equal(t, []string{
+			"build", "--tag", "myimage:latest", "--file", "/path/to/Dockerfile",
+			"--target", "dev",
+			"/path/to",
+		}, runner.calls[0].Args)
+	})
+
+	t.Run("passes the labels in sorted order", func(t *testing.T) {
+		runner := &mockRunner{}
+		rt := apple.NewRuntimeWithRunner(runner)
+
+		_, err := rt.BuildImage(context.Background(), runtime.BuildImageOptions{
+			DockerfilePath: "/path/to/Dockerfile",
+			ImageName:      "myimage:latest",
+			Labels:         map[string]string{"team": "platform", "contagent.session": "contagent-test"},
+		}, internal.NewDiscardWriter())
+		require.NoError(t, err)
+
+		require.Len(t, runner.calls, 1)
+		require.Equal(t, []string{
+			"build", "--tag", "myimage:latest", "--file", "/path/to/Dockerfile",
+			"--label", "contagent.session=contagent-test",
+			"--label", "team=platform",
+			"/path/to",
+		}, runner.calls[0].Args)
+	})
+
+	t.Run("builds without the layer cache", func(t *testing.T) {
+		runner := &mockRunner{}
+		rt := apple.NewRuntimeWithRunner(runner)
+
+		_, err := rt.BuildImage(context.Background(), runtime.BuildImageOptions{
+			DockerfilePath: "/path/to/Dockerfile",
+			ImageName:      "myimage:latest",
+			NoCache:        true,
+		}, internal.NewDiscardWriter())
+		require.NoError(t, err)
+
+		require.Len(t, runner.calls, 1)
+		require.Equal(t, []string{
+			"build", "--tag", "myimage:latest", "--file", "/path/to/Dockerfile",
+			"--no-cache",
+			"/path/to",
+		}, runner.calls[0].Args)
+	})
+
 	t.Run("returns error on build failure", func(t *testing.T) {
 		runner := &mockRunner{
 			runFunc: func(ctx context.Context, stdin io.Reader, stdout, stderr io.Writer, name string, args ...string) error {
@@ -95,9 +181,9 @@ func TestRuntimeBuildImage(t *testing.T) {
 			},
 		}
 		rt := apple.NewRuntimeWithRunner(runner)
-		w := &mockWriter{}
+		w := internal.NewDiscardWriter()
 
-		_, err := rt.BuildImage(context.Background(), "./Dockerfile", "myimage:latest", w)
+		_, err := rt.BuildImage(context.Background(), runtime.BuildImageOptions{DockerfilePath: "./Dockerfile", ImageName: "myimage:latest"}, w)
 		require.Error(t, err)
 		require.Contains(t, err.Error(), "failed to build image")
 	})
@@ -139,6 +225,97 @@ func TestRuntimeCreateContainer(t *testing.T) {
 		require.Contains(t, call.Args, "infinity")
 	})
 
+	t.Run("publishes ports", func(t *testing.T) {
+		runner := &mockRunner{}
+		rt := apple.NewRuntimeWithRunner(runner)
+
+		_, err := rt.CreateContainer(context.Background(), runtime.CreateContainerOptions{
+			SessionID: "test-session",
+			Image:     runtime.Image{Name: "myimage:latest"},
+			Args:      []string{"echo"},
+			Ports: []internal.PortMapping{
+				{HostPort: 8080, ContainerPort: 3000, Protocol: "tcp"},
+				{HostPort: 5353, ContainerPort: 53, Protocol: "udp"},
+			},
+		})
+		require.NoError(t, err)
+
+		require.Len(t, runner.calls, 1)
+		require.Equal(t, []string{
+			"create", "--name", "test-session", "--ssh",
+			"--label", "contagent.session=test-session",
+			"--publish", "8080:3000/tcp",
+			"--publish", "5353:53/udp",
+			"myimage:latest", "sleep", "infinity",
+		}, runner.calls[0].Args)
+	})
+
+	t.Run("passes labels in sorted order with the session label", func(t *testing.T) {
+		runner := &mockRunner{}
+		rt := apple.NewRuntimeWithRunner(runner)
+
+		_, err := rt.CreateContainer(context.Background(), runtime.CreateContainerOptions{
+			SessionID: "test-session",
+			Image:     runtime.Image{Name: "myimage:latest"},
+			Args:      []string{"echo"},
+			Labels:    map[string]string{"team": "platform", "owner": "alice"},
+		})
+		require.NoError(t, err)
+
+		require.Len(t, runner.calls, 1)
+		require.Equal(t, []string{
+			"create", "--name", "test-session", "--ssh",
+			"--label", "contagent.session=test-session",
+			"--label", "owner=alice",
+			"--label", "team=platform",
+			"myimage:latest", "sleep", "infinity",
+		}, runner.calls[0].Args)
+	})
+
+	t.Run("passes the platform", func(t *testing.T) {
+		runner := &mockRunner{}
+		rt := apple.NewRuntimeWithRunner(runner)
+
+		_, err := rt.CreateContainer(context.Background(), runtime.CreateContainerOptions{
+			SessionID: "test-session",
+			Image:     runtime.Image{Name: "myimage:latest"},
+			Args:      []string{"echo"},
+			Platform:  "linux/amd64",
+		})
+		require.NoError(t, err)
+
+		require.Len(t, runner.calls, 1)
+		require.Equal(t, []string{
+			"create", "--name", "test-session", "--ssh",
+			"--label", "contagent.session=test-session",
+			"--platform", "linux/amd64",
+			"myimage:latest", "sleep", "infinity",
+		}, runner.calls[0].Args)
+	})
+
+	t.Run("passes resource limits", func(t *testing.T) {
+		runner := &mockRunner{}
+		rt := apple.NewRuntimeWithRunner(runner)
+
+		_, err := rt.CreateContainer(context.Background(), runtime.CreateContainerOptions{
+			SessionID: "test-session",
+			Image:     runtime.Image{Name: "myimage:latest"},
+			Args:      []string{"echo"},
+			Memory:    512 * 1024 * 1024,
+			CPUs:      1.5,
+		})
+		require.NoError(t, err)
+
+		require.Len(t, runner.calls, 1)
+		require.Equal(t, []string{
+			"create", "--name", "test-session", "--ssh",
+			"--label", "contagent.session=test-session",
+			"--memory", "536870912",
+			"--cpus", "2",
+			"myimage:latest", "sleep", "infinity",
+		}, runner.calls[0].Args)
+	})
+
 	t.Run("returns error on create failure", func(t *testing.T) {
 		runner := &mockRunner{
 			runFunc: func(ctx context.Context, stdin io.Reader, stdout, stderr io.Writer, name string, args ...string) error {
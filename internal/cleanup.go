@@ -1,24 +1,47 @@
 package internal
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
+	"slices"
 	"sync"
+	"time"
 )
 
+// DefaultCleanupTimeout bounds how long a single cleanup function may run before
+// it is abandoned. It exceeds the container removal timeout so that a healthy
+// daemon always has time to finish.
+const DefaultCleanupTimeout = 15 * time.Second
+
 // CleanupManager tracks resources and ensures ordered cleanup in LIFO order.
+// Cleanup functions that do not depend on each other can be registered with
+// AddIndependent to run concurrently instead.
 type CleanupManager struct {
-	mu    sync.Mutex
-	funcs []cleanupFunc
+	mu      sync.Mutex
+	funcs   []cleanupFunc
+	timeout time.Duration
 }
 
 type cleanupFunc struct {
-	name string
-	fn   func() error
+	name        string
+	fn          func() error
+	independent bool
 }
 
 // NewCleanupManager creates a new cleanup manager.
 func NewCleanupManager() *CleanupManager {
-	return &CleanupManager{} //nolint:exhaustruct // Zero values are appropriate for mu and funcs
+	return &CleanupManager{ //nolint:exhaustruct // Zero values are appropriate for mu and funcs
+		timeout: DefaultCleanupTimeout,
+	}
+}
+
+// SetTimeout changes how long each cleanup function may run before it is abandoned.
+func (m *CleanupManager) SetTimeout(timeout time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.timeout = timeout
 }
 
 // Add registers a cleanup function. Functions are executed in LIFO order
@@ -26,18 +49,134 @@ func NewCleanupManager() *CleanupManager {
 func (m *CleanupManager) Add(name string, fn func() error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	m.funcs = append([]cleanupFunc{{name, fn}}, m.funcs...)
+	m.funcs = append([]cleanupFunc{{name, fn, false}}, m.funcs...)
+}
+
+// AddIndependent registers a cleanup function that does not depend on the functions
+// registered next to it with AddIndependent. Such adjacent functions run concurrently
+// as a group that keeps their place in the LIFO order: the group starts once the
+// function executed before it has finished, and the function executed after it waits
+// for the whole group to finish.
+func (m *CleanupManager) AddIndependent(name string, fn func() error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.funcs = append([]cleanupFunc{{name, fn, true}}, m.funcs...)
+}
+
+// Remove unregisters the cleanup functions added under name, keeping the others in
+// LIFO order. Use it once a resource has been cleaned up early, so that its cleanup
+// does not fail on a resource that is already gone.
+func (m *CleanupManager) Remove(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.funcs = slices.DeleteFunc(m.funcs, func(cleanup cleanupFunc) bool {
+		return cleanup.name == name
+	})
+}
+
+// Replace swaps the function of the cleanups added under name for fn, keeping their
+// place in the LIFO order and whether they are independent. When no cleanup was added
+// under name, fn is added as with Add.
+func (m *CleanupManager) Replace(name string, fn func() error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	replaced := false
+	for i := range m.funcs {
+		if m.funcs[i].name == name {
+			m.funcs[i].fn = fn
+			replaced = true
+		}
+	}
+	if !replaced {
+		m.funcs = append([]cleanupFunc{{name, fn, false}}, m.funcs...)
+	}
 }
 
 // Execute runs all cleanup functions in reverse order (LIFO), logging any errors.
 // This method always completes all cleanup operations, even if some fail.
 func (m *CleanupManager) Execute() {
+	_ = m.ExecuteWithContext(context.Background()) //nolint:errcheck // Errors are already logged
+}
+
+// ExecuteWithContext runs all cleanup functions in reverse order (LIFO), with
+// adjacent independent functions running concurrently. Each function runs with a
+// deadline derived from ctx and the manager's timeout; a function that exceeds it is
+// abandoned and recorded as a timeout so that the remaining functions still run.
+// The functions run without the manager locked, so they may register or remove
+// cleanups, which only affect later executions. Errors are logged and returned
+// joined together, in LIFO order.
+func (m *CleanupManager) ExecuteWithContext(ctx context.Context) error {
 	m.mu.Lock()
-	defer m.mu.Unlock()
+	funcs := slices.Clone(m.funcs)
+	timeout := m.timeout
+	m.mu.Unlock()
 
-	for _, cleanup := range m.funcs {
-		if err := cleanup.fn(); err != nil {
-			log.Printf("cleanup failed for %s: %v", cleanup.name, err)
+	var errs []error
+	for i := 0; i < len(funcs); {
+		end := i + 1
+		if funcs[i].independent {
+			for end < len(funcs) && funcs[end].independent {
+				end++
+			}
 		}
+
+		for j, err := range runGroup(ctx, timeout, funcs[i:end]) {
+			if err != nil {
+				cleanup := funcs[i+j]
+				log.Printf("cleanup failed for %s: %v", cleanup.name, err)
+				errs = append(errs, fmt.Errorf("cleanup %s: %w", cleanup.name, err))
+			}
+		}
+		i = end
+	}
+
+	return errors.Join(errs...)
+}
+
+// runGroup runs cleanup functions concurrently and returns their errors in the
+// order of group once all of them have finished or timed out.
+func runGroup(ctx context.Context, timeout time.Duration, group []cleanupFunc) []error {
+	errs := make([]error, len(group))
+	if len(group) == 1 {
+		errs[0] = run(ctx, timeout, group[0])
+		return errs
+	}
+
+	var wg sync.WaitGroup
+	for i, cleanup := range group {
+		wg.Go(func() {
+			errs[i] = run(ctx, timeout, cleanup)
+		})
+	}
+	wg.Wait()
+
+	return errs
+}
+
+// run executes a single cleanup function, returning early with a timeout error
+// if the function does not finish within timeout of ctx. An abandoned function
+// keeps running in the background until it returns on its own. A panic in the
+// function is returned as an error, as it runs outside of the recover in main
+// that restores the terminal.
+func run(ctx context.Context, timeout time.Duration, cleanup cleanupFunc) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- fmt.Errorf("panic: %v", r)
+			}
+		}()
+		done <- cleanup.fn()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("timed out: %w", ctx.Err())
 	}
 }
@@ -1,8 +1,12 @@
 package internal
 
 import (
+	"context"
 	"errors"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestCleanupManager_Execute_LIFO_Order(t *testing.T) {
@@ -63,3 +67,314 @@ func TestCleanupManager_Execute_EmptyManager(t *testing.T) {
 	m := NewCleanupManager()
 	m.Execute()
 }
+
+func TestCleanupManager_ExecuteWithContext_SkipsSlowCleanup(t *testing.T) {
+	m := NewCleanupManager()
+	m.SetTimeout(20 * time.Millisecond)
+
+	release := make(chan struct{})
+	defer close(release)
+
+	var executed []string
+	m.Add("first", func() error {
+		executed = append(executed, "first")
+		return nil
+	})
+	m.Add("slow", func() error {
+		<-release
+		return nil
+	})
+	m.Add("third", func() error {
+		executed = append(executed, "third")
+		return nil
+	})
+
+	err := m.ExecuteWithContext(context.Background())
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected a deadline exceeded error, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "cleanup slow: timed out") {
+		t.Errorf("expected timeout error to name the slow cleanup, got %v", err)
+	}
+	if len(executed) != 2 || executed[0] != "third" || executed[1] != "first" {
+		t.Errorf("expected cleanups around the slow one to run, got %v", executed)
+	}
+}
+
+func TestCleanupManager_ExecuteWithContext_ReturnsErrors(t *testing.T) {
+	m := NewCleanupManager()
+
+	m.Add("first", func() error {
+		return errors.New("first failed")
+	})
+	m.Add("second", func() error {
+		return nil
+	})
+
+	err := m.ExecuteWithContext(context.Background())
+	if err == nil || err.Error() != "cleanup first: first failed" {
+		t.Errorf("expected error from first cleanup, got %v", err)
+	}
+}
+
+func TestCleanupManager_ExecuteWithContext_ReturnsPanics(t *testing.T) {
+	m := NewCleanupManager()
+
+	var executed []string
+	m.Add("first", func() error {
+		executed = append(executed, "first")
+		return nil
+	})
+	m.AddIndependent("git-server", func() error {
+		panic("server exploded")
+	})
+	m.AddIndependent("image", func() error {
+		return nil
+	})
+	m.Add("last", func() error {
+		panic("last exploded")
+	})
+
+	err := m.ExecuteWithContext(context.Background())
+	if err == nil || err.Error() != "cleanup last: panic: last exploded\ncleanup git-server: panic: server exploded" {
+		t.Errorf("expected the panics as errors, got %v", err)
+	}
+	if len(executed) != 1 {
+		t.Errorf("expected cleanups after the panics to run, got %v", executed)
+	}
+}
+
+func TestCleanupManager_ExecuteWithContext_CancelledContext(t *testing.T) {
+	m := NewCleanupManager()
+
+	release := make(chan struct{})
+	defer close(release)
+
+	m.Add("blocked", func() error {
+		<-release
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := m.ExecuteWithContext(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected a context canceled error, got %v", err)
+	}
+}
+
+func TestCleanupManager_ExecuteWithContext_RunsIndependentCleanupsConcurrently(t *testing.T) {
+	m := NewCleanupManager()
+	m.SetTimeout(time.Second)
+
+	// Each independent cleanup waits for the other to start, which only happens when
+	// they run at the same time
+	var mu sync.Mutex
+	var order []string
+	record := func(name string) {
+		mu.Lock()
+		defer mu.Unlock()
+		order = append(order, name)
+	}
+
+	started := map[string]chan struct{}{
+		"image":      make(chan struct{}),
+		"git-server": make(chan struct{}),
+	}
+	independent := func(name, other string) func() error {
+		return func() error {
+			close(started[name])
+			select {
+			case <-started[other]:
+			case <-time.After(500 * time.Millisecond):
+				return errors.New("ran alone")
+			}
+			record(name)
+			return nil
+		}
+	}
+
+	m.Add("first", func() error {
+		record("first")
+		return nil
+	})
+	m.AddIndependent("git-server", independent("git-server", "image"))
+	m.AddIndependent("image", independent("image", "git-server"))
+	m.Add("last", func() error {
+		record("last")
+		return nil
+	})
+
+	if err := m.ExecuteWithContext(context.Background()); err != nil {
+		t.Fatalf("expected independent cleanups to run concurrently, got %v", err)
+	}
+	if len(order) != 4 || order[0] != "last" || order[3] != "first" {
+		t.Errorf("expected ordered cleanups to run before and after the independent ones, got %v", order)
+	}
+}
+
+func TestCleanupManager_ExecuteWithContext_WaitsForIndependentCleanups(t *testing.T) {
+	m := NewCleanupManager()
+
+	var mu sync.Mutex
+	var order []string
+	record := func(name string) {
+		mu.Lock()
+		defer mu.Unlock()
+		order = append(order, name)
+	}
+
+	m.Add("first", func() error {
+		record("first")
+		return nil
+	})
+	m.AddIndependent("slow", func() error {
+		time.Sleep(20 * time.Millisecond)
+		record("slow")
+		return errors.New("slow failed")
+	})
+	m.AddIndependent("fast", func() error {
+		record("fast")
+		return errors.New("fast failed")
+	})
+
+	err := m.ExecuteWithContext(context.Background())
+	if err == nil || err.Error() != "cleanup fast: fast failed\ncleanup slow: slow failed" {
+		t.Errorf("expected errors of the independent cleanups in LIFO order, got %v", err)
+	}
+	if len(order) != 3 || order[0] != "fast" || order[1] != "slow" || order[2] != "first" {
+		t.Errorf("expected the ordered cleanup to wait for the independent ones, got %v", order)
+	}
+}
+
+func TestCleanupManager_ExecuteWithContext_KeepsIndependentCleanupsInPlace(t *testing.T) {
+	m := NewCleanupManager()
+	var order []string
+
+	// Independent cleanups separated by an ordered one do not run together
+	m.AddIndependent("first", func() error {
+		order = append(order, "first")
+		return nil
+	})
+	m.Add("second", func() error {
+		order = append(order, "second")
+		return nil
+	})
+	m.AddIndependent("third", func() error {
+		order = append(order, "third")
+		return nil
+	})
+
+	m.Execute()
+
+	if len(order) != 3 || order[0] != "third" || order[1] != "second" || order[2] != "first" {
+		t.Errorf("expected LIFO order [third, second, first], got %v", order)
+	}
+}
+
+func TestCleanupManager_Remove(t *testing.T) {
+	m := NewCleanupManager()
+	var order []string
+
+	for _, name := range []string{"first", "second", "third"} {
+		m.Add(name, func() error {
+			order = append(order, name)
+			return nil
+		})
+	}
+
+	m.Remove("second")
+	m.Remove("missing")
+	m.Execute()
+
+	if len(order) != 2 || order[0] != "third" || order[1] != "first" {
+		t.Errorf("expected the remaining cleanups in LIFO order [third, first], got %v", order)
+	}
+}
+
+func TestCleanupManager_Replace(t *testing.T) {
+	m := NewCleanupManager()
+	var order []string
+
+	for _, name := range []string{"first", "second", "third"} {
+		m.Add(name, func() error {
+			order = append(order, name)
+			return nil
+		})
+	}
+
+	m.Replace("second", func() error {
+		order = append(order, "replaced")
+		return nil
+	})
+	m.Replace("fourth", func() error {
+		order = append(order, "fourth")
+		return nil
+	})
+	m.Execute()
+
+	expected := []string{"fourth", "third", "replaced", "first"}
+	if strings.Join(order, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected the replaced cleanup to keep its place, %v, got %v", expected, order)
+	}
+}
+
+func TestCleanupManager_Replace_KeepsIndependence(t *testing.T) {
+	m := NewCleanupManager()
+	m.SetTimeout(time.Second)
+
+	// Each cleanup waits for the other to start, which only happens when they run at
+	// the same time
+	wait := func(started chan struct{}) error {
+		select {
+		case <-started:
+			return nil
+		case <-time.After(500 * time.Millisecond):
+			return errors.New("ran alone")
+		}
+	}
+
+	firstStarted, secondStarted := make(chan struct{}), make(chan struct{})
+	m.AddIndependent("first", func() error {
+		t.Error("replaced cleanup should not run")
+		return nil
+	})
+	m.AddIndependent("second", func() error {
+		close(secondStarted)
+		return wait(firstStarted)
+	})
+
+	m.Replace("first", func() error {
+		close(firstStarted)
+		return wait(secondStarted)
+	})
+
+	if err := m.ExecuteWithContext(context.Background()); err != nil {
+		t.Errorf("expected the replacement to stay independent, got %v", err)
+	}
+}
+
+func TestCleanupManager_ExecuteWithContext_AllowsCleanupsToChangeTheManager(t *testing.T) {
+	m := NewCleanupManager()
+	m.SetTimeout(time.Second)
+
+	var order []string
+	m.Add("first", func() error {
+		order = append(order, "first")
+		return nil
+	})
+	m.Add("second", func() error {
+		order = append(order, "second")
+		m.Remove("first")
+		m.Add("later", func() error { return nil })
+		return nil
+	})
+
+	if err := m.ExecuteWithContext(context.Background()); err != nil {
+		t.Fatalf("expected cleanups that change the manager not to block, got %v", err)
+	}
+	if strings.Join(order, ",") != "second,first" {
+		t.Errorf("expected changes to only affect later executions, got %v", order)
+	}
+}
@@ -1,13 +1,20 @@
 package internal
 
 import (
+	"encoding/json"
 	"fmt"
+	"net"
+	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
+	"regexp"
 	goruntime "runtime"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/docker/go-units"
 	"github.com/ryanmoran/contagent/internal/config"
 )
 
@@ -23,25 +30,112 @@ const (
 	DefaultTTYRetries = 10
 
 	// DefaultRetryDelay is the base delay between TTY resize retry attempts.
-	// Each retry multiplies this by (retry+1) to implement exponential backoff:
-	// 10ms, 20ms, 30ms, etc.
+	// Each retry doubles it to implement exponential backoff, up to a second:
+	// 10ms, 20ms, 40ms, etc., each reduced by up to half at random.
 	DefaultRetryDelay = 10 * time.Millisecond
+
+	// DefaultGitUserName and DefaultGitUserEmail are the commit identity used when
+	// neither the configuration nor the host's git config provides one.
+	DefaultGitUserName  = "Contagent"
+	DefaultGitUserEmail = "contagent@example.com"
+
+	// DefaultLogFile is the path template of the log file every session writes its
+	// output to, relative to the home directory, unless another one is configured.
+	DefaultLogFile = ".contagent/logs/{session}.log"
 )
 
 type Config struct {
 	Runtime     string
 	ImageName   ImageName
 	WorkingDir  string
+	User        string
 	StopTimeout int
 	TTYRetries  int
 	RetryDelay  time.Duration
+	TTYResync   time.Duration
+	Timeout     time.Duration
 	GitUser     GitUserConfig
 
-	Args           Command
-	Env            Environment
-	Volumes        []string
-	DockerfilePath string
-	Network        string
+	Args             Command
+	Entrypoint       []string
+	Env              Environment
+	SensitiveEnv     *regexp.Regexp
+	Volumes          []string
+	Ports            []PortMapping
+	DockerfilePath   string
+	BuildContext     string
+	BuildTarget      string
+	BuildArgs        map[string]string
+	Labels           map[string]string
+	ImageLabels      map[string]string
+	ForceRebuild     bool
+	NoCache          bool
+	KeepIntermediate bool
+	PullPolicy       PullPolicy
+	Progress         BuildProgress
+	DockerConfig     string
+	Secrets          []BuildSecret
+	Platform         string
+	ReadOnly         bool
+	Tmpfs            map[string]string
+	Name             string
+	Network          string
+	ExtraHosts       []string
+	NoGateway        bool
+	GitServerAddr    string
+	GitTLS           bool
+	GitTLSCert       string
+	GitTLSKey        string
+	GitReadOnly      bool
+	Ref              string
+	Symlinks         bool
+	Submodules       bool
+	Stream           bool
+	NoGit            bool
+	MaxArchiveSize   int64
+	Chown            *Ownership
+	AutoPush         bool
+	ResetBranch      bool
+	DryRun           bool
+	PrintConfig      bool
+	Reap             bool
+	Keep             bool
+	RmImage          bool
+	Restart          RestartPolicy
+	NoTTY            bool
+	NoAttach         bool
+	LogFormat        string
+	LogFile          string
+	LogLevel         LogLevel
+	Timestamps       bool
+	Memory           int64
+	CPUs             float64
+}
+
+// JSON returns the configuration as indented JSON, to share it when reporting a
+// problem. The values of environment variables and build args whose names match
+// SensitiveEnv are redacted, as they hold secrets such as ANTHROPIC_API_KEY or NPM_TOKEN.
+func (c Config) JSON() ([]byte, error) {
+	c.Env = c.Env.Redacted(c.SensitiveEnv)
+	c.BuildArgs = redactedArgs(c.BuildArgs, c.SensitiveEnv)
+	return json.MarshalIndent(c, "", "  ")
+}
+
+// redactedArgs returns a copy of args with the values of the keys that match sensitive
+// replaced by "<redacted>", as Environment.Redacted does for environment variables.
+func redactedArgs(args map[string]string, sensitive *regexp.Regexp) map[string]string {
+	if args == nil {
+		return nil
+	}
+
+	redacted := make(map[string]string, len(args))
+	for key, value := range args {
+		if sensitive.MatchString(key) {
+			value = "<redacted>"
+		}
+		redacted[key] = value
+	}
+	return redacted
 }
 
 type GitUserConfig struct {
@@ -66,28 +160,291 @@ func ParseConfig(args []string, environment []string, startDir string) (Config,
 	// Build environment variables with defaults (runtime-aware)
 	env := buildEnvironment(environment, cfg.Env, rt)
 
+	logFormat, err := resolveLogFormat(cfg.LogFormat)
+	if err != nil {
+		return Config{}, err
+	}
+
+	logLevel, err := resolveLogLevel(cfg.Quiet, cfg.Verbose)
+	if err != nil {
+		return Config{}, err
+	}
+
+	pullPolicy, err := resolvePullPolicy(cfg.PullPolicy)
+	if err != nil {
+		return Config{}, err
+	}
+
+	progress, err := resolveBuildProgress(cfg.Progress)
+	if err != nil {
+		return Config{}, err
+	}
+
+	if cfg.Name != "" {
+		if err := validateSessionName(cfg.Name); err != nil {
+			return Config{}, err
+		}
+	}
+
+	if err := ImageName(cfg.Image).Validate(); err != nil {
+		return Config{}, err
+	}
+
+	if cfg.Target != "" && !buildStageName.MatchString(cfg.Target) {
+		return Config{}, fmt.Errorf("invalid target %q: must be the name of a build stage, starting with a letter followed by letters, digits, periods, underscores, or dashes\nFor example: --target dev", cfg.Target)
+	}
+
+	if err := validatePlatform(cfg.Platform); err != nil {
+		return Config{}, err
+	}
+
+	for arch := range cfg.Dockerfiles {
+		if !knownArchitectures[arch] {
+			return Config{}, fmt.Errorf("invalid dockerfiles architecture %q: expected an architecture such as amd64 or arm64\nFor example: --dockerfile-arm64 ./Dockerfile.arm64", arch)
+		}
+	}
+
+	memory, err := parseMemory(cfg.Memory)
+	if err != nil {
+		return Config{}, err
+	}
+
+	maxArchiveSize, err := parseArchiveSize(cfg.MaxArchive)
+	if err != nil {
+		return Config{}, err
+	}
+
+	sensitiveEnv, err := parseRedactPattern(cfg.Redact)
+	if err != nil {
+		return Config{}, err
+	}
+
+	chown, err := parseChown(cfg.Chown)
+	if err != nil {
+		return Config{}, err
+	}
+
+	if cfg.StopTimeout < 0 {
+		return Config{}, fmt.Errorf("invalid stop timeout %d: must not be negative\nFor example: --stop-timeout 30", cfg.StopTimeout)
+	}
+
+	if cfg.TTYRetries < 0 {
+		return Config{}, fmt.Errorf("invalid TTY retries %d: must not be negative\nFor example: --tty-retries 5", cfg.TTYRetries)
+	}
+
+	if cfg.RetryDelay < 0 {
+		return Config{}, fmt.Errorf("invalid retry delay %s: must not be negative\nFor example: --retry-delay 50ms", cfg.RetryDelay)
+	}
+
+	if cfg.CPUs < 0 {
+		return Config{}, fmt.Errorf("invalid cpus %v: must not be negative\nFor example: --cpus 1.5", cfg.CPUs)
+	}
+
+	workingDir, err := validateWorkingDir(cfg.WorkingDir)
+	if err != nil {
+		return Config{}, err
+	}
+
+	if cfg.Git.ServerAddress != "" && net.ParseIP(cfg.Git.ServerAddress) == nil {
+		return Config{}, fmt.Errorf("invalid git server address %q: must be an IP address\nFor example: --git-server-address 172.17.0.1", cfg.Git.ServerAddress)
+	}
+
+	// Reject malformed volumes before they reach the container runtime
+	for _, volume := range cfg.Volumes {
+		if err := validateVolume(volume); err != nil {
+			return Config{}, err
+		}
+	}
+
+	for _, host := range cfg.ExtraHosts {
+		if err := validateExtraHost(host); err != nil {
+			return Config{}, err
+		}
+	}
+
+	for key := range cfg.Labels {
+		if err := validateLabel("--label", key); err != nil {
+			return Config{}, err
+		}
+	}
+	for key := range cfg.ImageLabels {
+		if err := validateLabel("--image-label", key); err != nil {
+			return Config{}, err
+		}
+	}
+
+	ports := make([]PortMapping, 0, len(cfg.Ports))
+	for _, port := range cfg.Ports {
+		mapping, err := parsePortMapping(port)
+		if err != nil {
+			return Config{}, err
+		}
+		ports = append(ports, mapping)
+	}
+
+	if err := validateUser(cfg.User); err != nil {
+		return Config{}, err
+	}
+
+	if rt == "apple" && cfg.User != "" {
+		return Config{}, fmt.Errorf("--user is not supported by the apple runtime\nSet USER in the Dockerfile instead")
+	}
+
+	secrets := make([]BuildSecret, 0, len(cfg.Secrets))
+	for _, spec := range cfg.Secrets {
+		secret, err := parseBuildSecret(spec, startDir)
+		if err != nil {
+			return Config{}, err
+		}
+		secrets = append(secrets, secret)
+	}
+
+	if rt == "apple" && len(secrets) > 0 {
+		return Config{}, fmt.Errorf("--secret is not supported by the apple runtime\nUse --runtime docker to expose secrets to the image build")
+	}
+
+	if rt == "apple" && cfg.Progress != "" {
+		return Config{}, fmt.Errorf("--progress is not supported by the apple runtime\nThe apple runtime always shows the full build output")
+	}
+
+	if rt == "apple" && cfg.NoAttach {
+		return Config{}, fmt.Errorf("--attach=false is not supported by the apple runtime\nThe apple runtime runs the command by attaching to the container")
+	}
+
+	if rt == "apple" && cfg.DockerConfig != "" {
+		return Config{}, fmt.Errorf("--docker-config is not supported by the apple runtime\nLog in with 'container registry login' instead")
+	}
+
+	if rt == "apple" && (cfg.ReadOnly || len(cfg.Tmpfs) > 0) {
+		return Config{}, fmt.Errorf("--read-only and --tmpfs are not supported by the apple runtime\nUse --runtime docker for a read-only root filesystem")
+	}
+
+	restart, err := parseRestartPolicy(cfg.Restart)
+	if err != nil {
+		return Config{}, err
+	}
+
+	if rt == "apple" && restart.Enabled() {
+		return Config{}, fmt.Errorf("--restart is not supported by the apple runtime\nUse --runtime docker to restart the container when its command exits")
+	}
+
+	// Wait follows the container across restarts, so with these policies the command
+	// only finishes when contagent is interrupted or times out
+	if cfg.AutoPush && (restart.Name == RestartAlways || restart.Name == RestartUnlessStopped) {
+		return Config{}, fmt.Errorf("--auto-push cannot be used with --restart %s: the command is restarted whenever it exits, so it never finishes successfully\nUse --restart on-failure to restart only after failures", restart.Name)
+	}
+
+	if cfg.NoGit && cfg.Submodules {
+		return Config{}, fmt.Errorf("--no-git cannot be used with --include-submodules: git archive does not include the content of submodules\nRemove --no-git to copy submodules into the container")
+	}
+	if cfg.NoGit && cfg.AutoPush {
+		return Config{}, fmt.Errorf("--no-git cannot be used with --auto-push: the container has no repository to push from\nRemove --no-git to fetch the session branch back")
+	}
+
+	gitTLS, err := resolveGitTLS(cfg.Git, startDir)
+	if err != nil {
+		return Config{}, err
+	}
+	if cfg.NoGit && gitTLS.Enabled {
+		return Config{}, fmt.Errorf("--no-git cannot be used with --git-tls: no git server is started\nRemove --git-tls to serve the repository over HTTPS")
+	}
+
+	if cfg.NoGit && cfg.Git.ReadOnly {
+		return Config{}, fmt.Errorf("--no-git cannot be used with --git-read-only: no git server is started\nRemove --git-read-only, as the container already cannot push without a git server")
+	}
+
+	if cfg.Keep && cfg.RmImage {
+		return Config{}, fmt.Errorf("--keep cannot be used with --rm-image: the kept container still uses the image\nRemove --rm-image to keep the container")
+	}
+
+	if cfg.Stream && cfg.Submodules {
+		return Config{}, fmt.Errorf("--stream-archive cannot be used with --include-submodules: git archive does not include the content of submodules\nRemove --stream-archive to copy submodules into the container")
+	}
+
+	tmpfs, err := parseTmpfs(cfg.Tmpfs, workingDir)
+	if err != nil {
+		return Config{}, err
+	}
+
 	// Build volumes with defaults (runtime-aware)
 	volumes := buildVolumes(cfg.Volumes, rt)
 
 	// Resolve relative host paths in volumes to absolute paths
 	volumes = resolveVolumePaths(volumes, startDir)
 
+	// The repository must stay writable when the root filesystem is read only
+	if cfg.ReadOnly {
+		volumes = writableWorkingDir(volumes, workingDir)
+	}
+
 	return Config{
-		Runtime:        rt,
-		ImageName:      ImageName(cfg.Image),
-		WorkingDir:     cfg.WorkingDir,
-		DockerfilePath: cfg.Dockerfile,
-		StopTimeout:    cfg.StopTimeout,
-		TTYRetries:     cfg.TTYRetries,
-		RetryDelay:     cfg.RetryDelay,
+		Runtime:          rt,
+		ImageName:        ImageName(cfg.Image),
+		WorkingDir:       workingDir,
+		User:             cfg.User,
+		DockerfilePath:   resolveDockerfile(cfg.Dockerfile, cfg.Dockerfiles, cfg.Platform),
+		BuildContext:     cfg.Context,
+		BuildTarget:      cfg.Target,
+		BuildArgs:        cfg.BuildArgs,
+		Labels:           cfg.Labels,
+		ImageLabels:      cfg.ImageLabels,
+		ForceRebuild:     cfg.ForceRebuild || cfg.NoCache,
+		NoCache:          cfg.NoCache,
+		KeepIntermediate: cfg.KeepIntermediate,
+		PullPolicy:       pullPolicy,
+		Progress:         progress,
+		DockerConfig:     resolveDockerConfig(cfg.DockerConfig, environment),
+		Secrets:          secrets,
+		Platform:         cfg.Platform,
+		ReadOnly:         cfg.ReadOnly,
+		Tmpfs:            tmpfs,
+		GitServerAddr:    cfg.Git.ServerAddress,
+		GitTLS:           gitTLS.Enabled,
+		GitTLSCert:       gitTLS.Cert,
+		GitTLSKey:        gitTLS.Key,
+		GitReadOnly:      cfg.Git.ReadOnly,
+		StopTimeout:      cfg.StopTimeout,
+		TTYRetries:       cfg.TTYRetries,
+		RetryDelay:       cfg.RetryDelay,
+		TTYResync:        cfg.TTYResync,
+		Timeout:          cfg.Timeout,
+		MaxArchiveSize:   maxArchiveSize,
+		Chown:            chown,
 		GitUser: GitUserConfig{
-			Name:  cfg.Git.User.Name,
-			Email: cfg.Git.User.Email,
+			Name:  resolveGitUser(cfg.Git.User.Name, "user.name", DefaultGitUserName, environment, startDir),
+			Email: resolveGitUser(cfg.Git.User.Email, "user.email", DefaultGitUserEmail, environment, startDir),
 		},
-		Args:    Command(programArgs),
-		Env:     Environment(env),
-		Volumes: volumes,
-		Network: cfg.Network,
+		Args:         Command(programArgs),
+		Entrypoint:   entrypoint(cfg.Entrypoint),
+		Env:          Environment(env),
+		SensitiveEnv: sensitiveEnv,
+		Volumes:      volumes,
+		Ports:        ports,
+		Name:         cfg.Name,
+		Network:      cfg.Network,
+		ExtraHosts:   cfg.ExtraHosts,
+		NoGateway:    cfg.NoGateway,
+		Ref:          cfg.Ref,
+		Symlinks:     cfg.Symlinks,
+		Submodules:   cfg.Submodules,
+		Stream:       cfg.Stream,
+		NoGit:        cfg.NoGit,
+		AutoPush:     cfg.AutoPush,
+		ResetBranch:  cfg.ResetBranch,
+		DryRun:       cfg.DryRun,
+		PrintConfig:  cfg.PrintConfig,
+		Reap:         cfg.Reap,
+		Keep:         cfg.Keep,
+		RmImage:      cfg.RmImage,
+		Restart:      restart,
+		NoTTY:        cfg.NoTTY,
+		NoAttach:     cfg.NoAttach,
+		LogFormat:    logFormat,
+		LogFile:      resolveLogFile(cfg.LogFile, environment),
+		LogLevel:     logLevel,
+		Timestamps:   cfg.Timestamps,
+		Memory:       memory,
+		CPUs:         cfg.CPUs,
 	}, nil
 }
 
@@ -123,10 +480,536 @@ func buildVolumes(configVolumes []string, rt string) []string {
 	}
 }
 
+// entrypoint converts a configured entrypoint into the container entrypoint: nil keeps
+// the image's entrypoint and an empty entrypoint resets it.
+func entrypoint(configured *string) []string {
+	if configured == nil {
+		return nil
+	}
+	if *configured == "" {
+		return []string{}
+	}
+
+	return []string{*configured}
+}
+
+// validateUser checks that a container user has the NAME, UID, NAME:GROUP, or
+// UID:GID form. An empty user keeps the image's default user.
+func validateUser(user string) error {
+	if user == "" {
+		return nil
+	}
+
+	name, group, hasGroup := strings.Cut(user, ":")
+	if name == "" || (hasGroup && (group == "" || strings.Contains(group, ":"))) {
+		return fmt.Errorf("invalid user %q: expected NAME, UID, or UID:GID\nFor example: --user 1000:1000", user)
+	}
+
+	return nil
+}
+
+// resolveDockerConfig returns the directory of the Docker config.json that registry
+// credentials are read from: the configured directory, else $DOCKER_CONFIG, else
+// ~/.docker. Returns an empty string when none can be determined.
+func resolveDockerConfig(configured string, environment []string) string {
+	if configured != "" {
+		return configured
+	}
+
+	var home string
+	for _, variable := range environment {
+		name, value, _ := strings.Cut(variable, "=")
+		switch name {
+		case "DOCKER_CONFIG":
+			if value != "" {
+				return value
+			}
+		case "HOME":
+			home = value
+		}
+	}
+
+	if home == "" {
+		return ""
+	}
+	return filepath.Join(home, ".docker")
+}
+
+// resolveLogFile returns the path template of the session log file: the configured
+// path, else DefaultLogFile in the home directory. An empty configured path disables
+// the log file, as does a missing home directory when none is configured.
+func resolveLogFile(configured *string, environment []string) string {
+	if configured != nil {
+		return *configured
+	}
+
+	for _, variable := range environment {
+		if home, ok := strings.CutPrefix(variable, "HOME="); ok && home != "" {
+			return filepath.Join(home, DefaultLogFile)
+		}
+	}
+	return ""
+}
+
+// parseBuildSecret parses an id=NAME,src=PATH build secret, resolving a relative
+// source path against baseDir. Returns an error if either key is missing or unknown,
+// or if the source is not a readable file.
+func parseBuildSecret(spec, baseDir string) (BuildSecret, error) {
+	var secret BuildSecret
+	for field := range strings.SplitSeq(spec, ",") {
+		key, value, _ := strings.Cut(field, "=")
+		switch key {
+		case "id":
+			secret.ID = value
+		case "src", "source":
+			secret.Source = value
+		default:
+			return BuildSecret{}, fmt.Errorf("invalid secret %q: unknown key %q\nFor example: --secret id=npmrc,src=$HOME/.npmrc", spec, key)
+		}
+	}
+
+	if secret.ID == "" || secret.Source == "" {
+		return BuildSecret{}, fmt.Errorf("invalid secret %q: must set both id and src\nFor example: --secret id=npmrc,src=$HOME/.npmrc", spec)
+	}
+
+	if !filepath.IsAbs(secret.Source) {
+		secret.Source = filepath.Join(baseDir, secret.Source)
+	}
+
+	info, err := os.Stat(secret.Source)
+	if err != nil {
+		return BuildSecret{}, fmt.Errorf("invalid secret %q: %w\nEnsure the source file exists and is readable", spec, err)
+	}
+	if info.IsDir() {
+		return BuildSecret{}, fmt.Errorf("invalid secret %q: %s is a directory\nThe source must be a file", spec, secret.Source)
+	}
+
+	return secret, nil
+}
+
+// parseRestartPolicy parses a no, on-failure[:MAX], always, or unless-stopped restart
+// policy. An empty policy never restarts the container.
+func parseRestartPolicy(spec string) (RestartPolicy, error) {
+	name, count, hasCount := strings.Cut(spec, ":")
+	switch name {
+	case "", RestartNo, RestartAlways, RestartUnlessStopped:
+		if hasCount {
+			return RestartPolicy{}, fmt.Errorf("invalid restart policy %q: a maximum retry count is only allowed with %s\nFor example: --restart on-failure:3", spec, RestartOnFailure)
+		}
+		return RestartPolicy{Name: name}, nil
+	case RestartOnFailure:
+		if !hasCount {
+			return RestartPolicy{Name: name}, nil
+		}
+		retries, err := strconv.Atoi(count)
+		if err != nil || retries < 0 {
+			return RestartPolicy{}, fmt.Errorf("invalid restart policy %q: maximum retry count %q must be a non-negative number\nFor example: --restart on-failure:3", spec, count)
+		}
+		return RestartPolicy{Name: name, MaxRetries: retries}, nil
+	default:
+		return RestartPolicy{}, fmt.Errorf("invalid restart policy %q: must be %s, %s[:MAX], %s, or %s\nFor example: --restart on-failure:3", spec, RestartNo, RestartOnFailure, RestartAlways, RestartUnlessStopped)
+	}
+}
+
+// parseTmpfs converts PATH[:OPTIONS] tmpfs specs into a map of container paths to
+// mount options. The working directory cannot be a tmpfs, because the repository is
+// copied there before the container starts and the tmpfs would hide it.
+func parseTmpfs(specs []string, workingDir string) (map[string]string, error) {
+	tmpfs := make(map[string]string, len(specs))
+	for _, spec := range specs {
+		target, options, _ := strings.Cut(spec, ":")
+		if !path.IsAbs(target) {
+			return nil, fmt.Errorf("invalid tmpfs %q: container path %q must be absolute\nFor example: --tmpfs /tmp", spec, target)
+		}
+
+		target = path.Clean(target)
+		if target == workingDir {
+			return nil, fmt.Errorf("invalid tmpfs %q: the repository is copied to %s before the container starts\nUse --volume to keep the working directory writable instead", spec, workingDir)
+		}
+		tmpfs[target] = options
+	}
+
+	return tmpfs, nil
+}
+
+// writableWorkingDir adds an anonymous volume for the working directory unless a
+// volume is already mounted there.
+func writableWorkingDir(volumes []string, workingDir string) []string {
+	for _, volume := range volumes {
+		parts := strings.Split(volume, ":")
+		if len(parts) >= 2 && path.Clean(parts[1]) == workingDir {
+			return volumes
+		}
+	}
+
+	return append(volumes, workingDir)
+}
+
+// resolveGitUser determines a commit identity field. A configured value wins;
+// otherwise the host's git config for key is used, as seen from dir with the
+// given environment, so commits stay attributable to the developer. The fallback
+// is used only when the host has nothing configured.
+func resolveGitUser(configured, key, fallback string, environment []string, dir string) string {
+	if configured != "" {
+		return configured
+	}
+
+	cmd := exec.Command("git", "config", "--get", key)
+	cmd.Dir = dir
+	cmd.Env = append([]string{}, environment...)
+	output, err := cmd.Output()
+	if err == nil {
+		if value := strings.TrimSpace(string(output)); value != "" {
+			return value
+		}
+	}
+
+	return fallback
+}
+
+// platformComponent matches a single os, architecture, or variant in a platform.
+var platformComponent = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// buildStageName matches the names that a Dockerfile can give a stage with FROM ... AS NAME.
+var buildStageName = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_.-]*$`)
+
+// gitTLS is the resolved HTTPS configuration of the git server.
+type gitTLS struct {
+	Enabled bool
+	Cert    string
+	Key     string
+}
+
+// resolveGitTLS determines whether the git server serves HTTPS, which giving a
+// certificate implies, and resolves the certificate and key paths against baseDir.
+// Returns an error if only one of the certificate and key is given.
+func resolveGitTLS(cfg config.GitConfig, baseDir string) (gitTLS, error) {
+	if (cfg.TLSCert == "") != (cfg.TLSKey == "") {
+		return gitTLS{}, fmt.Errorf("--git-tls-cert and --git-tls-key must be used together\nFor example: --git-tls-cert server.crt --git-tls-key server.key")
+	}
+
+	resolved := gitTLS{Enabled: cfg.TLS || cfg.TLSCert != ""}
+	if cfg.TLSCert != "" {
+		resolved.Cert, resolved.Key = cfg.TLSCert, cfg.TLSKey
+		if !filepath.IsAbs(resolved.Cert) {
+			resolved.Cert = filepath.Join(baseDir, resolved.Cert)
+		}
+		if !filepath.IsAbs(resolved.Key) {
+			resolved.Key = filepath.Join(baseDir, resolved.Key)
+		}
+	}
+
+	return resolved, nil
+}
+
+// resolveLogFormat validates the requested log format, defaulting to text when
+// none is configured.
+func resolveLogFormat(format string) (string, error) {
+	switch format {
+	case "":
+		return LogFormatText, nil
+	case LogFormatText, LogFormatJSON:
+		return format, nil
+	default:
+		return "", fmt.Errorf("invalid log format %q: must be %q or %q\nFor example: --log-format json", format, LogFormatText, LogFormatJSON)
+	}
+}
+
+// resolvePullPolicy validates the requested pull policy, defaulting to pulling
+// missing base images when none is configured.
+func resolvePullPolicy(policy string) (PullPolicy, error) {
+	switch PullPolicy(policy) {
+	case "":
+		return PullPolicyMissing, nil
+	case PullPolicyAlways, PullPolicyMissing, PullPolicyNever:
+		return PullPolicy(policy), nil
+	default:
+		return "", fmt.Errorf("invalid pull policy %q: must be %q, %q, or %q\nFor example: --pull-policy always", policy, PullPolicyAlways, PullPolicyMissing, PullPolicyNever)
+	}
+}
+
+// resolveBuildProgress validates the build progress setting, defaulting to auto.
+func resolveBuildProgress(progress string) (BuildProgress, error) {
+	switch BuildProgress(progress) {
+	case "":
+		return BuildProgressAuto, nil
+	case BuildProgressAuto, BuildProgressPlain:
+		return BuildProgress(progress), nil
+	default:
+		return "", fmt.Errorf("invalid progress %q: must be %q or %q\nFor example: --progress plain", progress, BuildProgressAuto, BuildProgressPlain)
+	}
+}
+
+// resolveLogLevel maps the quiet and verbose settings onto a LogLevel. They are
+// mutually exclusive.
+func resolveLogLevel(quiet, verbose bool) (LogLevel, error) {
+	switch {
+	case quiet && verbose:
+		return LogLevelInfo, fmt.Errorf("quiet and verbose cannot be used together\nChoose one of --quiet or --verbose")
+	case quiet:
+		return LogLevelWarning, nil
+	case verbose:
+		return LogLevelDebug, nil
+	default:
+		return LogLevelInfo, nil
+	}
+}
+
+// hostArch is the architecture contagent runs on, which selects the Dockerfile when no
+// platform is given. It is a variable so that tests can simulate other hosts.
+var hostArch = goruntime.GOARCH
+
+// knownArchitectures are the architectures, named as by Go and Docker platforms, that
+// an architecture-specific Dockerfile can be given for.
+var knownArchitectures = map[string]bool{
+	"386":     true,
+	"amd64":   true,
+	"arm":     true,
+	"arm64":   true,
+	"ppc64le": true,
+	"riscv64": true,
+	"s390x":   true,
+}
+
+// resolveDockerfile returns the Dockerfile to build. The Dockerfile for the target
+// architecture, that of the platform when one is given and of the host otherwise, takes
+// precedence over the default Dockerfile.
+func resolveDockerfile(dockerfile string, dockerfiles map[string]string, platform string) string {
+	arch := hostArch
+	if platform != "" {
+		arch = strings.Split(platform, "/")[1]
+	}
+
+	if path := dockerfiles[arch]; path != "" {
+		return path
+	}
+	return dockerfile
+}
+
+// validatePlatform checks that a platform has the form os/arch[/variant], such as
+// linux/amd64 or linux/arm64/v8. An empty platform selects the daemon's default.
+func validatePlatform(platform string) error {
+	if platform == "" {
+		return nil
+	}
+
+	parts := strings.Split(platform, "/")
+	if len(parts) < 2 || len(parts) > 3 {
+		return fmt.Errorf("invalid platform %q: expected OS/ARCH[/VARIANT]\nFor example: --platform linux/amd64", platform)
+	}
+	for _, part := range parts {
+		if !platformComponent.MatchString(part) {
+			return fmt.Errorf("invalid platform %q: %q is not a valid component\nFor example: --platform linux/amd64", platform, part)
+		}
+	}
+
+	return nil
+}
+
+// parseMemory converts a human-readable memory size such as "512m" or "2g" into
+// bytes. An empty value means no limit and yields zero.
+func parseMemory(memory string) (int64, error) {
+	if memory == "" {
+		return 0, nil
+	}
+
+	bytes, err := units.RAMInBytes(memory)
+	if err != nil || bytes <= 0 {
+		return 0, fmt.Errorf("invalid memory %q: expected a positive size such as 512m or 2g", memory)
+	}
+
+	return bytes, nil
+}
+
+// parseArchiveSize converts a human-readable size such as "500m" or "2g" into bytes. An
+// empty value means no limit and yields zero.
+func parseArchiveSize(size string) (int64, error) {
+	if size == "" {
+		return 0, nil
+	}
+
+	bytes, err := units.RAMInBytes(size)
+	if err != nil || bytes <= 0 {
+		return 0, fmt.Errorf("invalid archive size %q: expected a positive size such as 500m or 2g\nFor example: --max-archive-size 1g", size)
+	}
+
+	return bytes, nil
+}
+
+// parseRedactPattern compiles the regular expression that matches the names of
+// sensitive environment variables, using DefaultSensitivePattern when none is given.
+func parseRedactPattern(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		pattern = DefaultSensitivePattern
+	}
+
+	sensitive, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redact pattern %q: %w\nFor example: --redact-pattern '(?i)(TOKEN|SECRET|PASSWORD)'", pattern, err)
+	}
+
+	return sensitive, nil
+}
+
+// parseChown parses the owner of the copied repository in the form UID[:GID], where
+// the GID defaults to the UID. An empty value yields nil, leaving the files owned by
+// the container user.
+func parseChown(spec string) (*Ownership, error) {
+	if spec == "" {
+		return nil, nil
+	}
+
+	uidPart, gidPart, hasGID := strings.Cut(spec, ":")
+	uid, err := strconv.Atoi(uidPart)
+	if err != nil || uid < 0 {
+		return nil, fmt.Errorf("invalid chown %q: UID %q must be a non-negative number\nFor example: --chown 1000:1000", spec, uidPart)
+	}
+
+	gid := uid
+	if hasGID {
+		gid, err = strconv.Atoi(gidPart)
+		if err != nil || gid < 0 {
+			return nil, fmt.Errorf("invalid chown %q: GID %q must be a non-negative number\nFor example: --chown 1000:1000", spec, gidPart)
+		}
+	}
+
+	return &Ownership{UID: uid, GID: gid}, nil
+}
+
+// parsePortMapping parses a published port in the form host:container[/protocol].
+// The protocol defaults to tcp and may be tcp, udp, or sctp.
+func parsePortMapping(spec string) (PortMapping, error) {
+	ports, protocol, hasProtocol := strings.Cut(spec, "/")
+	if !hasProtocol {
+		protocol = "tcp"
+	}
+	if protocol != "tcp" && protocol != "udp" && protocol != "sctp" {
+		return PortMapping{}, fmt.Errorf("invalid port %q: unknown protocol %q\nSupported protocols: tcp, udp, sctp", spec, protocol)
+	}
+
+	hostPort, containerPort, ok := strings.Cut(ports, ":")
+	if !ok {
+		return PortMapping{}, fmt.Errorf("invalid port %q: expected HOST:CONTAINER[/PROTOCOL]\nFor example: --publish 8080:3000", spec)
+	}
+
+	host, err := parsePortNumber(hostPort)
+	if err != nil {
+		return PortMapping{}, fmt.Errorf("invalid port %q: host %w", spec, err)
+	}
+
+	container, err := parsePortNumber(containerPort)
+	if err != nil {
+		return PortMapping{}, fmt.Errorf("invalid port %q: container %w", spec, err)
+	}
+
+	return PortMapping{HostPort: host, ContainerPort: container, Protocol: protocol}, nil
+}
+
+// parsePortNumber parses a port number between 1 and 65535.
+func parsePortNumber(port string) (uint16, error) {
+	number, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || number == 0 {
+		return 0, fmt.Errorf("port %q must be a number between 1 and 65535", port)
+	}
+	return uint16(number), nil
+}
+
+// volumeModes lists the options accepted in the mode field of a volume spec.
+var volumeModes = map[string]bool{
+	"ro": true, "rw": true,
+	"z": true, "Z": true,
+	"cached": true, "delegated": true, "consistent": true,
+	"nocopy": true,
+	"shared": true, "rshared": true,
+	"slave": true, "rslave": true,
+	"private": true, "rprivate": true,
+}
+
+// validateWorkingDir checks that the container working directory is an absolute path
+// below the root and returns it cleaned. The repository archive is extracted into its
+// parent under its final path component, so the root itself cannot be used.
+func validateWorkingDir(dir string) (string, error) {
+	cleaned := path.Clean(dir)
+	if !path.IsAbs(cleaned) || cleaned == "/" {
+		return "", fmt.Errorf("invalid working directory %q: must be an absolute path below /\nFor example: --workdir /workspace", dir)
+	}
+	return cleaned, nil
+}
+
+// reservedLabels are the labels that contagent sets itself, with what they are set to.
+// Containers inherit the labels of their image, so both kinds are reserved for both.
+var reservedLabels = map[string]string{
+	SessionLabel: "the session ID",
+	CommitLabel:  "the commit of the session",
+}
+
+// validateLabel checks that a label key given with flag is set and is not one
+// contagent sets itself.
+func validateLabel(flag, key string) error {
+	if key == "" {
+		return fmt.Errorf("invalid label: key is empty\nFor example: %s team=platform", flag)
+	}
+
+	if value, ok := reservedLabels[key]; ok {
+		return fmt.Errorf("invalid label %q: the label is set by contagent to %s", key, value)
+	}
+
+	return nil
+}
+
+// validateExtraHost checks that a host alias has the NAME:IP form, where IP is an
+// IP address or host-gateway.
+func validateExtraHost(host string) error {
+	name, address, ok := strings.Cut(host, ":")
+	if !ok || name == "" {
+		return fmt.Errorf("invalid host %q: expected NAME:IP\nFor example: --add-host db.local:10.0.0.5", host)
+	}
+
+	if address != "host-gateway" && net.ParseIP(address) == nil {
+		return fmt.Errorf("invalid host %q: %q must be an IP address or host-gateway\nFor example: --add-host db.local:10.0.0.5", host, address)
+	}
+
+	return nil
+}
+
+// validateVolume checks that a volume spec has the form host:container[:mode],
+// with an absolute container path and a comma-separated list of known modes.
+func validateVolume(volume string) error {
+	parts := strings.Split(volume, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return fmt.Errorf("invalid volume %q: expected HOST:CONTAINER[:MODE]\nFor example: --volume ./data:/data:ro", volume)
+	}
+
+	if parts[0] == "" {
+		return fmt.Errorf("invalid volume %q: host path or volume name is empty\nFor example: --volume ./data:/data:ro", volume)
+	}
+
+	if !path.IsAbs(parts[1]) {
+		return fmt.Errorf("invalid volume %q: container path %q must be absolute\nFor example: --volume ./data:/data:ro", volume, parts[1])
+	}
+
+	if len(parts) == 3 {
+		access := ""
+		for _, mode := range strings.Split(parts[2], ",") {
+			if !volumeModes[mode] {
+				return fmt.Errorf("invalid volume %q: unknown mode %q\nSupported modes: ro, rw, z, Z, cached, delegated, consistent, nocopy, and mount propagation options", volume, mode)
+			}
+			if mode == "ro" || mode == "rw" {
+				if access != "" {
+					return fmt.Errorf("invalid volume %q: mode cannot combine %q and %q", volume, access, mode)
+				}
+				access = mode
+			}
+		}
+	}
+
+	return nil
+}
+
 // resolveVolumePaths resolves relative host paths in volume mount specs to absolute paths.
-// Volume specs have the format [host-path:]container-path[:options].
+// Volume specs have the format host-path:container-path[:options].
 // Host paths starting with "." are treated as relative and resolved against baseDir.
-// Named volumes (no leading "/" or ".") and container-only specs are left unchanged.
+// Named volumes (no leading "/" or ".") are left unchanged.
 func resolveVolumePaths(volumes []string, baseDir string) []string {
 	resolved := make([]string, len(volumes))
 	for i, volume := range volumes {
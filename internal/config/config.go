@@ -2,6 +2,9 @@ package config
 
 import (
 	"flag"
+	"fmt"
+	"io"
+	"path/filepath"
 	"strings"
 	"time"
 )
@@ -9,22 +12,76 @@ import (
 // Config represents the parsed and merged configuration for contagent.
 // It includes all settings that can be specified via config files or CLI flags.
 type Config struct {
-	Runtime     string            `yaml:"runtime"`
-	Image       string            `yaml:"image"`
-	WorkingDir  string            `yaml:"working_dir"`
-	Dockerfile  string            `yaml:"dockerfile"`
-	Network     string            `yaml:"network"`
-	StopTimeout int               `yaml:"stop_timeout"`
-	TTYRetries  int               `yaml:"tty_retries"`
-	RetryDelay  time.Duration     `yaml:"retry_delay"`
-	Git         GitConfig         `yaml:"git"`
-	Env         map[string]string `yaml:"env"`
-	Volumes     []string          `yaml:"volumes"`
+	Runtime          string            `yaml:"runtime"`
+	Name             string            `yaml:"name"`
+	Image            string            `yaml:"image"`
+	WorkingDir       string            `yaml:"working_dir"`
+	User             string            `yaml:"user"`
+	Entrypoint       *string           `yaml:"entrypoint"`
+	Dockerfile       string            `yaml:"dockerfile"`
+	Dockerfiles      map[string]string `yaml:"dockerfiles"`
+	Context          string            `yaml:"context"`
+	Target           string            `yaml:"target"`
+	Platform         string            `yaml:"platform"`
+	ReadOnly         bool              `yaml:"read_only"`
+	ForceRebuild     bool              `yaml:"force_rebuild"`
+	NoCache          bool              `yaml:"no_cache"`
+	KeepIntermediate bool              `yaml:"keep_intermediate"`
+	PullPolicy       string            `yaml:"pull_policy"`
+	Progress         string            `yaml:"progress"`
+	DockerConfig     string            `yaml:"docker_config"`
+	Network          string            `yaml:"network"`
+	NoGateway        bool              `yaml:"no_host_gateway"`
+	Ref              string            `yaml:"ref"`
+	Symlinks         bool              `yaml:"symlinks"`
+	Submodules       bool              `yaml:"include_submodules"`
+	Stream           bool              `yaml:"stream_archive"`
+	MaxArchive       string            `yaml:"max_archive_size"`
+	NoGit            bool              `yaml:"no_git"`
+	Chown            string            `yaml:"chown"`
+	AutoPush         bool              `yaml:"auto_push"`
+	ResetBranch      bool              `yaml:"reset_branch"`
+	DryRun           bool              `yaml:"dry_run"`
+	PrintConfig      bool              `yaml:"print_config"`
+	Redact           string            `yaml:"redact_pattern"`
+	Reap             bool              `yaml:"reap"`
+	Keep             bool              `yaml:"keep"`
+	RmImage          bool              `yaml:"rm_image"`
+	Restart          string            `yaml:"restart"`
+	NoTTY            bool              `yaml:"no_tty"`
+	NoAttach         bool              `yaml:"no_attach"`
+	LogFormat        string            `yaml:"log_format"`
+	LogFile          *string           `yaml:"log_file"`
+	Quiet            bool              `yaml:"quiet"`
+	Verbose          bool              `yaml:"verbose"`
+	Timestamps       bool              `yaml:"timestamps"`
+	Memory           string            `yaml:"memory"`
+	CPUs             float64           `yaml:"cpus"`
+	StopTimeout      int               `yaml:"stop_timeout"`
+	TTYRetries       int               `yaml:"tty_retries"`
+	RetryDelay       time.Duration     `yaml:"retry_delay"`
+	TTYResync        time.Duration     `yaml:"tty_resync"`
+	Timeout          time.Duration     `yaml:"timeout"`
+	Git              GitConfig         `yaml:"git"`
+	Env              map[string]string `yaml:"env"`
+	Volumes          []string          `yaml:"volumes"`
+	Ports            []string          `yaml:"ports"`
+	Tmpfs            []string          `yaml:"tmpfs"`
+	Secrets          []string          `yaml:"secrets"`
+	ExtraHosts       []string          `yaml:"extra_hosts"`
+	BuildArgs        map[string]string `yaml:"build_args"`
+	Labels           map[string]string `yaml:"labels"`
+	ImageLabels      map[string]string `yaml:"image_labels"`
 }
 
 // GitConfig represents Git-specific configuration settings.
 type GitConfig struct {
-	User GitUserConfig `yaml:"user"`
+	User          GitUserConfig `yaml:"user"`
+	ServerAddress string        `yaml:"server_address"`
+	TLS           bool          `yaml:"tls"`
+	TLSCert       string        `yaml:"tls_cert"`
+	TLSKey        string        `yaml:"tls_key"`
+	ReadOnly      bool          `yaml:"read_only"`
 }
 
 // GitUserConfig represents Git user identity configuration.
@@ -33,6 +90,21 @@ type GitUserConfig struct {
 	Email string `yaml:"email"`
 }
 
+// UsageError reports invalid command-line arguments. It carries the flag usage
+// text so callers can show the user how to invoke contagent correctly.
+type UsageError struct {
+	Err   error
+	Usage string
+}
+
+func (e *UsageError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *UsageError) Unwrap() error {
+	return e.Err
+}
+
 // stringSlice is a custom flag type that allows multiple values
 type stringSlice []string
 
@@ -45,6 +117,24 @@ func (s *stringSlice) Set(value string) error {
 	return nil
 }
 
+// readOnlyVolumes is a custom flag type that adds HOST:CONTAINER volumes to a
+// stringSlice with the ro mode appended, keeping their order among other volumes
+type readOnlyVolumes struct {
+	volumes *stringSlice
+}
+
+func (r readOnlyVolumes) String() string {
+	return ""
+}
+
+func (r readOnlyVolumes) Set(value string) error {
+	if strings.Count(value, ":") != 1 {
+		return fmt.Errorf("expected HOST:CONTAINER, use --volume to set other modes")
+	}
+	*r.volumes = append(*r.volumes, value+":ro")
+	return nil
+}
+
 // Load discovers, loads, and merges all configuration sources.
 // It follows the resolution order: defaults → global config → project config → CLI flags.
 // Environment variable expansion is applied after all merging is complete.
@@ -64,14 +154,11 @@ func Load(cliArgs []string, environment []string, startDir string) (Config, []st
 		StopTimeout: 10,
 		TTYRetries:  10,
 		RetryDelay:  10 * time.Millisecond,
-		Git: GitConfig{
-			User: GitUserConfig{
-				Name:  "Contagent",
-				Email: "contagent@example.com",
-			},
-		},
-		Env:     make(map[string]string),
-		Volumes: []string{},
+		Env:         make(map[string]string),
+		Volumes:     []string{},
+		BuildArgs:   make(map[string]string),
+		Labels:      make(map[string]string),
+		ImageLabels: make(map[string]string),
 	}
 
 	// 2. Find and load global config
@@ -102,50 +189,186 @@ func Load(cliArgs []string, environment []string, startDir string) (Config, []st
 
 	// 4. Parse CLI flags
 	var (
-		envFlags    stringSlice
-		volumeFlags stringSlice
-		retryDelay  string
+		envFlags        stringSlice
+		envFileFlags    stringSlice
+		volumeFlags     stringSlice
+		portFlags       stringSlice
+		hostFlags       stringSlice
+		tmpfsFlags      stringSlice
+		secretFlags     stringSlice
+		buildArgFlags   stringSlice
+		labelFlags      stringSlice
+		imageLabelFlags stringSlice
+		entrypoint      string
+		logFile         string
+		dockerfileAMD64 string
+		dockerfileARM64 string
+		retryDelay      string
+		ttyResync       string
+		timeout         string
+		attach          bool
 	)
 
 	cliCfg := Config{ //nolint:exhaustruct // Partial initialization, fields populated via CLI flags
 		Git: GitConfig{
-			User: GitUserConfig{}, //nolint:exhaustruct // Empty, populated via CLI flags
+			User:          GitUserConfig{}, //nolint:exhaustruct // Empty, populated via CLI flags
+			ServerAddress: "",
 		},
-		Env:     make(map[string]string),
-		Volumes: []string{},
+		Env:         make(map[string]string),
+		Volumes:     []string{},
+		Dockerfiles: make(map[string]string),
+		BuildArgs:   make(map[string]string),
+		Labels:      make(map[string]string),
+		ImageLabels: make(map[string]string),
 	}
 
 	fs := flag.NewFlagSet("contagent", flag.ContinueOnError)
+	fs.SetOutput(io.Discard) // Errors and usage are reported by the caller via UsageError
 	fs.StringVar(&cliCfg.Runtime, "runtime", "", "Container runtime (docker or apple)")
-	fs.StringVar(&cliCfg.Dockerfile, "dockerfile", "", "Dockerfile path")
+	fs.StringVar(&cliCfg.Name, "name", "", "Name for the session, used for the container (contagent-NAME) and branch (contagent/NAME) instead of a random identifier")
+	fs.StringVar(&cliCfg.Dockerfile, "dockerfile", "", "Dockerfile path (defaults to a built-in Debian image with git)")
+	fs.StringVar(&dockerfileAMD64, "dockerfile-amd64", "", "Dockerfile path used instead of --dockerfile when the image is built for amd64")
+	fs.StringVar(&dockerfileARM64, "dockerfile-arm64", "", "Dockerfile path used instead of --dockerfile when the image is built for arm64")
+	fs.StringVar(&cliCfg.Context, "context", "", "Image build context directory (defaults to the Dockerfile's directory)")
+	fs.StringVar(&cliCfg.Target, "target", "", "Stage of a multi-stage Dockerfile to build (defaults to the last stage)")
+	fs.BoolVar(&cliCfg.ForceRebuild, "force-rebuild", false, "Rebuild the image even when its inputs are unchanged")
+	fs.BoolVar(&cliCfg.NoCache, "no-cache", false, "Rebuild the image without reusing cached layers (implies --force-rebuild)")
+	fs.BoolVar(&cliCfg.KeepIntermediate, "keep-intermediate", false, "Keep the intermediate containers of the image build, to inspect the layers of each step (Docker only)")
+	fs.StringVar(&cliCfg.PullPolicy, "pull-policy", "", "When to pull base images: always, missing, or never (defaults to missing)")
+	fs.Var(&secretFlags, "secret", "Expose a secret to the image build as id=NAME,src=PATH, for RUN --mount=type=secret (Docker only; can be repeated)")
+	fs.StringVar(&cliCfg.Progress, "progress", "", "Image build output: auto for a line per build step, or plain for the full output (defaults to auto; Docker only)")
+	fs.StringVar(&cliCfg.DockerConfig, "docker-config", "", "Directory of the Docker config.json to read registry credentials for base images from (defaults to $DOCKER_CONFIG or ~/.docker; Docker only)")
+	fs.StringVar(&cliCfg.Platform, "platform", "", "Target platform for the image and container (os/arch[/variant], e.g. linux/amd64)")
 	fs.StringVar(&cliCfg.Image, "image", "", "Container image name")
-	fs.StringVar(&cliCfg.WorkingDir, "working-dir", "", "Working directory in container")
+	fs.StringVar(&cliCfg.Image, "image-name", "", "Container image name (alias for --image)")
+	fs.StringVar(&cliCfg.WorkingDir, "working-dir", "", "Working directory in container, where the repository is copied")
+	fs.StringVar(&cliCfg.WorkingDir, "workdir", "", "Working directory in container (alias for --working-dir)")
+	fs.StringVar(&cliCfg.User, "user", "", "User to run the container as (NAME, UID, or UID:GID; Docker only)")
+	fs.StringVar(&entrypoint, "entrypoint", "", "Override the image's entrypoint, or reset it when empty (Docker only)")
 	fs.StringVar(&cliCfg.Network, "network", "", "Docker network to use")
-	fs.IntVar(&cliCfg.StopTimeout, "stop-timeout", 0, "Stop timeout in seconds")
-	fs.IntVar(&cliCfg.TTYRetries, "tty-retries", 0, "TTY retry attempts")
-	fs.StringVar(&retryDelay, "retry-delay", "", "Retry delay duration")
+	fs.BoolVar(&cliCfg.NoGateway, "no-host-gateway", false, "Do not map host.docker.internal to the host gateway in the container")
+	fs.StringVar(&cliCfg.Ref, "ref", "", "Git ref or commit to copy into the container (defaults to HEAD)")
+	fs.BoolVar(&cliCfg.Symlinks, "symlinks", false, "Copy symlinks tracked in the repository into the container instead of skipping them")
+	fs.BoolVar(&cliCfg.Submodules, "include-submodules", false, "Copy the checked-out content of git submodules into the container")
+	fs.StringVar(&cliCfg.MaxArchive, "max-archive-size", "", "Fail before copying the repository when its tracked files exceed this size (e.g. 500m, 2g)")
+	fs.StringVar(&cliCfg.Chown, "chown", "", "Owner of the repository files copied into the container as UID[:GID] (defaults to the container user)")
+	fs.BoolVar(&cliCfg.NoGit, "no-git", false, "Copy only the tracked files into the container, without .git, and do not start the git server")
+	fs.BoolVar(&cliCfg.Stream, "stream-archive", false, "Stream the repository from git archive with only the history of the commit, instead of copying .git and checking it out")
+	fs.BoolVar(&cliCfg.AutoPush, "auto-push", false, "Fetch the session branch back into the host repository when the command exits successfully")
+	fs.BoolVar(&cliCfg.ResetBranch, "reset-branch", false, "Reset the session branch to the archived commit when it already exists, such as when rerunning with the same --name")
+	fs.BoolVar(&cliCfg.DryRun, "dry-run", false, "Print what would be run without building the image or starting a container")
+	fs.BoolVar(&cliCfg.PrintConfig, "print-config", false, "Print the resolved configuration as JSON, with the values of sensitive environment variables redacted, and exit")
+	fs.StringVar(&cliCfg.Redact, "redact-pattern", "", "Regular expression matching the names of environment variables and build args whose values are redacted in output (default: API_KEY, TOKEN, SECRET, and PASSWORD)")
+	fs.BoolVar(&cliCfg.Reap, "reap", false, "Remove stopped containers left behind by other contagent sessions before starting (Docker only)")
+	fs.StringVar(&cliCfg.Restart, "restart", "", "Restart policy for the container: no, on-failure[:MAX], always, or unless-stopped (Docker only)")
+	fs.BoolVar(&cliCfg.Keep, "keep", false, "Leave the container in place after the command exits, for inspection")
+	fs.BoolVar(&cliCfg.RmImage, "rm-image", false, "Remove the built image after the container is removed, unless other containers use it (Docker only)")
+	fs.BoolVar(&cliCfg.NoTTY, "no-tty", false, "Disable TTY allocation (implied when stdin is not a terminal)")
+	fs.BoolVar(&attach, "attach", true, "Attach the terminal to the container; with --attach=false the container runs without stdin and its output is streamed from the logs (Docker only)")
+	fs.StringVar(&cliCfg.LogFormat, "log-format", "", "Format of contagent's own output (text or json)")
+	fs.StringVar(&logFile, "log-file", "", "Also write contagent's output to this file, where {session} is replaced with the session ID, or to no file when empty (defaults to ~/.contagent/logs/{session}.log)")
+	fs.BoolVar(&cliCfg.Quiet, "quiet", false, "Only show warnings and errors")
+	fs.BoolVar(&cliCfg.Verbose, "verbose", false, "Show debug output")
+	fs.BoolVar(&cliCfg.Timestamps, "timestamps", false, "Prefix each output line with a timestamp")
+	fs.BoolVar(&cliCfg.ReadOnly, "read-only", false, "Mount the container's root filesystem as read only (Docker only)")
+	fs.StringVar(&cliCfg.Memory, "memory", "", "Container memory limit (e.g. 512m, 2g)")
+	fs.Float64Var(&cliCfg.CPUs, "cpus", 0, "Number of CPUs available to the container (e.g. 1.5)")
+	fs.IntVar(&cliCfg.StopTimeout, "stop-timeout", 0, "Seconds to wait for the container to stop before it is killed (defaults to 10)")
+	fs.IntVar(&cliCfg.TTYRetries, "tty-retries", 0, "Number of TTY resize retry attempts (defaults to 10)")
+	fs.StringVar(&retryDelay, "retry-delay", "", "Base delay between TTY resize retries (e.g. 50ms; defaults to 10ms)")
+	fs.StringVar(&ttyResync, "tty-resync", "", "Also resize the container's TTY at this interval, for terminals that miss resize signals (e.g. 2s; Docker only)")
+	fs.StringVar(&timeout, "timeout", "", "Stop the container if it is still running after this duration (e.g. 30m)")
 	fs.StringVar(&cliCfg.Git.User.Name, "git-user-name", "", "Git user name")
 	fs.StringVar(&cliCfg.Git.User.Email, "git-user-email", "", "Git user email")
+	fs.StringVar(&cliCfg.Git.ServerAddress, "git-server-address", "", "Host address the git server listens on (defaults to 127.0.0.1)")
+	fs.BoolVar(&cliCfg.Git.TLS, "git-tls", false, "Serve the repository over HTTPS with a self-signed certificate, which the container does not verify")
+	fs.StringVar(&cliCfg.Git.TLSCert, "git-tls-cert", "", "Certificate file the git server serves HTTPS with, instead of a self-signed one (requires --git-tls-key)")
+	fs.StringVar(&cliCfg.Git.TLSKey, "git-tls-key", "", "Private key file of --git-tls-cert")
+	fs.BoolVar(&cliCfg.Git.ReadOnly, "git-read-only", false, "Only allow the container to fetch from the git server, rejecting pushes")
 	fs.Var(&envFlags, "env", "Environment variable (KEY=VALUE)")
+	fs.Var(&envFileFlags, "env-file", "File of environment variables (KEY=VALUE per line)")
 	fs.Var(&volumeFlags, "volume", "Volume mount")
+	fs.Var(readOnlyVolumes{volumes: &volumeFlags}, "volume-ro", "Read-only volume mount (HOST:CONTAINER, shorthand for --volume HOST:CONTAINER:ro)")
+	fs.Var(&tmpfsFlags, "tmpfs", "Mount a tmpfs in the container (PATH[:OPTIONS], Docker only)")
+	fs.Var(&portFlags, "publish", "Publish a container port to the host (HOST:CONTAINER[/PROTOCOL])")
+	fs.Var(&portFlags, "p", "Publish a container port to the host (shorthand for --publish)")
+	fs.Var(&hostFlags, "add-host", "Add a host alias to the container (NAME:IP, where IP may be host-gateway)")
+	fs.Var(&buildArgFlags, "build-arg", "Image build argument (KEY=VALUE, or KEY to pass it through from the host)")
+	fs.Var(&labelFlags, "label", "Container label (KEY=VALUE)")
+	fs.Var(&imageLabelFlags, "image-label", "Label added to the built image (KEY=VALUE)")
 
 	if err := fs.Parse(cliArgs); err != nil {
-		return Config{}, nil, err
+		return Config{}, nil, newUsageError(fs, fmt.Errorf("invalid command-line arguments: %w", err))
 	}
 
 	// Extract remaining program arguments
 	programArgs := fs.Args()
 
+	cliCfg.NoAttach = !attach
+
+	// An empty entrypoint resets the image's entrypoint and an empty log file disables
+	// the log, so they are only set when given, while an empty target would silently
+	// build the last stage instead
+	var emptyTarget bool
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "entrypoint":
+			cliCfg.Entrypoint = &entrypoint
+		case "log-file":
+			cliCfg.LogFile = &logFile
+		case "target":
+			emptyTarget = cliCfg.Target == ""
+		}
+	})
+	if emptyTarget {
+		return Config{}, nil, newUsageError(fs, fmt.Errorf("invalid value \"\" for flag -target: must name a build stage"))
+	}
+
 	// 5. Handle retry delay parsing
 	if retryDelay != "" {
 		duration, err := time.ParseDuration(retryDelay)
 		if err != nil {
-			return Config{}, nil, err
+			return Config{}, nil, newUsageError(fs, fmt.Errorf("invalid value %q for flag -retry-delay: %w", retryDelay, err))
 		}
 		cliCfg.RetryDelay = duration
 	}
 
-	// Parse env flags
+	if ttyResync != "" {
+		duration, err := time.ParseDuration(ttyResync)
+		if err != nil {
+			return Config{}, nil, newUsageError(fs, fmt.Errorf("invalid value %q for flag -tty-resync: %w", ttyResync, err))
+		}
+		if duration < 0 {
+			return Config{}, nil, newUsageError(fs, fmt.Errorf("invalid value %q for flag -tty-resync: must not be negative", ttyResync))
+		}
+		cliCfg.TTYResync = duration
+	}
+
+	if timeout != "" {
+		duration, err := time.ParseDuration(timeout)
+		if err != nil {
+			return Config{}, nil, newUsageError(fs, fmt.Errorf("invalid value %q for flag -timeout: %w", timeout, err))
+		}
+		if duration < 0 {
+			return Config{}, nil, newUsageError(fs, fmt.Errorf("invalid value %q for flag -timeout: must not be negative", timeout))
+		}
+		cliCfg.Timeout = duration
+	}
+
+	// Load env files, relative paths are resolved against startDir
+	for _, path := range envFileFlags {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(startDir, path)
+		}
+
+		fileEnv, err := ParseEnvFile(path)
+		if err != nil {
+			return Config{}, nil, err
+		}
+		cliCfg.Env = MergeEnv(cliCfg.Env, fileEnv)
+	}
+
+	// Parse env flags, these override env file entries
 	for _, env := range envFlags {
 		key, value, ok := strings.Cut(env, "=")
 		if ok {
@@ -153,11 +376,51 @@ func Load(cliArgs []string, environment []string, startDir string) (Config, []st
 		}
 	}
 
-	// Set volumes
+	// Set the architecture-specific Dockerfiles
+	if dockerfileAMD64 != "" {
+		cliCfg.Dockerfiles["amd64"] = dockerfileAMD64
+	}
+	if dockerfileARM64 != "" {
+		cliCfg.Dockerfiles["arm64"] = dockerfileARM64
+	}
+
+	// Parse build arg flags, later flags win for duplicate keys. As with docker build
+	// --build-arg, a key without a value is passed through from the environment
+	hostEnv := makeEnvMap(environment)
+	for _, arg := range buildArgFlags {
+		key, value, err := parseKeyValueFlag("build-arg", arg, hostEnv)
+		if err != nil {
+			return Config{}, nil, newUsageError(fs, err)
+		}
+		cliCfg.BuildArgs[key] = value
+	}
+
+	// Parse label flags, a label without a value is set to the empty string
+	for _, label := range labelFlags {
+		key, value, _ := strings.Cut(label, "=")
+		cliCfg.Labels[key] = value
+	}
+	for _, label := range imageLabelFlags {
+		key, value, _ := strings.Cut(label, "=")
+		cliCfg.ImageLabels[key] = value
+	}
+
+	// Set volumes, tmpfs mounts, build secrets, published ports, and host aliases
 	cliCfg.Volumes = volumeFlags
+	cliCfg.Tmpfs = tmpfsFlags
+	cliCfg.Secrets = secretFlags
+	cliCfg.Ports = portFlags
+	cliCfg.ExtraHosts = hostFlags
 
-	// 6. Merge CLI flags with config
+	// 6. Merge CLI flags with config. Merge cannot tell a false boolean from one that
+	// was not given, so the boolean flags given on the command line are applied again,
+	// which lets them turn off settings from config files, e.g. --attach or --keep=false
 	cfg = Merge(cfg, cliCfg)
+	fs.Visit(func(f *flag.Flag) {
+		if field, ok := boolFlags[f.Name]; ok {
+			*field(&cfg) = *field(&cliCfg)
+		}
+	})
 
 	// 7. Expand environment variables
 	cfg = ExpandEnv(cfg, environment)
@@ -166,3 +429,56 @@ func Load(cliArgs []string, environment []string, startDir string) (Config, []st
 
 	return cfg, programArgs, nil
 }
+
+// boolFlags maps each boolean flag to the Config field it sets. The attach flag sets
+// NoAttach, which holds its negation.
+var boolFlags = map[string]func(*Config) *bool{
+	"force-rebuild":      func(c *Config) *bool { return &c.ForceRebuild },
+	"no-cache":           func(c *Config) *bool { return &c.NoCache },
+	"keep-intermediate":  func(c *Config) *bool { return &c.KeepIntermediate },
+	"no-host-gateway":    func(c *Config) *bool { return &c.NoGateway },
+	"symlinks":           func(c *Config) *bool { return &c.Symlinks },
+	"include-submodules": func(c *Config) *bool { return &c.Submodules },
+	"no-git":             func(c *Config) *bool { return &c.NoGit },
+	"stream-archive":     func(c *Config) *bool { return &c.Stream },
+	"auto-push":          func(c *Config) *bool { return &c.AutoPush },
+	"reset-branch":       func(c *Config) *bool { return &c.ResetBranch },
+	"dry-run":            func(c *Config) *bool { return &c.DryRun },
+	"print-config":       func(c *Config) *bool { return &c.PrintConfig },
+	"reap":               func(c *Config) *bool { return &c.Reap },
+	"keep":               func(c *Config) *bool { return &c.Keep },
+	"rm-image":           func(c *Config) *bool { return &c.RmImage },
+	"no-tty":             func(c *Config) *bool { return &c.NoTTY },
+	"attach":             func(c *Config) *bool { return &c.NoAttach },
+	"quiet":              func(c *Config) *bool { return &c.Quiet },
+	"verbose":            func(c *Config) *bool { return &c.Verbose },
+	"timestamps":         func(c *Config) *bool { return &c.Timestamps },
+	"read-only":          func(c *Config) *bool { return &c.ReadOnly },
+	"git-tls":            func(c *Config) *bool { return &c.Git.TLS },
+	"git-read-only":      func(c *Config) *bool { return &c.Git.ReadOnly },
+}
+
+// parseKeyValueFlag parses a KEY=VALUE value of the named flag. A KEY alone takes its
+// value from hostEnv, and is an error when it is not set there.
+func parseKeyValueFlag(flag, arg string, hostEnv map[string]string) (string, string, error) {
+	if key, value, ok := strings.Cut(arg, "="); ok {
+		return key, value, nil
+	}
+
+	value, ok := hostEnv[arg]
+	if !ok {
+		return "", "", fmt.Errorf("invalid value %q for flag -%s: expected KEY=VALUE, or the name of a set environment variable", arg, flag)
+	}
+	return arg, value, nil
+}
+
+// newUsageError wraps err in a UsageError describing the flags accepted by fs.
+func newUsageError(fs *flag.FlagSet, err error) *UsageError {
+	var usage strings.Builder
+	usage.WriteString("Usage: contagent [flags] [command [args...]]\n\nFlags:\n")
+	fs.SetOutput(&usage)
+	fs.PrintDefaults()
+	fs.SetOutput(io.Discard)
+
+	return &UsageError{Err: err, Usage: usage.String()}
+}
@@ -1,6 +1,11 @@
 package config
 
 import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -21,8 +26,8 @@ func TestLoad_WithDefaultsOnly(t *testing.T) {
 	require.Equal(t, 10, cfg.StopTimeout)
 	require.Equal(t, 10, cfg.TTYRetries)
 	require.Equal(t, 10*time.Millisecond, cfg.RetryDelay)
-	require.Equal(t, "Contagent", cfg.Git.User.Name)
-	require.Equal(t, "contagent@example.com", cfg.Git.User.Email)
+	require.Equal(t, "", cfg.Git.User.Name)  // Resolved from host git config by the caller
+	require.Equal(t, "", cfg.Git.User.Email) // Resolved from host git config by the caller
 	require.NotNil(t, cfg.Env)
 	require.NotNil(t, cfg.Volumes)
 }
@@ -82,6 +87,8 @@ func TestLoad_WithNumericFlags(t *testing.T) {
 		"--stop-timeout", "30",
 		"--tty-retries", "5",
 		"--retry-delay", "50ms",
+		"--tty-resync", "2s",
+		"--timeout", "1h30m",
 	}
 
 	cfg, programArgs, err := Load(args, []string{}, t.TempDir())
@@ -91,6 +98,8 @@ func TestLoad_WithNumericFlags(t *testing.T) {
 	require.Equal(t, 30, cfg.StopTimeout)
 	require.Equal(t, 5, cfg.TTYRetries)
 	require.Equal(t, 50*time.Millisecond, cfg.RetryDelay)
+	require.Equal(t, 2*time.Second, cfg.TTYResync)
+	require.Equal(t, 90*time.Minute, cfg.Timeout)
 }
 
 func TestLoad_WithEnvironmentVariableExpansion(t *testing.T) {
@@ -130,6 +139,43 @@ func TestLoad_WithInvalidRetryDelay(t *testing.T) {
 	require.Nil(t, programArgs)
 }
 
+func TestLoad_WithInvalidTimeout(t *testing.T) {
+	for _, value := range []string{"forever", "-5m"} {
+		cfg, programArgs, err := Load([]string{"--timeout", value}, []string{}, t.TempDir())
+		require.ErrorContains(t, err, fmt.Sprintf("invalid value %q for flag -timeout", value))
+		require.Equal(t, Config{}, cfg)
+		require.Nil(t, programArgs)
+	}
+}
+
+func TestLoad_WithInvalidTTYResync(t *testing.T) {
+	for _, value := range []string{"often", "-2s"} {
+		cfg, programArgs, err := Load([]string{"--tty-resync", value}, []string{}, t.TempDir())
+		require.ErrorContains(t, err, fmt.Sprintf("invalid value %q for flag -tty-resync", value))
+		require.Equal(t, Config{}, cfg)
+		require.Nil(t, programArgs)
+	}
+}
+
+func TestLoad_WithReadOnlyVolumes(t *testing.T) {
+	args := []string{
+		"--volume", "/host:/container",
+		"--volume-ro", "/secrets:/secrets",
+		"--volume", "/data:/data:rw",
+	}
+
+	cfg, _, err := Load(args, []string{}, t.TempDir())
+	require.NoError(t, err)
+	require.Equal(t, []string{"/host:/container", "/secrets:/secrets:ro", "/data:/data:rw"}, cfg.Volumes)
+}
+
+func TestLoad_WithInvalidReadOnlyVolume(t *testing.T) {
+	for _, value := range []string{"/secrets", "/secrets:/secrets:ro"} {
+		_, _, err := Load([]string{"--volume-ro", value}, []string{}, t.TempDir())
+		require.ErrorContains(t, err, fmt.Sprintf("invalid value %q for flag -volume-ro: expected HOST:CONTAINER", value))
+	}
+}
+
 func TestLoad_WithInvalidEnvFormat(t *testing.T) {
 	// Environment variables without '=' should be ignored
 	args := []string{
@@ -138,7 +184,7 @@ func TestLoad_WithInvalidEnvFormat(t *testing.T) {
 		"--env", "ALSO_VALID=another",
 	}
 
-	cfg, programArgs, err := Load(args, []string{}, t.TempDir())
+	cfg, programArgs, err := Load(args, []string{"INVALID_NO_EQUALS=value"}, t.TempDir())
 	require.NoError(t, err)
 	require.Empty(t, programArgs)
 
@@ -148,6 +194,70 @@ func TestLoad_WithInvalidEnvFormat(t *testing.T) {
 	require.NotContains(t, cfg.Env, "INVALID_NO_EQUALS")
 }
 
+func TestLoad_WithKeyOnlyBuildArgs(t *testing.T) {
+	t.Run("passes keys without values through from the environment", func(t *testing.T) {
+		args := []string{
+			"--build-arg", "NPM_TOKEN",
+			"--build-arg", "EMPTY",
+		}
+
+		cfg, programArgs, err := Load(args, []string{"NPM_TOKEN=npm-secret", "EMPTY="}, t.TempDir())
+		require.NoError(t, err)
+		require.Empty(t, programArgs)
+
+		require.Equal(t, map[string]string{"NPM_TOKEN": "npm-secret", "EMPTY": ""}, cfg.BuildArgs)
+	})
+
+	t.Run("returns a usage error for keys that are not set", func(t *testing.T) {
+		_, _, err := Load([]string{"--build-arg", "INVALID_NO_EQUALS"}, []string{}, t.TempDir())
+		require.ErrorContains(t, err, `invalid value "INVALID_NO_EQUALS" for flag -build-arg: expected KEY=VALUE`)
+
+		var usageErr *UsageError
+		require.ErrorAs(t, err, &usageErr)
+	})
+}
+
+func TestLoad_WithBooleanFlagsOverridingConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	config := "no_attach: true\ndry_run: true\nprint_config: true\nreap: true\nkeep: true\n"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".contagent.yaml"), []byte(config), 0600))
+
+	t.Run("keeps the config file values when the flags are not given", func(t *testing.T) {
+		cfg, _, err := Load([]string{}, []string{}, dir)
+		require.NoError(t, err)
+
+		require.True(t, cfg.NoAttach)
+		require.True(t, cfg.DryRun)
+		require.True(t, cfg.PrintConfig)
+		require.True(t, cfg.Reap)
+		require.True(t, cfg.Keep)
+	})
+
+	t.Run("turns the config file values off when the flags are given", func(t *testing.T) {
+		cfg, _, err := Load([]string{"--attach", "--dry-run=false", "--print-config=false", "--reap=false", "--keep=false"}, []string{}, dir)
+		require.NoError(t, err)
+
+		require.False(t, cfg.NoAttach)
+		require.False(t, cfg.DryRun)
+		require.False(t, cfg.PrintConfig)
+		require.False(t, cfg.Reap)
+		require.False(t, cfg.Keep)
+	})
+
+	t.Run("maps every boolean flag to a field", func(t *testing.T) {
+		_, _, err := Load([]string{"--help"}, []string{}, t.TempDir())
+
+		var usageErr *UsageError
+		require.ErrorAs(t, err, &usageErr)
+		for _, line := range strings.Split(usageErr.Usage, "\n") {
+			name, ok := strings.CutPrefix(strings.TrimSpace(line), "-")
+			if ok && !strings.Contains(name, " ") {
+				require.Contains(t, boolFlags, name)
+			}
+		}
+	})
+}
+
 func TestLoad_WithEmptyArgs(t *testing.T) {
 	// Verify flag parsing handles empty args without panicking
 	cfg, programArgs, err := Load([]string{}, []string{}, t.TempDir())
@@ -156,6 +266,44 @@ func TestLoad_WithEmptyArgs(t *testing.T) {
 	require.Empty(t, programArgs)
 }
 
+func TestLoad_WithUnknownFlag(t *testing.T) {
+	_, _, err := Load([]string{"--bogus", "bash"}, []string{}, t.TempDir())
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "invalid command-line arguments")
+	require.Contains(t, err.Error(), "flag provided but not defined: -bogus")
+
+	var usageErr *UsageError
+	require.ErrorAs(t, err, &usageErr)
+	require.Contains(t, usageErr.Usage, "-retry-delay")
+}
+
+func TestLoad_WithHelpFlag(t *testing.T) {
+	_, _, err := Load([]string{"--help"}, []string{}, t.TempDir())
+	require.ErrorIs(t, err, flag.ErrHelp)
+
+	var usageErr *UsageError
+	require.ErrorAs(t, err, &usageErr)
+	require.Contains(t, usageErr.Usage, "Usage: contagent")
+}
+
+func TestLoad_ArchitectureDockerfiles(t *testing.T) {
+	args := []string{
+		"--dockerfile-amd64", "Dockerfile.amd64",
+		"--dockerfile-arm64", "~/Dockerfile.arm64",
+		"bash",
+	}
+
+	cfg, _, err := Load(args, []string{}, t.TempDir())
+	require.NoError(t, err)
+
+	home, err := os.UserHomeDir()
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		"amd64": "Dockerfile.amd64",
+		"arm64": filepath.Join(home, "Dockerfile.arm64"),
+	}, cfg.Dockerfiles)
+}
+
 func TestLoad_WithTrailingArgs(t *testing.T) {
 	// Trailing args after flags should be captured as program args
 	args := []string{
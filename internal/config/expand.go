@@ -9,8 +9,10 @@ import (
 // ExpandEnv expands environment variables in config values.
 // It processes:
 //   - env map values: expands $VAR and ${VAR} using provided environment
+//   - build_args map values: expands $VAR and ${VAR} using provided environment
 //   - volumes paths: expands variables in volume mount strings
-//   - file paths: expands ~/ prefix to user's home directory in WorkingDir, Dockerfile, and Volumes
+//   - secrets: expands variables in build secret specs
+//   - file paths: expands ~/ prefix to user's home directory in WorkingDir, Dockerfile, Dockerfiles, Context, DockerConfig, LogFile, Git.TLSCert, Git.TLSKey, and Volumes
 //
 // Uses os.ExpandEnv behavior: undefined variables expand to empty string.
 // Returns a new Config with expanded values.
@@ -32,6 +34,14 @@ func ExpandEnv(cfg Config, environment []string) Config {
 		}
 	}
 
+	// Expand environment variables in BuildArgs map
+	if cfg.BuildArgs != nil {
+		result.BuildArgs = make(map[string]string, len(cfg.BuildArgs))
+		for key, value := range cfg.BuildArgs {
+			result.BuildArgs[key] = os.Expand(value, mapper)
+		}
+	}
+
 	// Expand environment variables in Volumes slice
 	if cfg.Volumes != nil {
 		result.Volumes = make([]string, len(cfg.Volumes))
@@ -41,9 +51,33 @@ func ExpandEnv(cfg Config, environment []string) Config {
 		}
 	}
 
+	// Expand environment variables in Secrets slice
+	if cfg.Secrets != nil {
+		result.Secrets = make([]string, len(cfg.Secrets))
+		for i, secret := range cfg.Secrets {
+			result.Secrets[i] = os.Expand(secret, mapper)
+		}
+	}
+
+	// Expand home directory in architecture-specific Dockerfiles
+	if cfg.Dockerfiles != nil {
+		result.Dockerfiles = make(map[string]string, len(cfg.Dockerfiles))
+		for arch, path := range cfg.Dockerfiles {
+			result.Dockerfiles[arch] = expandHome(path)
+		}
+	}
+
 	// Expand home directory in file path fields
 	result.WorkingDir = expandHome(cfg.WorkingDir)
 	result.Dockerfile = expandHome(cfg.Dockerfile)
+	result.Context = expandHome(cfg.Context)
+	result.DockerConfig = expandHome(cfg.DockerConfig)
+	if cfg.LogFile != nil {
+		logFile := expandHome(*cfg.LogFile)
+		result.LogFile = &logFile
+	}
+	result.Git.TLSCert = expandHome(cfg.Git.TLSCert)
+	result.Git.TLSKey = expandHome(cfg.Git.TLSKey)
 
 	return result
 }
@@ -105,6 +105,17 @@ func TestExpandEnv(t *testing.T) {
 		require.Equal(t, "/home/user/cache:/cache", result.Volumes[1])
 	})
 
+	t.Run("VariablesInSecrets", func(t *testing.T) {
+		cfg := Config{
+			Secrets: []string{"id=npmrc,src=$HOME/.npmrc"},
+		}
+		environment := []string{"HOME=/home/user"}
+
+		result := ExpandEnv(cfg, environment)
+
+		require.Equal(t, []string{"id=npmrc,src=/home/user/.npmrc"}, result.Secrets)
+	})
+
 	t.Run("BothEnvAndVolumes", func(t *testing.T) {
 		cfg := Config{
 			Env: map[string]string{
@@ -103,6 +103,33 @@ func ParseFile(path string) (Config, error) {
 	return cfg, nil
 }
 
+// ParseEnvFile reads KEY=VALUE lines from the env file at the given path.
+// Blank lines and lines starting with # are ignored, and values keep everything
+// after the first "=". Later lines win for duplicate keys.
+// Returns error if the file cannot be read or a line is not KEY=VALUE.
+func ParseEnvFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read env file %s: %w", path, err)
+	}
+
+	env := make(map[string]string)
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok || strings.TrimSpace(key) == "" {
+			return nil, fmt.Errorf("cannot parse env file %s: line %d is not KEY=VALUE", path, i+1)
+		}
+		env[strings.TrimSpace(key)] = value
+	}
+
+	return env, nil
+}
+
 // makeEnvMap converts environment slice (KEY=VALUE) to a map for easier lookup.
 func makeEnvMap(environment []string) map[string]string {
 	envMap := make(map[string]string)
@@ -254,6 +254,45 @@ func TestParseFile(t *testing.T) {
 	})
 }
 
+func TestParseEnvFile(t *testing.T) {
+	t.Run("parses KEY=VALUE lines skipping comments and blanks", func(t *testing.T) {
+		env, err := ParseEnvFile(filepath.Join("testdata", "vars.env"))
+		require.NoError(t, err)
+
+		require.Equal(t, map[string]string{
+			"FOO":      "bar",
+			"EQUATION": "a=b=c",
+			"EMPTY":    "",
+		}, env)
+	})
+
+	t.Run("later lines win for duplicate keys", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "dup.env")
+		require.NoError(t, os.WriteFile(path, []byte("FOO=first\nFOO=second\n"), 0o600))
+
+		env, err := ParseEnvFile(path)
+		require.NoError(t, err)
+		require.Equal(t, map[string]string{"FOO": "second"}, env)
+	})
+
+	t.Run("returns error for nonexistent file", func(t *testing.T) {
+		env, err := ParseEnvFile("/nonexistent/vars.env")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "cannot read env file /nonexistent/vars.env")
+		require.Nil(t, env)
+	})
+
+	t.Run("returns error for a line without =", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "bad.env")
+		require.NoError(t, os.WriteFile(path, []byte("FOO=bar\nNOT_A_PAIR\n"), 0o600))
+
+		env, err := ParseEnvFile(path)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "line 2 is not KEY=VALUE")
+		require.Nil(t, env)
+	})
+}
+
 func TestMakeEnvMap(t *testing.T) {
 	t.Run("converts environment slice to map", func(t *testing.T) {
 		env := []string{
@@ -2,8 +2,8 @@ package config
 
 // Merge combines two configs using hybrid merge strategy:
 //   - Scalar fields (image, dockerfile, etc.): override takes precedence if non-zero
-//   - Map fields (env): keys are merged, override keys win
-//   - List fields (volumes): append override to base
+//   - Map fields (env, build_args): keys are merged, override keys win
+//   - List fields (volumes, ports): append override to base
 //
 // Returns a new Config with the merged values.
 func Merge(base, override Config) Config {
@@ -13,18 +13,138 @@ func Merge(base, override Config) Config {
 	if override.Runtime != "" {
 		result.Runtime = override.Runtime
 	}
+	if override.Name != "" {
+		result.Name = override.Name
+	}
 	if override.Image != "" {
 		result.Image = override.Image
 	}
 	if override.WorkingDir != "" {
 		result.WorkingDir = override.WorkingDir
 	}
+	if override.User != "" {
+		result.User = override.User
+	}
+	if override.Entrypoint != nil {
+		result.Entrypoint = override.Entrypoint
+	}
 	if override.Dockerfile != "" {
 		result.Dockerfile = override.Dockerfile
+		// A Dockerfile set by a later layer replaces the architecture-specific
+		// Dockerfiles of earlier layers, which would otherwise take precedence over it
+		result.Dockerfiles = nil
+	}
+	if override.Context != "" {
+		result.Context = override.Context
+	}
+	if override.Target != "" {
+		result.Target = override.Target
+	}
+	if override.Platform != "" {
+		result.Platform = override.Platform
+	}
+	if override.ReadOnly {
+		result.ReadOnly = true
+	}
+	if override.ForceRebuild {
+		result.ForceRebuild = true
+	}
+	if override.NoCache {
+		result.NoCache = true
+	}
+	if override.KeepIntermediate {
+		result.KeepIntermediate = true
+	}
+	if override.PullPolicy != "" {
+		result.PullPolicy = override.PullPolicy
+	}
+	if override.Progress != "" {
+		result.Progress = override.Progress
+	}
+	if override.DockerConfig != "" {
+		result.DockerConfig = override.DockerConfig
 	}
 	if override.Network != "" {
 		result.Network = override.Network
 	}
+	if override.NoGateway {
+		result.NoGateway = true
+	}
+	if override.Ref != "" {
+		result.Ref = override.Ref
+	}
+	if override.Symlinks {
+		result.Symlinks = true
+	}
+	if override.Submodules {
+		result.Submodules = true
+	}
+	if override.Stream {
+		result.Stream = true
+	}
+	if override.Chown != "" {
+		result.Chown = override.Chown
+	}
+	if override.NoGit {
+		result.NoGit = true
+	}
+	if override.MaxArchive != "" {
+		result.MaxArchive = override.MaxArchive
+	}
+	if override.AutoPush {
+		result.AutoPush = true
+	}
+	if override.ResetBranch {
+		result.ResetBranch = true
+	}
+	if override.DryRun {
+		result.DryRun = true
+	}
+	if override.PrintConfig {
+		result.PrintConfig = true
+	}
+	if override.Redact != "" {
+		result.Redact = override.Redact
+	}
+	if override.Reap {
+		result.Reap = true
+	}
+	if override.Keep {
+		result.Keep = true
+	}
+	if override.RmImage {
+		result.RmImage = true
+	}
+	if override.Restart != "" {
+		result.Restart = override.Restart
+	}
+	if override.NoTTY {
+		result.NoTTY = true
+	}
+	if override.NoAttach {
+		result.NoAttach = true
+	}
+	if override.LogFormat != "" {
+		result.LogFormat = override.LogFormat
+	}
+	if override.LogFile != nil {
+		result.LogFile = override.LogFile
+	}
+	if override.Quiet {
+		result.Quiet = true
+	}
+	if override.Verbose {
+		result.Verbose = true
+	}
+	if override.Timestamps {
+		result.Timestamps = true
+	}
+	if override.Memory != "" {
+		result.Memory = override.Memory
+	}
+	if override.CPUs != 0 {
+		result.CPUs = override.CPUs
+	}
 	if override.StopTimeout != 0 {
 		result.StopTimeout = override.StopTimeout
 	}
@@ -34,19 +154,64 @@ func Merge(base, override Config) Config {
 	if override.RetryDelay != 0 {
 		result.RetryDelay = override.RetryDelay
 	}
+	if override.TTYResync != 0 {
+		result.TTYResync = override.TTYResync
+	}
+	if override.Timeout != 0 {
+		result.Timeout = override.Timeout
+	}
 	if override.Git.User.Name != "" {
 		result.Git.User.Name = override.Git.User.Name
 	}
 	if override.Git.User.Email != "" {
 		result.Git.User.Email = override.Git.User.Email
 	}
+	if override.Git.ServerAddress != "" {
+		result.Git.ServerAddress = override.Git.ServerAddress
+	}
+	if override.Git.TLS {
+		result.Git.TLS = true
+	}
+	if override.Git.TLSCert != "" {
+		result.Git.TLSCert = override.Git.TLSCert
+	}
+	if override.Git.TLSKey != "" {
+		result.Git.TLSKey = override.Git.TLSKey
+	}
+	if override.Git.ReadOnly {
+		result.Git.ReadOnly = true
+	}
 
 	// Env map merge
 	result.Env = MergeEnv(base.Env, override.Env)
 
+	// Architecture-specific Dockerfiles map merge
+	result.Dockerfiles = MergeEnv(result.Dockerfiles, override.Dockerfiles)
+
+	// Build args map merge
+	result.BuildArgs = MergeEnv(base.BuildArgs, override.BuildArgs)
+
+	// Labels map merge
+	result.Labels = MergeEnv(base.Labels, override.Labels)
+
+	// Image labels map merge
+	result.ImageLabels = MergeEnv(base.ImageLabels, override.ImageLabels)
+
 	// Volumes list append
 	result.Volumes = append(result.Volumes, override.Volumes...)
 
+	// Tmpfs list append
+	result.Tmpfs = append(result.Tmpfs, override.Tmpfs...)
+
+	// Secrets list append
+	result.Secrets = append(result.Secrets, override.Secrets...)
+
+	// Ports list append
+	result.Ports = append(result.Ports, override.Ports...)
+
+	// Extra hosts list append
+	result.ExtraHosts = append(result.ExtraHosts, override.ExtraHosts...)
+
 	return result
 }
 
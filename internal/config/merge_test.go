@@ -64,13 +64,19 @@ func TestMerge(t *testing.T) {
 		}
 
 		override := Config{
-			Image:       "override-image",
-			WorkingDir:  "/override",
-			Dockerfile:  "Dockerfile.override",
-			Network:     "override-network",
-			StopTimeout: 10,
-			TTYRetries:  7,
-			RetryDelay:  10 * time.Millisecond,
+			Image:        "override-image",
+			WorkingDir:   "/override",
+			Dockerfile:   "Dockerfile.override",
+			Network:      "override-network",
+			StopTimeout:  10,
+			TTYRetries:   7,
+			RetryDelay:   10 * time.Millisecond,
+			TTYResync:    2 * time.Second,
+			Restart:      "on-failure:3",
+			Name:         "override-name",
+			Progress:     "plain",
+			Target:       "dev",
+			DockerConfig: "/override/docker",
 			Git: GitConfig{
 				User: GitUserConfig{
 					Name:  "Override User",
@@ -88,6 +94,12 @@ func TestMerge(t *testing.T) {
 		require.Equal(t, 10, result.StopTimeout)
 		require.Equal(t, 7, result.TTYRetries)
 		require.Equal(t, 10*time.Millisecond, result.RetryDelay)
+		require.Equal(t, 2*time.Second, result.TTYResync)
+		require.Equal(t, "on-failure:3", result.Restart)
+		require.Equal(t, "override-name", result.Name)
+		require.Equal(t, "plain", result.Progress)
+		require.Equal(t, "dev", result.Target)
+		require.Equal(t, "/override/docker", result.DockerConfig)
 		require.Equal(t, "Override User", result.Git.User.Name)
 		require.Equal(t, "override@example.com", result.Git.User.Email)
 	})
@@ -151,6 +163,38 @@ func TestMerge(t *testing.T) {
 		require.Equal(t, expected, result.Env)
 	})
 
+	t.Run("build args maps are merged with override precedence", func(t *testing.T) {
+		base := Config{
+			BuildArgs: map[string]string{
+				"BASE_VERSION": "1.0",
+				"BASE_ONLY":    "base",
+			},
+		}
+
+		override := Config{
+			BuildArgs: map[string]string{
+				"BASE_VERSION": "2.0",
+			},
+		}
+
+		result := Merge(base, override)
+
+		require.Equal(t, map[string]string{
+			"BASE_VERSION": "2.0",
+			"BASE_ONLY":    "base",
+		}, result.BuildArgs)
+	})
+
+	t.Run("no_tty can be enabled but not disabled by an override", func(t *testing.T) {
+		require.True(t, Merge(Config{NoTTY: false}, Config{NoTTY: true}).NoTTY)
+		require.True(t, Merge(Config{NoTTY: true}, Config{NoTTY: false}).NoTTY)
+	})
+
+	t.Run("no_attach can be enabled but not disabled by an override", func(t *testing.T) {
+		require.True(t, Merge(Config{NoAttach: false}, Config{NoAttach: true}).NoAttach)
+		require.True(t, Merge(Config{NoAttach: true}, Config{NoAttach: false}).NoAttach)
+	})
+
 	t.Run("volumes are appended", func(t *testing.T) {
 		base := Config{
 			Volumes: []string{"/base/vol1", "/base/vol2"},
@@ -166,6 +210,114 @@ func TestMerge(t *testing.T) {
 		require.Equal(t, expected, result.Volumes)
 	})
 
+	t.Run("ports are appended", func(t *testing.T) {
+		base := Config{
+			Ports: []string{"8080:3000"},
+		}
+
+		override := Config{
+			Ports: []string{"5353:53/udp"},
+		}
+
+		result := Merge(base, override)
+
+		require.Equal(t, []string{"8080:3000", "5353:53/udp"}, result.Ports)
+	})
+
+	t.Run("secrets are appended", func(t *testing.T) {
+		base := Config{
+			Secrets: []string{"id=npmrc,src=/base/npmrc"},
+		}
+
+		override := Config{
+			Secrets: []string{"id=netrc,src=/override/netrc"},
+		}
+
+		result := Merge(base, override)
+
+		require.Equal(t, []string{"id=npmrc,src=/base/npmrc", "id=netrc,src=/override/netrc"}, result.Secrets)
+	})
+
+	t.Run("labels maps are merged with override precedence", func(t *testing.T) {
+		base := Config{
+			Labels: map[string]string{
+				"team":  "platform",
+				"owner": "alice",
+			},
+		}
+
+		override := Config{
+			Labels: map[string]string{
+				"team": "infra",
+			},
+		}
+
+		result := Merge(base, override)
+
+		require.Equal(t, map[string]string{
+			"team":  "infra",
+			"owner": "alice",
+		}, result.Labels)
+	})
+
+	t.Run("architecture-specific dockerfiles are merged unless the override sets a dockerfile", func(t *testing.T) {
+		base := Config{
+			Dockerfiles: map[string]string{
+				"amd64": "Dockerfile.amd64",
+				"arm64": "Dockerfile.arm64",
+			},
+		}
+
+		result := Merge(base, Config{Dockerfiles: map[string]string{"arm64": "Dockerfile.graviton"}})
+		require.Equal(t, map[string]string{
+			"amd64": "Dockerfile.amd64",
+			"arm64": "Dockerfile.graviton",
+		}, result.Dockerfiles)
+
+		result = Merge(base, Config{Dockerfile: "Dockerfile.dev"})
+		require.Equal(t, "Dockerfile.dev", result.Dockerfile)
+		require.Empty(t, result.Dockerfiles)
+
+		result = Merge(base, Config{Dockerfile: "Dockerfile.dev", Dockerfiles: map[string]string{"arm64": "Dockerfile.dev.arm64"}})
+		require.Equal(t, map[string]string{"arm64": "Dockerfile.dev.arm64"}, result.Dockerfiles)
+	})
+
+	t.Run("entrypoint overrides when set, even to empty", func(t *testing.T) {
+		entrypoint := "/bin/sh"
+		reset := ""
+
+		result := Merge(Config{Entrypoint: &entrypoint}, Config{})
+		require.Equal(t, &entrypoint, result.Entrypoint)
+
+		result = Merge(Config{Entrypoint: &entrypoint}, Config{Entrypoint: &reset})
+		require.Equal(t, &reset, result.Entrypoint)
+	})
+
+	t.Run("log file overrides when set, even to empty", func(t *testing.T) {
+		logFile := "/var/log/{session}.log"
+		disabled := ""
+
+		result := Merge(Config{LogFile: &logFile}, Config{})
+		require.Equal(t, &logFile, result.LogFile)
+
+		result = Merge(Config{LogFile: &logFile}, Config{LogFile: &disabled})
+		require.Equal(t, &disabled, result.LogFile)
+	})
+
+	t.Run("extra hosts are appended", func(t *testing.T) {
+		base := Config{
+			ExtraHosts: []string{"db.local:10.0.0.5"},
+		}
+
+		override := Config{
+			ExtraHosts: []string{"host.local:host-gateway"},
+		}
+
+		result := Merge(base, override)
+
+		require.Equal(t, []string{"db.local:10.0.0.5", "host.local:host-gateway"}, result.ExtraHosts)
+	})
+
 	t.Run("empty base with override", func(t *testing.T) {
 		base := Config{}
 
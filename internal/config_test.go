@@ -1,12 +1,18 @@
 package internal_test
 
 import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 
 	"github.com/ryanmoran/contagent/internal"
+	cfgpkg "github.com/ryanmoran/contagent/internal/config"
 )
 
 func TestConfig(t *testing.T) {
@@ -64,6 +70,53 @@ func TestConfig(t *testing.T) {
 			}, config.Env)
 		})
 
+		t.Run("with --env-file flags", func(t *testing.T) {
+			dir := t.TempDir()
+			err := os.WriteFile(filepath.Join(dir, "vars.env"), []byte(
+				"# comment\n"+
+					"\n"+
+					"FROM_FILE=file-value\n"+
+					"OVERRIDDEN=file-value\n"+
+					"URL=https://example.com/?a=b\n",
+			), 0o600)
+			require.NoError(t, err)
+
+			args := []string{
+				"--runtime", "apple",
+				"--env-file", "vars.env",
+				"--env", "OVERRIDDEN=flag-value",
+				"some-program",
+			}
+			env := []string{
+				"TERM=some-term",
+				"COLORTERM=some-color-term",
+			}
+
+			config, err := internal.ParseConfig(args, env, dir)
+			require.NoError(t, err)
+			require.ElementsMatch(t, []string{
+				"TERM=some-term",
+				"COLORTERM=some-color-term",
+				"FROM_FILE=file-value",
+				"OVERRIDDEN=flag-value",
+				"URL=https://example.com/?a=b",
+			}, config.Env)
+		})
+
+		t.Run("with a missing --env-file", func(t *testing.T) {
+			args := []string{
+				"--env-file", "/nonexistent/vars.env",
+				"some-program",
+			}
+			env := []string{
+				"TERM=some-term",
+			}
+
+			_, err := internal.ParseConfig(args, env, ".")
+			require.Error(t, err)
+			require.Contains(t, err.Error(), "cannot read env file /nonexistent/vars.env")
+		})
+
 		t.Run("with --volume flags", func(t *testing.T) {
 			args := []string{
 				"--runtime", "docker",
@@ -83,6 +136,181 @@ func TestConfig(t *testing.T) {
 			}, config.Volumes)
 		})
 
+		t.Run("with valid --volume specs", func(t *testing.T) {
+			for _, volume := range []string{
+				"/host/path:/container/path",
+				"./relative:/container/path",
+				"myvolume:/container/path",
+				"/host/path:/container/path:ro",
+				"/host/path:/container/path:rw,z",
+				"/host/path:/container/path:cached",
+			} {
+				args := []string{
+					"--runtime", "apple",
+					"--volume", volume,
+					"some-program",
+				}
+				env := []string{
+					"TERM=some-term",
+				}
+
+				_, err := internal.ParseConfig(args, env, ".")
+				require.NoError(t, err, volume)
+			}
+		})
+
+		t.Run("with malformed --volume specs", func(t *testing.T) {
+			for volume, message := range map[string]string{
+				"/path":                       "expected HOST:CONTAINER[:MODE]",
+				"/a:/b:ro:extra":              "expected HOST:CONTAINER[:MODE]",
+				":/container/path":            "host path or volume name is empty",
+				"/host/path:relative":         `container path "relative" must be absolute`,
+				"/host/path:":                 `container path "" must be absolute`,
+				"/host/path:/container:bogus": `unknown mode "bogus"`,
+				"/host/path:/container:ro,rw": `mode cannot combine "ro" and "rw"`,
+			} {
+				args := []string{
+					"--runtime", "apple",
+					"--volume", volume,
+					"some-program",
+				}
+				env := []string{
+					"TERM=some-term",
+				}
+
+				_, err := internal.ParseConfig(args, env, ".")
+				require.Error(t, err, volume)
+				require.Contains(t, err.Error(), message, volume)
+			}
+		})
+
+		t.Run("with --read-only and --tmpfs flags", func(t *testing.T) {
+			args := []string{
+				"--runtime", "docker",
+				"--read-only",
+				"--tmpfs", "/tmp",
+				"--tmpfs", "/run/:size=64m",
+				"some-program",
+			}
+			env := []string{
+				"TERM=some-term",
+			}
+
+			config, err := internal.ParseConfig(args, env, ".")
+			require.NoError(t, err)
+			require.True(t, config.ReadOnly)
+			require.Equal(t, map[string]string{"/tmp": "", "/run": "size=64m"}, config.Tmpfs)
+			require.Equal(t, []string{
+				"/var/run/docker.sock:/var/run/docker.sock",
+				"/run/host-services/ssh-auth.sock:/run/host-services/ssh-auth.sock",
+				"/app",
+			}, config.Volumes, "the working directory should be an anonymous volume")
+		})
+
+		t.Run("with --read-only and a volume on the working directory", func(t *testing.T) {
+			args := []string{
+				"--runtime", "docker",
+				"--read-only",
+				"--volume", "workspace:/app",
+				"some-program",
+			}
+			env := []string{
+				"TERM=some-term",
+			}
+
+			config, err := internal.ParseConfig(args, env, ".")
+			require.NoError(t, err)
+			require.Equal(t, []string{
+				"/var/run/docker.sock:/var/run/docker.sock",
+				"/run/host-services/ssh-auth.sock:/run/host-services/ssh-auth.sock",
+				"workspace:/app",
+			}, config.Volumes)
+		})
+
+		t.Run("with invalid --tmpfs flags", func(t *testing.T) {
+			for tmpfs, message := range map[string]string{
+				"tmp":         `container path "tmp" must be absolute`,
+				":size=64m":   `container path "" must be absolute`,
+				"/app/":       "the repository is copied to /app before the container starts",
+				"/app:size=1": "the repository is copied to /app before the container starts",
+			} {
+				args := []string{
+					"--runtime", "docker",
+					"--tmpfs", tmpfs,
+					"some-program",
+				}
+				env := []string{
+					"TERM=some-term",
+				}
+
+				_, err := internal.ParseConfig(args, env, ".")
+				require.Error(t, err, tmpfs)
+				require.Contains(t, err.Error(), message, tmpfs)
+			}
+		})
+
+		t.Run("with --read-only on the apple runtime", func(t *testing.T) {
+			_, err := internal.ParseConfig([]string{"--runtime", "apple", "--read-only", "some-program"}, []string{"TERM=some-term"}, ".")
+			require.ErrorContains(t, err, "--read-only and --tmpfs are not supported by the apple runtime")
+		})
+
+		t.Run("with --add-host and --no-host-gateway flags", func(t *testing.T) {
+			args := []string{
+				"--add-host", "db.local:10.0.0.5",
+				"--add-host", "host.local:host-gateway",
+				"--no-host-gateway",
+				"some-program",
+			}
+			env := []string{
+				"TERM=some-term",
+			}
+
+			config, err := internal.ParseConfig(args, env, ".")
+			require.NoError(t, err)
+			require.Equal(t, []string{"db.local:10.0.0.5", "host.local:host-gateway"}, config.ExtraHosts)
+			require.True(t, config.NoGateway)
+		})
+
+		t.Run("with malformed --add-host specs", func(t *testing.T) {
+			for host, message := range map[string]string{
+				"db.local":             "expected NAME:IP",
+				":10.0.0.5":            "expected NAME:IP",
+				"db.local:not-an-ip":   `"not-an-ip" must be an IP address or host-gateway`,
+				"db.local:10.0.0.5:80": `"10.0.0.5:80" must be an IP address or host-gateway`,
+			} {
+				args := []string{
+					"--add-host", host,
+					"some-program",
+				}
+				env := []string{
+					"TERM=some-term",
+				}
+
+				_, err := internal.ParseConfig(args, env, ".")
+				require.Error(t, err, host)
+				require.Contains(t, err.Error(), message, host)
+			}
+		})
+
+		t.Run("with --volume-ro flags", func(t *testing.T) {
+			args := []string{
+				"--runtime", "docker",
+				"--volume-ro", "/host/path:/container/path",
+				"some-program",
+			}
+			env := []string{
+				"TERM=some-term",
+			}
+
+			config, err := internal.ParseConfig(args, env, ".")
+			require.NoError(t, err)
+			require.Equal(t, []string{
+				"/var/run/docker.sock:/var/run/docker.sock",
+				"/run/host-services/ssh-auth.sock:/run/host-services/ssh-auth.sock",
+				"/host/path:/container/path:ro",
+			}, config.Volumes)
+		})
+
 		t.Run("with relative --volume host path", func(t *testing.T) {
 			dir := t.TempDir()
 			args := []string{
@@ -186,6 +414,43 @@ func TestConfig(t *testing.T) {
 			}, config.Volumes)
 		})
 
+		t.Run("returns a usage error for an unknown flag", func(t *testing.T) {
+			args := []string{
+				"--not-a-flag", "value",
+				"some-program",
+			}
+			env := []string{
+				"TERM=some-term",
+			}
+
+			config, err := internal.ParseConfig(args, env, ".")
+			require.Error(t, err)
+			require.Contains(t, err.Error(), "flag provided but not defined: -not-a-flag")
+			require.Equal(t, internal.Config{}, config)
+
+			var usageErr *cfgpkg.UsageError
+			require.ErrorAs(t, err, &usageErr)
+			require.Contains(t, usageErr.Usage, "Usage: contagent [flags] [command [args...]]")
+			require.Contains(t, usageErr.Usage, "-dockerfile")
+		})
+
+		t.Run("returns a usage error for an invalid flag value", func(t *testing.T) {
+			args := []string{
+				"--stop-timeout", "soon",
+				"some-program",
+			}
+			env := []string{
+				"TERM=some-term",
+			}
+
+			_, err := internal.ParseConfig(args, env, ".")
+			require.Error(t, err)
+			require.Contains(t, err.Error(), `invalid value "soon" for flag -stop-timeout`)
+
+			var usageErr *cfgpkg.UsageError
+			require.ErrorAs(t, err, &usageErr)
+		})
+
 		t.Run("returns error when config loading fails with invalid retry delay", func(t *testing.T) {
 			args := []string{
 				"--retry-delay", "not-a-duration",
@@ -201,6 +466,34 @@ func TestConfig(t *testing.T) {
 			require.Equal(t, internal.Config{}, config)
 		})
 
+		t.Run("when given --stop-timeout, --tty-retries, and --retry-delay flags", func(t *testing.T) {
+			config, err := internal.ParseConfig([]string{
+				"--stop-timeout", "30",
+				"--tty-retries", "5",
+				"--retry-delay", "50ms",
+				"some-program",
+			}, []string{"TERM=some-term"}, ".")
+			require.NoError(t, err)
+			require.Equal(t, 30, config.StopTimeout)
+			require.Equal(t, 5, config.TTYRetries)
+			require.Equal(t, 50*time.Millisecond, config.RetryDelay)
+		})
+
+		t.Run("returns error for a negative --stop-timeout", func(t *testing.T) {
+			_, err := internal.ParseConfig([]string{"--stop-timeout", "-1", "some-program"}, []string{"TERM=some-term"}, ".")
+			require.ErrorContains(t, err, "invalid stop timeout -1: must not be negative")
+		})
+
+		t.Run("returns error for a negative --tty-retries", func(t *testing.T) {
+			_, err := internal.ParseConfig([]string{"--tty-retries", "-3", "some-program"}, []string{"TERM=some-term"}, ".")
+			require.ErrorContains(t, err, "invalid TTY retries -3: must not be negative")
+		})
+
+		t.Run("returns error for a negative --retry-delay", func(t *testing.T) {
+			_, err := internal.ParseConfig([]string{"--retry-delay", "-5ms", "some-program"}, []string{"TERM=some-term"}, ".")
+			require.ErrorContains(t, err, "invalid retry delay -5ms: must not be negative")
+		})
+
 		t.Run("returns error when global config file is set but does not exist", func(t *testing.T) {
 			args := []string{"some-program"}
 			env := []string{
@@ -241,5 +534,950 @@ func TestConfig(t *testing.T) {
 			require.NoError(t, err)
 			require.Equal(t, "some-network", config.Network)
 		})
-	})
+
+		t.Run("when given an --image-name flag", func(t *testing.T) {
+			args := []string{
+				"--image-name", "registry.example.com:5000/team/agent:v1.2.3",
+				"some-program",
+			}
+			env := []string{
+				"TERM=some-term",
+			}
+
+			config, err := internal.ParseConfig(args, env, ".")
+			require.NoError(t, err)
+			require.Equal(t, internal.ImageName("registry.example.com:5000/team/agent:v1.2.3"), config.ImageName)
+		})
+
+		t.Run("when given a --name flag", func(t *testing.T) {
+			args := []string{
+				"--name", "fix-login",
+				"some-program",
+			}
+			env := []string{
+				"TERM=some-term",
+			}
+
+			config, err := internal.ParseConfig(args, env, ".")
+			require.NoError(t, err)
+			require.Equal(t, "fix-login", config.Name)
+		})
+
+		t.Run("when given an invalid --name flag", func(t *testing.T) {
+			args := []string{
+				"--name", "fix login",
+				"some-program",
+			}
+			env := []string{
+				"TERM=some-term",
+			}
+
+			_, err := internal.ParseConfig(args, env, ".")
+			require.ErrorContains(t, err, `invalid name "fix login"`)
+		})
+
+		t.Run("when given an invalid --image-name flag", func(t *testing.T) {
+			args := []string{
+				"--image-name", "Team/Agent:latest",
+				"some-program",
+			}
+			env := []string{
+				"TERM=some-term",
+			}
+
+			_, err := internal.ParseConfig(args, env, ".")
+			require.ErrorContains(t, err, `invalid image name "Team/Agent:latest": repository must be lowercase`)
+		})
+
+		t.Run("when not given an --image-name flag", func(t *testing.T) {
+			args := []string{
+				"some-program",
+			}
+			env := []string{
+				"TERM=some-term",
+			}
+
+			config, err := internal.ParseConfig(args, env, ".")
+			require.NoError(t, err)
+			require.Equal(t, internal.ImageName("contagent:latest"), config.ImageName)
+		})
+
+		t.Run("with --build-arg flags", func(t *testing.T) {
+			args := []string{
+				"--build-arg", "BASE_VERSION=1.0",
+				"--build-arg", "OTHER=value",
+				"--build-arg", "BASE_VERSION=2.0",
+				"some-program",
+			}
+			env := []string{
+				"TERM=some-term",
+			}
+
+			config, err := internal.ParseConfig(args, env, ".")
+			require.NoError(t, err)
+			require.Equal(t, map[string]string{
+				"BASE_VERSION": "2.0",
+				"OTHER":        "value",
+			}, config.BuildArgs)
+		})
+
+		t.Run("with --label flags", func(t *testing.T) {
+			args := []string{
+				"--label", "team=platform",
+				"--label", "owner=alice",
+				"--label", "team=infra",
+				"--label", "ephemeral",
+				"some-program",
+			}
+			env := []string{
+				"TERM=some-term",
+			}
+
+			config, err := internal.ParseConfig(args, env, ".")
+			require.NoError(t, err)
+			require.Equal(t, map[string]string{
+				"team":      "infra",
+				"owner":     "alice",
+				"ephemeral": "",
+			}, config.Labels)
+		})
+
+		t.Run("with invalid --label flags", func(t *testing.T) {
+			for label, message := range map[string]string{
+				"=value":                 "key is empty",
+				"contagent.session=mine": `invalid label "contagent.session"`,
+			} {
+				args := []string{
+					"--label", label,
+					"some-program",
+				}
+				env := []string{
+					"TERM=some-term",
+				}
+
+				_, err := internal.ParseConfig(args, env, ".")
+				require.Error(t, err, label)
+				require.Contains(t, err.Error(), message, label)
+			}
+		})
+
+		t.Run("with --image-label flags", func(t *testing.T) {
+			config, err := internal.ParseConfig([]string{"--image-label", "team=platform", "--image-label", "ephemeral", "some-program"}, []string{"TERM=some-term"}, ".")
+			require.NoError(t, err)
+			require.Equal(t, map[string]string{"team": "platform", "ephemeral": ""}, config.ImageLabels)
+
+			for label, message := range map[string]string{
+				"=value":                 "For example: --image-label team=platform",
+				"contagent.session=mine": `invalid label "contagent.session": the label is set by contagent to the session ID`,
+				"contagent.commit=abc":   `invalid label "contagent.commit": the label is set by contagent to the commit of the session`,
+			} {
+				_, err := internal.ParseConfig([]string{"--image-label", label, "some-program"}, []string{"TERM=some-term"}, ".")
+				require.Error(t, err, label)
+				require.Contains(t, err.Error(), message, label)
+			}
+		})
+
+		t.Run("when given a --context flag", func(t *testing.T) {
+			args := []string{
+				"--dockerfile", "/some/path/to/a/Dockerfile",
+				"--context", "/some/path",
+				"some-program",
+			}
+			env := []string{
+				"TERM=some-term",
+			}
+
+			config, err := internal.ParseConfig(args, env, ".")
+			require.NoError(t, err)
+			require.Equal(t, "/some/path", config.BuildContext)
+		})
+
+		t.Run("when given an --attach=false flag", func(t *testing.T) {
+			config, err := internal.ParseConfig([]string{"--runtime", "docker", "--attach=false", "some-program"}, []string{"TERM=some-term"}, ".")
+			require.NoError(t, err)
+			require.True(t, config.NoAttach)
+
+			config, err = internal.ParseConfig([]string{"--runtime", "docker", "some-program"}, []string{"TERM=some-term"}, ".")
+			require.NoError(t, err)
+			require.False(t, config.NoAttach)
+
+			_, err = internal.ParseConfig([]string{"--runtime", "apple", "--attach=false", "some-program"}, []string{"TERM=some-term"}, ".")
+			require.ErrorContains(t, err, "--attach=false is not supported by the apple runtime")
+		})
+
+		t.Run("when given a --target flag", func(t *testing.T) {
+			args := []string{
+				"--target", "dev",
+				"some-program",
+			}
+			env := []string{
+				"TERM=some-term",
+			}
+
+			config, err := internal.ParseConfig(args, env, ".")
+			require.NoError(t, err)
+			require.Equal(t, "dev", config.BuildTarget)
+		})
+
+		t.Run("when given architecture-specific Dockerfiles", func(t *testing.T) {
+			args := []string{
+				"--dockerfile", "Dockerfile",
+				"--dockerfile-amd64", "Dockerfile.amd64",
+				"--dockerfile-arm64", "Dockerfile.arm64",
+				"some-program",
+			}
+
+			for arch, dockerfile := range map[string]string{
+				"amd64":   "Dockerfile.amd64",
+				"arm64":   "Dockerfile.arm64",
+				"riscv64": "Dockerfile",
+			} {
+				internal.SetHostArch(t, arch)
+
+				config, err := internal.ParseConfig(args, []string{"TERM=some-term"}, ".")
+				require.NoError(t, err)
+				require.Equal(t, dockerfile, config.DockerfilePath, arch)
+			}
+
+			// The platform's architecture takes precedence over the host's
+			internal.SetHostArch(t, "amd64")
+			config, err := internal.ParseConfig(append([]string{"--platform", "linux/arm64/v8"}, args...), []string{"TERM=some-term"}, ".")
+			require.NoError(t, err)
+			require.Equal(t, "Dockerfile.arm64", config.DockerfilePath)
+
+			t.Run("from a config file", func(t *testing.T) {
+				dir := t.TempDir()
+				require.NoError(t, os.WriteFile(filepath.Join(dir, ".contagent.yaml"), []byte(
+					"dockerfile: Dockerfile\n"+
+						"dockerfiles:\n"+
+						"  arm64: Dockerfile.arm64\n",
+				), 0o600))
+
+				internal.SetHostArch(t, "arm64")
+				config, err := internal.ParseConfig([]string{"some-program"}, []string{"TERM=some-term"}, dir)
+				require.NoError(t, err)
+				require.Equal(t, "Dockerfile.arm64", config.DockerfilePath)
+
+				// A --dockerfile flag replaces the Dockerfiles of the config file
+				config, err = internal.ParseConfig([]string{"--dockerfile", "Dockerfile.dev", "some-program"}, []string{"TERM=some-term"}, dir)
+				require.NoError(t, err)
+				require.Equal(t, "Dockerfile.dev", config.DockerfilePath)
+			})
+
+			t.Run("for an unknown architecture", func(t *testing.T) {
+				dir := t.TempDir()
+				require.NoError(t, os.WriteFile(filepath.Join(dir, ".contagent.yaml"), []byte(
+					"dockerfiles:\n"+
+						"  x86_64: Dockerfile.amd64\n",
+				), 0o600))
+
+				_, err := internal.ParseConfig([]string{"some-program"}, []string{"TERM=some-term"}, dir)
+				require.ErrorContains(t, err, `invalid dockerfiles architecture "x86_64"`)
+			})
+		})
+
+		t.Run("when given an empty --target flag", func(t *testing.T) {
+			_, err := internal.ParseConfig([]string{"--target", "", "some-program"}, []string{"TERM=some-term"}, ".")
+			require.ErrorContains(t, err, `invalid value "" for flag -target: must name a build stage`)
+		})
+
+		t.Run("when given an invalid --target flag", func(t *testing.T) {
+			for _, target := range []string{"1st", "dev stage", "-dev", "dev:latest"} {
+				_, err := internal.ParseConfig([]string{"--target", target, "some-program"}, []string{"TERM=some-term"}, ".")
+				require.ErrorContains(t, err, fmt.Sprintf("invalid target %q: must be the name of a build stage", target))
+			}
+		})
+
+		t.Run("when given a --git-user-name flag", func(t *testing.T) {
+			args := []string{
+				"--git-user-name", "Alice",
+				"some-program",
+			}
+			env := []string{
+				"TERM=some-term",
+			}
+
+			config, err := internal.ParseConfig(args, env, ".")
+			require.NoError(t, err)
+			require.Equal(t, internal.GitUserConfig{
+				Name:  "Alice",
+				Email: "contagent@example.com",
+			}, config.GitUser)
+		})
+
+		t.Run("when given a --git-user-email flag", func(t *testing.T) {
+			args := []string{
+				"--git-user-email", "alice@example.com",
+				"some-program",
+			}
+			env := []string{
+				"TERM=some-term",
+			}
+
+			config, err := internal.ParseConfig(args, env, ".")
+			require.NoError(t, err)
+			require.Equal(t, internal.GitUserConfig{
+				Name:  "Contagent",
+				Email: "alice@example.com",
+			}, config.GitUser)
+		})
+
+		t.Run("when not given git user flags", func(t *testing.T) {
+			args := []string{
+				"some-program",
+			}
+			env := []string{
+				"TERM=some-term",
+			}
+
+			config, err := internal.ParseConfig(args, env, ".")
+			require.NoError(t, err)
+			require.Equal(t, internal.GitUserConfig{
+				Name:  "Contagent",
+				Email: "contagent@example.com",
+			}, config.GitUser)
+		})
+
+		t.Run("when the host git config has a user identity", func(t *testing.T) {
+			repo := t.TempDir()
+			for _, args := range [][]string{
+				{"init", "--quiet"},
+				{"config", "user.name", "Repo User"},
+				{"config", "user.email", "repo@example.com"},
+			} {
+				cmd := exec.Command("git", args...)
+				cmd.Dir = repo
+				output, err := cmd.CombinedOutput()
+				require.NoError(t, err, string(output))
+			}
+
+			env := []string{
+				"TERM=some-term",
+			}
+
+			config, err := internal.ParseConfig([]string{"some-program"}, env, repo)
+			require.NoError(t, err)
+			require.Equal(t, internal.GitUserConfig{
+				Name:  "Repo User",
+				Email: "repo@example.com",
+			}, config.GitUser)
+
+			config, err = internal.ParseConfig([]string{"--git-user-name", "Alice", "some-program"}, env, repo)
+			require.NoError(t, err)
+			require.Equal(t, internal.GitUserConfig{
+				Name:  "Alice",
+				Email: "repo@example.com",
+			}, config.GitUser)
+		})
+
+		t.Run("when given --memory and --cpus flags", func(t *testing.T) {
+			args := []string{
+				"--memory", "512m",
+				"--cpus", "1.5",
+				"some-program",
+			}
+			env := []string{
+				"TERM=some-term",
+			}
+
+			config, err := internal.ParseConfig(args, env, ".")
+			require.NoError(t, err)
+			require.Equal(t, int64(512*1024*1024), config.Memory)
+			require.InDelta(t, 1.5, config.CPUs, 0)
+
+			config, err = internal.ParseConfig([]string{"--memory", "2g", "some-program"}, env, ".")
+			require.NoError(t, err)
+			require.Equal(t, int64(2*1024*1024*1024), config.Memory)
+		})
+
+		t.Run("when not given resource limits", func(t *testing.T) {
+			config, err := internal.ParseConfig([]string{"some-program"}, []string{"TERM=some-term"}, ".")
+			require.NoError(t, err)
+			require.Zero(t, config.Memory)
+			require.Zero(t, config.CPUs)
+		})
+
+		t.Run("returns error for an invalid --memory value", func(t *testing.T) {
+			for _, memory := range []string{"lots", "-1g", "0"} {
+				_, err := internal.ParseConfig([]string{"--memory", memory, "some-program"}, []string{"TERM=some-term"}, ".")
+				require.Error(t, err, memory)
+				require.Contains(t, err.Error(), "invalid memory", memory)
+			}
+		})
+
+		t.Run("returns error for an invalid --cpus value", func(t *testing.T) {
+			_, err := internal.ParseConfig([]string{"--cpus", "-1", "some-program"}, []string{"TERM=some-term"}, ".")
+			require.Error(t, err)
+			require.Contains(t, err.Error(), "invalid cpus -1: must not be negative")
+
+			_, err = internal.ParseConfig([]string{"--cpus", "many", "some-program"}, []string{"TERM=some-term"}, ".")
+			require.Error(t, err)
+			require.Contains(t, err.Error(), `invalid value "many" for flag -cpus`)
+		})
+
+		t.Run("when given --publish flags", func(t *testing.T) {
+			args := []string{
+				"--publish", "8080:3000",
+				"-p", "5353:53/udp",
+				"some-program",
+			}
+			env := []string{
+				"TERM=some-term",
+			}
+
+			config, err := internal.ParseConfig(args, env, ".")
+			require.NoError(t, err)
+			require.Equal(t, []internal.PortMapping{
+				{HostPort: 8080, ContainerPort: 3000, Protocol: "tcp"},
+				{HostPort: 5353, ContainerPort: 53, Protocol: "udp"},
+			}, config.Ports)
+			require.Equal(t, internal.Command{"some-program"}, config.Args)
+		})
+
+		t.Run("returns error for malformed --publish specs", func(t *testing.T) {
+			for port, message := range map[string]string{
+				"3000":           "expected HOST:CONTAINER[/PROTOCOL]",
+				"http:3000":      `host port "http" must be a number between 1 and 65535`,
+				"8080:70000":     `container port "70000" must be a number between 1 and 65535`,
+				"0:3000":         `host port "0" must be a number between 1 and 65535`,
+				"8080:3000/icmp": `unknown protocol "icmp"`,
+			} {
+				_, err := internal.ParseConfig([]string{"--publish", port, "some-program"}, []string{"TERM=some-term"}, ".")
+				require.Error(t, err, port)
+				require.Contains(t, err.Error(), message, port)
+			}
+		})
+
+		t.Run("when given a --force-rebuild flag", func(t *testing.T) {
+			for _, flag := range []string{"--force-rebuild", "--no-cache"} {
+				config, err := internal.ParseConfig([]string{flag, "some-program"}, []string{"TERM=some-term"}, ".")
+				require.NoError(t, err)
+				require.True(t, config.ForceRebuild, flag)
+				require.Equal(t, internal.Command{"some-program"}, config.Args)
+			}
+
+			config, err := internal.ParseConfig([]string{"some-program"}, []string{"TERM=some-term"}, ".")
+			require.NoError(t, err)
+			require.False(t, config.ForceRebuild)
+			require.False(t, config.NoCache)
+
+			config, err = internal.ParseConfig([]string{"--force-rebuild", "some-program"}, []string{"TERM=some-term"}, ".")
+			require.NoError(t, err)
+			require.False(t, config.NoCache, "--force-rebuild still reuses cached layers")
+		})
+
+		t.Run("when given a --no-cache flag", func(t *testing.T) {
+			config, err := internal.ParseConfig([]string{"--no-cache", "some-program"}, []string{"TERM=some-term"}, ".")
+			require.NoError(t, err)
+			require.True(t, config.NoCache)
+			require.True(t, config.ForceRebuild)
+		})
+
+		t.Run("when given a --keep-intermediate flag", func(t *testing.T) {
+			config, err := internal.ParseConfig([]string{"--keep-intermediate", "some-program"}, []string{"TERM=some-term"}, ".")
+			require.NoError(t, err)
+			require.True(t, config.KeepIntermediate)
+
+			config, err = internal.ParseConfig([]string{"some-program"}, []string{"TERM=some-term"}, ".")
+			require.NoError(t, err)
+			require.False(t, config.KeepIntermediate)
+		})
+
+		t.Run("when given a --platform flag", func(t *testing.T) {
+			for _, platform := range []string{"linux/amd64", "linux/arm64/v8"} {
+				config, err := internal.ParseConfig([]string{"--platform", platform, "some-program"}, []string{"TERM=some-term"}, ".")
+				require.NoError(t, err)
+				require.Equal(t, platform, config.Platform)
+			}
+		})
+
+		t.Run("returns error for a malformed --platform", func(t *testing.T) {
+			for platform, message := range map[string]string{
+				"amd64":               "expected OS/ARCH[/VARIANT]",
+				"linux/arm64/v8/more": "expected OS/ARCH[/VARIANT]",
+				"linux/":              `"" is not a valid component`,
+				"Linux/AMD64":         `"Linux" is not a valid component`,
+				"linux/amd 64":        `"amd 64" is not a valid component`,
+			} {
+				_, err := internal.ParseConfig([]string{"--platform", platform, "some-program"}, []string{"TERM=some-term"}, ".")
+				require.Error(t, err, platform)
+				require.Contains(t, err.Error(), message, platform)
+			}
+		})
+
+		t.Run("when given a --log-format flag", func(t *testing.T) {
+			config, err := internal.ParseConfig([]string{"--log-format", "json", "some-program"}, []string{"TERM=some-term"}, ".")
+			require.NoError(t, err)
+			require.Equal(t, internal.LogFormatJSON, config.LogFormat)
+		})
+
+		t.Run("defaults the log format to text", func(t *testing.T) {
+			config, err := internal.ParseConfig([]string{"some-program"}, []string{"TERM=some-term"}, ".")
+			require.NoError(t, err)
+			require.Equal(t, internal.LogFormatText, config.LogFormat)
+		})
+
+		t.Run("returns error for an unknown --log-format", func(t *testing.T) {
+			_, err := internal.ParseConfig([]string{"--log-format", "xml", "some-program"}, []string{"TERM=some-term"}, ".")
+			require.Error(t, err)
+			require.Contains(t, err.Error(), `invalid log format "xml"`)
+		})
+
+		t.Run("when given a --pull-policy flag", func(t *testing.T) {
+			config, err := internal.ParseConfig([]string{"--pull-policy", "always", "some-program"}, []string{"TERM=some-term"}, ".")
+			require.NoError(t, err)
+			require.Equal(t, internal.PullPolicyAlways, config.PullPolicy)
+		})
+
+		t.Run("defaults the pull policy to missing", func(t *testing.T) {
+			config, err := internal.ParseConfig([]string{"some-program"}, []string{"TERM=some-term"}, ".")
+			require.NoError(t, err)
+			require.Equal(t, internal.PullPolicyMissing, config.PullPolicy)
+		})
+
+		t.Run("returns error for an unknown --pull-policy", func(t *testing.T) {
+			_, err := internal.ParseConfig([]string{"--pull-policy", "sometimes", "some-program"}, []string{"TERM=some-term"}, ".")
+			require.ErrorContains(t, err, `invalid pull policy "sometimes"`)
+		})
+
+		t.Run("when given a --progress flag", func(t *testing.T) {
+			config, err := internal.ParseConfig([]string{"--runtime", "docker", "--progress", "plain", "some-program"}, []string{"TERM=some-term"}, ".")
+			require.NoError(t, err)
+			require.Equal(t, internal.BuildProgressPlain, config.Progress)
+
+			_, err = internal.ParseConfig([]string{"--runtime", "apple", "--progress", "plain", "some-program"}, []string{"TERM=some-term"}, ".")
+			require.ErrorContains(t, err, "--progress is not supported by the apple runtime")
+		})
+
+		t.Run("defaults the progress to auto", func(t *testing.T) {
+			config, err := internal.ParseConfig([]string{"some-program"}, []string{"TERM=some-term"}, ".")
+			require.NoError(t, err)
+			require.Equal(t, internal.BuildProgressAuto, config.Progress)
+		})
+
+		t.Run("returns error for an unknown --progress", func(t *testing.T) {
+			_, err := internal.ParseConfig([]string{"--progress", "tty", "some-program"}, []string{"TERM=some-term"}, ".")
+			require.ErrorContains(t, err, `invalid progress "tty": must be "auto" or "plain"`)
+		})
+
+		t.Run("when given a --docker-config flag", func(t *testing.T) {
+			config, err := internal.ParseConfig([]string{"--runtime", "docker", "--docker-config", "/some/docker-config", "some-program"}, []string{"TERM=some-term", "DOCKER_CONFIG=/other/docker-config"}, ".")
+			require.NoError(t, err)
+			require.Equal(t, "/some/docker-config", config.DockerConfig)
+
+			_, err = internal.ParseConfig([]string{"--runtime", "apple", "--docker-config", "/some/docker-config", "some-program"}, []string{"TERM=some-term"}, ".")
+			require.ErrorContains(t, err, "--docker-config is not supported by the apple runtime")
+		})
+
+		t.Run("when given --secret flags", func(t *testing.T) {
+			dir := t.TempDir()
+			require.NoError(t, os.WriteFile(filepath.Join(dir, "npmrc"), []byte("token"), 0600))
+			require.NoError(t, os.WriteFile(filepath.Join(dir, "netrc"), []byte("token"), 0600))
+
+			config, err := internal.ParseConfig([]string{
+				"--runtime", "docker",
+				"--secret", "id=npmrc,src=npmrc",
+				"--secret", "source=" + filepath.Join(dir, "netrc") + ",id=netrc",
+				"some-program",
+			}, []string{"TERM=some-term"}, dir)
+			require.NoError(t, err)
+			require.Equal(t, []internal.BuildSecret{
+				{ID: "npmrc", Source: filepath.Join(dir, "npmrc")},
+				{ID: "netrc", Source: filepath.Join(dir, "netrc")},
+			}, config.Secrets)
+		})
+
+		t.Run("returns error for an invalid --secret flag", func(t *testing.T) {
+			dir := t.TempDir()
+			require.NoError(t, os.WriteFile(filepath.Join(dir, "npmrc"), []byte("token"), 0600))
+
+			for spec, message := range map[string]string{
+				"id=npmrc":                     "must set both id and src",
+				"src=npmrc":                    "must set both id and src",
+				"id=npmrc,src=npmrc,mode=0400": `unknown key "mode"`,
+				"id=npmrc,src=missing":         "Ensure the source file exists",
+				"id=npmrc,src=.":               "is a directory",
+			} {
+				_, err := internal.ParseConfig([]string{"--runtime", "docker", "--secret", spec, "some-program"}, []string{"TERM=some-term"}, dir)
+				require.ErrorContains(t, err, "invalid secret", spec)
+				require.ErrorContains(t, err, message, spec)
+			}
+
+			_, err := internal.ParseConfig([]string{"--runtime", "apple", "--secret", "id=npmrc,src=npmrc", "some-program"}, []string{"TERM=some-term"}, dir)
+			require.ErrorContains(t, err, "--secret is not supported by the apple runtime")
+		})
+
+		t.Run("defaults the Docker config to $DOCKER_CONFIG or ~/.docker", func(t *testing.T) {
+			home := t.TempDir()
+
+			config, err := internal.ParseConfig([]string{"some-program"}, []string{"TERM=some-term", "HOME=" + home, "DOCKER_CONFIG=/other/docker-config"}, ".")
+			require.NoError(t, err)
+			require.Equal(t, "/other/docker-config", config.DockerConfig)
+
+			config, err = internal.ParseConfig([]string{"some-program"}, []string{"TERM=some-term", "HOME=" + home}, ".")
+			require.NoError(t, err)
+			require.Equal(t, filepath.Join(home, ".docker"), config.DockerConfig)
+		})
+
+		t.Run("defaults the log file to ~/.contagent/logs/{session}.log", func(t *testing.T) {
+			home := t.TempDir()
+
+			config, err := internal.ParseConfig([]string{"some-program"}, []string{"TERM=some-term", "HOME=" + home}, ".")
+			require.NoError(t, err)
+			require.Equal(t, filepath.Join(home, ".contagent", "logs", "{session}.log"), config.LogFile)
+
+			config, err = internal.ParseConfig([]string{"--log-file", "/tmp/{session}.log", "some-program"}, []string{"TERM=some-term", "HOME=" + home}, ".")
+			require.NoError(t, err)
+			require.Equal(t, "/tmp/{session}.log", config.LogFile)
+
+			config, err = internal.ParseConfig([]string{"--log-file", "", "some-program"}, []string{"TERM=some-term", "HOME=" + home}, ".")
+			require.NoError(t, err)
+			require.Empty(t, config.LogFile)
+
+			config, err = internal.ParseConfig([]string{"some-program"}, []string{"TERM=some-term"}, ".")
+			require.NoError(t, err)
+			require.Empty(t, config.LogFile)
+		})
+
+		t.Run("when given a --quiet flag", func(t *testing.T) {
+			config, err := internal.ParseConfig([]string{"--quiet", "some-program"}, []string{"TERM=some-term"}, ".")
+			require.NoError(t, err)
+			require.Equal(t, internal.LogLevelWarning, config.LogLevel)
+		})
+
+		t.Run("when given a --verbose flag", func(t *testing.T) {
+			config, err := internal.ParseConfig([]string{"--verbose", "some-program"}, []string{"TERM=some-term"}, ".")
+			require.NoError(t, err)
+			require.Equal(t, internal.LogLevelDebug, config.LogLevel)
+		})
+
+		t.Run("defaults the log level to info", func(t *testing.T) {
+			config, err := internal.ParseConfig([]string{"some-program"}, []string{"TERM=some-term"}, ".")
+			require.NoError(t, err)
+			require.Equal(t, internal.LogLevelInfo, config.LogLevel)
+		})
+
+		t.Run("returns error when given both --quiet and --verbose", func(t *testing.T) {
+			_, err := internal.ParseConfig([]string{"--quiet", "--verbose", "some-program"}, []string{"TERM=some-term"}, ".")
+			require.Error(t, err)
+			require.Contains(t, err.Error(), "quiet and verbose cannot be used together")
+		})
+
+		t.Run("when given a --timestamps flag", func(t *testing.T) {
+			config, err := internal.ParseConfig([]string{"--timestamps", "some-program"}, []string{"TERM=some-term"}, ".")
+			require.NoError(t, err)
+			require.True(t, config.Timestamps)
+		})
+
+		t.Run("when given a --symlinks flag", func(t *testing.T) {
+			config, err := internal.ParseConfig([]string{"--symlinks", "some-program"}, []string{"TERM=some-term"}, ".")
+			require.NoError(t, err)
+			require.True(t, config.Symlinks)
+		})
+
+		t.Run("when given a --include-submodules flag", func(t *testing.T) {
+			config, err := internal.ParseConfig([]string{"--include-submodules", "some-program"}, []string{"TERM=some-term"}, ".")
+			require.NoError(t, err)
+			require.True(t, config.Submodules)
+		})
+
+		t.Run("when given a --max-archive-size flag", func(t *testing.T) {
+			config, err := internal.ParseConfig([]string{"--max-archive-size", "1g", "some-program"}, []string{"TERM=some-term"}, ".")
+			require.NoError(t, err)
+			require.Equal(t, int64(1<<30), config.MaxArchiveSize)
+
+			for _, size := range []string{"lots", "-1g", "0"} {
+				_, err := internal.ParseConfig([]string{"--max-archive-size", size, "some-program"}, []string{"TERM=some-term"}, ".")
+				require.ErrorContains(t, err, "invalid archive size", size)
+			}
+		})
+
+		t.Run("when given a --redact-pattern flag", func(t *testing.T) {
+			config, err := internal.ParseConfig([]string{"some-program"}, []string{"TERM=some-term"}, ".")
+			require.NoError(t, err)
+			require.Equal(t, internal.DefaultSensitivePattern, config.SensitiveEnv.String())
+
+			config, err = internal.ParseConfig([]string{"--redact-pattern", "^DATABASE_", "some-program"}, []string{"TERM=some-term"}, ".")
+			require.NoError(t, err)
+			require.True(t, config.SensitiveEnv.MatchString("DATABASE_URL"))
+			require.False(t, config.SensitiveEnv.MatchString("GITHUB_TOKEN"))
+
+			_, err = internal.ParseConfig([]string{"--redact-pattern", "(TOKEN", "some-program"}, []string{"TERM=some-term"}, ".")
+			require.ErrorContains(t, err, `invalid redact pattern "(TOKEN"`)
+		})
+
+		t.Run("when given a --chown flag", func(t *testing.T) {
+			config, err := internal.ParseConfig([]string{"some-program"}, []string{"TERM=some-term"}, ".")
+			require.NoError(t, err)
+			require.Nil(t, config.Chown)
+
+			config, err = internal.ParseConfig([]string{"--chown", "1000:2000", "some-program"}, []string{"TERM=some-term"}, ".")
+			require.NoError(t, err)
+			require.Equal(t, &internal.Ownership{UID: 1000, GID: 2000}, config.Chown)
+
+			config, err = internal.ParseConfig([]string{"--chown", "0", "some-program"}, []string{"TERM=some-term"}, ".")
+			require.NoError(t, err)
+			require.Equal(t, &internal.Ownership{UID: 0, GID: 0}, config.Chown)
+
+			for _, spec := range []string{"user", "1000:group", "-1", "1000:", ":1000"} {
+				_, err := internal.ParseConfig([]string{"--chown", spec, "some-program"}, []string{"TERM=some-term"}, ".")
+				require.ErrorContains(t, err, "invalid chown", spec)
+			}
+		})
+
+		t.Run("when given a --no-git flag", func(t *testing.T) {
+			config, err := internal.ParseConfig([]string{"--no-git", "some-program"}, []string{"TERM=some-term"}, ".")
+			require.NoError(t, err)
+			require.True(t, config.NoGit)
+
+			_, err = internal.ParseConfig([]string{"--no-git", "--include-submodules", "some-program"}, []string{"TERM=some-term"}, ".")
+			require.ErrorContains(t, err, "--no-git cannot be used with --include-submodules")
+
+			_, err = internal.ParseConfig([]string{"--no-git", "--auto-push", "some-program"}, []string{"TERM=some-term"}, ".")
+			require.ErrorContains(t, err, "--no-git cannot be used with --auto-push")
+		})
+
+		t.Run("when given a --stream-archive flag", func(t *testing.T) {
+			config, err := internal.ParseConfig([]string{"--stream-archive", "some-program"}, []string{"TERM=some-term"}, ".")
+			require.NoError(t, err)
+			require.True(t, config.Stream)
+
+			_, err = internal.ParseConfig([]string{"--stream-archive", "--include-submodules", "some-program"}, []string{"TERM=some-term"}, ".")
+			require.ErrorContains(t, err, "--stream-archive cannot be used with --include-submodules")
+		})
+
+		t.Run("when given a --timeout flag", func(t *testing.T) {
+			config, err := internal.ParseConfig([]string{"--timeout", "45m", "some-program"}, []string{"TERM=some-term"}, ".")
+			require.NoError(t, err)
+			require.Equal(t, 45*time.Minute, config.Timeout)
+		})
+
+		t.Run("when given an --auto-push flag", func(t *testing.T) {
+			config, err := internal.ParseConfig([]string{"--auto-push", "some-program"}, []string{"TERM=some-term"}, ".")
+			require.NoError(t, err)
+			require.True(t, config.AutoPush)
+		})
+
+		t.Run("when given a --reset-branch flag", func(t *testing.T) {
+			config, err := internal.ParseConfig([]string{"--reset-branch", "some-program"}, []string{"TERM=some-term"}, ".")
+			require.NoError(t, err)
+			require.True(t, config.ResetBranch)
+
+			config, err = internal.ParseConfig([]string{"some-program"}, []string{"TERM=some-term"}, ".")
+			require.NoError(t, err)
+			require.False(t, config.ResetBranch)
+		})
+
+		t.Run("when given an --entrypoint flag", func(t *testing.T) {
+			config, err := internal.ParseConfig([]string{"--entrypoint", "/bin/sh", "some-program"}, []string{"TERM=some-term"}, ".")
+			require.NoError(t, err)
+			require.Equal(t, []string{"/bin/sh"}, config.Entrypoint)
+
+			config, err = internal.ParseConfig([]string{"--entrypoint", "", "some-program"}, []string{"TERM=some-term"}, ".")
+			require.NoError(t, err)
+			require.NotNil(t, config.Entrypoint)
+			require.Empty(t, config.Entrypoint)
+
+			config, err = internal.ParseConfig([]string{"some-program"}, []string{"TERM=some-term"}, ".")
+			require.NoError(t, err)
+			require.Nil(t, config.Entrypoint)
+		})
+
+		t.Run("when given a --user flag", func(t *testing.T) {
+			for _, user := range []string{"1000", "1000:1000", "node", "node:staff"} {
+				config, err := internal.ParseConfig([]string{"--runtime", "docker", "--user", user, "some-program"}, []string{"TERM=some-term"}, ".")
+				require.NoError(t, err, user)
+				require.Equal(t, user, config.User)
+			}
+		})
+
+		t.Run("returns error for an invalid --user flag", func(t *testing.T) {
+			for _, user := range []string{":1000", "1000:", "1000:1000:1000"} {
+				_, err := internal.ParseConfig([]string{"--runtime", "docker", "--user", user, "some-program"}, []string{"TERM=some-term"}, ".")
+				require.ErrorContains(t, err, "invalid user", user)
+			}
+
+			_, err := internal.ParseConfig([]string{"--runtime", "apple", "--user", "1000", "some-program"}, []string{"TERM=some-term"}, ".")
+			require.ErrorContains(t, err, "--user is not supported by the apple runtime")
+		})
+
+		t.Run("when given a --reap flag", func(t *testing.T) {
+			config, err := internal.ParseConfig([]string{"--reap", "some-program"}, []string{"TERM=some-term"}, ".")
+			require.NoError(t, err)
+			require.True(t, config.Reap)
+		})
+
+		t.Run("when given a --keep flag", func(t *testing.T) {
+			config, err := internal.ParseConfig([]string{"--keep", "some-program"}, []string{"TERM=some-term"}, ".")
+			require.NoError(t, err)
+			require.True(t, config.Keep)
+		})
+
+		t.Run("when given an --rm-image flag", func(t *testing.T) {
+			config, err := internal.ParseConfig([]string{"--rm-image", "some-program"}, []string{"TERM=some-term"}, ".")
+			require.NoError(t, err)
+			require.True(t, config.RmImage)
+
+			_, err = internal.ParseConfig([]string{"--rm-image", "--keep", "some-program"}, []string{"TERM=some-term"}, ".")
+			require.ErrorContains(t, err, "--keep cannot be used with --rm-image")
+		})
+
+		t.Run("when given a --restart flag", func(t *testing.T) {
+			for spec, policy := range map[string]internal.RestartPolicy{
+				"":               {},
+				"no":             {Name: internal.RestartNo},
+				"on-failure":     {Name: internal.RestartOnFailure},
+				"on-failure:3":   {Name: internal.RestartOnFailure, MaxRetries: 3},
+				"always":         {Name: internal.RestartAlways},
+				"unless-stopped": {Name: internal.RestartUnlessStopped},
+			} {
+				config, err := internal.ParseConfig([]string{"--runtime", "docker", "--restart", spec, "some-program"}, []string{"TERM=some-term"}, ".")
+				require.NoError(t, err, spec)
+				require.Equal(t, policy, config.Restart, spec)
+			}
+		})
+
+		t.Run("returns error for an invalid --restart flag", func(t *testing.T) {
+			for _, spec := range []string{"sometimes", "always:3", "on-failure:", "on-failure:-1", "on-failure:many"} {
+				_, err := internal.ParseConfig([]string{"--runtime", "docker", "--restart", spec, "some-program"}, []string{"TERM=some-term"}, ".")
+				require.ErrorContains(t, err, "invalid restart policy", spec)
+			}
+
+			_, err := internal.ParseConfig([]string{"--runtime", "apple", "--restart", "always", "some-program"}, []string{"TERM=some-term"}, ".")
+			require.ErrorContains(t, err, "--restart is not supported by the apple runtime")
+
+			_, err = internal.ParseConfig([]string{"--runtime", "docker", "--restart", "unless-stopped", "--auto-push", "some-program"}, []string{"TERM=some-term"}, ".")
+			require.ErrorContains(t, err, "--auto-push cannot be used with --restart unless-stopped")
+
+			_, err = internal.ParseConfig([]string{"--runtime", "docker", "--restart", "on-failure", "--auto-push", "some-program"}, []string{"TERM=some-term"}, ".")
+			require.NoError(t, err)
+		})
+
+		t.Run("when given a --workdir flag", func(t *testing.T) {
+			config, err := internal.ParseConfig([]string{"--workdir", "/workspace/project/", "some-program"}, []string{"TERM=some-term"}, ".")
+			require.NoError(t, err)
+			require.Equal(t, "/workspace/project", config.WorkingDir)
+		})
+
+		t.Run("returns error for a working directory that cannot hold the repository", func(t *testing.T) {
+			for _, dir := range []string{"workspace", "/", "/.."} {
+				_, err := internal.ParseConfig([]string{"--workdir", dir, "some-program"}, []string{"TERM=some-term"}, ".")
+				require.Error(t, err, dir)
+				require.Contains(t, err.Error(), "invalid working directory", dir)
+			}
+		})
+
+		t.Run("when given a --ref flag", func(t *testing.T) {
+			config, err := internal.ParseConfig([]string{"--ref", "v1.2.0", "some-program"}, []string{"TERM=some-term"}, ".")
+			require.NoError(t, err)
+			require.Equal(t, "v1.2.0", config.Ref)
+		})
+
+		t.Run("when given a --git-server-address flag", func(t *testing.T) {
+			config, err := internal.ParseConfig([]string{"--git-server-address", "172.17.0.1", "some-program"}, []string{"TERM=some-term"}, ".")
+			require.NoError(t, err)
+			require.Equal(t, "172.17.0.1", config.GitServerAddr)
+		})
+
+		t.Run("returns error for a --git-server-address that is not an IP address", func(t *testing.T) {
+			_, err := internal.ParseConfig([]string{"--git-server-address", "docker0", "some-program"}, []string{"TERM=some-term"}, ".")
+			require.Error(t, err)
+			require.Contains(t, err.Error(), `invalid git server address "docker0"`)
+		})
+
+		t.Run("when given --git-tls flags", func(t *testing.T) {
+			config, err := internal.ParseConfig([]string{"some-program"}, []string{"TERM=some-term"}, ".")
+			require.NoError(t, err)
+			require.False(t, config.GitTLS)
+
+			config, err = internal.ParseConfig([]string{"--git-tls", "some-program"}, []string{"TERM=some-term"}, ".")
+			require.NoError(t, err)
+			require.True(t, config.GitTLS)
+			require.Empty(t, config.GitTLSCert)
+
+			// A certificate implies --git-tls, and relative paths are resolved
+			dir := t.TempDir()
+			config, err = internal.ParseConfig([]string{"--git-tls-cert", "server.crt", "--git-tls-key", "/etc/ssl/server.key", "some-program"}, []string{"TERM=some-term"}, dir)
+			require.NoError(t, err)
+			require.True(t, config.GitTLS)
+			require.Equal(t, filepath.Join(dir, "server.crt"), config.GitTLSCert)
+			require.Equal(t, "/etc/ssl/server.key", config.GitTLSKey)
+
+			_, err = internal.ParseConfig([]string{"--git-tls-cert", "server.crt", "some-program"}, []string{"TERM=some-term"}, ".")
+			require.ErrorContains(t, err, "--git-tls-cert and --git-tls-key must be used together")
+
+			_, err = internal.ParseConfig([]string{"--git-tls", "--no-git", "some-program"}, []string{"TERM=some-term"}, ".")
+			require.ErrorContains(t, err, "--no-git cannot be used with --git-tls")
+		})
+
+		t.Run("when given a --git-read-only flag", func(t *testing.T) {
+			config, err := internal.ParseConfig([]string{"--git-read-only", "some-program"}, []string{"TERM=some-term"}, ".")
+			require.NoError(t, err)
+			require.True(t, config.GitReadOnly)
+
+			_, err = internal.ParseConfig([]string{"--git-read-only", "--no-git", "some-program"}, []string{"TERM=some-term"}, ".")
+			require.ErrorContains(t, err, "--no-git cannot be used with --git-read-only")
+		})
+
+		t.Run("when given a --no-tty flag", func(t *testing.T) {
+			args := []string{
+				"--no-tty",
+				"some-program",
+			}
+			env := []string{
+				"TERM=some-term",
+			}
+
+			config, err := internal.ParseConfig(args, env, ".")
+			require.NoError(t, err)
+			require.True(t, config.NoTTY)
+			require.Equal(t, internal.Command{"some-program"}, config.Args)
+		})
+	})
+}
+
+func TestConfigJSON(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	config, err := internal.ParseConfig([]string{
+		"--runtime", "docker",
+		"--env", "GITHUB_TOKEN=ghp_secret",
+		"--env", "LOG_LEVEL=debug",
+		"--build-arg", "NPM_TOKEN=npm_secret",
+		"--build-arg", "NODE_VERSION=22",
+		"--volume", "/data:/data",
+		"--timeout", "30m",
+		"some-program", "--flag",
+	}, []string{"TERM=some-term", "ANTHROPIC_API_KEY=sk-ant-secret"}, ".")
+	require.NoError(t, err)
+
+	data, err := config.JSON()
+	require.NoError(t, err)
+	require.NotContains(t, string(data), "sk-ant-secret")
+	require.NotContains(t, string(data), "ghp_secret")
+	require.NotContains(t, string(data), "npm_secret")
+
+	var decoded internal.Config
+	require.NoError(t, json.Unmarshal(data, &decoded))
+
+	require.ElementsMatch(t, internal.Environment{
+		"TERM=some-term",
+		"COLORTERM=truecolor",
+		"ANTHROPIC_API_KEY=<redacted>",
+		"SSH_AUTH_SOCK=/run/host-services/ssh-auth.sock",
+		"GITHUB_TOKEN=<redacted>",
+		"LOG_LEVEL=debug",
+	}, decoded.Env)
+	require.Equal(t, map[string]string{
+		"NPM_TOKEN":    "<redacted>",
+		"NODE_VERSION": "22",
+	}, decoded.BuildArgs)
+	require.Equal(t, "npm_secret", config.BuildArgs["NPM_TOKEN"], "the config itself is not redacted")
+	require.Equal(t, internal.Command{"some-program", "--flag"}, decoded.Args)
+	require.Contains(t, decoded.Volumes, "/data:/data")
+
+	// Everything but the environment and build args round-trips
+	decoded.Env = config.Env
+	decoded.BuildArgs = config.BuildArgs
+	require.Equal(t, config, decoded)
 }
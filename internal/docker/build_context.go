@@ -0,0 +1,399 @@
+package docker
+
+import (
+	"archive/tar"
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/ryanmoran/contagent/internal"
+)
+
+// cacheTagPrefix prefixes the tag that identifies an image by a digest of its
+// build inputs, allowing unchanged builds to be skipped.
+const cacheTagPrefix = "cache-"
+
+// externalDockerfileName is the name under which a Dockerfile that lives outside
+// of the build context is added to the context archive.
+const externalDockerfileName = ".contagent.Dockerfile"
+
+// dockerfileName returns the path of the Dockerfile relative to the build context,
+// as expected by the Docker API. Dockerfiles outside of the context are added to the
+// archive under externalDockerfileName.
+func dockerfileName(contextDir, dockerfilePath string) (string, error) {
+	absContext, err := filepath.Abs(contextDir)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve build context %q: %w", contextDir, err)
+	}
+
+	absDockerfile, err := filepath.Abs(dockerfilePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve Dockerfile %q: %w", dockerfilePath, err)
+	}
+
+	rel, err := filepath.Rel(absContext, absDockerfile)
+	if err != nil {
+		return "", fmt.Errorf("failed to locate Dockerfile %q within build context %q: %w", dockerfilePath, contextDir, err)
+	}
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return externalDockerfileName, nil
+	}
+
+	return filepath.ToSlash(rel), nil
+}
+
+// contextDigest returns a hex-encoded SHA-256 digest of everything that affects
+// an image build: the build context as it would be sent to the daemon, the
+// Dockerfile name, the target stage, the build args, the target platform, and the
+// image labels other than internal.SessionLabel and internal.CommitLabel. Those change
+// with every session and commit, while the repository is copied into the container
+// separately, so the image does not depend on them.
+func contextDigest(contextDir, dockerfilePath, name, target string, buildArgs map[string]string, platform string, labels map[string]string) (string, error) {
+	hash := sha256.New()
+
+	tw := tar.NewWriter(hash)
+	if err := writeBuildContext(tw, contextDir, dockerfilePath, name); err != nil {
+		return "", err
+	}
+	if err := tw.Close(); err != nil {
+		return "", fmt.Errorf("failed to finalize build context digest: %w", err)
+	}
+
+	keys := make([]string, 0, len(buildArgs))
+	for key := range buildArgs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	fmt.Fprintf(hash, "dockerfile=%s\n", name)
+	// Only written when set, so that digests of builds without a target stay the same
+	if target != "" {
+		fmt.Fprintf(hash, "target=%s\n", target)
+	}
+	fmt.Fprintf(hash, "platform=%s\n", platform)
+	for _, key := range keys {
+		fmt.Fprintf(hash, "build-arg=%s=%s\n", key, buildArgs[key])
+	}
+
+	labelKeys := make([]string, 0, len(labels))
+	for key := range labels {
+		if key != internal.SessionLabel && key != internal.CommitLabel {
+			labelKeys = append(labelKeys, key)
+		}
+	}
+	sort.Strings(labelKeys)
+	for _, key := range labelKeys {
+		fmt.Fprintf(hash, "label=%s=%s\n", key, labels[key])
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// cacheTagFor returns the image reference used to find a previous build with
+// the same inputs. It keeps the repository of imageName and replaces the tag.
+func cacheTagFor(imageName internal.ImageName, digest string) string {
+	repository := string(imageName)
+	if i := strings.LastIndex(repository, ":"); i > strings.LastIndex(repository, "/") {
+		repository = repository[:i]
+	}
+
+	return repository + ":" + cacheTagPrefix + digest[:16]
+}
+
+// baseImages returns the remote images referenced by FROM instructions in a
+// Dockerfile, in order and without duplicates. References to earlier build stages,
+// "scratch", and images containing ARG variables are skipped.
+func baseImages(dockerfile []byte) []string {
+	var images []string
+	seen := map[string]bool{}
+	stages := map[string]bool{}
+
+	for _, line := range strings.Split(string(dockerfile), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || !strings.EqualFold(fields[0], "FROM") {
+			continue
+		}
+
+		// Skip flags such as --platform
+		args := fields[1:]
+		for len(args) > 0 && strings.HasPrefix(args[0], "--") {
+			args = args[1:]
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		image := args[0]
+		remote := image != "scratch" && !stages[strings.ToLower(image)] && !strings.Contains(image, "$")
+		if len(args) >= 3 && strings.EqualFold(args[1], "AS") {
+			stages[strings.ToLower(args[2])] = true
+		}
+
+		if !remote || seen[image] {
+			continue
+		}
+		seen[image] = true
+		images = append(images, image)
+	}
+
+	return images
+}
+
+// writeContextArchive writes the build context as a tar archive to w, including
+// the end-of-archive marker.
+func writeContextArchive(w io.Writer, contextDir, dockerfilePath, name string) error {
+	tw := tar.NewWriter(w)
+	if err := writeBuildContext(tw, contextDir, dockerfilePath, name); err != nil {
+		return fmt.Errorf("%w\nThis is a system error with tar archive creation", err)
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to finish build context archive: %w\nThis is a system error with tar archive creation", err)
+	}
+	return nil
+}
+
+// writeBuildContext walks contextDir and writes every entry not excluded by a
+// .dockerignore file into tw. The Dockerfile at dockerfilePath is always written
+// under name, even when it lives outside the context or matches an ignore pattern,
+// because the daemon cannot build without it.
+func writeBuildContext(tw *tar.Writer, contextDir, dockerfilePath, name string) error {
+	ignore, err := readDockerignore(contextDir)
+	if err != nil {
+		return err
+	}
+
+	// The Dockerfile and .dockerignore are always sent, matching the Docker CLI.
+	ignore.keep(name, ".dockerignore")
+
+	err = filepath.WalkDir(contextDir, func(absPath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(contextDir, absPath)
+		if err != nil {
+			return fmt.Errorf("failed to get relative path: %w", err)
+		}
+		if relPath == "." {
+			return nil
+		}
+		relPath = filepath.ToSlash(relPath)
+
+		if ignore.excluded(relPath) {
+			if entry.IsDir() && ignore.skippable(relPath) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return fmt.Errorf("failed to stat %s: %w", absPath, err)
+		}
+
+		return addContextEntry(tw, absPath, relPath, info)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to add build context %q to tar archive: %w", contextDir, err)
+	}
+
+	if name == externalDockerfileName {
+		info, err := os.Stat(dockerfilePath)
+		if err != nil {
+			return fmt.Errorf("failed to stat Dockerfile %q: %w", dockerfilePath, err)
+		}
+		if err := addContextEntry(tw, dockerfilePath, name, info); err != nil {
+			return fmt.Errorf("failed to add Dockerfile to tar archive: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// addContextEntry writes a single file, directory, or symlink into the build context.
+// Ownership is reset to root so the build does not depend on host uids.
+func addContextEntry(tw *tar.Writer, absPath, relPath string, info os.FileInfo) error {
+	var link string
+	if info.Mode()&os.ModeSymlink != 0 {
+		var err error
+		link, err = os.Readlink(absPath)
+		if err != nil {
+			return fmt.Errorf("failed to read symlink %s: %w", absPath, err)
+		}
+	}
+
+	header, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return fmt.Errorf("failed to create tar header for %s: %w", absPath, err)
+	}
+	header.Name = relPath
+	if info.IsDir() {
+		header.Name += "/"
+	}
+	header.Uid, header.Gid = 0, 0
+	header.Uname, header.Gname = "", ""
+
+	if err := tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to write header for %s: %w", relPath, err)
+	}
+
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+
+	file, err := os.Open(absPath)
+	if err != nil {
+		return fmt.Errorf("failed to open file %s: %w", absPath, err)
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(tw, file); err != nil {
+		return fmt.Errorf("failed to write file %s: %w", relPath, err)
+	}
+
+	return nil
+}
+
+// ignoreMatcher evaluates .dockerignore patterns against slash-separated paths
+// relative to the build context. Later patterns take precedence over earlier ones,
+// and patterns prefixed with "!" re-include previously excluded paths.
+type ignoreMatcher struct {
+	patterns []ignorePattern
+	always   map[string]bool
+}
+
+type ignorePattern struct {
+	expr   *regexp.Regexp
+	negate bool
+}
+
+// readDockerignore parses the .dockerignore file in contextDir. A missing file
+// yields a matcher that excludes nothing.
+func readDockerignore(contextDir string) (ignoreMatcher, error) {
+	matcher := ignoreMatcher{always: map[string]bool{}} //nolint:exhaustruct // patterns populated below
+
+	file, err := os.Open(filepath.Join(contextDir, ".dockerignore"))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return matcher, nil
+		}
+		return ignoreMatcher{}, fmt.Errorf("failed to read .dockerignore: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		negate := strings.HasPrefix(line, "!")
+		if negate {
+			line = strings.TrimSpace(line[1:])
+		}
+
+		pattern := path.Clean(strings.TrimPrefix(filepath.ToSlash(line), "/"))
+		expr, err := compileIgnorePattern(pattern)
+		if err != nil {
+			return ignoreMatcher{}, fmt.Errorf("invalid .dockerignore pattern %q: %w", line, err)
+		}
+
+		matcher.patterns = append(matcher.patterns, ignorePattern{expr: expr, negate: negate})
+	}
+	if err := scanner.Err(); err != nil {
+		return ignoreMatcher{}, fmt.Errorf("failed to read .dockerignore: %w", err)
+	}
+
+	return matcher, nil
+}
+
+// compileIgnorePattern converts a .dockerignore glob into an anchored regular
+// expression. "**" matches any number of path segments, "*" matches within a
+// single segment, and "?" matches a single non-separator character.
+func compileIgnorePattern(pattern string) (*regexp.Regexp, error) {
+	var expr strings.Builder
+	expr.WriteString("^")
+
+	for i := 0; i < len(pattern); i++ {
+		switch ch := pattern[i]; ch {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				i++
+				if i+1 < len(pattern) && pattern[i+1] == '/' {
+					i++
+					expr.WriteString("(.*/)?")
+					continue
+				}
+				expr.WriteString(".*")
+				continue
+			}
+			expr.WriteString("[^/]*")
+		case '?':
+			expr.WriteString("[^/]")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(ch)))
+		}
+	}
+
+	expr.WriteString("$")
+	return regexp.Compile(expr.String())
+}
+
+// keep marks paths that are never excluded, regardless of the patterns.
+func (m ignoreMatcher) keep(paths ...string) {
+	for _, p := range paths {
+		m.always[p] = true
+	}
+}
+
+// skippable reports whether an excluded directory can be skipped entirely. It
+// cannot when a pattern re-includes paths or a kept path lives beneath it.
+func (m ignoreMatcher) skippable(dir string) bool {
+	for _, p := range m.patterns {
+		if p.negate {
+			return false
+		}
+	}
+	for p := range m.always {
+		if strings.HasPrefix(p, dir+"/") {
+			return false
+		}
+	}
+	return true
+}
+
+// excluded reports whether relPath should be left out of the build context.
+// A pattern matching any parent directory also matches the path itself.
+func (m ignoreMatcher) excluded(relPath string) bool {
+	if m.always[relPath] {
+		return false
+	}
+
+	excluded := false
+	for _, p := range m.patterns {
+		if p.matches(relPath) {
+			excluded = !p.negate
+		}
+	}
+	return excluded
+}
+
+func (p ignorePattern) matches(relPath string) bool {
+	for candidate := relPath; candidate != "."; candidate = path.Dir(candidate) {
+		if p.expr.MatchString(candidate) {
+			return true
+		}
+	}
+	return false
+}
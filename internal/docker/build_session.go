@@ -0,0 +1,91 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+
+	controlapi "github.com/moby/buildkit/api/services/control"
+	"github.com/moby/buildkit/session"
+	"github.com/moby/buildkit/session/secrets/secretsprovider"
+
+	"github.com/ryanmoran/contagent/internal"
+)
+
+// buildSessionKey is the shared key of build sessions. BuildKit uses it to tell the
+// sessions of different clients apart when it reuses local sources between builds.
+const buildSessionKey = "contagent"
+
+// readSecrets reads the content of each build secret, keyed by secret ID. Returns an
+// error if a source file cannot be read.
+func readSecrets(secrets []internal.BuildSecret) (map[string][]byte, error) {
+	contents := make(map[string][]byte, len(secrets))
+	for _, secret := range secrets {
+		content, err := os.ReadFile(secret.Source)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read secret %q from %s: %w\nEnsure the source file exists and is readable", secret.ID, secret.Source, err)
+		}
+		contents[secret.ID] = content
+	}
+	return contents, nil
+}
+
+// startBuildSession opens a BuildKit session with the daemon that serves secrets, over
+// a connection hijacked from the /session endpoint. Returns an error if the daemon
+// does not accept the session, which means it cannot run BuildKit builds.
+func (c Client) startBuildSession(ctx context.Context, secrets map[string][]byte) (*session.Session, error) {
+	s, err := session.NewSession(ctx, buildSessionKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start BuildKit session: %w", err)
+	}
+	s.Allow(secretsprovider.FromMap(secrets))
+
+	// The session dials the daemon once it starts running, so the outcome of the
+	// dial is passed back to report a daemon that does not accept the session
+	dialed := make(chan error, 1)
+	dialer := func(ctx context.Context, proto string, meta map[string][]string) (net.Conn, error) {
+		conn, err := c.client.DialHijack(ctx, "/session", proto, meta)
+		dialed <- err
+		return conn, err
+	}
+	go s.Run(ctx, dialer) //nolint:errcheck // the dial error is read from dialed, and Run returns nil once the session is closed
+
+	if err := <-dialed; err != nil {
+		return nil, fmt.Errorf("failed to start BuildKit session: %w\n--secret requires BuildKit, which needs Docker 18.09 or later with a Linux daemon", err)
+	}
+	return s, nil
+}
+
+// buildKitTraceID identifies the build output messages that carry BuildKit progress.
+const buildKitTraceID = "moby.buildkit.trace"
+
+// buildKitProgress prints the progress that BuildKit reports in trace messages: the
+// name of each build step once it starts, followed by the output of its commands.
+type buildKitProgress map[string]bool
+
+// print decodes a trace message, a StatusResponse, and prints the steps that started
+// since the previous message along with new command output. Messages that cannot be
+// decoded are skipped, as the progress is informational only.
+func (p buildKitProgress) print(aux json.RawMessage, w internal.Writer) {
+	var trace []byte
+	if json.Unmarshal(aux, &trace) != nil {
+		return
+	}
+
+	var status controlapi.StatusResponse
+	if status.UnmarshalVT(trace) != nil {
+		return
+	}
+
+	for _, vertex := range status.Vertexes {
+		if vertex.Started != nil && !p[vertex.Digest] {
+			p[vertex.Digest] = true
+			w.Printf("%s\n", vertex.Name)
+		}
+	}
+	for _, log := range status.Logs {
+		w.Print(string(log.Msg))
+	}
+}
@@ -1,15 +1,24 @@
 package docker
 
 import (
-	"archive/tar"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/moby/moby/api/types/build"
 	"github.com/moby/moby/api/types/container"
+	"github.com/moby/moby/api/types/network"
 	"github.com/moby/moby/client"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/ryanmoran/contagent/internal"
 	"github.com/ryanmoran/contagent/internal/runtime"
 )
@@ -43,130 +52,500 @@ func (c Client) Close() error {
 	return c.client.Close()
 }
 
+// HostAlias is the hostname that Docker containers use to reach the host.
+const HostAlias = "host.docker.internal"
+
 // HostAddress returns the hostname that containers use to reach the host.
 func (c Client) HostAddress() string {
-	return "host.docker.internal"
+	return HostAlias
 }
 
-// BuildImage builds a Docker image from a Dockerfile and tags it with the specified image name.
-// It creates a tar archive containing the Dockerfile, sends it to the Docker daemon, and streams
-// the build output to the provided Writer. Returns an error if the Dockerfile cannot be read,
-// the tar archive cannot be created, the image build fails, or the build output cannot be decoded.
-func (c Client) BuildImage(ctx context.Context, dockerfilePath string, imageName internal.ImageName, w internal.Writer) (runtime.Image, error) {
-	dockerfile, err := os.ReadFile(dockerfilePath)
+// BuildImage builds a Docker image from a Dockerfile and tags it with the specified image
+// name. It creates a tar archive of the build context directory, honoring any .dockerignore
+// file, sends it to the Docker daemon, and streams the build output to the provided Writer.
+// A completed build ends with a summary line naming the image, its ID, and how long the
+// build took.
+//
+// Each build is also tagged with a digest of its inputs, which covers the build context,
+// the options that shape the image, and the labels other than internal.SessionLabel and
+// internal.CommitLabel. When an image with that tag already exists, the build is skipped
+// and the cached image is returned, labeled with the session and commit of the build that
+// produced it. The cache is not consulted when
+// ForceRebuild or NoCache is set, or when PullPolicy is internal.PullPolicyAlways, since the
+// digest does not cover the base images.
+//
+// Base images are pulled before the build according to PullPolicy. Registry credentials
+// from DockerConfig are sent with those pulls and with the build; when they cannot be read,
+// a warning is printed and the build continues without them. Secrets are served over a
+// BuildKit session, so builds with secrets run on BuildKit. With Progress set to
+// internal.BuildProgressAuto, the output is condensed to a line per build step, and the
+// output of a failing step is printed before the error is returned.
+//
+// Returns an error if the Dockerfile cannot be read, a base image cannot be pulled under
+// PullPolicyAlways or is missing under PullPolicyNever, a secret cannot be read, the daemon
+// does not support BuildKit when secrets are given, the tar archive cannot be created, the
+// image build fails, or the build output cannot be decoded.
+func (c Client) BuildImage(ctx context.Context, opts runtime.BuildImageOptions, w internal.Writer) (runtime.Image, error) {
+	dockerfilePath := opts.DockerfilePath
+	imageName := opts.ImageName
+
+	info, err := os.Stat(dockerfilePath)
 	if err != nil {
 		return runtime.Image{}, fmt.Errorf("failed to read Dockerfile at %q: %w\nEnsure the file exists and is readable", dockerfilePath, err)
 	}
+	if info.IsDir() {
+		return runtime.Image{}, fmt.Errorf("failed to read Dockerfile at %q: path is a directory\nEnsure the file exists and is readable", dockerfilePath)
+	}
 
-	pr, pw := io.Pipe()
-	defer pr.Close()
+	contextDir := opts.ContextDir
+	if contextDir == "" {
+		contextDir = filepath.Dir(dockerfilePath)
+	}
 
-	errChan := make(chan error, 1)
+	name, err := dockerfileName(contextDir, dockerfilePath)
+	if err != nil {
+		return runtime.Image{}, err
+	}
 
-	go func() {
-		tw := tar.NewWriter(pw)
-		defer func() {
-			tw.Close()
-			pw.Close()
-		}()
+	digest, err := contextDigest(contextDir, dockerfilePath, name, opts.Target, opts.BuildArgs, opts.Platform, opts.Labels)
+	if err != nil {
+		return runtime.Image{}, fmt.Errorf("%w\nThis is a system error with tar archive creation", err)
+	}
+	cacheTag := cacheTagFor(imageName, digest)
+	w.Debugf("Build inputs digest for %s is %s", imageName, digest)
 
-		header := &tar.Header{
-			Name: "Dockerfile",
-			Mode: 0644,
-			Size: int64(len(dockerfile)),
-		}
+	rebuild := opts.ForceRebuild || opts.NoCache || opts.PullPolicy == internal.PullPolicyAlways
+	if !rebuild && c.reuseCachedImage(ctx, cacheTag, imageName) {
+		w.Printf("Reusing cached image %s (build inputs unchanged)\n", cacheTag)
+		return runtime.Image{
+			Name:     string(imageName),
+			CacheTag: cacheTag,
+		}, nil
+	}
+
+	auths, err := loadRegistryAuths(opts.DockerConfig)
+	if err != nil {
+		w.Warningf("%v", err)
+	}
+
+	buildOptions := client.ImageBuildOptions{
+		Dockerfile:  name,
+		Tags:        []string{string(imageName), cacheTag},
+		Target:      opts.Target,
+		Remove:      !opts.KeepIntermediate,
+		NoCache:     opts.NoCache,
+		BuildArgs:   buildArgs(opts.BuildArgs),
+		Labels:      opts.Labels,
+		Platforms:   platforms(opts.Platform),
+		AuthConfigs: auths,
+	}
 
-		if err := tw.WriteHeader(header); err != nil {
-			errChan <- fmt.Errorf("failed to write tar header for Dockerfile: %w\nThis is a system error with tar archive creation", err)
-			return
+	if len(opts.Secrets) > 0 {
+		secrets, err := readSecrets(opts.Secrets)
+		if err != nil {
+			return runtime.Image{}, err
 		}
 
-		if _, err := tw.Write(dockerfile); err != nil {
-			errChan <- fmt.Errorf("failed to write Dockerfile to tar archive: %w\nThis is a system error with tar archive creation", err)
-			return
+		buildSession, err := c.startBuildSession(ctx, secrets)
+		if err != nil {
+			return runtime.Image{}, err
 		}
-		errChan <- nil
+		defer buildSession.Close()
+
+		buildOptions.Version = build.BuilderBuildKit
+		buildOptions.SessionID = buildSession.ID()
+	}
+
+	start := time.Now()
+	if err := c.pullBaseImages(ctx, dockerfilePath, opts.Platform, opts.PullPolicy, auths, w); err != nil {
+		return runtime.Image{}, err
+	}
+
+	// The build context is streamed to the daemon while it is being archived. The
+	// archive error is only read once the goroutine has finished, and closing the
+	// reader unblocks the goroutine on every return path, so a failure to write the
+	// context is never lost and the goroutine never outlives the build.
+	pr, pw := io.Pipe()
+	var contextErr error
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		contextErr = writeContextArchive(pw, contextDir, dockerfilePath, name)
+		pw.CloseWithError(contextErr)
 	}()
 
-	response, err := c.client.ImageBuild(ctx, pr, client.ImageBuildOptions{
-		Dockerfile: "Dockerfile",
-		Tags:       []string{string(imageName)},
-		Remove:     true,
-	})
+	wait := func() error {
+		pr.Close()
+		<-done
+		return contextErr
+	}
+	defer wait() //nolint:errcheck // only unblocks the goroutine on early returns
+
+	response, err := c.client.ImageBuild(ctx, pr, buildOptions)
 	if err != nil {
+		// A broken context makes the request fail too, but its error says why
+		if archiveErr := wait(); archiveErr != nil && !errors.Is(archiveErr, io.ErrClosedPipe) {
+			return runtime.Image{}, archiveErr
+		}
+		if buildOptions.Version == build.BuilderBuildKit {
+			return runtime.Image{}, fmt.Errorf("failed to build image %q with BuildKit: %w\n--secret requires BuildKit, which needs Docker 18.09 or later with a Linux daemon", imageName, err)
+		}
 		return runtime.Image{}, fmt.Errorf("failed to build image %q: %w\nCheck Docker daemon logs for details", imageName, err)
 	}
 	defer response.Body.Close()
 
-	// Check if tar creation had an error
-	select {
-	case err := <-errChan:
-		if err != nil {
-			return runtime.Image{}, err
+	// Decoding blocks while the daemon is quiet, so it happens in the background and
+	// closing the body on cancellation unblocks it
+	stop := make(chan struct{})
+	defer close(stop)
+	messages := decodeBuildOutput(response.Body, stop)
+
+	var imageID string
+	progress := buildKitProgress{}
+	var steps *stepProgress
+	if opts.Progress == internal.BuildProgressAuto {
+		steps = &stepProgress{}
+	}
+	for {
+		var message buildMessage
+		var ok bool
+		select {
+		case <-ctx.Done():
+			response.Body.Close()
+			return runtime.Image{}, ctx.Err()
+		case message, ok = <-messages:
+		}
+		if !ok {
+			break
+		}
+
+		if message.err != nil {
+			return runtime.Image{}, fmt.Errorf("failed to decode build output: %w\nDocker may have returned malformed JSON", message.err)
+		}
+		output := message.output
+
+		// BuildKit reports failures without an error code
+		if output.ErrorDetail.Code != 0 || output.ErrorDetail.Message != "" {
+			if steps != nil {
+				steps.flush(w)
+			}
+			return runtime.Image{}, fmt.Errorf("docker build failed: %s\nCheck your Dockerfile syntax and base image availability", output.ErrorDetail.Message)
+		}
+
+		// BuildKit reports its progress in trace messages instead of the stream
+		if output.ID == buildKitTraceID {
+			progress.print(output.Aux, w)
+			continue
+		}
+
+		// The builder reports the ID of the finished image in an aux message
+		if len(output.Aux) > 0 {
+			var aux struct {
+				ID string `json:"ID"`
+			}
+			if json.Unmarshal(output.Aux, &aux) == nil && aux.ID != "" {
+				imageID = aux.ID
+			}
 		}
-	case <-ctx.Done():
-		return runtime.Image{}, ctx.Err()
+
+		// Warnings do not fail the build, so they are easy to miss among the
+		// regular output unless they are reported as warnings
+		for _, text := range []string{output.Message, output.Status} {
+			if warning, ok := buildWarning(text); ok {
+				w.Warningf("%s", warning)
+			} else if text != "" {
+				w.Debug(strings.TrimSpace(text))
+			}
+		}
+
+		if warning, ok := buildWarning(output.Stream); ok {
+			w.Warningf("%s", warning)
+			continue
+		}
+		if steps != nil {
+			steps.print(output.Stream, w)
+			continue
+		}
+		w.Print(output.Stream)
 	}
 
-	decoder := json.NewDecoder(response.Body)
+	// The daemon only stops reading the context early when it no longer needs it
+	if err := wait(); err != nil && !errors.Is(err, io.ErrClosedPipe) {
+		return runtime.Image{}, err
+	}
+
+	elapsed := time.Since(start).Round(time.Millisecond)
+	if imageID != "" {
+		w.Printf("Built image %s (%s) in %s\n", string(imageName), imageID, elapsed)
+	} else {
+		w.Printf("Built image %s in %s\n", string(imageName), elapsed)
+	}
+
+	return runtime.Image{
+		Name:     string(imageName),
+		CacheTag: cacheTag,
+	}, nil
+}
+
+// buildOutput is a single JSON message of the daemon's build output.
+type buildOutput struct {
+	ID          string          `json:"id"`
+	Stream      string          `json:"stream"`
+	Status      string          `json:"status"`
+	Message     string          `json:"message"`
+	Aux         json.RawMessage `json:"aux"`
+	ErrorDetail struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"errorDetail"`
+}
+
+// buildMessage carries a decoded build output message, or the error that ended decoding.
+type buildMessage struct {
+	output buildOutput
+	err    error
+}
+
+// decodeBuildOutput decodes the build output in body on a separate goroutine and sends
+// each message on the returned channel, which is closed once body is exhausted, after
+// a decode error, or once stop is closed.
+func decodeBuildOutput(body io.Reader, stop <-chan struct{}) <-chan buildMessage {
+	messages := make(chan buildMessage)
+
+	go func() {
+		defer close(messages)
+
+		decoder := json.NewDecoder(body)
+		for decoder.More() {
+			var message buildMessage
+			message.err = decoder.Decode(&message.output)
+
+			select {
+			case messages <- message:
+			case <-stop:
+				return
+			}
+			if message.err != nil {
+				return
+			}
+		}
+	}()
+
+	return messages
+}
+
+// buildWarning reports whether a line of build output is a warning, such as
+// "[Warning] One or more build-args [FOO] were not consumed", and returns the
+// message without its warning marker.
+func buildWarning(text string) (string, bool) {
+	text = strings.TrimSpace(text)
+	for _, marker := range []string{"[warning]", "warning:"} {
+		if len(text) >= len(marker) && strings.EqualFold(text[:len(marker)], marker) {
+			return strings.TrimSpace(text[len(marker):]), true
+		}
+	}
+	return "", false
+}
+
+// buildStep matches the line the builder prints when it starts a build step, such as
+// "Step 3/12 : RUN make".
+var buildStep = regexp.MustCompile(`^Step (\d+)/(\d+) : (.*)$`)
+
+// stepProgress condenses the build output to a line per build step. The output of the
+// current step is held back so that it can still be shown when the step fails.
+type stepProgress struct {
+	output strings.Builder
+}
+
+// print prints a progress line when text starts a build step, and otherwise holds text
+// back until the next step starts.
+func (p *stepProgress) print(text string, w internal.Writer) {
+	if match := buildStep.FindStringSubmatch(strings.TrimSpace(text)); match != nil {
+		p.output.Reset()
+		w.Printf("Step %s/%s: %s\n", match[1], match[2], match[3])
+		return
+	}
+	p.output.WriteString(text)
+}
+
+// flush prints the output held back for the current step.
+func (p *stepProgress) flush(w internal.Writer) {
+	w.Print(p.output.String())
+	p.output.Reset()
+}
+
+// PullImage pulls ref from its registry and streams the pull progress to the provided
+// Writer, printing a line whenever a layer changes status. Returns an error if the pull
+// cannot be started, the registry reports a failure, or the progress cannot be decoded.
+func (c Client) PullImage(ctx context.Context, ref string, w internal.Writer) error {
+	return c.pullImage(ctx, ref, "", nil, w)
+}
+
+// pullImage pulls ref for the given platform, or the daemon's default platform when empty,
+// sending the credentials in auths for the registry that hosts ref.
+func (c Client) pullImage(ctx context.Context, ref, platform string, auths registryAuths, w internal.Writer) error {
+	response, err := c.client.ImagePull(ctx, ref, client.ImagePullOptions{
+		Platforms:    platforms(platform),
+		RegistryAuth: auths.encode(ref),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to pull image %q: %w\nCheck the image name and your registry credentials", ref, err)
+	}
+	defer response.Close()
+
+	last := map[string]string{}
+	decoder := json.NewDecoder(response)
 	for decoder.More() {
 		select {
 		case <-ctx.Done():
-			return runtime.Image{}, ctx.Err()
+			return ctx.Err()
 		default:
 		}
 
 		var output struct {
-			Stream      string `json:"stream"`
+			ID          string `json:"id"`
+			Status      string `json:"status"`
+			Progress    string `json:"progress"`
 			ErrorDetail struct {
-				Code    int    `json:"code"`
 				Message string `json:"message"`
 			} `json:"errorDetail"`
 		}
-		err := decoder.Decode(&output)
-		if err != nil {
-			return runtime.Image{}, fmt.Errorf("failed to decode build output: %w\nDocker may have returned malformed JSON", err)
+		if err := decoder.Decode(&output); err != nil {
+			return fmt.Errorf("failed to decode pull output for image %q: %w\nDocker may have returned malformed JSON", ref, err)
 		}
 
-		if output.ErrorDetail.Code != 0 {
-			return runtime.Image{}, fmt.Errorf("docker build failed: %s\nCheck your Dockerfile syntax and base image availability", output.ErrorDetail.Message)
+		if output.ErrorDetail.Message != "" {
+			return fmt.Errorf("failed to pull image %q: %s", ref, output.ErrorDetail.Message)
 		}
 
-		w.Print(output.Stream)
+		// Progress updates repeat the same status many times per layer
+		if last[output.ID] == output.Status {
+			continue
+		}
+		last[output.ID] = output.Status
+
+		// Per-layer progress is noisy, so it is only shown in verbose mode
+		if output.ID == "" {
+			w.Println(output.Status)
+			continue
+		}
+
+		line := output.ID + ": " + output.Status
+		if output.Progress != "" {
+			line += " " + output.Progress
+		}
+		w.Debug(line)
 	}
 
-	return runtime.Image{
-		Name: string(imageName),
-	}, nil
+	return nil
+}
+
+// pullBaseImages pulls the remote base images referenced by the Dockerfile according to
+// policy, so that their download progress is visible instead of the build stalling
+// silently. Under PullPolicyMissing, only images not yet present locally are pulled and
+// failures only produce warnings; the build pulls on its own. PullPolicyAlways pulls every
+// image and returns an error if a pull fails. PullPolicyNever pulls nothing and returns an
+// error if an image is not present locally. Pulls send the matching credentials in auths.
+func (c Client) pullBaseImages(ctx context.Context, dockerfilePath, platform string, policy internal.PullPolicy, auths registryAuths, w internal.Writer) error {
+	content, err := os.ReadFile(dockerfilePath)
+	if err != nil {
+		return nil
+	}
+
+	for _, ref := range baseImages(content) {
+		if policy != internal.PullPolicyAlways {
+			if _, err := c.client.ImageInspect(ctx, ref); err == nil {
+				w.Debugf("Base image %s is present locally", ref)
+				continue
+			}
+		}
+
+		if policy == internal.PullPolicyNever {
+			return fmt.Errorf("base image %q is not present locally and the pull policy is %q\nPull it with 'docker pull %s' or use --pull-policy missing", ref, policy, ref)
+		}
+
+		w.Printf("Pulling base image %s\n", ref)
+		if err := c.pullImage(ctx, ref, platform, auths, w); err != nil {
+			if policy == internal.PullPolicyAlways {
+				return err
+			}
+			w.Warningf("%v", err)
+		}
+	}
+
+	return nil
 }
 
-// CreateContainer creates a new Docker container with the specified configuration.
-// It configures the container with TTY support, stdin attachment, environment variables,
-// working directory, volume mounts, and network settings to allow communication with the host
-// via host.docker.internal. Returns a Container handle or an error if creation fails.
+// reuseCachedImage reports whether an image built from identical inputs exists under
+// cacheTag, pointing imageName at it if so. Any failure is treated as a cache miss so
+// that the caller falls back to a regular build.
+func (c Client) reuseCachedImage(ctx context.Context, cacheTag string, imageName internal.ImageName) bool {
+	if _, err := c.client.ImageInspect(ctx, cacheTag); err != nil {
+		return false
+	}
+
+	_, err := c.client.ImageTag(ctx, client.ImageTagOptions{
+		Source: cacheTag,
+		Target: string(imageName),
+	})
+	return err == nil
+}
+
+// CreateContainer creates a new Docker container with the specified configuration. The
+// container attaches stdin unless NoStdin is set, and reaches the host as
+// host.docker.internal unless NoGateway is set. Returns a Container handle or an error if
+// the session ID is not a valid container name or creation fails.
 func (c Client) CreateContainer(ctx context.Context, opts runtime.CreateContainerOptions) (runtime.Container, error) {
+	if err := opts.SessionID.Valid(); err != nil {
+		return nil, err
+	}
+
+	exposedPorts, portBindings, err := publishedPorts(opts.Ports)
+	if err != nil {
+		return nil, err
+	}
+
+	binds, anonymous := splitVolumes(opts.Volumes)
+
 	response, err := c.client.ContainerCreate(ctx, client.ContainerCreateOptions{
 		Config: &container.Config{
 			Image:        opts.Image.Name,
 			Cmd:          []string(opts.Args),
-			Tty:          true,
-			OpenStdin:    true,
-			AttachStdin:  true,
+			Entrypoint:   opts.Entrypoint,
+			Tty:          opts.TTY,
+			OpenStdin:    !opts.NoStdin,
+			StdinOnce:    !opts.TTY && !opts.NoStdin,
+			AttachStdin:  !opts.NoStdin,
 			AttachStdout: true,
 			AttachStderr: true,
 			Env:          []string(opts.Env),
 			WorkingDir:   opts.WorkingDir,
+			User:         opts.User,
+			ExposedPorts: exposedPorts,
+			Volumes:      anonymous,
+			Labels:       containerLabels(opts.Labels, opts.SessionID),
 		},
 		HostConfig: &container.HostConfig{
-			ExtraHosts: []string{
-				"host.docker.internal:host-gateway",
+			ExtraHosts:     extraHosts(opts.ExtraHosts, opts.NoGateway),
+			Binds:          binds,
+			Tmpfs:          opts.Tmpfs,
+			ReadonlyRootfs: opts.ReadOnly,
+			NetworkMode:    container.NetworkMode(opts.Network),
+			PortBindings:   portBindings,
+			RestartPolicy: container.RestartPolicy{
+				Name:              container.RestartPolicyMode(opts.Restart.Name),
+				MaximumRetryCount: opts.Restart.MaxRetries,
+			},
+			Resources: container.Resources{
+				Memory:   opts.Memory,
+				NanoCPUs: int64(opts.CPUs * 1e9),
 			},
-			Binds:       opts.Volumes,
-			NetworkMode: container.NetworkMode(opts.Network),
 		},
 		Name:             string(opts.SessionID),
 		NetworkingConfig: nil,
-		Platform:         nil,
+		Platform:         platformSpec(opts.Platform),
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create container %q from image %q: %w\nEnsure image exists and container config is valid", opts.SessionID, opts.Image.Name, err)
@@ -175,32 +554,255 @@ func (c Client) CreateContainer(ctx context.Context, opts runtime.CreateContaine
 	return Container{
 		ID:          response.ID,
 		Name:        string(opts.SessionID),
+		TTY:         opts.TTY,
 		client:      c.client,
 		StopTimeout: opts.StopTimeout,
 		TTYRetries:  opts.TTYRetries,
 		RetryDelay:  opts.RetryDelay,
+		TTYResync:   opts.TTYResync,
+		Timeout:     opts.Timeout,
+		Restart:     opts.Restart,
+		Keep:        opts.Keep,
 	}, nil
 }
 
-// Ping pings the Docker daemon and returns the API version if successful.
-func (c Client) Ping(ctx context.Context) (string, error) {
-	ping, err := c.client.Ping(ctx, client.PingOptions{})
+// Healthcheck pings the Docker daemon. Returns an error explaining how to start Docker
+// if the daemon cannot be reached.
+func (c Client) Healthcheck(ctx context.Context) error {
+	_, err := c.client.Ping(ctx, client.PingOptions{})
 	if err != nil {
-		return "", fmt.Errorf("failed to ping docker daemon: %w", err)
+		return fmt.Errorf("docker daemon unreachable: %w\nMake sure Docker is installed and running (try 'docker ps') and DOCKER_HOST is set correctly", err)
+	}
+
+	return nil
+}
+
+// PruneOrphans force-removes the stopped containers, and their anonymous volumes, labeled
+// with internal.SessionLabel that do not belong to the current session, such as those left
+// behind when a previous run was killed before it could clean up. Running containers are
+// left alone, as they belong to sessions still open in other terminals. Each removed
+// container is reported to w. Returns an error if the containers cannot be listed, or
+// naming each container that could not be removed once the others have been.
+func (c Client) PruneOrphans(ctx context.Context, current internal.SessionID, w internal.Writer) error {
+	result, err := c.client.ContainerList(ctx, client.ContainerListOptions{ //nolint:exhaustruct // Only stopped containers and the label filter differ from the defaults
+		All: true,
+		Filters: make(client.Filters).
+			Add("label", internal.SessionLabel).
+			Add("status", "created", "exited"),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list containers: %w\nEnsure Docker is running", err)
+	}
+
+	var errs []error
+	for _, item := range result.Items {
+		session := item.Labels[internal.SessionLabel]
+		if session == string(current) {
+			continue
+		}
+
+		_, err := c.client.ContainerRemove(ctx, item.ID, client.ContainerRemoveOptions{
+			Force:         true,
+			RemoveVolumes: true,
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to remove orphaned container %q: %w", session, err))
+			continue
+		}
+		w.Printf("Removed orphaned container %s\n", session)
 	}
-	return ping.APIVersion, nil
+
+	return errors.Join(errs...)
 }
 
-// ListContainers lists all containers and returns their IDs.
-func (c Client) ListContainers(ctx context.Context) ([]string, error) {
-	result, err := c.client.ContainerList(ctx, client.ContainerListOptions{})
+// builtinNetworks are the network modes Docker provides without a network object
+// that could be created.
+var builtinNetworks = map[string]bool{
+	"default": true,
+	"host":    true,
+	"bridge":  true,
+	"none":    true,
+}
+
+// EnsureNetwork creates a bridge network with the given name unless one already exists.
+// The built-in network modes (default, host, bridge, none) and container:<id> modes are
+// left alone. Returns true if the network was created, in which case the caller owns it
+// and should remove it with RemoveNetwork. Returns an error if the networks cannot be
+// listed or the network cannot be created.
+func (c Client) EnsureNetwork(ctx context.Context, name string) (bool, error) {
+	if name == "" || builtinNetworks[name] || strings.HasPrefix(name, "container:") {
+		return false, nil
+	}
+
+	result, err := c.client.NetworkList(ctx, client.NetworkListOptions{
+		Filters: make(client.Filters).Add("name", name),
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to list containers: %w", err)
+		return false, fmt.Errorf("failed to list networks: %w\nEnsure Docker is running", err)
 	}
 
-	var ids []string
+	// The name filter also matches partial names
 	for _, item := range result.Items {
-		ids = append(ids, item.ID)
+		if item.Name == name {
+			return false, nil
+		}
+	}
+
+	_, err = c.client.NetworkCreate(ctx, name, client.NetworkCreateOptions{ //nolint:exhaustruct // Only the driver differs from the daemon defaults
+		Driver: "bridge",
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to create network %q: %w\nCheck that the name is valid and does not conflict with an existing network", name, err)
+	}
+
+	return true, nil
+}
+
+// RemoveNetwork removes the network with the given name. Returns an error if the
+// network does not exist or still has containers attached.
+func (c Client) RemoveNetwork(ctx context.Context, name string) error {
+	_, err := c.client.NetworkRemove(ctx, name, client.NetworkRemoveOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to remove network %q: %w\nContainers may still be attached to it", name, err)
+	}
+
+	return nil
+}
+
+// ErrImageInUse is returned by RemoveImage when containers were created from the image.
+var ErrImageInUse = errors.New("image is used by containers")
+
+// RemoveImage removes the references of image, its cache tag and then its name, along
+// with untagged parent layers. References are removed without force, so only the
+// session's own tags go, and Docker deletes the image once no tags are left. An image from
+// which any container, running or stopped, was created keeps its name, as other sessions
+// may still need it, and ErrImageInUse is returned. Returns an error if a reference cannot
+// be found or removed.
+func (c Client) RemoveImage(ctx context.Context, image runtime.Image) error {
+	refs := []string{image.Name}
+	if image.CacheTag != "" {
+		refs = []string{image.CacheTag, image.Name}
+	}
+
+	for _, ref := range refs {
+		_, err := c.client.ImageRemove(ctx, ref, client.ImageRemoveOptions{ //nolint:exhaustruct // Images are removed for every platform
+			PruneChildren: true,
+		})
+		if cerrdefs.IsConflict(err) {
+			return fmt.Errorf("failed to remove image %q: %w", image.Name, ErrImageInUse)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to remove image %q: %w\nUse 'docker rmi' to remove it manually", ref, err)
+		}
+	}
+
+	return nil
+}
+
+// buildArgs converts build args into the pointer-valued map expected by the Docker API.
+// Returns nil when there are no build args.
+func buildArgs(args map[string]string) map[string]*string {
+	if len(args) == 0 {
+		return nil
+	}
+
+	result := make(map[string]*string, len(args))
+	for key, value := range args {
+		result[key] = &value
+	}
+	return result
+}
+
+// containerLabels returns the labels for a container: the configured labels plus the
+// session label, which always carries the session ID.
+func containerLabels(labels map[string]string, sessionID internal.SessionID) map[string]string {
+	result := make(map[string]string, len(labels)+1)
+	for key, value := range labels {
+		result[key] = value
+	}
+	result[internal.SessionLabel] = string(sessionID)
+	return result
+}
+
+// splitVolumes separates HOST:CONTAINER[:MODE] binds from anonymous volumes, which are
+// given as a container path alone. Returns nil for the anonymous volumes when there
+// are none.
+func splitVolumes(volumes []string) ([]string, map[string]struct{}) {
+	var binds []string
+	var anonymous map[string]struct{}
+	for _, volume := range volumes {
+		if strings.Contains(volume, ":") {
+			binds = append(binds, volume)
+			continue
+		}
+
+		if anonymous == nil {
+			anonymous = make(map[string]struct{})
+		}
+		anonymous[volume] = struct{}{}
+	}
+
+	return binds, anonymous
+}
+
+// extraHosts returns the host aliases for a container: host.docker.internal mapped to
+// the host gateway, unless noGateway is set, followed by the configured aliases.
+func extraHosts(hosts []string, noGateway bool) []string {
+	var result []string
+	if !noGateway {
+		result = append(result, HostAlias+":host-gateway")
+	}
+	return append(result, hosts...)
+}
+
+// publishedPorts converts published ports into the exposed port set and host port
+// bindings expected by the Docker API. Returns nil maps when there are no ports.
+func publishedPorts(ports []internal.PortMapping) (network.PortSet, network.PortMap, error) {
+	if len(ports) == 0 {
+		return nil, nil, nil
+	}
+
+	exposed := make(network.PortSet, len(ports))
+	bindings := make(network.PortMap, len(ports))
+	for _, mapping := range ports {
+		port, ok := network.PortFrom(mapping.ContainerPort, network.IPProtocol(mapping.Protocol))
+		if !ok {
+			return nil, nil, fmt.Errorf("invalid published port %q: missing protocol", mapping)
+		}
+
+		exposed[port] = struct{}{}
+		bindings[port] = append(bindings[port], network.PortBinding{
+			HostPort: strconv.Itoa(int(mapping.HostPort)),
+		})
+	}
+
+	return exposed, bindings, nil
+}
+
+// platformSpec converts an os/arch[/variant] platform into the form expected by the
+// Docker API. Returns nil when no platform is requested.
+func platformSpec(platform string) *ocispec.Platform {
+	if platform == "" {
+		return nil
+	}
+
+	osName, arch, _ := strings.Cut(platform, "/")
+	arch, variant, _ := strings.Cut(arch, "/")
+	return &ocispec.Platform{
+		OS:           osName,
+		Architecture: arch,
+		Variant:      variant,
+		OSVersion:    "",
+		OSFeatures:   nil,
+	}
+}
+
+// platforms wraps a single platform in the list expected by build and pull options.
+// Returns nil when no platform is requested.
+func platforms(platform string) []ocispec.Platform {
+	spec := platformSpec(platform)
+	if spec == nil {
+		return nil
 	}
-	return ids, nil
+	return []ocispec.Platform{*spec}
 }
@@ -49,17 +49,54 @@ func TestBuildImage(t *testing.T) {
 		writer := newMockWriter()
 		ctx := context.Background()
 
-		image, err := client.BuildImage(ctx, dockerfilePath, "test-image:latest", writer)
+		image, err := client.BuildImage(ctx, runtime.BuildImageOptions{DockerfilePath: dockerfilePath, ImageName: "test-image:latest"}, writer)
 		require.NoError(t, err)
 		require.Equal(t, "test-image:latest", image.Name)
 		require.Contains(t, writer.String(), "Step")
 	})
 
+	t.Run("builds image that copies a file from the build context", func(t *testing.T) {
+		tmpDir := t.TempDir()
+
+		dockerfilePath := filepath.Join(tmpDir, "Dockerfile")
+		err := os.WriteFile(dockerfilePath, []byte("FROM alpine:latest\nCOPY hello.txt /hello.txt\nRUN grep -q 'from context' /hello.txt\n"), 0644)
+		require.NoError(t, err)
+
+		err = os.WriteFile(filepath.Join(tmpDir, "hello.txt"), []byte("from context\n"), 0644)
+		require.NoError(t, err)
+
+		writer := newMockWriter()
+		ctx := context.Background()
+
+		image, err := client.BuildImage(ctx, runtime.BuildImageOptions{DockerfilePath: dockerfilePath, ImageName: "test-context:latest"}, writer)
+		require.NoError(t, err)
+		require.Equal(t, "test-context:latest", image.Name)
+	})
+
+	t.Run("builds the named stage of a multi-stage Dockerfile", func(t *testing.T) {
+		tmpDir := t.TempDir()
+
+		// Building the prod stage fails, so the build only succeeds if it stops at dev
+		dockerfilePath := filepath.Join(tmpDir, "Dockerfile")
+		err := os.WriteFile(dockerfilePath, []byte("FROM alpine:latest AS dev\nRUN echo dev\n\nFROM dev AS prod\nRUN exit 1\n"), 0644)
+		require.NoError(t, err)
+
+		writer := newMockWriter()
+		ctx := context.Background()
+
+		image, err := client.BuildImage(ctx, runtime.BuildImageOptions{DockerfilePath: dockerfilePath, ImageName: "test-target:latest", Target: "dev"}, writer)
+		require.NoError(t, err)
+		require.Equal(t, "test-target:latest", image.Name)
+
+		_, err = client.BuildImage(ctx, runtime.BuildImageOptions{DockerfilePath: dockerfilePath, ImageName: "test-target:latest"}, writer)
+		require.Error(t, err)
+	})
+
 	t.Run("fails with non-existent Dockerfile", func(t *testing.T) {
 		writer := newMockWriter()
 		ctx := context.Background()
 
-		_, err := client.BuildImage(ctx, "/nonexistent/Dockerfile", "test-image:latest", writer)
+		_, err := client.BuildImage(ctx, runtime.BuildImageOptions{DockerfilePath: "/nonexistent/Dockerfile", ImageName: "test-image:latest"}, writer)
 		require.Error(t, err)
 		require.Contains(t, err.Error(), "failed to read Dockerfile")
 	})
@@ -76,7 +113,7 @@ func TestBuildImage(t *testing.T) {
 		writer := newMockWriter()
 		ctx := context.Background()
 
-		_, err = client.BuildImage(ctx, dockerfilePath, "test-image:latest", writer)
+		_, err = client.BuildImage(ctx, runtime.BuildImageOptions{DockerfilePath: dockerfilePath, ImageName: "test-image:latest"}, writer)
 		require.Error(t, err)
 	})
 
@@ -92,7 +129,7 @@ func TestBuildImage(t *testing.T) {
 		writer := newMockWriter()
 		ctx := context.Background()
 
-		_, err = client.BuildImage(ctx, dockerfilePath, "test-output:latest", writer)
+		_, err = client.BuildImage(ctx, runtime.BuildImageOptions{DockerfilePath: dockerfilePath, ImageName: "test-output:latest"}, writer)
 		require.NoError(t, err)
 
 		output := writer.String()
@@ -1,20 +1,38 @@
 package docker_test
 
 import (
+	"archive/tar"
 	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"io"
+	"net"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
+	cerrdefs "github.com/containerd/errdefs"
+	controlapi "github.com/moby/buildkit/api/services/control"
+	"github.com/moby/buildkit/session/secrets"
+	"github.com/moby/moby/api/types/build"
+	"github.com/moby/moby/api/types/container"
+	"github.com/moby/moby/api/types/network"
+	"github.com/moby/moby/api/types/registry"
 	"github.com/moby/moby/client"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/ryanmoran/contagent/internal"
 	"github.com/ryanmoran/contagent/internal/docker"
 	"github.com/ryanmoran/contagent/internal/runtime"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 // TestBuildImageWithMock tests BuildImage using a mock Docker client
@@ -53,12 +71,77 @@ func TestBuildImageWithMock(t *testing.T) {
 		writer := newMockWriter()
 		ctx := context.Background()
 
-		image, err := c.BuildImage(ctx, dockerfilePath, "test:latest", writer)
+		image, err := c.BuildImage(ctx, runtime.BuildImageOptions{DockerfilePath: dockerfilePath, ImageName: "test:latest"}, writer)
 		require.NoError(t, err)
 		require.Equal(t, "test:latest", image.Name)
 		require.Contains(t, writer.String(), "Step")
 	})
 
+	t.Run("reports a summary with the built image ID", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		dockerfilePath := filepath.Join(tmpDir, "Dockerfile")
+		require.NoError(t, os.WriteFile(dockerfilePath, []byte("FROM scratch\n"), 0600))
+
+		outputBytes := []byte(`{"stream":"Step 1/1 : FROM scratch\n"}
+{"aux":{"ID":"sha256:0123456789abcdef"}}
+{"stream":"Successfully built 0123456789ab\n"}
+`)
+
+		mock := &mockDockerClient{
+			imageBuildFunc: func(ctx context.Context, buildContext io.Reader, options client.ImageBuildOptions) (client.ImageBuildResult, error) {
+				io.Copy(io.Discard, buildContext) //nolint:errcheck // draining pipe for goroutine completion
+				return client.ImageBuildResult{
+					Body: io.NopCloser(bytes.NewReader(outputBytes)),
+				}, nil
+			},
+		}
+
+		c := docker.NewClient(mock)
+		writer := newMockWriter()
+
+		_, err := c.BuildImage(context.Background(), runtime.BuildImageOptions{DockerfilePath: dockerfilePath, ImageName: "test:latest"}, writer)
+		require.NoError(t, err)
+
+		lines := strings.Split(strings.TrimSpace(writer.String()), "\n")
+		summary := lines[len(lines)-1]
+		require.Contains(t, summary, "Built image test:latest (sha256:0123456789abcdef) in ")
+	})
+
+	t.Run("reports build warnings as warnings", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		dockerfilePath := filepath.Join(tmpDir, "Dockerfile")
+		require.NoError(t, os.WriteFile(dockerfilePath, []byte("FROM scratch\n"), 0600))
+
+		outputBytes := []byte(`{"stream":"Step 1/1 : FROM scratch\n"}
+{"stream":"[Warning] One or more build-args [FOO] were not consumed\n"}
+{"status":"WARNING: the MAINTAINER instruction is deprecated"}
+{"status":"Downloading"}
+{"stream":"Successfully built 0123456789ab\n"}
+`)
+
+		mock := &mockDockerClient{
+			imageBuildFunc: func(ctx context.Context, buildContext io.Reader, options client.ImageBuildOptions) (client.ImageBuildResult, error) {
+				io.Copy(io.Discard, buildContext) //nolint:errcheck // draining pipe for goroutine completion
+				return client.ImageBuildResult{
+					Body: io.NopCloser(bytes.NewReader(outputBytes)),
+				}, nil
+			},
+		}
+
+		c := docker.NewClient(mock)
+		writer := newMockWriter()
+
+		_, err := c.BuildImage(context.Background(), runtime.BuildImageOptions{DockerfilePath: dockerfilePath, ImageName: "test:latest"}, writer)
+		require.NoError(t, err)
+
+		output := writer.String()
+		require.Contains(t, output, "Step 1/1 : FROM scratch\n")
+		require.Contains(t, output, "Warning: One or more build-args [FOO] were not consumed\n")
+		require.Contains(t, output, "Warning: the MAINTAINER instruction is deprecated\n")
+		require.Contains(t, output, "Debug: Downloading\n")
+		require.NotContains(t, output, "[Warning]")
+	})
+
 	t.Run("fails when ImageBuild returns error", func(t *testing.T) {
 		tmpDir, err := os.MkdirTemp("", "docker-mock-test")
 		require.NoError(t, err)
@@ -79,7 +162,7 @@ func TestBuildImageWithMock(t *testing.T) {
 		writer := newMockWriter()
 		ctx := context.Background()
 
-		_, err = c.BuildImage(ctx, dockerfilePath, "test:latest", writer)
+		_, err = c.BuildImage(ctx, runtime.BuildImageOptions{DockerfilePath: dockerfilePath, ImageName: "test:latest"}, writer)
 		require.Error(t, err)
 		require.Contains(t, err.Error(), "failed to build image")
 	})
@@ -116,11 +199,273 @@ func TestBuildImageWithMock(t *testing.T) {
 		writer := newMockWriter()
 		ctx := context.Background()
 
-		_, err = c.BuildImage(ctx, dockerfilePath, "test:latest", writer)
+		_, err = c.BuildImage(ctx, runtime.BuildImageOptions{DockerfilePath: dockerfilePath, ImageName: "test:latest"}, writer)
 		require.Error(t, err)
 		require.Contains(t, err.Error(), "dockerfile parse error")
 	})
 
+	t.Run("passes build args to Docker API", func(t *testing.T) {
+		tmpDir, err := os.MkdirTemp("", "docker-mock-test")
+		require.NoError(t, err)
+		defer os.RemoveAll(tmpDir)
+
+		dockerfilePath := filepath.Join(tmpDir, "Dockerfile")
+		err = os.WriteFile(dockerfilePath, []byte("ARG BASE_VERSION\nFROM alpine:${BASE_VERSION}\n"), 0600)
+		require.NoError(t, err)
+
+		var capturedOptions client.ImageBuildOptions
+		mock := &mockDockerClient{
+			imageBuildFunc: func(ctx context.Context, buildContext io.Reader, options client.ImageBuildOptions) (client.ImageBuildResult, error) {
+				io.Copy(io.Discard, buildContext) //nolint:errcheck // draining pipe for goroutine completion
+				capturedOptions = options
+				return client.ImageBuildResult{
+					Body: io.NopCloser(bytes.NewReader(nil)),
+				}, nil
+			},
+		}
+
+		c := docker.NewClient(mock)
+		writer := newMockWriter()
+		ctx := context.Background()
+
+		_, err = c.BuildImage(ctx, runtime.BuildImageOptions{
+			DockerfilePath: dockerfilePath,
+			ImageName:      "test:latest",
+			BuildArgs: map[string]string{
+				"BASE_VERSION": "3.20",
+				"EMPTY":        "",
+			},
+		}, writer)
+		require.NoError(t, err)
+
+		require.Len(t, capturedOptions.BuildArgs, 2)
+		require.NotNil(t, capturedOptions.BuildArgs["BASE_VERSION"])
+		require.Equal(t, "3.20", *capturedOptions.BuildArgs["BASE_VERSION"])
+		require.NotNil(t, capturedOptions.BuildArgs["EMPTY"])
+		require.Equal(t, "", *capturedOptions.BuildArgs["EMPTY"])
+	})
+
+	t.Run("forwards the platform to the Docker API", func(t *testing.T) {
+		dockerfilePath := filepath.Join(t.TempDir(), "Dockerfile")
+		require.NoError(t, os.WriteFile(dockerfilePath, []byte("FROM scratch\n"), 0600))
+
+		var capturedOptions client.ImageBuildOptions
+		mock := &mockDockerClient{
+			imageBuildFunc: func(ctx context.Context, buildContext io.Reader, options client.ImageBuildOptions) (client.ImageBuildResult, error) {
+				io.Copy(io.Discard, buildContext) //nolint:errcheck // draining pipe for goroutine completion
+				capturedOptions = options
+				return client.ImageBuildResult{
+					Body: io.NopCloser(bytes.NewReader(nil)),
+				}, nil
+			},
+		}
+
+		_, err := docker.NewClient(mock).BuildImage(context.Background(), runtime.BuildImageOptions{
+			DockerfilePath: dockerfilePath,
+			ImageName:      "test:latest",
+			Platform:       "linux/arm64/v8",
+		}, newMockWriter())
+		require.NoError(t, err)
+
+		require.Equal(t, []ocispec.Platform{
+			{OS: "linux", Architecture: "arm64", Variant: "v8"},
+		}, capturedOptions.Platforms)
+	})
+
+	t.Run("forwards the image labels to the Docker API", func(t *testing.T) {
+		dockerfilePath := filepath.Join(t.TempDir(), "Dockerfile")
+		require.NoError(t, os.WriteFile(dockerfilePath, []byte("FROM scratch\n"), 0600))
+
+		var capturedOptions client.ImageBuildOptions
+		mock := &mockDockerClient{
+			imageInspectFunc: func(ctx context.Context, imageID string, inspectOpts ...client.ImageInspectOption) (client.ImageInspectResult, error) {
+				return client.ImageInspectResult{}, errors.New("No such image")
+			},
+			imageBuildFunc: func(ctx context.Context, buildContext io.Reader, options client.ImageBuildOptions) (client.ImageBuildResult, error) {
+				io.Copy(io.Discard, buildContext) //nolint:errcheck // draining pipe for goroutine completion
+				capturedOptions = options
+				return client.ImageBuildResult{
+					Body: io.NopCloser(bytes.NewReader(nil)),
+				}, nil
+			},
+		}
+
+		labels := map[string]string{
+			"contagent.session": "contagent-test",
+			"contagent.commit":  "0123456789abcdef",
+			"team":              "platform",
+		}
+		_, err := docker.NewClient(mock).BuildImage(context.Background(), runtime.BuildImageOptions{
+			DockerfilePath: dockerfilePath,
+			ImageName:      "test:latest",
+			Labels:         labels,
+		}, newMockWriter())
+		require.NoError(t, err)
+
+		require.Equal(t, labels, capturedOptions.Labels)
+	})
+
+	t.Run("forwards the target to the Docker API", func(t *testing.T) {
+		dockerfilePath := filepath.Join(t.TempDir(), "Dockerfile")
+		require.NoError(t, os.WriteFile(dockerfilePath, []byte("FROM scratch AS dev\nFROM dev AS prod\n"), 0600))
+
+		var capturedOptions client.ImageBuildOptions
+		mock := &mockDockerClient{
+			imageBuildFunc: func(ctx context.Context, buildContext io.Reader, options client.ImageBuildOptions) (client.ImageBuildResult, error) {
+				io.Copy(io.Discard, buildContext) //nolint:errcheck // draining pipe for goroutine completion
+				capturedOptions = options
+				return client.ImageBuildResult{
+					Body: io.NopCloser(bytes.NewReader(nil)),
+				}, nil
+			},
+		}
+
+		_, err := docker.NewClient(mock).BuildImage(context.Background(), runtime.BuildImageOptions{
+			DockerfilePath: dockerfilePath,
+			ImageName:      "test:latest",
+			Target:         "dev",
+		}, newMockWriter())
+		require.NoError(t, err)
+
+		require.Equal(t, "dev", capturedOptions.Target)
+		require.True(t, capturedOptions.Remove, "intermediate containers are removed by default")
+		require.False(t, capturedOptions.NoCache)
+	})
+
+	t.Run("sends the build context directory honoring .dockerignore", func(t *testing.T) {
+		contextDir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(contextDir, "Dockerfile"), []byte("FROM alpine:latest\nCOPY hello.txt /hello.txt\n"), 0600))
+		require.NoError(t, os.WriteFile(filepath.Join(contextDir, "hello.txt"), []byte("hello\n"), 0600))
+		require.NoError(t, os.WriteFile(filepath.Join(contextDir, "secret.env"), []byte("TOKEN=abc\n"), 0600))
+		require.NoError(t, os.MkdirAll(filepath.Join(contextDir, "node_modules", "pkg"), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(contextDir, "node_modules", "pkg", "index.js"), []byte("x"), 0600))
+		require.NoError(t, os.MkdirAll(filepath.Join(contextDir, "src"), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(contextDir, "src", "main.go"), []byte("package main\n"), 0600))
+		require.NoError(t, os.WriteFile(filepath.Join(contextDir, "src", "debug.log"), []byte("log"), 0600))
+		require.NoError(t, os.WriteFile(filepath.Join(contextDir, "src", "keep.log"), []byte("log"), 0600))
+		require.NoError(t, os.WriteFile(filepath.Join(contextDir, ".dockerignore"), []byte("# comment\n*.env\nnode_modules\n**/*.log\n!src/keep.log\n"), 0600))
+
+		var capturedOptions client.ImageBuildOptions
+		files := map[string]string{}
+		mock := &mockDockerClient{
+			imageBuildFunc: func(ctx context.Context, buildContext io.Reader, options client.ImageBuildOptions) (client.ImageBuildResult, error) {
+				capturedOptions = options
+				tr := tar.NewReader(buildContext)
+				for {
+					header, err := tr.Next()
+					if errors.Is(err, io.EOF) {
+						break
+					}
+					require.NoError(t, err)
+					content, err := io.ReadAll(tr)
+					require.NoError(t, err)
+					files[header.Name] = string(content)
+				}
+				return client.ImageBuildResult{
+					Body: io.NopCloser(bytes.NewReader(nil)),
+				}, nil
+			},
+		}
+
+		c := docker.NewClient(mock)
+		_, err := c.BuildImage(context.Background(), runtime.BuildImageOptions{
+			DockerfilePath: filepath.Join(contextDir, "Dockerfile"),
+			ImageName:      "test:latest",
+		}, newMockWriter())
+		require.NoError(t, err)
+
+		require.Equal(t, "Dockerfile", capturedOptions.Dockerfile)
+		require.Contains(t, files, "Dockerfile")
+		require.Contains(t, files, ".dockerignore")
+		require.Equal(t, "hello\n", files["hello.txt"])
+		require.Contains(t, files, "src/")
+		require.Contains(t, files, "src/main.go")
+		require.Contains(t, files, "src/keep.log")
+		require.NotContains(t, files, "secret.env")
+		require.NotContains(t, files, "node_modules/")
+		require.NotContains(t, files, "node_modules/pkg/index.js")
+		require.NotContains(t, files, "src/debug.log")
+	})
+
+	t.Run("adds a Dockerfile outside of the build context", func(t *testing.T) {
+		contextDir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(contextDir, "hello.txt"), []byte("hello\n"), 0600))
+
+		dockerfilePath := filepath.Join(t.TempDir(), "Dockerfile.custom")
+		require.NoError(t, os.WriteFile(dockerfilePath, []byte("FROM alpine:latest\n"), 0600))
+
+		var capturedOptions client.ImageBuildOptions
+		files := map[string]string{}
+		mock := &mockDockerClient{
+			imageBuildFunc: func(ctx context.Context, buildContext io.Reader, options client.ImageBuildOptions) (client.ImageBuildResult, error) {
+				capturedOptions = options
+				tr := tar.NewReader(buildContext)
+				for {
+					header, err := tr.Next()
+					if errors.Is(err, io.EOF) {
+						break
+					}
+					require.NoError(t, err)
+					content, err := io.ReadAll(tr)
+					require.NoError(t, err)
+					files[header.Name] = string(content)
+				}
+				return client.ImageBuildResult{
+					Body: io.NopCloser(bytes.NewReader(nil)),
+				}, nil
+			},
+		}
+
+		c := docker.NewClient(mock)
+		_, err := c.BuildImage(context.Background(), runtime.BuildImageOptions{
+			DockerfilePath: dockerfilePath,
+			ContextDir:     contextDir,
+			ImageName:      "test:latest",
+		}, newMockWriter())
+		require.NoError(t, err)
+
+		require.Equal(t, ".contagent.Dockerfile", capturedOptions.Dockerfile)
+		require.Equal(t, "FROM alpine:latest\n", files[".contagent.Dockerfile"])
+		require.Equal(t, "hello\n", files["hello.txt"])
+	})
+
+	t.Run("fails when the build context cannot be written", func(t *testing.T) {
+		contextDir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(contextDir, "Dockerfile"), []byte("FROM scratch\n"), 0600))
+		large := filepath.Join(contextDir, "large.bin")
+		require.NoError(t, os.WriteFile(large, make([]byte, 1<<20), 0600))
+
+		mock := &mockDockerClient{
+			imageBuildFunc: func(ctx context.Context, buildContext io.Reader, options client.ImageBuildOptions) (client.ImageBuildResult, error) {
+				// Shrink the file once its header has been sent, so the archive comes
+				// up short of the size it announced
+				tr := tar.NewReader(buildContext)
+				for {
+					header, err := tr.Next()
+					require.NoError(t, err)
+					if header.Name == "large.bin" {
+						break
+					}
+				}
+				require.NoError(t, os.Truncate(large, 0))
+				io.Copy(io.Discard, buildContext) //nolint:errcheck // draining pipe for goroutine completion
+
+				return client.ImageBuildResult{
+					Body: io.NopCloser(strings.NewReader(`{"stream":"Successfully built 0123456789ab\n"}` + "\n")),
+				}, nil
+			},
+		}
+
+		writer := newMockWriter()
+		_, err := docker.NewClient(mock).BuildImage(context.Background(), runtime.BuildImageOptions{
+			DockerfilePath: filepath.Join(contextDir, "Dockerfile"),
+			ImageName:      "test:latest",
+		}, writer)
+		require.ErrorContains(t, err, "failed to finish build context archive")
+		require.ErrorContains(t, err, "This is a system error with tar archive creation")
+		require.NotContains(t, writer.String(), "Built image")
+	})
+
 	t.Run("handles context cancellation", func(t *testing.T) {
 		tmpDir, err := os.MkdirTemp("", "docker-mock-test")
 		require.NoError(t, err)
@@ -142,9 +487,46 @@ func TestBuildImageWithMock(t *testing.T) {
 		ctx, cancel := context.WithCancel(context.Background())
 		cancel()
 
-		_, err = c.BuildImage(ctx, dockerfilePath, "test:latest", writer)
+		_, err = c.BuildImage(ctx, runtime.BuildImageOptions{DockerfilePath: dockerfilePath, ImageName: "test:latest"}, writer)
 		require.Error(t, err)
 	})
+
+	t.Run("returns promptly when cancelled while the daemon is quiet", func(t *testing.T) {
+		dockerfilePath := filepath.Join(t.TempDir(), "Dockerfile")
+		require.NoError(t, os.WriteFile(dockerfilePath, []byte("FROM scratch\n"), 0600))
+
+		// The body never produces output, and only unblocks once it is closed
+		body, bodyWriter := io.Pipe()
+		defer bodyWriter.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		mock := &mockDockerClient{
+			imageBuildFunc: func(ctx context.Context, buildContext io.Reader, options client.ImageBuildOptions) (client.ImageBuildResult, error) {
+				io.Copy(io.Discard, buildContext) //nolint:errcheck // draining pipe for goroutine completion
+				return client.ImageBuildResult{Body: body}, nil
+			},
+		}
+
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			cancel()
+		}()
+
+		errs := make(chan error, 1)
+		go func() {
+			_, err := docker.NewClient(mock).BuildImage(ctx, runtime.BuildImageOptions{DockerfilePath: dockerfilePath, ImageName: "test:latest"}, newMockWriter())
+			errs <- err
+		}()
+
+		select {
+		case err := <-errs:
+			require.ErrorIs(t, err, context.Canceled)
+		case <-time.After(5 * time.Second):
+			t.Fatal("BuildImage did not return after the context was cancelled")
+		}
+	})
 }
 
 // TestCreateContainerWithMock tests CreateContainer using a mock Docker client
@@ -206,6 +588,25 @@ func TestCreateContainerWithMock(t *testing.T) {
 		require.Contains(t, err.Error(), "failed to create container")
 	})
 
+	t.Run("fails when the session ID is not a valid container name", func(t *testing.T) {
+		mock := &mockDockerClient{
+			containerCreateFunc: func(ctx context.Context, options client.ContainerCreateOptions) (client.ContainerCreateResult, error) {
+				t.Fatal("ContainerCreate should not be called")
+				return client.ContainerCreateResult{}, nil
+			},
+		}
+
+		c := docker.NewClient(mock)
+		ctx := context.Background()
+
+		_, err := c.CreateContainer(ctx, runtime.CreateContainerOptions{
+			SessionID: "-my agent",
+			Image:     runtime.Image{Name: "alpine:latest"},
+			Args:      []string{"echo", "test"},
+		})
+		require.ErrorContains(t, err, `invalid container name "-my agent"`)
+	})
+
 	t.Run("passes correct configuration to Docker API", func(t *testing.T) {
 		var capturedOptions client.ContainerCreateOptions
 
@@ -231,6 +632,7 @@ func TestCreateContainerWithMock(t *testing.T) {
 			Volumes:     volumes,
 			WorkingDir:  workingDir,
 			Network:     "some-network",
+			TTY:         true,
 			StopTimeout: 10,
 			TTYRetries:  10,
 			RetryDelay:  100 * time.Millisecond,
@@ -247,43 +649,1372 @@ func TestCreateContainerWithMock(t *testing.T) {
 		require.Contains(t, capturedOptions.HostConfig.ExtraHosts, "host.docker.internal:host-gateway")
 		require.Equal(t, "test-name", capturedOptions.Name)
 	})
-}
 
-// TestClientClose tests that Close works correctly
-func TestClientClose(t *testing.T) {
-	t.Run("calls close on underlying client", func(t *testing.T) {
-		closeCalled := false
+	t.Run("passes the entrypoint", func(t *testing.T) {
+		for name, entrypoint := range map[string][]string{
+			"image default": nil,
+			"override":      {"/bin/sh"},
+			"reset":         {},
+		} {
+			var capturedOptions client.ContainerCreateOptions
+			mock := &mockDockerClient{
+				containerCreateFunc: func(ctx context.Context, options client.ContainerCreateOptions) (client.ContainerCreateResult, error) {
+					capturedOptions = options
+					return client.ContainerCreateResult{ID: "container123"}, nil
+				},
+			}
+
+			c := docker.NewClient(mock)
+			_, err := c.CreateContainer(context.Background(), runtime.CreateContainerOptions{
+				SessionID:  "test-name",
+				Image:      runtime.Image{Name: "alpine:latest"},
+				Args:       []string{"sh"},
+				Entrypoint: entrypoint,
+			})
+			require.NoError(t, err, name)
+
+			// A nil entrypoint keeps the image's, while an empty one resets it
+			require.Equal(t, entrypoint, capturedOptions.Config.Entrypoint, name)
+			require.Equal(t, entrypoint == nil, capturedOptions.Config.Entrypoint == nil, name)
+		}
+	})
+
+	t.Run("runs the container as the configured user", func(t *testing.T) {
+		var capturedOptions client.ContainerCreateOptions
 		mock := &mockDockerClient{
-			closeFunc: func() error {
-				closeCalled = true
-				return nil
+			containerCreateFunc: func(ctx context.Context, options client.ContainerCreateOptions) (client.ContainerCreateResult, error) {
+				capturedOptions = options
+				return client.ContainerCreateResult{ID: "container123"}, nil
 			},
 		}
 
 		c := docker.NewClient(mock)
-		err := c.Close()
+		_, err := c.CreateContainer(context.Background(), runtime.CreateContainerOptions{
+			SessionID: "test-name",
+			Image:     runtime.Image{Name: "alpine:latest"},
+			Args:      []string{"sh"},
+			User:      "1000:1000",
+		})
+		require.NoError(t, err)
+
+		require.Equal(t, "1000:1000", capturedOptions.Config.User)
+	})
+
+	t.Run("labels the container with the session ID", func(t *testing.T) {
+		var capturedOptions client.ContainerCreateOptions
+		mock := &mockDockerClient{
+			containerCreateFunc: func(ctx context.Context, options client.ContainerCreateOptions) (client.ContainerCreateResult, error) {
+				capturedOptions = options
+				return client.ContainerCreateResult{ID: "container123"}, nil
+			},
+		}
 
+		labels := map[string]string{"team": "platform"}
+
+		c := docker.NewClient(mock)
+		_, err := c.CreateContainer(context.Background(), runtime.CreateContainerOptions{
+			SessionID: "test-name",
+			Image:     runtime.Image{Name: "alpine:latest"},
+			Args:      []string{"sh"},
+			Labels:    labels,
+		})
 		require.NoError(t, err)
-		require.True(t, closeCalled)
+
+		require.Equal(t, map[string]string{
+			"contagent.session": "test-name",
+			"team":              "platform",
+		}, capturedOptions.Config.Labels)
+		require.Equal(t, map[string]string{"team": "platform"}, labels, "the configured labels should not be modified")
 	})
 
-	t.Run("returns close error", func(t *testing.T) {
+	t.Run("mounts a read-only root filesystem with tmpfs and anonymous volumes", func(t *testing.T) {
+		var capturedOptions client.ContainerCreateOptions
 		mock := &mockDockerClient{
-			closeFunc: func() error {
-				return errors.New("close failed")
+			containerCreateFunc: func(ctx context.Context, options client.ContainerCreateOptions) (client.ContainerCreateResult, error) {
+				capturedOptions = options
+				return client.ContainerCreateResult{ID: "container123"}, nil
 			},
 		}
 
 		c := docker.NewClient(mock)
-		err := c.Close()
-		require.Error(t, err)
-		require.Contains(t, err.Error(), "close failed")
+		_, err := c.CreateContainer(context.Background(), runtime.CreateContainerOptions{
+			SessionID: "test-name",
+			Image:     runtime.Image{Name: "alpine:latest"},
+			Args:      []string{"sh"},
+			Volumes:   []string{"/host:/container", "/app"},
+			ReadOnly:  true,
+			Tmpfs:     map[string]string{"/tmp": "", "/run": "size=64m"},
+		})
+		require.NoError(t, err)
+
+		require.True(t, capturedOptions.HostConfig.ReadonlyRootfs)
+		require.Equal(t, map[string]string{"/tmp": "", "/run": "size=64m"}, capturedOptions.HostConfig.Tmpfs)
+		require.Equal(t, []string{"/host:/container"}, capturedOptions.HostConfig.Binds)
+		require.Equal(t, map[string]struct{}{"/app": {}}, capturedOptions.Config.Volumes)
 	})
-}
 
-// TestHostAddress tests HostAddress returns correct value
-func TestHostAddress(t *testing.T) {
-	mock := &mockDockerClient{}
-	c := docker.NewClient(mock)
-	require.Equal(t, "host.docker.internal", c.HostAddress())
+	t.Run("adds extra hosts after the host gateway alias", func(t *testing.T) {
+		var capturedOptions client.ContainerCreateOptions
+		mock := &mockDockerClient{
+			containerCreateFunc: func(ctx context.Context, options client.ContainerCreateOptions) (client.ContainerCreateResult, error) {
+				capturedOptions = options
+				return client.ContainerCreateResult{ID: "container123"}, nil
+			},
+		}
+
+		c := docker.NewClient(mock)
+		_, err := c.CreateContainer(context.Background(), runtime.CreateContainerOptions{
+			SessionID:  "test-name",
+			Image:      runtime.Image{Name: "alpine:latest"},
+			Args:       []string{"sh"},
+			ExtraHosts: []string{"db.local:10.0.0.5", "host.local:host-gateway"},
+		})
+		require.NoError(t, err)
+
+		require.Equal(t, []string{
+			"host.docker.internal:host-gateway",
+			"db.local:10.0.0.5",
+			"host.local:host-gateway",
+		}, capturedOptions.HostConfig.ExtraHosts)
+	})
+
+	t.Run("omits the host gateway alias when disabled", func(t *testing.T) {
+		var capturedOptions client.ContainerCreateOptions
+		mock := &mockDockerClient{
+			containerCreateFunc: func(ctx context.Context, options client.ContainerCreateOptions) (client.ContainerCreateResult, error) {
+				capturedOptions = options
+				return client.ContainerCreateResult{ID: "container123"}, nil
+			},
+		}
+
+		c := docker.NewClient(mock)
+		_, err := c.CreateContainer(context.Background(), runtime.CreateContainerOptions{
+			SessionID:  "test-name",
+			Image:      runtime.Image{Name: "alpine:latest"},
+			Args:       []string{"sh"},
+			ExtraHosts: []string{"host.local:host-gateway"},
+			NoGateway:  true,
+		})
+		require.NoError(t, err)
+
+		require.Equal(t, []string{"host.local:host-gateway"}, capturedOptions.HostConfig.ExtraHosts)
+	})
+
+	t.Run("applies memory and CPU limits", func(t *testing.T) {
+		var capturedOptions client.ContainerCreateOptions
+		mock := &mockDockerClient{
+			containerCreateFunc: func(ctx context.Context, options client.ContainerCreateOptions) (client.ContainerCreateResult, error) {
+				capturedOptions = options
+				return client.ContainerCreateResult{ID: "container123"}, nil
+			},
+		}
+
+		c := docker.NewClient(mock)
+		_, err := c.CreateContainer(context.Background(), runtime.CreateContainerOptions{
+			SessionID: "test-name",
+			Image:     runtime.Image{Name: "alpine:latest"},
+			Args:      []string{"sh"},
+			Memory:    2 * 1024 * 1024 * 1024,
+			CPUs:      1.5,
+		})
+		require.NoError(t, err)
+
+		require.Equal(t, int64(2*1024*1024*1024), capturedOptions.HostConfig.Memory)
+		require.Equal(t, int64(1500000000), capturedOptions.HostConfig.NanoCPUs)
+	})
+
+	t.Run("publishes ports to the host", func(t *testing.T) {
+		var capturedOptions client.ContainerCreateOptions
+		mock := &mockDockerClient{
+			containerCreateFunc: func(ctx context.Context, options client.ContainerCreateOptions) (client.ContainerCreateResult, error) {
+				capturedOptions = options
+				return client.ContainerCreateResult{ID: "container123"}, nil
+			},
+		}
+
+		c := docker.NewClient(mock)
+		_, err := c.CreateContainer(context.Background(), runtime.CreateContainerOptions{
+			SessionID: "test-name",
+			Image:     runtime.Image{Name: "alpine:latest"},
+			Args:      []string{"sh"},
+			Ports: []internal.PortMapping{
+				{HostPort: 8080, ContainerPort: 3000, Protocol: "tcp"},
+				{HostPort: 8081, ContainerPort: 3000, Protocol: "tcp"},
+				{HostPort: 5353, ContainerPort: 53, Protocol: "udp"},
+			},
+		})
+		require.NoError(t, err)
+
+		require.Equal(t, network.PortMap{
+			network.MustParsePort("3000/tcp"): {
+				{HostPort: "8080"},
+				{HostPort: "8081"},
+			},
+			network.MustParsePort("53/udp"): {
+				{HostPort: "5353"},
+			},
+		}, capturedOptions.HostConfig.PortBindings)
+		require.Equal(t, network.PortSet{
+			network.MustParsePort("3000/tcp"): {},
+			network.MustParsePort("53/udp"):   {},
+		}, capturedOptions.Config.ExposedPorts)
+	})
+
+	t.Run("forwards the platform to the Docker API", func(t *testing.T) {
+		var capturedOptions client.ContainerCreateOptions
+		mock := &mockDockerClient{
+			containerCreateFunc: func(ctx context.Context, options client.ContainerCreateOptions) (client.ContainerCreateResult, error) {
+				capturedOptions = options
+				return client.ContainerCreateResult{ID: "container123"}, nil
+			},
+		}
+
+		opts := createTestContainerOpts()
+		opts.Platform = "linux/amd64"
+		_, err := docker.NewClient(mock).CreateContainer(context.Background(), opts)
+		require.NoError(t, err)
+
+		require.Equal(t, &ocispec.Platform{OS: "linux", Architecture: "amd64"}, capturedOptions.Platform)
+	})
+
+	t.Run("leaves the platform to the daemon by default", func(t *testing.T) {
+		var capturedOptions client.ContainerCreateOptions
+		mock := &mockDockerClient{
+			containerCreateFunc: func(ctx context.Context, options client.ContainerCreateOptions) (client.ContainerCreateResult, error) {
+				capturedOptions = options
+				return client.ContainerCreateResult{ID: "container123"}, nil
+			},
+		}
+
+		_, err := docker.NewClient(mock).CreateContainer(context.Background(), createTestContainerOpts())
+		require.NoError(t, err)
+
+		require.Nil(t, capturedOptions.Platform)
+	})
+
+	t.Run("leaves resources unlimited by default", func(t *testing.T) {
+		var capturedOptions client.ContainerCreateOptions
+		mock := &mockDockerClient{
+			containerCreateFunc: func(ctx context.Context, options client.ContainerCreateOptions) (client.ContainerCreateResult, error) {
+				capturedOptions = options
+				return client.ContainerCreateResult{ID: "container123"}, nil
+			},
+		}
+
+		c := docker.NewClient(mock)
+		_, err := c.CreateContainer(context.Background(), createTestContainerOpts())
+		require.NoError(t, err)
+
+		require.Zero(t, capturedOptions.HostConfig.Memory)
+		require.Zero(t, capturedOptions.HostConfig.NanoCPUs)
+	})
+
+	t.Run("forwards the restart policy", func(t *testing.T) {
+		var capturedOptions client.ContainerCreateOptions
+		mock := &mockDockerClient{
+			containerCreateFunc: func(ctx context.Context, options client.ContainerCreateOptions) (client.ContainerCreateResult, error) {
+				capturedOptions = options
+				return client.ContainerCreateResult{ID: "container123"}, nil
+			},
+		}
+
+		c := docker.NewClient(mock)
+		opts := createTestContainerOpts()
+		opts.Restart = internal.RestartPolicy{Name: internal.RestartOnFailure, MaxRetries: 3}
+		_, err := c.CreateContainer(context.Background(), opts)
+		require.NoError(t, err)
+
+		require.Equal(t, container.RestartPolicy{
+			Name:              container.RestartPolicyOnFailure,
+			MaximumRetryCount: 3,
+		}, capturedOptions.HostConfig.RestartPolicy)
+	})
+
+	t.Run("disables TTY and closes stdin after attach when TTY is off", func(t *testing.T) {
+		var capturedOptions client.ContainerCreateOptions
+		mock := &mockDockerClient{
+			containerCreateFunc: func(ctx context.Context, options client.ContainerCreateOptions) (client.ContainerCreateResult, error) {
+				capturedOptions = options
+				return client.ContainerCreateResult{ID: "container123"}, nil
+			},
+		}
+
+		c := docker.NewClient(mock)
+		container, err := c.CreateContainer(context.Background(), runtime.CreateContainerOptions{
+			SessionID: "test-name",
+			Image:     runtime.Image{Name: "alpine:latest"},
+			Args:      []string{"cat"},
+		})
+		require.NoError(t, err)
+
+		require.False(t, capturedOptions.Config.Tty)
+		require.True(t, capturedOptions.Config.OpenStdin)
+		require.True(t, capturedOptions.Config.StdinOnce)
+
+		dockerContainer, ok := container.(docker.Container)
+		require.True(t, ok)
+		require.False(t, dockerContainer.TTY)
+	})
+
+	t.Run("creates the container without stdin when NoStdin is set", func(t *testing.T) {
+		var capturedOptions client.ContainerCreateOptions
+		mock := &mockDockerClient{
+			containerCreateFunc: func(ctx context.Context, options client.ContainerCreateOptions) (client.ContainerCreateResult, error) {
+				capturedOptions = options
+				return client.ContainerCreateResult{ID: "container123"}, nil
+			},
+		}
+
+		_, err := docker.NewClient(mock).CreateContainer(context.Background(), runtime.CreateContainerOptions{
+			SessionID: "test-name",
+			Image:     runtime.Image{Name: "alpine:latest"},
+			Args:      []string{"make", "test"},
+			NoStdin:   true,
+		})
+		require.NoError(t, err)
+
+		require.False(t, capturedOptions.Config.Tty)
+		require.False(t, capturedOptions.Config.OpenStdin)
+		require.False(t, capturedOptions.Config.StdinOnce)
+		require.False(t, capturedOptions.Config.AttachStdin)
+	})
+}
+
+// TestClientClose tests that Close works correctly
+func TestClientClose(t *testing.T) {
+	t.Run("calls close on underlying client", func(t *testing.T) {
+		closeCalled := false
+		mock := &mockDockerClient{
+			closeFunc: func() error {
+				closeCalled = true
+				return nil
+			},
+		}
+
+		c := docker.NewClient(mock)
+		err := c.Close()
+
+		require.NoError(t, err)
+		require.True(t, closeCalled)
+	})
+
+	t.Run("returns close error", func(t *testing.T) {
+		mock := &mockDockerClient{
+			closeFunc: func() error {
+				return errors.New("close failed")
+			},
+		}
+
+		c := docker.NewClient(mock)
+		err := c.Close()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "close failed")
+	})
+}
+
+// TestEnsureNetworkWithMock tests that EnsureNetwork only creates missing networks
+func TestEnsureNetworkWithMock(t *testing.T) {
+	t.Run("creates a bridge network when it is missing", func(t *testing.T) {
+		var created string
+		var options client.NetworkCreateOptions
+		mock := &mockDockerClient{
+			networkListFunc: func(ctx context.Context, opts client.NetworkListOptions) (client.NetworkListResult, error) {
+				// The name filter also matches partial names
+				return client.NetworkListResult{Items: []network.Summary{
+					{Network: network.Network{Name: "my-network-2"}},
+				}}, nil
+			},
+			networkCreateFunc: func(ctx context.Context, name string, opts client.NetworkCreateOptions) (client.NetworkCreateResult, error) {
+				created = name
+				options = opts
+				return client.NetworkCreateResult{ID: "network-id"}, nil
+			},
+		}
+
+		c := docker.NewClient(mock)
+		ok, err := c.EnsureNetwork(context.Background(), "my-network")
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, "my-network", created)
+		require.Equal(t, "bridge", options.Driver)
+	})
+
+	t.Run("leaves an existing network alone", func(t *testing.T) {
+		var filters client.Filters
+		mock := &mockDockerClient{
+			networkListFunc: func(ctx context.Context, opts client.NetworkListOptions) (client.NetworkListResult, error) {
+				filters = opts.Filters
+				return client.NetworkListResult{Items: []network.Summary{
+					{Network: network.Network{Name: "my-network"}},
+				}}, nil
+			},
+			networkCreateFunc: func(ctx context.Context, name string, opts client.NetworkCreateOptions) (client.NetworkCreateResult, error) {
+				t.Fatal("network should not be created")
+				return client.NetworkCreateResult{}, nil
+			},
+		}
+
+		c := docker.NewClient(mock)
+		ok, err := c.EnsureNetwork(context.Background(), "my-network")
+		require.NoError(t, err)
+		require.False(t, ok)
+		require.Equal(t, client.Filters{"name": {"my-network": true}}, filters)
+	})
+
+	t.Run("skips built-in networks", func(t *testing.T) {
+		mock := &mockDockerClient{
+			networkListFunc: func(ctx context.Context, opts client.NetworkListOptions) (client.NetworkListResult, error) {
+				t.Fatal("networks should not be listed")
+				return client.NetworkListResult{}, nil
+			},
+		}
+
+		c := docker.NewClient(mock)
+		for _, name := range []string{"", "default", "host", "bridge", "none", "container:other"} {
+			ok, err := c.EnsureNetwork(context.Background(), name)
+			require.NoError(t, err, name)
+			require.False(t, ok, name)
+		}
+	})
+
+	t.Run("returns error when the network cannot be created", func(t *testing.T) {
+		mock := &mockDockerClient{
+			networkListFunc: func(ctx context.Context, opts client.NetworkListOptions) (client.NetworkListResult, error) {
+				return client.NetworkListResult{}, nil
+			},
+			networkCreateFunc: func(ctx context.Context, name string, opts client.NetworkCreateOptions) (client.NetworkCreateResult, error) {
+				return client.NetworkCreateResult{}, errors.New("create failed")
+			},
+		}
+
+		c := docker.NewClient(mock)
+		_, err := c.EnsureNetwork(context.Background(), "my-network")
+		require.ErrorContains(t, err, `failed to create network "my-network": create failed`)
+	})
+
+	t.Run("removes the network", func(t *testing.T) {
+		var removed string
+		mock := &mockDockerClient{
+			networkRemoveFunc: func(ctx context.Context, networkID string, opts client.NetworkRemoveOptions) (client.NetworkRemoveResult, error) {
+				removed = networkID
+				return client.NetworkRemoveResult{}, nil
+			},
+		}
+
+		c := docker.NewClient(mock)
+		require.NoError(t, c.RemoveNetwork(context.Background(), "my-network"))
+		require.Equal(t, "my-network", removed)
+	})
+}
+
+func TestPruneOrphansWithMock(t *testing.T) {
+	t.Run("removes containers of other sessions", func(t *testing.T) {
+		var options client.ContainerListOptions
+		var removed []string
+		var force bool
+		mock := &mockDockerClient{
+			containerListFunc: func(ctx context.Context, opts client.ContainerListOptions) (client.ContainerListResult, error) {
+				options = opts
+				return client.ContainerListResult{Items: []container.Summary{
+					{ID: "current-id", Labels: map[string]string{"contagent.session": "contagent-current"}},
+					{ID: "stale-id", Labels: map[string]string{"contagent.session": "contagent-stale"}},
+				}}, nil
+			},
+			containerRemoveFunc: func(ctx context.Context, containerID string, opts client.ContainerRemoveOptions) (client.ContainerRemoveResult, error) {
+				removed = append(removed, containerID)
+				force = opts.Force
+				return client.ContainerRemoveResult{}, nil
+			},
+		}
+
+		w := newMockWriter()
+		c := docker.NewClient(mock)
+		require.NoError(t, c.PruneOrphans(context.Background(), "contagent-current", w))
+
+		require.True(t, options.All)
+		require.Equal(t, client.Filters{
+			"label":  {"contagent.session": true},
+			"status": {"created": true, "exited": true},
+		}, options.Filters)
+		require.Equal(t, []string{"stale-id"}, removed)
+		require.True(t, force)
+		require.Contains(t, w.String(), "Removed orphaned container contagent-stale")
+		require.NotContains(t, w.String(), "contagent-current")
+	})
+
+	t.Run("removes the other containers when one cannot be removed", func(t *testing.T) {
+		mock := &mockDockerClient{
+			containerListFunc: func(ctx context.Context, opts client.ContainerListOptions) (client.ContainerListResult, error) {
+				return client.ContainerListResult{Items: []container.Summary{
+					{ID: "stuck-id", Labels: map[string]string{"contagent.session": "contagent-stuck"}},
+					{ID: "stale-id", Labels: map[string]string{"contagent.session": "contagent-stale"}},
+					{ID: "busy-id", Labels: map[string]string{"contagent.session": "contagent-busy"}},
+				}}, nil
+			},
+			containerRemoveFunc: func(ctx context.Context, containerID string, opts client.ContainerRemoveOptions) (client.ContainerRemoveResult, error) {
+				if containerID != "stale-id" {
+					return client.ContainerRemoveResult{}, errors.New("remove failed")
+				}
+				return client.ContainerRemoveResult{}, nil
+			},
+		}
+
+		w := newMockWriter()
+		c := docker.NewClient(mock)
+		err := c.PruneOrphans(context.Background(), "contagent-current", w)
+		require.ErrorContains(t, err, `failed to remove orphaned container "contagent-stuck": remove failed`)
+		require.ErrorContains(t, err, `failed to remove orphaned container "contagent-busy": remove failed`)
+		require.Contains(t, w.String(), "Removed orphaned container contagent-stale")
+	})
+
+	t.Run("returns error when containers cannot be listed", func(t *testing.T) {
+		mock := &mockDockerClient{
+			containerListFunc: func(ctx context.Context, opts client.ContainerListOptions) (client.ContainerListResult, error) {
+				return client.ContainerListResult{}, errors.New("list failed")
+			},
+		}
+
+		c := docker.NewClient(mock)
+		err := c.PruneOrphans(context.Background(), "contagent-current", newMockWriter())
+		require.ErrorContains(t, err, "failed to list containers: list failed")
+	})
+}
+
+func TestRemoveImageWithMock(t *testing.T) {
+	t.Run("removes the cache tag and then the name without force", func(t *testing.T) {
+		var removedRefs []string
+		var removeOptions []client.ImageRemoveOptions
+		mock := &mockDockerClient{
+			imageRemoveFunc: func(ctx context.Context, imageID string, opts client.ImageRemoveOptions) (client.ImageRemoveResult, error) {
+				removedRefs = append(removedRefs, imageID)
+				removeOptions = append(removeOptions, opts)
+				return client.ImageRemoveResult{}, nil
+			},
+		}
+
+		c := docker.NewClient(mock)
+		err := c.RemoveImage(context.Background(), runtime.Image{Name: "contagent:latest", CacheTag: "contagent:cache-abc123"})
+		require.NoError(t, err)
+
+		require.Equal(t, []string{"contagent:cache-abc123", "contagent:latest"}, removedRefs)
+		require.Equal(t, []client.ImageRemoveOptions{{PruneChildren: true}, {PruneChildren: true}}, removeOptions)
+	})
+
+	t.Run("removes only the name when the image has no cache tag", func(t *testing.T) {
+		var removedRefs []string
+		mock := &mockDockerClient{
+			imageRemoveFunc: func(ctx context.Context, imageID string, opts client.ImageRemoveOptions) (client.ImageRemoveResult, error) {
+				removedRefs = append(removedRefs, imageID)
+				return client.ImageRemoveResult{}, nil
+			},
+		}
+
+		c := docker.NewClient(mock)
+		require.NoError(t, c.RemoveImage(context.Background(), runtime.Image{Name: "contagent:latest"}))
+
+		require.Equal(t, []string{"contagent:latest"}, removedRefs)
+	})
+
+	t.Run("returns ErrImageInUse when a container uses the image", func(t *testing.T) {
+		mock := &mockDockerClient{
+			imageRemoveFunc: func(ctx context.Context, imageID string, opts client.ImageRemoveOptions) (client.ImageRemoveResult, error) {
+				if imageID == "contagent:latest" {
+					return client.ImageRemoveResult{}, cerrdefs.ErrConflict
+				}
+				return client.ImageRemoveResult{}, nil
+			},
+		}
+
+		c := docker.NewClient(mock)
+		err := c.RemoveImage(context.Background(), runtime.Image{Name: "contagent:latest", CacheTag: "contagent:cache-abc123"})
+		require.ErrorIs(t, err, docker.ErrImageInUse)
+	})
+
+	t.Run("returns error when a reference cannot be removed", func(t *testing.T) {
+		mock := &mockDockerClient{
+			imageRemoveFunc: func(ctx context.Context, imageID string, opts client.ImageRemoveOptions) (client.ImageRemoveResult, error) {
+				return client.ImageRemoveResult{}, errors.New("No such image")
+			},
+		}
+
+		c := docker.NewClient(mock)
+		err := c.RemoveImage(context.Background(), runtime.Image{Name: "contagent:latest", CacheTag: "contagent:cache-abc123"})
+		require.ErrorContains(t, err, `failed to remove image "contagent:cache-abc123": No such image`)
+	})
+}
+
+func TestHealthcheckWithMock(t *testing.T) {
+	t.Run("succeeds when the daemon responds", func(t *testing.T) {
+		mock := &mockDockerClient{
+			pingFunc: func(ctx context.Context, options client.PingOptions) (client.PingResult, error) {
+				return client.PingResult{APIVersion: "1.51"}, nil
+			},
+		}
+
+		c := docker.NewClient(mock)
+		require.NoError(t, c.Healthcheck(context.Background()))
+	})
+
+	t.Run("returns error when the daemon is unreachable", func(t *testing.T) {
+		mock := &mockDockerClient{
+			pingFunc: func(ctx context.Context, options client.PingOptions) (client.PingResult, error) {
+				return client.PingResult{}, errors.New("connection refused")
+			},
+		}
+
+		c := docker.NewClient(mock)
+		err := c.Healthcheck(context.Background())
+		require.ErrorContains(t, err, "docker daemon unreachable: connection refused")
+		require.ErrorContains(t, err, "Make sure Docker is installed and running")
+	})
+}
+
+// TestHostAddress tests HostAddress returns correct value
+func TestHostAddress(t *testing.T) {
+	mock := &mockDockerClient{}
+	c := docker.NewClient(mock)
+	require.Equal(t, "host.docker.internal", c.HostAddress())
+}
+
+// TestBuildImageCacheWithMock tests that BuildImage reuses images built from unchanged inputs
+func TestBuildImageCacheWithMock(t *testing.T) {
+	setup := func(t *testing.T) string {
+		t.Helper()
+
+		dockerfilePath := filepath.Join(t.TempDir(), "Dockerfile")
+		require.NoError(t, os.WriteFile(dockerfilePath, []byte("FROM alpine:latest\n"), 0600))
+		return dockerfilePath
+	}
+
+	// buildTags runs a build that misses the cache and returns the tags it was given.
+	buildTags := func(t *testing.T, opts runtime.BuildImageOptions) []string {
+		t.Helper()
+
+		var tags []string
+		mock := &mockDockerClient{
+			imageInspectFunc: func(ctx context.Context, imageID string, inspectOpts ...client.ImageInspectOption) (client.ImageInspectResult, error) {
+				return client.ImageInspectResult{}, errors.New("No such image")
+			},
+			imageBuildFunc: func(ctx context.Context, buildContext io.Reader, options client.ImageBuildOptions) (client.ImageBuildResult, error) {
+				io.Copy(io.Discard, buildContext) //nolint:errcheck // draining pipe for goroutine completion
+				tags = options.Tags
+				return client.ImageBuildResult{
+					Body: io.NopCloser(bytes.NewReader(nil)),
+				}, nil
+			},
+		}
+
+		image, err := docker.NewClient(mock).BuildImage(context.Background(), opts, newMockWriter())
+		require.NoError(t, err)
+		require.Equal(t, string(opts.ImageName), image.Name)
+		return tags
+	}
+
+	t.Run("builds and tags with a digest on a cache miss", func(t *testing.T) {
+		dockerfilePath := setup(t)
+
+		tags := buildTags(t, runtime.BuildImageOptions{DockerfilePath: dockerfilePath, ImageName: "test:latest"})
+
+		require.Len(t, tags, 2)
+		require.Equal(t, "test:latest", tags[0])
+		require.Regexp(t, `^test:cache-[0-9a-f]{16}$`, tags[1])
+	})
+
+	t.Run("reuses the cached image on a cache hit", func(t *testing.T) {
+		dockerfilePath := setup(t)
+		opts := runtime.BuildImageOptions{DockerfilePath: dockerfilePath, ImageName: "registry.local:5000/test:latest"}
+		cacheTag := buildTags(t, opts)[1]
+		require.Regexp(t, `^registry.local:5000/test:cache-[0-9a-f]{16}$`, cacheTag)
+
+		var inspected string
+		var tagOptions client.ImageTagOptions
+		mock := &mockDockerClient{
+			imageInspectFunc: func(ctx context.Context, imageID string, inspectOpts ...client.ImageInspectOption) (client.ImageInspectResult, error) {
+				inspected = imageID
+				return client.ImageInspectResult{}, nil
+			},
+			imageTagFunc: func(ctx context.Context, options client.ImageTagOptions) (client.ImageTagResult, error) {
+				tagOptions = options
+				return client.ImageTagResult{}, nil
+			},
+			imageBuildFunc: func(ctx context.Context, buildContext io.Reader, options client.ImageBuildOptions) (client.ImageBuildResult, error) {
+				t.Fatal("image should not be rebuilt on a cache hit")
+				return client.ImageBuildResult{}, nil
+			},
+		}
+
+		writer := newMockWriter()
+		image, err := docker.NewClient(mock).BuildImage(context.Background(), opts, writer)
+		require.NoError(t, err)
+
+		require.Equal(t, "registry.local:5000/test:latest", image.Name)
+		require.Equal(t, cacheTag, inspected)
+		require.Equal(t, client.ImageTagOptions{Source: cacheTag, Target: "registry.local:5000/test:latest"}, tagOptions)
+		require.Contains(t, writer.String(), "Reusing cached image "+cacheTag)
+	})
+
+	t.Run("changes the digest when build inputs change", func(t *testing.T) {
+		dockerfilePath := setup(t)
+		opts := runtime.BuildImageOptions{DockerfilePath: dockerfilePath, ImageName: "test:latest"}
+		original := buildTags(t, opts)[1]
+
+		require.Equal(t, original, buildTags(t, opts)[1])
+
+		opts.BuildArgs = map[string]string{"VERSION": "2"}
+		require.NotEqual(t, original, buildTags(t, opts)[1])
+
+		opts.BuildArgs = nil
+		opts.Target = "dev"
+		require.NotEqual(t, original, buildTags(t, opts)[1])
+
+		opts.Target = ""
+		opts.Labels = map[string]string{"maintainer": "someone"}
+		require.NotEqual(t, original, buildTags(t, opts)[1])
+
+		opts.Labels = nil
+		require.NoError(t, os.WriteFile(dockerfilePath, []byte("FROM alpine:3.20\n"), 0600))
+		require.NotEqual(t, original, buildTags(t, opts)[1])
+	})
+
+	t.Run("keeps the digest across sessions and commits", func(t *testing.T) {
+		dockerfilePath := setup(t)
+		opts := runtime.BuildImageOptions{
+			DockerfilePath: dockerfilePath,
+			ImageName:      "test:latest",
+			Labels:         map[string]string{"contagent.session": "contagent-first", "contagent.commit": "0123456789abcdef"},
+		}
+		original := buildTags(t, opts)[1]
+
+		opts.Labels = map[string]string{"contagent.session": "contagent-second", "contagent.commit": "0123456789abcdef"}
+		require.Equal(t, original, buildTags(t, opts)[1])
+
+		opts.Labels = map[string]string{"contagent.session": "contagent-second", "contagent.commit": "fedcba9876543210"}
+		require.Equal(t, original, buildTags(t, opts)[1])
+	})
+
+	t.Run("skips the cache lookup when forcing a rebuild", func(t *testing.T) {
+		dockerfilePath := setup(t)
+
+		buildCalled := false
+		mock := &mockDockerClient{
+			imageInspectFunc: func(ctx context.Context, imageID string, inspectOpts ...client.ImageInspectOption) (client.ImageInspectResult, error) {
+				require.NotContains(t, imageID, ":cache-", "cache should not be consulted when forcing a rebuild")
+				return client.ImageInspectResult{}, nil
+			},
+			imageBuildFunc: func(ctx context.Context, buildContext io.Reader, options client.ImageBuildOptions) (client.ImageBuildResult, error) {
+				io.Copy(io.Discard, buildContext) //nolint:errcheck // draining pipe for goroutine completion
+				buildCalled = true
+				return client.ImageBuildResult{
+					Body: io.NopCloser(bytes.NewReader(nil)),
+				}, nil
+			},
+		}
+
+		_, err := docker.NewClient(mock).BuildImage(context.Background(), runtime.BuildImageOptions{
+			DockerfilePath: dockerfilePath,
+			ImageName:      "test:latest",
+			ForceRebuild:   true,
+		}, newMockWriter())
+		require.NoError(t, err)
+		require.True(t, buildCalled)
+	})
+
+	t.Run("forwards the layer cache and intermediate container options", func(t *testing.T) {
+		dockerfilePath := setup(t)
+
+		var buildOptions client.ImageBuildOptions
+		mock := &mockDockerClient{
+			imageInspectFunc: func(ctx context.Context, imageID string, inspectOpts ...client.ImageInspectOption) (client.ImageInspectResult, error) {
+				require.NotContains(t, imageID, ":cache-", "cache should not be consulted when building without the layer cache")
+				return client.ImageInspectResult{}, nil
+			},
+			imageBuildFunc: func(ctx context.Context, buildContext io.Reader, options client.ImageBuildOptions) (client.ImageBuildResult, error) {
+				io.Copy(io.Discard, buildContext) //nolint:errcheck // draining pipe for goroutine completion
+				buildOptions = options
+				return client.ImageBuildResult{
+					Body: io.NopCloser(bytes.NewReader(nil)),
+				}, nil
+			},
+		}
+
+		_, err := docker.NewClient(mock).BuildImage(context.Background(), runtime.BuildImageOptions{
+			DockerfilePath:   dockerfilePath,
+			ImageName:        "test:latest",
+			NoCache:          true,
+			KeepIntermediate: true,
+		}, newMockWriter())
+		require.NoError(t, err)
+		require.True(t, buildOptions.NoCache)
+		require.False(t, buildOptions.Remove)
+		require.False(t, buildOptions.ForceRemove)
+	})
+}
+
+// TestPullImageWithMock tests PullImage using a mock Docker client
+func TestPullImageWithMock(t *testing.T) {
+	t.Run("streams pull progress to the writer, with per-layer progress at debug level", func(t *testing.T) {
+		var pulledRef string
+		mock := &mockDockerClient{
+			imagePullFunc: func(ctx context.Context, refStr string, options client.ImagePullOptions) (client.ImagePullResponse, error) {
+				pulledRef = refStr
+				return newMockPullResponse(`{"status":"Pulling from library/alpine","id":"3.20"}
+{"status":"Pulling fs layer","id":"abc123"}
+{"status":"Downloading","progress":"[=>    ] 1MB/4MB","id":"abc123"}
+{"status":"Downloading","progress":"[===> ] 3MB/4MB","id":"abc123"}
+{"status":"Pull complete","id":"abc123"}
+{"status":"Status: Downloaded newer image for alpine:3.20"}
+`), nil
+			},
+		}
+
+		writer := newMockWriter()
+		err := docker.NewClient(mock).PullImage(context.Background(), "alpine:3.20", writer)
+		require.NoError(t, err)
+
+		require.Equal(t, "alpine:3.20", pulledRef)
+		require.Equal(t, "Debug: 3.20: Pulling from library/alpine\n"+
+			"Debug: abc123: Pulling fs layer\n"+
+			"Debug: abc123: Downloading [=>    ] 1MB/4MB\n"+
+			"Debug: abc123: Pull complete\n"+
+			"Status: Downloaded newer image for alpine:3.20\n", writer.String())
+	})
+
+	t.Run("returns error reported by the registry", func(t *testing.T) {
+		mock := &mockDockerClient{
+			imagePullFunc: func(ctx context.Context, refStr string, options client.ImagePullOptions) (client.ImagePullResponse, error) {
+				return newMockPullResponse(`{"errorDetail":{"message":"manifest unknown"},"error":"manifest unknown"}`), nil
+			},
+		}
+
+		err := docker.NewClient(mock).PullImage(context.Background(), "alpine:nope", newMockWriter())
+		require.Error(t, err)
+		require.Contains(t, err.Error(), `failed to pull image "alpine:nope": manifest unknown`)
+	})
+
+	t.Run("returns error when the pull cannot start", func(t *testing.T) {
+		mock := &mockDockerClient{
+			imagePullFunc: func(ctx context.Context, refStr string, options client.ImagePullOptions) (client.ImagePullResponse, error) {
+				return nil, errors.New("daemon unavailable")
+			},
+		}
+
+		err := docker.NewClient(mock).PullImage(context.Background(), "alpine:3.20", newMockWriter())
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "daemon unavailable")
+	})
+
+	t.Run("pulls missing remote base images before building", func(t *testing.T) {
+		dockerfilePath := filepath.Join(t.TempDir(), "Dockerfile")
+		require.NoError(t, os.WriteFile(dockerfilePath, []byte(
+			"ARG GO_VERSION=1.26\n"+
+				"FROM --platform=linux/amd64 golang:${GO_VERSION} AS builder\n"+
+				"FROM alpine:3.20 AS base\n"+
+				"FROM base\n"+
+				"FROM scratch\n"+
+				"from debian:bookworm\n"+
+				"FROM alpine:3.20\n",
+		), 0600))
+
+		var pulled []string
+		mock := &mockDockerClient{
+			imageInspectFunc: func(ctx context.Context, imageID string, inspectOpts ...client.ImageInspectOption) (client.ImageInspectResult, error) {
+				if imageID == "debian:bookworm" {
+					return client.ImageInspectResult{}, nil
+				}
+				return client.ImageInspectResult{}, errors.New("No such image")
+			},
+			imagePullFunc: func(ctx context.Context, refStr string, options client.ImagePullOptions) (client.ImagePullResponse, error) {
+				pulled = append(pulled, refStr)
+				return newMockPullResponse(`{"status":"Pull complete","id":"abc123"}`), nil
+			},
+			imageBuildFunc: func(ctx context.Context, buildContext io.Reader, options client.ImageBuildOptions) (client.ImageBuildResult, error) {
+				io.Copy(io.Discard, buildContext) //nolint:errcheck // draining pipe for goroutine completion
+				return client.ImageBuildResult{
+					Body: io.NopCloser(bytes.NewReader(nil)),
+				}, nil
+			},
+		}
+
+		writer := newMockWriter()
+		_, err := docker.NewClient(mock).BuildImage(context.Background(), runtime.BuildImageOptions{
+			DockerfilePath: dockerfilePath,
+			ImageName:      "test:latest",
+		}, writer)
+		require.NoError(t, err)
+
+		require.Equal(t, []string{"alpine:3.20"}, pulled)
+		require.Contains(t, writer.String(), "Pulling base image alpine:3.20\nDebug: abc123: Pull complete\n")
+	})
+
+	t.Run("sends registry credentials with the build and base image pulls", func(t *testing.T) {
+		dockerfilePath := filepath.Join(t.TempDir(), "Dockerfile")
+		require.NoError(t, os.WriteFile(dockerfilePath, []byte("FROM registry.example.com/team/base:1.0\nFROM alpine:3.20\n"), 0600))
+
+		configDir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(configDir, "config.json"), []byte(`{
+			"auths": {
+				"registry.example.com": {"auth": "`+base64.StdEncoding.EncodeToString([]byte("user:secret"))+`"}
+			}
+		}`), 0600))
+
+		registryAuth := map[string]string{}
+		var capturedOptions client.ImageBuildOptions
+		mock := &mockDockerClient{
+			imageInspectFunc: func(ctx context.Context, imageID string, inspectOpts ...client.ImageInspectOption) (client.ImageInspectResult, error) {
+				return client.ImageInspectResult{}, errors.New("No such image")
+			},
+			imagePullFunc: func(ctx context.Context, refStr string, options client.ImagePullOptions) (client.ImagePullResponse, error) {
+				registryAuth[refStr] = options.RegistryAuth
+				return newMockPullResponse(`{"status":"Pull complete","id":"abc123"}`), nil
+			},
+			imageBuildFunc: func(ctx context.Context, buildContext io.Reader, options client.ImageBuildOptions) (client.ImageBuildResult, error) {
+				io.Copy(io.Discard, buildContext) //nolint:errcheck // draining pipe for goroutine completion
+				capturedOptions = options
+				return client.ImageBuildResult{
+					Body: io.NopCloser(bytes.NewReader(nil)),
+				}, nil
+			},
+		}
+
+		_, err := docker.NewClient(mock).BuildImage(context.Background(), runtime.BuildImageOptions{
+			DockerfilePath: dockerfilePath,
+			ImageName:      "test:latest",
+			DockerConfig:   configDir,
+		}, newMockWriter())
+		require.NoError(t, err)
+
+		require.Contains(t, capturedOptions.AuthConfigs, "registry.example.com")
+		require.Equal(t, "user", capturedOptions.AuthConfigs["registry.example.com"].Username)
+		require.Equal(t, "secret", capturedOptions.AuthConfigs["registry.example.com"].Password)
+
+		content, err := base64.URLEncoding.DecodeString(registryAuth["registry.example.com/team/base:1.0"])
+		require.NoError(t, err)
+		var auth registry.AuthConfig
+		require.NoError(t, json.Unmarshal(content, &auth))
+		require.Equal(t, "user", auth.Username)
+		require.Equal(t, "secret", auth.Password)
+
+		require.Empty(t, registryAuth["alpine:3.20"], "credentials should only be sent to their registry")
+	})
+
+	t.Run("builds without registry credentials when the Docker config is malformed", func(t *testing.T) {
+		dockerfilePath := filepath.Join(t.TempDir(), "Dockerfile")
+		require.NoError(t, os.WriteFile(dockerfilePath, []byte("FROM scratch\n"), 0600))
+
+		configDir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(configDir, "config.json"), []byte("{"), 0600))
+
+		var capturedOptions client.ImageBuildOptions
+		mock := &mockDockerClient{
+			imageBuildFunc: func(ctx context.Context, buildContext io.Reader, options client.ImageBuildOptions) (client.ImageBuildResult, error) {
+				io.Copy(io.Discard, buildContext) //nolint:errcheck // draining pipe for goroutine completion
+				capturedOptions = options
+				return client.ImageBuildResult{
+					Body: io.NopCloser(bytes.NewReader(nil)),
+				}, nil
+			},
+		}
+
+		writer := newMockWriter()
+		_, err := docker.NewClient(mock).BuildImage(context.Background(), runtime.BuildImageOptions{
+			DockerfilePath: dockerfilePath,
+			ImageName:      "test:latest",
+			DockerConfig:   configDir,
+		}, writer)
+		require.NoError(t, err)
+
+		require.Empty(t, capturedOptions.AuthConfigs)
+		require.Contains(t, writer.String(), "Warning: failed to read Docker config in "+configDir)
+	})
+}
+
+// TestBuildImagePullPolicyWithMock tests that BuildImage pulls base images according to the pull policy
+func TestBuildImagePullPolicyWithMock(t *testing.T) {
+	type result struct {
+		pulled []string
+		built  bool
+		output string
+		err    error
+	}
+
+	// build runs BuildImage for a Dockerfile based on alpine:3.20, which is present
+	// locally when present is set, along with a cached image for the same inputs.
+	build := func(t *testing.T, policy internal.PullPolicy, present bool, pullErr error) result {
+		t.Helper()
+
+		dockerfilePath := filepath.Join(t.TempDir(), "Dockerfile")
+		require.NoError(t, os.WriteFile(dockerfilePath, []byte("FROM alpine:3.20\n"), 0600))
+
+		var res result
+		mock := &mockDockerClient{
+			imageInspectFunc: func(ctx context.Context, imageID string, inspectOpts ...client.ImageInspectOption) (client.ImageInspectResult, error) {
+				if imageID == "alpine:3.20" && !present {
+					return client.ImageInspectResult{}, errors.New("No such image")
+				}
+				return client.ImageInspectResult{}, nil
+			},
+			imageTagFunc: func(ctx context.Context, options client.ImageTagOptions) (client.ImageTagResult, error) {
+				return client.ImageTagResult{}, nil
+			},
+			imagePullFunc: func(ctx context.Context, refStr string, options client.ImagePullOptions) (client.ImagePullResponse, error) {
+				res.pulled = append(res.pulled, refStr)
+				if pullErr != nil {
+					return nil, pullErr
+				}
+				return newMockPullResponse(`{"status":"Pull complete","id":"abc123"}`), nil
+			},
+			imageBuildFunc: func(ctx context.Context, buildContext io.Reader, options client.ImageBuildOptions) (client.ImageBuildResult, error) {
+				io.Copy(io.Discard, buildContext) //nolint:errcheck // draining pipe for goroutine completion
+				res.built = true
+				return client.ImageBuildResult{
+					Body: io.NopCloser(bytes.NewReader(nil)),
+				}, nil
+			},
+		}
+
+		writer := newMockWriter()
+		_, res.err = docker.NewClient(mock).BuildImage(context.Background(), runtime.BuildImageOptions{
+			DockerfilePath: dockerfilePath,
+			ImageName:      "test:latest",
+			PullPolicy:     policy,
+			ForceRebuild:   true,
+		}, writer)
+		res.output = writer.String()
+		return res
+	}
+
+	t.Run("always pulls base images that are present locally", func(t *testing.T) {
+		res := build(t, internal.PullPolicyAlways, true, nil)
+		require.NoError(t, res.err)
+		require.Equal(t, []string{"alpine:3.20"}, res.pulled)
+		require.True(t, res.built)
+	})
+
+	t.Run("always fails when a pull fails", func(t *testing.T) {
+		res := build(t, internal.PullPolicyAlways, true, errors.New("registry unavailable"))
+		require.ErrorContains(t, res.err, `failed to pull image "alpine:3.20"`)
+		require.False(t, res.built)
+	})
+
+	t.Run("always rebuilds even when the build inputs are unchanged", func(t *testing.T) {
+		dockerfilePath := filepath.Join(t.TempDir(), "Dockerfile")
+		require.NoError(t, os.WriteFile(dockerfilePath, []byte("FROM alpine:3.20\n"), 0600))
+
+		built := false
+		mock := &mockDockerClient{
+			imageInspectFunc: func(ctx context.Context, imageID string, inspectOpts ...client.ImageInspectOption) (client.ImageInspectResult, error) {
+				return client.ImageInspectResult{}, nil
+			},
+			imagePullFunc: func(ctx context.Context, refStr string, options client.ImagePullOptions) (client.ImagePullResponse, error) {
+				return newMockPullResponse(`{"status":"Pull complete","id":"abc123"}`), nil
+			},
+			imageBuildFunc: func(ctx context.Context, buildContext io.Reader, options client.ImageBuildOptions) (client.ImageBuildResult, error) {
+				io.Copy(io.Discard, buildContext) //nolint:errcheck // draining pipe for goroutine completion
+				built = true
+				return client.ImageBuildResult{
+					Body: io.NopCloser(bytes.NewReader(nil)),
+				}, nil
+			},
+		}
+
+		_, err := docker.NewClient(mock).BuildImage(context.Background(), runtime.BuildImageOptions{
+			DockerfilePath: dockerfilePath,
+			ImageName:      "test:latest",
+			PullPolicy:     internal.PullPolicyAlways,
+		}, newMockWriter())
+		require.NoError(t, err)
+		require.True(t, built, "the cached image should not be reused")
+	})
+
+	t.Run("missing pulls only absent base images", func(t *testing.T) {
+		res := build(t, internal.PullPolicyMissing, true, nil)
+		require.NoError(t, res.err)
+		require.Empty(t, res.pulled)
+		require.Contains(t, res.output, "Debug: Base image alpine:3.20 is present locally")
+
+		res = build(t, internal.PullPolicyMissing, false, nil)
+		require.NoError(t, res.err)
+		require.Equal(t, []string{"alpine:3.20"}, res.pulled)
+	})
+
+	t.Run("missing only warns when a pull fails", func(t *testing.T) {
+		res := build(t, internal.PullPolicyMissing, false, errors.New("registry unavailable"))
+		require.NoError(t, res.err)
+		require.True(t, res.built)
+		require.Contains(t, res.output, `Warning: failed to pull image "alpine:3.20"`)
+	})
+
+	t.Run("never builds with base images that are present locally", func(t *testing.T) {
+		res := build(t, internal.PullPolicyNever, true, nil)
+		require.NoError(t, res.err)
+		require.Empty(t, res.pulled)
+		require.True(t, res.built)
+	})
+
+	t.Run("never fails fast when a base image is absent", func(t *testing.T) {
+		res := build(t, internal.PullPolicyNever, false, nil)
+		require.ErrorContains(t, res.err, `base image "alpine:3.20" is not present locally and the pull policy is "never"`)
+		require.Empty(t, res.pulled)
+		require.False(t, res.built)
+	})
+}
+
+// TestBuildImageProgressWithMock tests the build output printed in each progress mode
+func TestBuildImageProgressWithMock(t *testing.T) {
+	// build runs BuildImage with the given progress mode on a daemon that replies with
+	// output and returns what was written.
+	build := func(t *testing.T, progress internal.BuildProgress, output string) (string, error) {
+		t.Helper()
+
+		dockerfilePath := filepath.Join(t.TempDir(), "Dockerfile")
+		require.NoError(t, os.WriteFile(dockerfilePath, []byte("FROM scratch\n"), 0600))
+
+		mock := &mockDockerClient{
+			imageBuildFunc: func(ctx context.Context, buildContext io.Reader, options client.ImageBuildOptions) (client.ImageBuildResult, error) {
+				io.Copy(io.Discard, buildContext) //nolint:errcheck // draining pipe for goroutine completion
+				return client.ImageBuildResult{
+					Body: io.NopCloser(strings.NewReader(output)),
+				}, nil
+			},
+		}
+
+		writer := newMockWriter()
+		_, err := docker.NewClient(mock).BuildImage(context.Background(), runtime.BuildImageOptions{
+			DockerfilePath: dockerfilePath,
+			ImageName:      "test:latest",
+			Progress:       progress,
+		}, writer)
+		return writer.String(), err
+	}
+
+	steps := `{"stream":"Step 1/3 : FROM alpine:3.20\n"}
+{"stream":" ---\u003e 0123456789ab\n"}
+{"stream":"Step 2/3 : RUN make\n"}
+{"stream":" ---\u003e Running in fedcba987654\n"}
+{"stream":"compiling...\n"}
+{"stream":"Step 3/3 : CMD [\"app\"]\n"}
+{"stream":"Successfully built 0123456789ab\n"}
+`
+
+	t.Run("condenses the output to a line per step with auto", func(t *testing.T) {
+		output, err := build(t, internal.BuildProgressAuto, steps)
+		require.NoError(t, err)
+
+		require.Contains(t, output, "Step 1/3: FROM alpine:3.20\nStep 2/3: RUN make\nStep 3/3: CMD [\"app\"]\n")
+		require.NotContains(t, output, "compiling...")
+		require.NotContains(t, output, "Running in")
+		require.NotContains(t, output, "Successfully built")
+		require.Contains(t, output, "Built image test:latest")
+	})
+
+	t.Run("shows the full output with plain", func(t *testing.T) {
+		output, err := build(t, internal.BuildProgressPlain, steps)
+		require.NoError(t, err)
+
+		require.Contains(t, output, "Step 2/3 : RUN make\n ---> Running in fedcba987654\ncompiling...\n")
+		require.Contains(t, output, "Successfully built 0123456789ab")
+	})
+
+	t.Run("shows the output of a failing step with auto", func(t *testing.T) {
+		output, err := build(t, internal.BuildProgressAuto, `{"stream":"Step 1/2 : FROM alpine:3.20\n"}
+{"stream":" ---\u003e 0123456789ab\n"}
+{"stream":"Step 2/2 : RUN make\n"}
+{"stream":"main.c:1: error: expected ';'\n"}
+{"errorDetail":{"code":1,"message":"The command '/bin/sh -c make' returned a non-zero code: 1"}}
+`)
+		require.ErrorContains(t, err, "returned a non-zero code: 1")
+
+		require.Contains(t, output, "Step 2/2: RUN make\nmain.c:1: error: expected ';'\n")
+		require.NotContains(t, output, "0123456789ab")
+	})
+}
+
+// TestBuildImageSecretsWithMock tests that BuildImage serves secrets to BuildKit builds
+func TestBuildImageSecretsWithMock(t *testing.T) {
+	// connPair returns both ends of a loopback TCP connection, standing in for the
+	// hijacked session connection and the daemon's end of it.
+	connPair := func(t *testing.T) (net.Conn, net.Conn) {
+		t.Helper()
+
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer listener.Close()
+
+		daemon, err := net.Dial("tcp", listener.Addr().String())
+		require.NoError(t, err)
+		t.Cleanup(func() { daemon.Close() })
+
+		session, err := listener.Accept()
+		require.NoError(t, err)
+
+		return session, daemon
+	}
+
+	// secretsClient returns a gRPC client for the secrets service on the daemon's end
+	// of the session, the way the daemon calls it.
+	secretsClient := func(t *testing.T, daemon net.Conn) secrets.SecretsClient {
+		t.Helper()
+
+		conn, err := grpc.NewClient("passthrough:///session",
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+			grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+				return daemon, nil
+			}),
+		)
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+
+		return secrets.NewSecretsClient(conn)
+	}
+
+	t.Run("builds with BuildKit and serves the secrets over the session", func(t *testing.T) {
+		dir := t.TempDir()
+		dockerfilePath := filepath.Join(dir, "Dockerfile")
+		require.NoError(t, os.WriteFile(dockerfilePath, []byte("FROM scratch\n"), 0600))
+		secretPath := filepath.Join(dir, "npmrc")
+		require.NoError(t, os.WriteFile(secretPath, []byte("token=hunter2"), 0600))
+
+		session, daemon := connPair(t)
+
+		var meta map[string][]string
+		var capturedOptions client.ImageBuildOptions
+		var secret *secrets.GetSecretResponse
+		var secretErr, missingErr error
+		mock := &mockDockerClient{
+			dialHijackFunc: func(ctx context.Context, url, proto string, m map[string][]string) (net.Conn, error) {
+				require.Equal(t, "/session", url)
+				require.Equal(t, "h2c", proto)
+				meta = m
+				return session, nil
+			},
+			imageBuildFunc: func(ctx context.Context, buildContext io.Reader, options client.ImageBuildOptions) (client.ImageBuildResult, error) {
+				io.Copy(io.Discard, buildContext) //nolint:errcheck // draining pipe for goroutine completion
+				capturedOptions = options
+
+				service := secretsClient(t, daemon)
+				secret, secretErr = service.GetSecret(ctx, &secrets.GetSecretRequest{ID: "npmrc"})
+				_, missingErr = service.GetSecret(ctx, &secrets.GetSecretRequest{ID: "unknown"})
+
+				return client.ImageBuildResult{
+					Body: io.NopCloser(bytes.NewReader(nil)),
+				}, nil
+			},
+		}
+
+		_, err := docker.NewClient(mock).BuildImage(context.Background(), runtime.BuildImageOptions{
+			DockerfilePath: dockerfilePath,
+			ImageName:      "test:latest",
+			Secrets:        []internal.BuildSecret{{ID: "npmrc", Source: secretPath}},
+		}, newMockWriter())
+		require.NoError(t, err)
+
+		require.Equal(t, build.BuilderBuildKit, capturedOptions.Version)
+		require.NotEmpty(t, capturedOptions.SessionID)
+		require.Equal(t, []string{capturedOptions.SessionID}, meta["X-Docker-Expose-Session-Uuid"])
+		require.Contains(t, meta["X-Docker-Expose-Session-Grpc-Method"], "/moby.buildkit.secrets.v1.Secrets/GetSecret")
+
+		require.NoError(t, secretErr)
+		require.Equal(t, "token=hunter2", string(secret.Data))
+		require.Equal(t, codes.NotFound, status.Code(missingErr))
+	})
+
+	t.Run("builds with the classic builder without secrets", func(t *testing.T) {
+		dockerfilePath := filepath.Join(t.TempDir(), "Dockerfile")
+		require.NoError(t, os.WriteFile(dockerfilePath, []byte("FROM scratch\n"), 0600))
+
+		var capturedOptions client.ImageBuildOptions
+		mock := &mockDockerClient{
+			imageBuildFunc: func(ctx context.Context, buildContext io.Reader, options client.ImageBuildOptions) (client.ImageBuildResult, error) {
+				io.Copy(io.Discard, buildContext) //nolint:errcheck // draining pipe for goroutine completion
+				capturedOptions = options
+				return client.ImageBuildResult{
+					Body: io.NopCloser(bytes.NewReader(nil)),
+				}, nil
+			},
+		}
+
+		_, err := docker.NewClient(mock).BuildImage(context.Background(), runtime.BuildImageOptions{
+			DockerfilePath: dockerfilePath,
+			ImageName:      "test:latest",
+		}, newMockWriter())
+		require.NoError(t, err)
+
+		require.Empty(t, capturedOptions.Version)
+		require.Empty(t, capturedOptions.SessionID)
+	})
+
+	t.Run("fails when the daemon does not support BuildKit", func(t *testing.T) {
+		dir := t.TempDir()
+		dockerfilePath := filepath.Join(dir, "Dockerfile")
+		require.NoError(t, os.WriteFile(dockerfilePath, []byte("FROM scratch\n"), 0600))
+		secretPath := filepath.Join(dir, "npmrc")
+		require.NoError(t, os.WriteFile(secretPath, []byte("token=hunter2"), 0600))
+
+		mock := &mockDockerClient{
+			dialHijackFunc: func(ctx context.Context, url, proto string, meta map[string][]string) (net.Conn, error) {
+				return nil, errors.New("unable to upgrade to h2c, received 404")
+			},
+		}
+
+		_, err := docker.NewClient(mock).BuildImage(context.Background(), runtime.BuildImageOptions{
+			DockerfilePath: dockerfilePath,
+			ImageName:      "test:latest",
+			Secrets:        []internal.BuildSecret{{ID: "npmrc", Source: secretPath}},
+		}, newMockWriter())
+		require.ErrorContains(t, err, "failed to start BuildKit session: unable to upgrade to h2c, received 404")
+		require.ErrorContains(t, err, "--secret requires BuildKit")
+	})
+
+	t.Run("fails when a secret cannot be read", func(t *testing.T) {
+		dockerfilePath := filepath.Join(t.TempDir(), "Dockerfile")
+		require.NoError(t, os.WriteFile(dockerfilePath, []byte("FROM scratch\n"), 0600))
+
+		_, err := docker.NewClient(&mockDockerClient{}).BuildImage(context.Background(), runtime.BuildImageOptions{
+			DockerfilePath: dockerfilePath,
+			ImageName:      "test:latest",
+			Secrets:        []internal.BuildSecret{{ID: "npmrc", Source: "/missing/npmrc"}},
+		}, newMockWriter())
+		require.ErrorContains(t, err, `failed to read secret "npmrc" from /missing/npmrc`)
+	})
+
+	t.Run("prints BuildKit progress and fails on BuildKit errors", func(t *testing.T) {
+		dockerfilePath := filepath.Join(t.TempDir(), "Dockerfile")
+		require.NoError(t, os.WriteFile(dockerfilePath, []byte("FROM scratch\n"), 0600))
+
+		vertex := &controlapi.Vertex{Digest: "sha256:abc", Name: "[1/2] RUN npm ci", Started: timestamppb.Now()}
+		trace, err := (&controlapi.StatusResponse{
+			Vertexes: []*controlapi.Vertex{vertex},
+			Logs:     []*controlapi.VertexLog{{Vertex: vertex.Digest, Msg: []byte("added 42 packages\n")}},
+		}).MarshalVT()
+		require.NoError(t, err)
+
+		// The vertex is reported again when it completes
+		again, err := (&controlapi.StatusResponse{Vertexes: []*controlapi.Vertex{vertex}}).MarshalVT()
+		require.NoError(t, err)
+
+		output, err := json.Marshal([]any{
+			map[string]any{"id": "moby.buildkit.trace", "aux": trace},
+			map[string]any{"id": "moby.buildkit.trace", "aux": again},
+			map[string]any{"errorDetail": map[string]any{"message": "failed to solve: process did not complete successfully"}},
+		})
+		require.NoError(t, err)
+		body := bytes.TrimSuffix(bytes.TrimPrefix(bytes.ReplaceAll(output, []byte("},{"), []byte("}\n{")), []byte("[")), []byte("]"))
+
+		mock := &mockDockerClient{
+			imageBuildFunc: func(ctx context.Context, buildContext io.Reader, options client.ImageBuildOptions) (client.ImageBuildResult, error) {
+				io.Copy(io.Discard, buildContext) //nolint:errcheck // draining pipe for goroutine completion
+				return client.ImageBuildResult{
+					Body: io.NopCloser(bytes.NewReader(body)),
+				}, nil
+			},
+		}
+
+		writer := newMockWriter()
+		_, err = docker.NewClient(mock).BuildImage(context.Background(), runtime.BuildImageOptions{
+			DockerfilePath: dockerfilePath,
+			ImageName:      "test:latest",
+		}, writer)
+		require.ErrorContains(t, err, "docker build failed: failed to solve: process did not complete successfully")
+		require.Equal(t, "[1/2] RUN npm ci\nadded 42 packages\n", writer.String()[strings.Index(writer.String(), "[1/2]"):])
+	})
 }
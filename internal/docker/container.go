@@ -8,13 +8,14 @@ import (
 	"sync"
 	"time"
 
+	cerrdefs "github.com/containerd/errdefs"
 	"github.com/docker/cli/cli/streams"
+	"github.com/moby/moby/api/pkg/stdcopy"
 	"github.com/moby/moby/api/types/container"
 	"github.com/moby/moby/client"
 	"github.com/moby/term"
 	"github.com/ryanmoran/contagent/internal"
 	"github.com/ryanmoran/contagent/internal/runtime"
-	"golang.org/x/sync/errgroup"
 )
 
 // Compile-time check that Container implements runtime.Container.
@@ -25,13 +26,19 @@ type Container struct {
 
 	ID          string
 	Name        string
+	TTY         bool
 	StopTimeout int
 	TTYRetries  int
 	RetryDelay  time.Duration
+	TTYResync   time.Duration
+	Timeout     time.Duration
+	Restart     internal.RestartPolicy
+	Keep        bool
 }
 
-// InspectUser returns the default user for the container's image by inspecting the container
-// config. If the user is specified as a name rather than a numeric ID, it resolves the name
+// InspectUser returns the user the container runs as, the configured user or else the image's
+// default user, by inspecting the container config. The archive copied into the container is
+// owned by this user. If the user is specified as a name rather than a numeric ID, it resolves the name
 // via /etc/passwd and /etc/group copied from the stopped container.
 func (c Container) InspectUser(ctx context.Context) (runtime.ImageUser, error) {
 	result, err := c.client.ContainerInspect(ctx, c.ID, client.ContainerInspectOptions{})
@@ -58,29 +65,56 @@ func (c Container) Start(ctx context.Context) error {
 	return nil
 }
 
-// Attach attaches to the container's stdin, stdout, and stderr streams with TTY support.
-// It sets the terminal to raw mode, monitors terminal resize events, and forwards I/O between
-// the local terminal and the container. Returns an error if terminal setup fails, TTY monitoring
-// fails, or container attachment fails.
-func (c Container) Attach(ctx context.Context, cancel context.CancelFunc, w internal.Writer) error {
-	stdin, stdout, _ := term.StdStreams()
-	in := streams.NewIn(stdin)
-	out := streams.NewOut(stdout)
-
-	// Attempt initial resize - if it fails, the TTY monitor will retry
-	height, width := out.GetTtySize()
-	_, err := c.client.ContainerResize(ctx, c.ID, client.ContainerResizeOptions{
-		Height: height,
-		Width:  width,
-	})
-	if err != nil {
+// Attach attaches to the container's stdin, stdout, and stderr streams. When the container
+// has a TTY and stdin is a terminal, it sets the terminal to raw mode, monitors terminal
+// resize events, and forwards I/O between the local terminal and the container. Otherwise
+// it falls back to plain stream forwarding suitable for piped input and CI. The returned
+// Attachment finishes once the container's output has been forwarded, resize monitoring
+// has stopped, and the terminal is restored; stdin forwarding is not waited for, as
+// reading stdin blocks until the next input. Returns an error if terminal setup fails, TTY
+// monitoring fails, or container attachment fails.
+func (c Container) Attach(ctx context.Context, cancel context.CancelFunc, w internal.Writer) (runtime.Attachment, error) {
+	stdin, stdout, stderr := term.StdStreams()
+	return c.attach(ctx, cancel, streams.NewIn(stdin), streams.NewOut(stdout), stderr, w)
+}
+
+// terminalInput is the local stdin as used by attach, which *streams.In implements.
+type terminalInput interface {
+	io.Reader
+	IsTerminal() bool
+	SetRawTerminal() error
+	RestoreTerminal()
+}
+
+// terminalOutput is the local stdout as used by attach, which *streams.Out implements.
+type terminalOutput interface {
+	io.Writer
+	TerminalSizer
+	SetRawTerminal() error
+	RestoreTerminal()
+}
+
+// attach implements Attach for the given local streams.
+func (c Container) attach(ctx context.Context, cancel context.CancelFunc, in terminalInput, out terminalOutput, errOut io.Writer, w internal.Writer) (runtime.Attachment, error) {
+	if !c.TTY || !in.IsTerminal() {
+		return c.attachStreams(ctx, in, out, errOut, w)
+	}
+
+	// Resizing is only needed while attached, so the monitor stops with the session
+	// rather than lingering until ctx is cancelled
+	monitorCtx, stopMonitor := context.WithCancel(ctx)
+
+	// Attempt initial resize - if it fails, the TTY monitor will retry. Once it
+	// succeeds, the monitor does not resize to the same size again.
+	tty := NewTTY(c.client, out, c.ID, c.TTYRetries, c.RetryDelay, c.TTYResync, w, cancel)
+	if err := tty.Resize(monitorCtx); err != nil {
 		w.Warningf("failed to resize tty: %v", err)
 	}
 
-	tty := NewTTY(c.client, out, c.ID, c.TTYRetries, c.RetryDelay, w, cancel)
-	err = tty.Monitor(ctx, cancel)
+	err := tty.Monitor(monitorCtx, cancel)
 	if err != nil {
-		return fmt.Errorf("failed to monitor tty size: %w", err)
+		stopMonitor()
+		return runtime.Attachment{}, fmt.Errorf("failed to monitor tty size: %w", err)
 	}
 
 	restore := sync.OnceFunc(func() {
@@ -88,9 +122,19 @@ func (c Container) Attach(ctx context.Context, cancel context.CancelFunc, w inte
 		out.RestoreTerminal()
 	})
 
+	// The terminal stays in raw mode once forwarding has started, until the forwarding
+	// goroutines restore it. Any error or panic before that must restore it here.
+	attached := false
+	defer func() {
+		if !attached {
+			restore()
+			stopMonitor()
+		}
+	}()
+
 	err = in.SetRawTerminal()
 	if err != nil {
-		return fmt.Errorf("failed to set stdin to raw terminal mode: %w\nYour terminal may not support TTY operations", err)
+		return runtime.Attachment{}, fmt.Errorf("failed to set stdin to raw terminal mode: %w\nYour terminal may not support TTY operations", err)
 	}
 
 	response, err := c.client.ContainerAttach(ctx, c.ID, client.ContainerAttachOptions{
@@ -100,86 +144,340 @@ func (c Container) Attach(ctx context.Context, cancel context.CancelFunc, w inte
 		Stderr: true,
 	})
 	if err != nil {
-		return fmt.Errorf("failed to attach to container %q: %w\nContainer may have exited prematurely or Docker API is unreachable", c.Name, err)
+		return runtime.Attachment{}, fmt.Errorf("failed to attach to container %q: %w\nContainer may have exited prematurely or Docker API is unreachable", c.Name, err)
 	}
 
-	// Use errgroup for coordinated goroutine management
-	g, gctx := errgroup.WithContext(ctx)
+	err = out.SetRawTerminal()
+	if err != nil {
+		response.Close()
+		return runtime.Attachment{}, fmt.Errorf("failed to set stdout to raw terminal mode: %w\nYour terminal may not support TTY operations", err)
+	}
 
 	// Forward stdin to container
-	g.Go(func() error {
+	go func() {
 		defer restore()
 		defer response.Conn.Close()
 
 		_, err := io.Copy(response.Conn, in)
 		// Context cancellation is expected, not an error
-		if gctx.Err() != nil {
-			return nil
+		if ctx.Err() != nil {
+			return
 		}
 		if err != nil {
 			w.Warningf("stdin forwarding error: %v", err)
 		}
-		return nil
-	})
-
-	err = out.SetRawTerminal()
-	if err != nil {
-		return fmt.Errorf("failed to set stdout to raw terminal mode: %w\nYour terminal may not support TTY operations", err)
-	}
+	}()
 
-	// Forward container output to stdout
-	g.Go(func() error {
+	// Forward container output to stdout. The session ends once the output does.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer func() {
+			stopMonitor()
+			<-tty.Done()
+		}()
 		defer restore()
 
 		_, err := io.Copy(out, response.Reader)
 		// Context cancellation is expected, not an error
-		if gctx.Err() != nil {
-			return nil
+		if ctx.Err() != nil {
+			return
 		}
 		if err != nil && !errors.Is(err, io.EOF) {
 			w.Warningf("stdout/stderr forwarding error: %v", err)
 		}
-		return nil
+	}()
+
+	attached = true
+	return runtime.NewAttachment(done, restore), nil
+}
+
+// attachStreams forwards stdin to the container and container output to stdout and
+// stderr without raw mode, TTY resizing, or SIGWINCH handling. Output from containers
+// without a TTY is demultiplexed onto stdout and stderr. Stdin is half-closed once the
+// input is exhausted so the container observes EOF.
+func (c Container) attachStreams(ctx context.Context, in io.Reader, out, errOut io.Writer, w internal.Writer) (runtime.Attachment, error) {
+	response, err := c.client.ContainerAttach(ctx, c.ID, client.ContainerAttachOptions{
+		Stream: true,
+		Stdin:  true,
+		Stdout: true,
+		Stderr: true,
 	})
+	if err != nil {
+		return runtime.Attachment{}, fmt.Errorf("failed to attach to container %q: %w\nContainer may have exited prematurely or Docker API is unreachable", c.Name, err)
+	}
 
-	// Wait for both goroutines to complete
-	// This doesn't block the main flow since the container will terminate
-	// or the context will be cancelled
+	// Forward stdin to container
 	go func() {
-		if err := g.Wait(); err != nil {
-			w.Warningf("container I/O forwarding error: %v", err)
+		_, err := io.Copy(response.Conn, in)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			w.Warningf("stdin forwarding error: %v", err)
+		}
+		if err := response.CloseWrite(); err != nil {
+			w.Warningf("failed to close container stdin: %v", err)
 		}
 	}()
 
+	// Forward container output to stdout and stderr
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer response.Close()
+
+		var err error
+		if c.TTY {
+			_, err = io.Copy(out, response.Reader)
+		} else {
+			_, err = stdcopy.StdCopy(out, errOut, response.Reader)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			w.Warningf("stdout/stderr forwarding error: %v", err)
+		}
+	}()
+
+	return runtime.NewAttachment(done, nil), nil
+}
+
+// Logs writes the container's stdout and stderr to the provided Writer. When follow is
+// true, it keeps streaming until the container exits or the context is cancelled. Output
+// from containers without a TTY is demultiplexed; TTY output is copied as-is. Returns an
+// error if the container does not exist or the log stream cannot be read, and the context
+// error if the context is cancelled.
+func (c Container) Logs(ctx context.Context, w internal.Writer, follow bool) error {
+	inspect, err := c.client.ContainerInspect(ctx, c.ID, client.ContainerInspectOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to inspect container %q: %w\nContainer may have been removed", c.Name, err)
+	}
+
+	logs, err := c.client.ContainerLogs(ctx, c.ID, client.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     follow,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to fetch logs for container %q: %w\nContainer may have been removed", c.Name, err)
+	}
+	defer logs.Close()
+
+	out := w.GetWriter()
+	if inspect.Container.Config != nil && inspect.Container.Config.Tty {
+		_, err = io.Copy(out, logs)
+	} else {
+		_, err = stdcopy.StdCopy(out, out, logs)
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read logs for container %q: %w", c.Name, err)
+	}
+
 	return nil
 }
 
-// Wait waits for the container to exit or for context cancellation.
-// If the context is cancelled, it attempts to gracefully stop the container with the configured
-// timeout. Returns an error if waiting for the container fails.
-func (c Container) Wait(ctx context.Context, w internal.Writer) error {
-	wait := c.client.ContainerWait(ctx, c.ID, client.ContainerWaitOptions{
-		Condition: container.WaitConditionNotRunning,
+// Exec runs cmd inside the running container without a TTY or stdin, alongside the
+// container's main process, and streams its stdout and stderr to the provided Writer.
+// Returns the exit code of cmd, or an error if the container is not running or the exec
+// cannot be created, attached to, or inspected.
+func (c Container) Exec(ctx context.Context, cmd []string, w internal.Writer) (int, error) {
+	created, err := c.client.ExecCreate(ctx, c.ID, client.ExecCreateOptions{ //nolint:exhaustruct // Only output is attached; the exec runs as the container's user
+		Cmd:          cmd,
+		AttachStdout: true,
+		AttachStderr: true,
 	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to create exec in container %q: %w\nCheck that the container is running", c.Name, err)
+	}
 
-	select {
-	case err := <-wait.Error:
-		if err != nil {
-			return fmt.Errorf("failed to wait for container %q: %w\nDocker daemon may have encountered an error", c.Name, err)
+	response, err := c.client.ExecAttach(ctx, created.ID, client.ExecAttachOptions{}) //nolint:exhaustruct // No TTY, so no console size
+	if err != nil {
+		return 0, fmt.Errorf("failed to attach to exec in container %q: %w", c.Name, err)
+	}
+	defer response.Close()
+
+	out := w.GetWriter()
+	_, err = stdcopy.StdCopy(out, out, response.Reader)
+	if ctx.Err() != nil {
+		return 0, ctx.Err()
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read exec output in container %q: %w", c.Name, err)
+	}
+
+	inspect, err := c.client.ExecInspect(ctx, created.ID, client.ExecInspectOptions{})
+	if err != nil {
+		return 0, fmt.Errorf("failed to inspect exec in container %q: %w", c.Name, err)
+	}
+
+	return inspect.ExitCode, nil
+}
+
+// Wait waits for the container to exit or for context cancellation and returns the
+// exit code of the container's command. If the context is cancelled, it attempts to
+// gracefully stop the container with the configured timeout and returns
+// runtime.ExitCodeInterrupted. If the container is still running after Timeout, it is
+// stopped the same way and an error wrapping runtime.ErrTimeout is returned. When the
+// daemon reports an out-of-memory kill, the exit message says so instead of only giving
+// the exit status, or a warning is printed if the command itself exited successfully.
+// Returns an error if waiting for the container fails.
+//
+// With a Restart policy, an exit that the daemon answers with a restart does not end
+// the wait: Wait keeps waiting until the container exits without being restarted, and
+// Timeout bounds the whole run across restarts.
+func (c Container) Wait(ctx context.Context, w internal.Writer) (int, error) {
+	condition := container.WaitConditionNotRunning
+
+	// A nil channel never fires, so there is no limit without a timeout
+	var timeout <-chan time.Time
+	if c.Timeout > 0 {
+		timeout = time.After(c.Timeout)
+	}
+
+	for {
+		wait := c.client.ContainerWait(ctx, c.ID, client.ContainerWaitOptions{
+			Condition: condition,
+		})
+
+		select {
+		case err := <-wait.Error:
+			if err != nil {
+				return 0, fmt.Errorf("failed to wait for container %q: %w\nDocker daemon may have encountered an error", c.Name, err)
+			}
+			return 0, nil
+		case status := <-wait.Result:
+			// Without a state the exit status is reported as is, as the exit itself is known
+			state, err := c.Inspect(ctx)
+			if err == nil && c.Restart.Enabled() && (state.Restarting || state.Running) {
+				w.Printf("\nContainer exited with status %d and is being restarted\n", status.StatusCode)
+				condition = container.WaitConditionNextExit
+				continue
+			}
+			// The daemon flags any OOM kill in the container, which may have hit a child
+			// process that the command survived
+			if err == nil && state.OOMKilled && status.StatusCode != 0 {
+				w.Printf("\nContainer was killed due to out-of-memory (exit status %d)\nTry raising the memory limit with --memory\n", status.StatusCode)
+				return int(status.StatusCode), nil
+			}
+			w.Printf("\nContainer exited with status: %d\n", status.StatusCode)
+			if err == nil && state.OOMKilled {
+				w.Warningf("a process in the container was killed due to out-of-memory; try raising the memory limit with --memory")
+			}
+			return int(status.StatusCode), nil
+		case <-ctx.Done():
+			w.Println("\nReceived signal, stopping container...")
+			c.stopAfterWait(w)
+			return runtime.ExitCodeInterrupted, nil
+		case <-timeout:
+			w.Printf("\nContainer did not exit within %s, stopping container...\n", c.Timeout)
+			c.stopAfterWait(w)
+			return 0, fmt.Errorf("container %q did not exit within %s: %w", c.Name, c.Timeout, runtime.ErrTimeout)
 		}
-	case status := <-wait.Result:
-		w.Printf("\nContainer exited with status: %d\n", status.StatusCode)
-	case <-ctx.Done():
-		w.Println("\nReceived signal, stopping container...")
-		timeout := c.StopTimeout
-		_, err := c.client.ContainerStop(context.Background(), c.ID, client.ContainerStopOptions{Timeout: &timeout})
-		if err != nil {
-			w.Warningf("failed to stop container: %v", err)
+	}
+}
+
+// State is the state of a container as reported by the Docker daemon. StartedAt and
+// FinishedAt are zero until the container has started and exited respectively.
+type State struct {
+	Running    bool
+	Restarting bool
+	ExitCode   int
+	StartedAt  time.Time
+	FinishedAt time.Time
+	OOMKilled  bool
+}
+
+// Inspect returns the current state of the container. Returns an error if the container
+// cannot be inspected or the daemon reports no state or malformed times.
+func (c Container) Inspect(ctx context.Context) (State, error) {
+	result, err := c.client.ContainerInspect(ctx, c.ID, client.ContainerInspectOptions{})
+	if err != nil {
+		return State{}, fmt.Errorf("failed to inspect container %q: %w", c.Name, err)
+	}
+
+	state := result.Container.State
+	if state == nil {
+		return State{}, fmt.Errorf("failed to inspect container %q: the daemon reported no state", c.Name)
+	}
+
+	startedAt, err := parseStateTime(state.StartedAt)
+	if err != nil {
+		return State{}, fmt.Errorf("failed to inspect container %q: invalid start time: %w", c.Name, err)
+	}
+
+	finishedAt, err := parseStateTime(state.FinishedAt)
+	if err != nil {
+		return State{}, fmt.Errorf("failed to inspect container %q: invalid finish time: %w", c.Name, err)
+	}
+
+	return State{
+		Running:    state.Running,
+		Restarting: state.Restarting,
+		ExitCode:   state.ExitCode,
+		StartedAt:  startedAt,
+		FinishedAt: finishedAt,
+		OOMKilled:  state.OOMKilled,
+	}, nil
+}
+
+// parseStateTime parses a time reported in a container state. The daemon reports times
+// that have not happened yet as the zero time, or leaves them empty.
+func parseStateTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339Nano, value)
+}
+
+// Stop stops the container, giving it StopTimeout seconds to exit before the daemon
+// kills it. A stop that fails, or completes with the container still running, is
+// escalated to force-removing the container, which is reported as a warning. A container
+// kept with Keep is killed instead, so that it stays in place. When the container cannot
+// be inspected after a successful stop, it is assumed to have stopped. Returns an error
+// if the container is left running.
+func (c Container) Stop(ctx context.Context, w internal.Writer) error {
+	timeout := c.StopTimeout
+	_, stopErr := c.client.ContainerStop(ctx, c.ID, client.ContainerStopOptions{Timeout: &timeout})
+
+	state, err := c.Inspect(ctx)
+	if stopErr == nil && (err != nil || !state.Running) {
+		return nil
+	}
+
+	reason := fmt.Sprintf("container %s is still running after being stopped", c.Name)
+	if stopErr != nil {
+		reason = fmt.Sprintf("failed to stop container %s: %v", c.Name, stopErr)
+	}
+
+	if c.Keep {
+		w.Warningf("%s; killing it", reason)
+		if _, err := c.client.ContainerKill(ctx, c.ID, client.ContainerKillOptions{}); err != nil {
+			return fmt.Errorf("container %q may still be running: failed to kill it: %w", c.Name, err)
 		}
+		return nil
+	}
+
+	w.Warningf("%s; removing it", reason)
+	if err := c.ForceRemove(ctx); err != nil {
+		return fmt.Errorf("container %q may still be running: %w", c.Name, err)
 	}
 	return nil
 }
 
+// stopAfterWait stops the container once Wait gives up on it. The context of Wait may
+// already be cancelled, so the stop runs without it. Failures only produce a warning, as
+// cleanup removes the container unless it is kept.
+func (c Container) stopAfterWait(w internal.Writer) {
+	if err := c.Stop(context.Background(), w); err != nil {
+		w.Warningf("%v", err)
+	}
+}
+
 // Remove removes the container from the Docker daemon.
 // Returns an error if the container is still running or cannot be removed.
 // Use ForceRemove to remove a running container.
@@ -192,13 +490,16 @@ func (c Container) Remove(ctx context.Context) error {
 	return nil
 }
 
-// ForceRemove forcibly removes the container from the Docker daemon, even if it is still running.
+// ForceRemove forcibly removes the container from the Docker daemon, even if it is still running,
+// along with its anonymous volumes. A container that is already gone, e.g. after Stop had to
+// remove it, is not an error.
 // Returns an error if the container cannot be removed, which may indicate an inconsistent state.
 func (c Container) ForceRemove(ctx context.Context) error {
 	_, err := c.client.ContainerRemove(ctx, c.ID, client.ContainerRemoveOptions{
-		Force: true,
+		Force:         true,
+		RemoveVolumes: true,
 	})
-	if err != nil {
+	if err != nil && !cerrdefs.IsNotFound(err) {
 		return fmt.Errorf("failed to force remove container %q: %w\nContainer may be in an inconsistent state", c.Name, err)
 	}
 
@@ -206,7 +507,7 @@ func (c Container) ForceRemove(ctx context.Context) error {
 }
 
 // CopyTo copies content from a reader to the specified path inside the container.
-// The content must be a tar archive. Returns an error if the container is not running,
+// The content must be a tar archive, which may be gzip-compressed. Returns an error if the container is not running,
 // the path is invalid, or the copy operation fails.
 func (c Container) CopyTo(ctx context.Context, content io.Reader, path string) error {
 	_, err := c.client.CopyToContainer(ctx, c.ID, client.CopyToContainerOptions{
@@ -219,3 +520,17 @@ func (c Container) CopyTo(ctx context.Context, content io.Reader, path string) e
 
 	return nil
 }
+
+// CopyFrom copies the file or directory at srcPath out of the container. The content
+// is streamed back as a tar archive which the caller must close. Returns an error if
+// the container no longer exists, the path is invalid, or the copy operation fails.
+func (c Container) CopyFrom(ctx context.Context, srcPath string) (io.ReadCloser, error) {
+	result, err := c.client.CopyFromContainer(ctx, c.ID, client.CopyFromContainerOptions{
+		SourcePath: srcPath,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy content from container %q at path %q: %w\nCheck that the container exists and path is valid", c.Name, srcPath, err)
+	}
+
+	return result.Content, nil
+}
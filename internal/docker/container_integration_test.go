@@ -7,6 +7,7 @@ import (
 	"archive/tar"
 	"bytes"
 	"context"
+	"io"
 	"strings"
 	"testing"
 	"time"
@@ -223,6 +224,131 @@ func TestContainerCopyTo(t *testing.T) {
 	})
 }
 
+// TestContainerCopyFrom tests copying files out of a container
+func TestContainerCopyFrom(t *testing.T) {
+	client, err := docker.NewDefaultClient()
+	if err != nil {
+		t.Skip("Docker not available:", err)
+	}
+	defer client.Close()
+
+	t.Run("copies a file written inside the container", func(t *testing.T) {
+		ctx := context.Background()
+
+		container, err := client.CreateContainer(ctx, integrationOpts("test-copy-from", []string{"sh", "-c", "mkdir -p /app/out && echo artifact > /app/out/result.txt"}))
+		require.NoError(t, err)
+		defer func() {
+			_ = container.ForceRemove(ctx)
+		}()
+
+		err = container.Start(ctx)
+		require.NoError(t, err)
+
+		_, err = container.Wait(ctx, newMockWriter())
+		require.NoError(t, err)
+
+		dc := container.(docker.Container)
+		content, err := dc.CopyFrom(ctx, "/app/out/result.txt")
+		require.NoError(t, err)
+		defer content.Close()
+
+		tr := tar.NewReader(content)
+		header, err := tr.Next()
+		require.NoError(t, err)
+		require.Equal(t, "result.txt", header.Name)
+
+		data, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		require.Equal(t, "artifact\n", string(data))
+	})
+
+	t.Run("fails to copy from non-existent container", func(t *testing.T) {
+		ctx := context.Background()
+
+		container, err := client.CreateContainer(ctx, integrationOpts("test-copy-from-fail", []string{"echo", "test"}))
+		require.NoError(t, err)
+
+		err = container.ForceRemove(ctx)
+		require.NoError(t, err)
+
+		dc := container.(docker.Container)
+		_, err = dc.CopyFrom(ctx, "/tmp")
+		require.ErrorContains(t, err, "failed to copy content from container")
+	})
+}
+
+// TestContainerExec tests running an extra command in a running container
+func TestContainerExec(t *testing.T) {
+	client, err := docker.NewDefaultClient()
+	if err != nil {
+		t.Skip("Docker not available:", err)
+	}
+	defer client.Close()
+
+	t.Run("runs echo alongside the main process", func(t *testing.T) {
+		ctx := context.Background()
+
+		container, err := client.CreateContainer(ctx, integrationOpts("test-exec", []string{"sleep", "30"}))
+		require.NoError(t, err)
+		defer func() {
+			_ = container.ForceRemove(ctx)
+		}()
+
+		err = container.Start(ctx)
+		require.NoError(t, err)
+
+		dc := container.(docker.Container)
+		writer := newMockWriter()
+		code, err := dc.Exec(ctx, []string{"echo", "from exec"}, writer)
+		require.NoError(t, err)
+		require.Equal(t, 0, code)
+		require.Equal(t, "from exec\n", writer.String())
+
+		code, err = dc.Exec(ctx, []string{"sh", "-c", "exit 7"}, newMockWriter())
+		require.NoError(t, err)
+		require.Equal(t, 7, code)
+	})
+
+	t.Run("runs as the configured user", func(t *testing.T) {
+		ctx := context.Background()
+
+		opts := integrationOpts("test-exec-user", []string{"sleep", "30"})
+		opts.User = "1000:1000"
+		container, err := client.CreateContainer(ctx, opts)
+		require.NoError(t, err)
+		defer func() {
+			_ = container.ForceRemove(ctx)
+		}()
+
+		user, err := container.InspectUser(ctx)
+		require.NoError(t, err)
+		require.Equal(t, runtime.ImageUser{UID: 1000, GID: 1000}, user)
+
+		err = container.Start(ctx)
+		require.NoError(t, err)
+
+		writer := newMockWriter()
+		code, err := container.(docker.Container).Exec(ctx, []string{"id", "-u"}, writer)
+		require.NoError(t, err)
+		require.Equal(t, 0, code)
+		require.Equal(t, "1000\n", writer.String())
+	})
+
+	t.Run("fails when the container is not running", func(t *testing.T) {
+		ctx := context.Background()
+
+		container, err := client.CreateContainer(ctx, integrationOpts("test-exec-fail", []string{"echo", "test"}))
+		require.NoError(t, err)
+		defer func() {
+			_ = container.ForceRemove(ctx)
+		}()
+
+		dc := container.(docker.Container)
+		_, err = dc.Exec(ctx, []string{"echo", "test"}, newMockWriter())
+		require.ErrorContains(t, err, "failed to create exec in container")
+	})
+}
+
 // TestContainerWait tests waiting for container completion
 func TestContainerWait(t *testing.T) {
 	client, err := docker.NewDefaultClient()
@@ -244,8 +370,9 @@ func TestContainerWait(t *testing.T) {
 		require.NoError(t, err)
 
 		writer := newMockWriter()
-		err = container.Wait(ctx, writer)
+		code, err := container.Wait(ctx, writer)
 		require.NoError(t, err)
+		require.Equal(t, 0, code)
 
 		require.Contains(t, writer.String(), "Container exited with status: 0")
 	})
@@ -263,8 +390,9 @@ func TestContainerWait(t *testing.T) {
 		require.NoError(t, err)
 
 		writer := newMockWriter()
-		err = container.Wait(ctx, writer)
+		code, err := container.Wait(ctx, writer)
 		require.NoError(t, err)
+		require.Equal(t, 42, code)
 
 		require.Contains(t, writer.String(), "Container exited with status: 42")
 	})
@@ -291,7 +419,7 @@ func TestContainerAttach(t *testing.T) {
 		require.NoError(t, err)
 
 		writer := newMockWriter()
-		err = container.Attach(ctx, writer)
+		_, err = container.Attach(ctx, func() {}, writer)
 		require.Error(t, err)
 	})
 }
@@ -404,7 +532,7 @@ func TestContainerLifecycle(t *testing.T) {
 		require.NoError(t, err)
 
 		writer := newMockWriter()
-		err = container.Wait(ctx, writer)
+		_, err = container.Wait(ctx, writer)
 		require.NoError(t, err)
 
 		output := writer.String()
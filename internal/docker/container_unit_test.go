@@ -1,12 +1,20 @@
 package docker_test
 
 import (
+	"archive/tar"
+	"bytes"
 	"context"
+	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
+	"net"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	cerrdefs "github.com/containerd/errdefs"
 	containertypes "github.com/moby/moby/api/types/container"
 	"github.com/moby/moby/client"
 	"github.com/ryanmoran/contagent/internal"
@@ -24,6 +32,7 @@ func createTestContainerOpts() runtime.CreateContainerOptions {
 		Volumes:     []string{},
 		WorkingDir:  "/app",
 		Network:     "some-network",
+		TTY:         true,
 		StopTimeout: 10,
 		TTYRetries:  10,
 		RetryDelay:  100 * time.Millisecond,
@@ -143,6 +152,7 @@ func TestContainerForceRemoveWithMock(t *testing.T) {
 				removeCalled = true
 				require.Equal(t, "container123", containerID)
 				require.True(t, options.Force)
+				require.True(t, options.RemoveVolumes)
 				return client.ContainerRemoveResult{}, nil
 			},
 		}
@@ -178,6 +188,139 @@ func TestContainerForceRemoveWithMock(t *testing.T) {
 		require.Error(t, err)
 		require.Contains(t, err.Error(), "failed to force remove container")
 	})
+
+	t.Run("succeeds when the container is already gone", func(t *testing.T) {
+		mock := &mockDockerClient{
+			containerCreateFunc: func(ctx context.Context, options client.ContainerCreateOptions) (client.ContainerCreateResult, error) {
+				return client.ContainerCreateResult{ID: "container123"}, nil
+			},
+			containerRemoveFunc: func(ctx context.Context, containerID string, options client.ContainerRemoveOptions) (client.ContainerRemoveResult, error) {
+				return client.ContainerRemoveResult{}, fmt.Errorf("no such container: %w", cerrdefs.ErrNotFound)
+			},
+		}
+
+		c := docker.NewClient(mock)
+		ctx := context.Background()
+
+		container, err := c.CreateContainer(ctx, createTestContainerOpts())
+		require.NoError(t, err)
+
+		require.NoError(t, container.ForceRemove(ctx))
+	})
+}
+
+// TestContainerStopWithMock tests Container.Stop using a mock Docker client
+func TestContainerStopWithMock(t *testing.T) {
+	type calls struct {
+		stopTimeout *int
+		killed      bool
+		removed     bool
+	}
+
+	// stop runs Stop on a container kept when keep is set, against a daemon whose stop
+	// fails with stopErr, that reports the container as running afterwards when running
+	// is set, and whose kill and remove fail with escalateErr.
+	stop := func(t *testing.T, keep bool, stopErr error, running bool, escalateErr error) (calls, string, error) {
+		t.Helper()
+
+		var result calls
+		mock := &mockDockerClient{
+			containerCreateFunc: func(ctx context.Context, options client.ContainerCreateOptions) (client.ContainerCreateResult, error) {
+				return client.ContainerCreateResult{ID: "container123"}, nil
+			},
+			containerStopFunc: func(ctx context.Context, containerID string, options client.ContainerStopOptions) (client.ContainerStopResult, error) {
+				require.Equal(t, "container123", containerID)
+				result.stopTimeout = options.Timeout
+				return client.ContainerStopResult{}, stopErr
+			},
+			containerInspectFunc: func(ctx context.Context, containerID string, options client.ContainerInspectOptions) (client.ContainerInspectResult, error) {
+				return client.ContainerInspectResult{Container: containertypes.InspectResponse{
+					State: &containertypes.State{Running: running},
+				}}, nil
+			},
+			containerKillFunc: func(ctx context.Context, containerID string, options client.ContainerKillOptions) (client.ContainerKillResult, error) {
+				require.Equal(t, "container123", containerID)
+				require.Empty(t, options.Signal)
+				result.killed = true
+				return client.ContainerKillResult{}, escalateErr
+			},
+			containerRemoveFunc: func(ctx context.Context, containerID string, options client.ContainerRemoveOptions) (client.ContainerRemoveResult, error) {
+				require.Equal(t, "container123", containerID)
+				require.True(t, options.Force)
+				result.removed = true
+				return client.ContainerRemoveResult{}, escalateErr
+			},
+		}
+
+		c := docker.NewClient(mock)
+		ctx := context.Background()
+
+		opts := createTestContainerOpts()
+		opts.Keep = keep
+		container, err := c.CreateContainer(ctx, opts)
+		require.NoError(t, err)
+
+		writer := newMockWriter()
+		err = container.(docker.Container).Stop(ctx, writer)
+		return result, writer.String(), err
+	}
+
+	t.Run("stops the container with the stop timeout", func(t *testing.T) {
+		result, output, err := stop(t, false, nil, false, nil)
+		require.NoError(t, err)
+		require.NotNil(t, result.stopTimeout)
+		require.Equal(t, 10, *result.stopTimeout)
+		require.False(t, result.killed)
+		require.False(t, result.removed)
+		require.Empty(t, output)
+	})
+
+	t.Run("force-removes a container that is still running after the stop", func(t *testing.T) {
+		result, output, err := stop(t, false, nil, true, nil)
+		require.NoError(t, err)
+		require.True(t, result.removed)
+		require.False(t, result.killed)
+		require.Contains(t, output, "Warning: container test is still running after being stopped; removing it")
+	})
+
+	t.Run("force-removes the container when the stop fails", func(t *testing.T) {
+		result, output, err := stop(t, false, errors.New("stop failed"), true, nil)
+		require.NoError(t, err)
+		require.True(t, result.removed)
+		require.Contains(t, output, "Warning: failed to stop container")
+		require.Contains(t, output, "removing it")
+	})
+
+	t.Run("fails when the running container cannot be removed", func(t *testing.T) {
+		result, _, err := stop(t, false, nil, true, errors.New("remove failed"))
+		require.True(t, result.removed)
+		require.ErrorContains(t, err, `container "test" may still be running`)
+		require.ErrorContains(t, err, "remove failed")
+	})
+
+	t.Run("kills a kept container that is still running after the stop", func(t *testing.T) {
+		result, output, err := stop(t, true, nil, true, nil)
+		require.NoError(t, err)
+		require.True(t, result.killed)
+		require.False(t, result.removed)
+		require.Contains(t, output, "Warning: container test is still running after being stopped; killing it")
+	})
+
+	t.Run("kills a kept container when the stop fails", func(t *testing.T) {
+		result, output, err := stop(t, true, errors.New("stop failed"), true, nil)
+		require.NoError(t, err)
+		require.True(t, result.killed)
+		require.False(t, result.removed)
+		require.Contains(t, output, "Warning: failed to stop container")
+		require.Contains(t, output, "killing it")
+	})
+
+	t.Run("fails when the running kept container cannot be killed", func(t *testing.T) {
+		result, _, err := stop(t, true, nil, true, errors.New("kill failed"))
+		require.True(t, result.killed)
+		require.ErrorContains(t, err, `container "test" may still be running`)
+		require.ErrorContains(t, err, "kill failed")
+	})
 }
 
 // TestContainerCopyToWithMock tests Container.CopyTo using a mock Docker client
@@ -229,6 +372,556 @@ func TestContainerCopyToWithMock(t *testing.T) {
 	})
 }
 
+// TestContainerCopyFromWithMock tests Container.CopyFrom using a mock Docker client
+func TestContainerCopyFromWithMock(t *testing.T) {
+	t.Run("copies content from container successfully", func(t *testing.T) {
+		var archive bytes.Buffer
+		tw := tar.NewWriter(&archive)
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: "result.txt", Mode: 0644, Size: 5}))
+		_, err := tw.Write([]byte("done\n"))
+		require.NoError(t, err)
+		require.NoError(t, tw.Close())
+
+		mock := &mockDockerClient{
+			containerCreateFunc: func(ctx context.Context, options client.ContainerCreateOptions) (client.ContainerCreateResult, error) {
+				return client.ContainerCreateResult{ID: "container123"}, nil
+			},
+			copyFromContainerFunc: func(ctx context.Context, containerID string, options client.CopyFromContainerOptions) (client.CopyFromContainerResult, error) {
+				require.Equal(t, "container123", containerID)
+				require.Equal(t, "/app/out/result.txt", options.SourcePath)
+				return client.CopyFromContainerResult{
+					Content: io.NopCloser(bytes.NewReader(archive.Bytes())),
+				}, nil
+			},
+		}
+
+		c := docker.NewClient(mock)
+		ctx := context.Background()
+
+		container, err := c.CreateContainer(ctx, createTestContainerOpts())
+		require.NoError(t, err)
+
+		dc, ok := container.(docker.Container)
+		require.True(t, ok, "container should be docker.Container type")
+		content, err := dc.CopyFrom(ctx, "/app/out/result.txt")
+		require.NoError(t, err)
+		defer content.Close()
+
+		tr := tar.NewReader(content)
+		header, err := tr.Next()
+		require.NoError(t, err)
+		require.Equal(t, "result.txt", header.Name)
+		data, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		require.Equal(t, "done\n", string(data))
+	})
+
+	t.Run("fails when CopyFromContainer returns error", func(t *testing.T) {
+		mock := &mockDockerClient{
+			containerCreateFunc: func(ctx context.Context, options client.ContainerCreateOptions) (client.ContainerCreateResult, error) {
+				return client.ContainerCreateResult{ID: "container123"}, nil
+			},
+			copyFromContainerFunc: func(ctx context.Context, containerID string, options client.CopyFromContainerOptions) (client.CopyFromContainerResult, error) {
+				return client.CopyFromContainerResult{}, errors.New("no such container")
+			},
+		}
+
+		c := docker.NewClient(mock)
+		ctx := context.Background()
+
+		container, err := c.CreateContainer(ctx, createTestContainerOpts())
+		require.NoError(t, err)
+
+		dc, ok := container.(docker.Container)
+		require.True(t, ok, "container should be docker.Container type")
+		_, err = dc.CopyFrom(ctx, "/app/out")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to copy content from container")
+		require.Contains(t, err.Error(), "no such container")
+	})
+}
+
+// multiplexedFrame encodes payload as a single frame of Docker's multiplexed log stream.
+func multiplexedFrame(stream byte, payload string) []byte {
+	header := []byte{stream, 0, 0, 0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(header[4:], uint32(len(payload)))
+	return append(header, payload...)
+}
+
+// TestContainerLogsWithMock tests Container.Logs using a mock Docker client
+func TestContainerLogsWithMock(t *testing.T) {
+	inspectFunc := func(tty bool) func(ctx context.Context, containerID string, options client.ContainerInspectOptions) (client.ContainerInspectResult, error) {
+		return func(ctx context.Context, containerID string, options client.ContainerInspectOptions) (client.ContainerInspectResult, error) {
+			return client.ContainerInspectResult{
+				Container: containertypes.InspectResponse{
+					Config: &containertypes.Config{Tty: tty},
+				},
+			}, nil
+		}
+	}
+
+	t.Run("demultiplexes stdout and stderr", func(t *testing.T) {
+		var stream []byte
+		stream = append(stream, multiplexedFrame(1, "hello from stdout\n")...)
+		stream = append(stream, multiplexedFrame(2, "hello from stderr\n")...)
+
+		mock := &mockDockerClient{
+			containerCreateFunc: func(ctx context.Context, options client.ContainerCreateOptions) (client.ContainerCreateResult, error) {
+				return client.ContainerCreateResult{ID: "container123"}, nil
+			},
+			containerInspectFunc: inspectFunc(false),
+			containerLogsFunc: func(ctx context.Context, containerID string, options client.ContainerLogsOptions) (client.ContainerLogsResult, error) {
+				require.Equal(t, "container123", containerID)
+				require.True(t, options.ShowStdout)
+				require.True(t, options.ShowStderr)
+				require.True(t, options.Follow)
+				return io.NopCloser(bytes.NewReader(stream)), nil
+			},
+		}
+
+		c := docker.NewClient(mock)
+		ctx := context.Background()
+
+		container, err := c.CreateContainer(ctx, createTestContainerOpts())
+		require.NoError(t, err)
+
+		dc, ok := container.(docker.Container)
+		require.True(t, ok, "container should be docker.Container type")
+
+		writer := newMockWriter()
+		err = dc.Logs(ctx, writer, true)
+		require.NoError(t, err)
+		require.Equal(t, "hello from stdout\nhello from stderr\n", writer.String())
+	})
+
+	t.Run("copies raw output for TTY containers", func(t *testing.T) {
+		mock := &mockDockerClient{
+			containerCreateFunc: func(ctx context.Context, options client.ContainerCreateOptions) (client.ContainerCreateResult, error) {
+				return client.ContainerCreateResult{ID: "container123"}, nil
+			},
+			containerInspectFunc: inspectFunc(true),
+			containerLogsFunc: func(ctx context.Context, containerID string, options client.ContainerLogsOptions) (client.ContainerLogsResult, error) {
+				require.False(t, options.Follow)
+				return io.NopCloser(strings.NewReader("raw tty output\r\n")), nil
+			},
+		}
+
+		c := docker.NewClient(mock)
+		ctx := context.Background()
+
+		container, err := c.CreateContainer(ctx, createTestContainerOpts())
+		require.NoError(t, err)
+
+		dc, ok := container.(docker.Container)
+		require.True(t, ok, "container should be docker.Container type")
+
+		writer := newMockWriter()
+		err = dc.Logs(ctx, writer, false)
+		require.NoError(t, err)
+		require.Equal(t, "raw tty output\r\n", writer.String())
+	})
+
+	t.Run("fails when the container does not exist", func(t *testing.T) {
+		mock := &mockDockerClient{
+			containerCreateFunc: func(ctx context.Context, options client.ContainerCreateOptions) (client.ContainerCreateResult, error) {
+				return client.ContainerCreateResult{ID: "container123"}, nil
+			},
+			containerInspectFunc: func(ctx context.Context, containerID string, options client.ContainerInspectOptions) (client.ContainerInspectResult, error) {
+				return client.ContainerInspectResult{}, errors.New("no such container")
+			},
+		}
+
+		c := docker.NewClient(mock)
+		ctx := context.Background()
+
+		container, err := c.CreateContainer(ctx, createTestContainerOpts())
+		require.NoError(t, err)
+
+		dc, ok := container.(docker.Container)
+		require.True(t, ok, "container should be docker.Container type")
+
+		err = dc.Logs(ctx, newMockWriter(), false)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "no such container")
+	})
+
+	t.Run("returns the context error when cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		pr, pw := io.Pipe()
+
+		mock := &mockDockerClient{
+			containerCreateFunc: func(ctx context.Context, options client.ContainerCreateOptions) (client.ContainerCreateResult, error) {
+				return client.ContainerCreateResult{ID: "container123"}, nil
+			},
+			containerInspectFunc: inspectFunc(false),
+			containerLogsFunc: func(ctx context.Context, containerID string, options client.ContainerLogsOptions) (client.ContainerLogsResult, error) {
+				go func() {
+					<-ctx.Done()
+					pw.CloseWithError(ctx.Err())
+				}()
+				return pr, nil
+			},
+		}
+
+		c := docker.NewClient(mock)
+
+		container, err := c.CreateContainer(ctx, createTestContainerOpts())
+		require.NoError(t, err)
+
+		dc, ok := container.(docker.Container)
+		require.True(t, ok, "container should be docker.Container type")
+
+		time.AfterFunc(10*time.Millisecond, cancel)
+		err = dc.Logs(ctx, newMockWriter(), true)
+		require.ErrorIs(t, err, context.Canceled)
+	})
+}
+
+// TestContainerExecWithMock tests Container.Exec using a mock Docker client
+func TestContainerExecWithMock(t *testing.T) {
+	// execAttach serves stream over an in-memory connection, then closes it as
+	// the daemon does once the exec process exits.
+	execAttach := func(stream []byte) func(ctx context.Context, execID string, options client.ExecAttachOptions) (client.ExecAttachResult, error) {
+		return func(ctx context.Context, execID string, options client.ExecAttachOptions) (client.ExecAttachResult, error) {
+			server, conn := net.Pipe()
+			go func() {
+				server.Write(stream) //nolint:errcheck // the test fails on missing output instead
+				server.Close()
+			}()
+			return client.ExecAttachResult{HijackedResponse: client.NewHijackedResponse(conn, "")}, nil
+		}
+	}
+
+	t.Run("streams output and returns the exit code", func(t *testing.T) {
+		var stream []byte
+		stream = append(stream, multiplexedFrame(1, "hello from exec\n")...)
+		stream = append(stream, multiplexedFrame(2, "warning from exec\n")...)
+
+		var createOptions client.ExecCreateOptions
+		mock := &mockDockerClient{
+			containerCreateFunc: func(ctx context.Context, options client.ContainerCreateOptions) (client.ContainerCreateResult, error) {
+				return client.ContainerCreateResult{ID: "container123"}, nil
+			},
+			execCreateFunc: func(ctx context.Context, containerID string, options client.ExecCreateOptions) (client.ExecCreateResult, error) {
+				require.Equal(t, "container123", containerID)
+				createOptions = options
+				return client.ExecCreateResult{ID: "exec123"}, nil
+			},
+			execAttachFunc: execAttach(stream),
+			execInspectFunc: func(ctx context.Context, execID string, options client.ExecInspectOptions) (client.ExecInspectResult, error) {
+				require.Equal(t, "exec123", execID)
+				return client.ExecInspectResult{ExitCode: 3}, nil
+			},
+		}
+
+		c := docker.NewClient(mock)
+		ctx := context.Background()
+
+		container, err := c.CreateContainer(ctx, createTestContainerOpts())
+		require.NoError(t, err)
+
+		dc, ok := container.(docker.Container)
+		require.True(t, ok, "container should be docker.Container type")
+
+		writer := newMockWriter()
+		code, err := dc.Exec(ctx, []string{"echo", "hello"}, writer)
+		require.NoError(t, err)
+		require.Equal(t, 3, code)
+		require.Equal(t, "hello from exec\nwarning from exec\n", writer.String())
+
+		require.Equal(t, []string{"echo", "hello"}, createOptions.Cmd)
+		require.True(t, createOptions.AttachStdout)
+		require.True(t, createOptions.AttachStderr)
+		require.False(t, createOptions.AttachStdin)
+		require.False(t, createOptions.TTY)
+	})
+
+	t.Run("fails when the exec cannot be created", func(t *testing.T) {
+		mock := &mockDockerClient{
+			containerCreateFunc: func(ctx context.Context, options client.ContainerCreateOptions) (client.ContainerCreateResult, error) {
+				return client.ContainerCreateResult{ID: "container123"}, nil
+			},
+			execCreateFunc: func(ctx context.Context, containerID string, options client.ExecCreateOptions) (client.ExecCreateResult, error) {
+				return client.ExecCreateResult{}, errors.New("container is not running")
+			},
+		}
+
+		c := docker.NewClient(mock)
+		ctx := context.Background()
+
+		container, err := c.CreateContainer(ctx, createTestContainerOpts())
+		require.NoError(t, err)
+
+		dc, ok := container.(docker.Container)
+		require.True(t, ok, "container should be docker.Container type")
+
+		_, err = dc.Exec(ctx, []string{"true"}, newMockWriter())
+		require.ErrorContains(t, err, "failed to create exec in container")
+		require.ErrorContains(t, err, "container is not running")
+	})
+
+	t.Run("fails when the exec cannot be inspected", func(t *testing.T) {
+		mock := &mockDockerClient{
+			containerCreateFunc: func(ctx context.Context, options client.ContainerCreateOptions) (client.ContainerCreateResult, error) {
+				return client.ContainerCreateResult{ID: "container123"}, nil
+			},
+			execCreateFunc: func(ctx context.Context, containerID string, options client.ExecCreateOptions) (client.ExecCreateResult, error) {
+				return client.ExecCreateResult{ID: "exec123"}, nil
+			},
+			execAttachFunc: execAttach(nil),
+			execInspectFunc: func(ctx context.Context, execID string, options client.ExecInspectOptions) (client.ExecInspectResult, error) {
+				return client.ExecInspectResult{}, errors.New("no such exec")
+			},
+		}
+
+		c := docker.NewClient(mock)
+		ctx := context.Background()
+
+		container, err := c.CreateContainer(ctx, createTestContainerOpts())
+		require.NoError(t, err)
+
+		dc, ok := container.(docker.Container)
+		require.True(t, ok, "container should be docker.Container type")
+
+		_, err = dc.Exec(ctx, []string{"true"}, newMockWriter())
+		require.ErrorContains(t, err, "failed to inspect exec in container")
+	})
+}
+
+// TestContainerAttachWithMock tests Container.Attach using a mock Docker client
+func TestContainerAttachWithMock(t *testing.T) {
+	// attach serves the container's side of the attach connection as server
+	attach := func(t *testing.T) (runtime.Container, net.Conn, net.Conn) {
+		t.Helper()
+
+		server, conn := net.Pipe()
+		t.Cleanup(func() {
+			server.Close()
+		})
+
+		mock := &mockDockerClient{
+			containerCreateFunc: func(ctx context.Context, options client.ContainerCreateOptions) (client.ContainerCreateResult, error) {
+				return client.ContainerCreateResult{ID: "container123"}, nil
+			},
+			containerAttachFunc: func(ctx context.Context, containerID string, options client.ContainerAttachOptions) (client.ContainerAttachResult, error) {
+				return client.ContainerAttachResult{HijackedResponse: client.NewHijackedResponse(conn, "")}, nil
+			},
+		}
+
+		opts := createTestContainerOpts()
+		opts.TTY = false
+		container, err := docker.NewClient(mock).CreateContainer(context.Background(), opts)
+		require.NoError(t, err)
+
+		return container, server, conn
+	}
+
+	t.Run("waits for the output to be forwarded", func(t *testing.T) {
+		container, server, conn := attach(t)
+
+		attachment, err := container.Attach(context.Background(), func() {}, newMockWriter())
+		require.NoError(t, err)
+
+		waited := make(chan error, 1)
+		go func() {
+			waited <- attachment.Wait(context.Background())
+		}()
+
+		select {
+		case <-waited:
+			t.Fatal("Wait returned while the container could still produce output")
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		// The daemon closes the connection once the container exits
+		require.NoError(t, server.Close())
+
+		select {
+		case err := <-waited:
+			require.NoError(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("Wait did not return after the output was forwarded")
+		}
+
+		// The output goroutine closes its end of the connection before finishing
+		_, err = conn.Read(make([]byte, 1))
+		require.ErrorIs(t, err, io.ErrClosedPipe)
+	})
+
+	t.Run("stops waiting when the context is done", func(t *testing.T) {
+		container, _, _ := attach(t)
+
+		attachment, err := container.Attach(context.Background(), func() {}, newMockWriter())
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		require.ErrorIs(t, attachment.Wait(ctx), context.Canceled)
+	})
+}
+
+// fakeTerminal stands in for stdin attached to a terminal, recording raw mode changes.
+type fakeTerminal struct {
+	io.Reader
+	raw      bool
+	restored bool
+}
+
+func (f *fakeTerminal) IsTerminal() bool { return true }
+func (f *fakeTerminal) SetRawTerminal() error {
+	f.raw = true
+	return nil
+}
+func (f *fakeTerminal) RestoreTerminal() { f.restored = true }
+
+// TestContainerAttachTerminalWithMock tests that Container.Attach restores the terminal
+// when attaching fails after raw mode is set
+func TestContainerAttachTerminalWithMock(t *testing.T) {
+	setup := func(t *testing.T, attach func(ctx context.Context, containerID string, options client.ContainerAttachOptions) (client.ContainerAttachResult, error)) docker.Container {
+		t.Helper()
+
+		mock := &mockDockerClient{
+			containerCreateFunc: func(ctx context.Context, options client.ContainerCreateOptions) (client.ContainerCreateResult, error) {
+				return client.ContainerCreateResult{ID: "container123"}, nil
+			},
+			containerAttachFunc: attach,
+		}
+
+		container, err := docker.NewClient(mock).CreateContainer(context.Background(), createTestContainerOpts())
+		require.NoError(t, err)
+
+		dc, ok := container.(docker.Container)
+		require.True(t, ok, "container should be docker.Container type")
+		return dc
+	}
+
+	t.Run("restores the terminal when attaching fails", func(t *testing.T) {
+		container := setup(t, func(ctx context.Context, containerID string, options client.ContainerAttachOptions) (client.ContainerAttachResult, error) {
+			return client.ContainerAttachResult{}, errors.New("connection refused")
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		in := &fakeTerminal{Reader: strings.NewReader("")}
+		_, err := container.AttachWithInput(ctx, cancel, in, newMockWriter())
+		require.ErrorContains(t, err, "connection refused")
+		require.True(t, in.raw)
+		require.True(t, in.restored)
+	})
+
+	t.Run("restores the terminal when attaching panics", func(t *testing.T) {
+		container := setup(t, func(ctx context.Context, containerID string, options client.ContainerAttachOptions) (client.ContainerAttachResult, error) {
+			panic("unexpected response")
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		in := &fakeTerminal{Reader: strings.NewReader("")}
+		require.PanicsWithValue(t, "unexpected response", func() {
+			_, _ = container.AttachWithInput(ctx, cancel, in, newMockWriter()) //nolint:errcheck // panics before returning
+		})
+		require.True(t, in.raw)
+		require.True(t, in.restored)
+	})
+
+	t.Run("leaves the terminal in raw mode while forwarding", func(t *testing.T) {
+		server, conn := net.Pipe()
+		container := setup(t, func(ctx context.Context, containerID string, options client.ContainerAttachOptions) (client.ContainerAttachResult, error) {
+			return client.ContainerAttachResult{HijackedResponse: client.NewHijackedResponse(conn, "")}, nil
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		// Stdin never produces input, like an idle terminal
+		stdin, stdinWriter := io.Pipe()
+		defer stdinWriter.Close()
+
+		in := &fakeTerminal{Reader: stdin}
+		attachment, err := container.AttachWithInput(ctx, cancel, in, newMockWriter())
+		require.NoError(t, err)
+		require.True(t, in.raw)
+
+		require.NoError(t, server.Close())
+		require.NoError(t, attachment.Wait(context.Background()))
+		require.True(t, in.restored)
+	})
+}
+
+// fakeTerminalOutput stands in for stdout attached to a terminal of a given size.
+type fakeTerminalOutput struct {
+	io.Writer
+	*fakeTerminalSize
+}
+
+func (f fakeTerminalOutput) SetRawTerminal() error { return nil }
+func (f fakeTerminalOutput) RestoreTerminal()      {}
+
+// TestContainerAttachMonitorWithMock tests that Container.Attach stops resizing the TTY
+// once the attach session ends
+func TestContainerAttachMonitorWithMock(t *testing.T) {
+	server, conn := net.Pipe()
+
+	var mu sync.Mutex
+	resizes := 0
+	mock := &mockDockerClient{
+		containerCreateFunc: func(ctx context.Context, options client.ContainerCreateOptions) (client.ContainerCreateResult, error) {
+			return client.ContainerCreateResult{ID: "container123"}, nil
+		},
+		containerResizeFunc: func(ctx context.Context, containerID string, options client.ContainerResizeOptions) (client.ContainerResizeResult, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			resizes++
+			return client.ContainerResizeResult{}, nil
+		},
+		containerAttachFunc: func(ctx context.Context, containerID string, options client.ContainerAttachOptions) (client.ContainerAttachResult, error) {
+			return client.ContainerAttachResult{HijackedResponse: client.NewHijackedResponse(conn, "")}, nil
+		},
+	}
+	count := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return resizes
+	}
+
+	opts := createTestContainerOpts()
+	opts.TTYResync = 5 * time.Millisecond
+	container, err := docker.NewClient(mock).CreateContainer(context.Background(), opts)
+	require.NoError(t, err)
+	dc, ok := container.(docker.Container)
+	require.True(t, ok, "container should be docker.Container type")
+
+	// The context outlives the attach session, as it does in main
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stdin, stdinWriter := io.Pipe()
+	defer stdinWriter.Close()
+
+	size := &fakeTerminalSize{height: 24, width: 80}
+	out := fakeTerminalOutput{Writer: io.Discard, fakeTerminalSize: size}
+	attachment, err := dc.AttachWithTerminal(ctx, cancel, &fakeTerminal{Reader: stdin}, out, newMockWriter())
+	require.NoError(t, err)
+	require.Equal(t, 1, count())
+
+	size.set(30, 100)
+	require.Eventually(t, func() bool {
+		return count() == 2
+	}, 5*time.Second, 5*time.Millisecond, "the monitor should resize while attached")
+
+	// The container exits, ending the session
+	require.NoError(t, server.Close())
+	require.NoError(t, attachment.Wait(context.Background()))
+
+	size.set(40, 120)
+	time.Sleep(50 * time.Millisecond)
+	require.Equal(t, 2, count(), "the monitor should stop once the session ends")
+	require.NoError(t, ctx.Err())
+}
+
 // TestContainerWaitWithMock tests Container.Wait using a mock Docker client
 func TestContainerWaitWithMock(t *testing.T) {
 	t.Run("waits for container to complete with exit code 0", func(t *testing.T) {
@@ -254,8 +947,9 @@ func TestContainerWaitWithMock(t *testing.T) {
 		require.NoError(t, err)
 
 		writer := newMockWriter()
-		err = container.Wait(ctx, writer)
+		code, err := container.Wait(ctx, writer)
 		require.NoError(t, err)
+		require.Equal(t, 0, code)
 		require.Contains(t, writer.String(), "Container exited with status: 0")
 	})
 
@@ -281,8 +975,9 @@ func TestContainerWaitWithMock(t *testing.T) {
 		require.NoError(t, err)
 
 		writer := newMockWriter()
-		err = container.Wait(ctx, writer)
+		code, err := container.Wait(ctx, writer)
 		require.NoError(t, err)
+		require.Equal(t, 42, code)
 		require.Contains(t, writer.String(), "Container exited with status: 42")
 	})
 
@@ -306,8 +1001,276 @@ func TestContainerWaitWithMock(t *testing.T) {
 		require.NoError(t, err)
 
 		writer := newMockWriter()
-		err = container.Wait(ctx, writer)
+		_, err = container.Wait(ctx, writer)
 		require.Error(t, err)
 		require.Contains(t, err.Error(), "failed to wait for container")
 	})
+
+	t.Run("stops container and reports interrupted exit code on cancellation", func(t *testing.T) {
+		stopCalled := false
+		mock := &mockDockerClient{
+			containerCreateFunc: func(ctx context.Context, options client.ContainerCreateOptions) (client.ContainerCreateResult, error) {
+				return client.ContainerCreateResult{ID: "container123"}, nil
+			},
+			containerWaitFunc: func(ctx context.Context, containerID string, options client.ContainerWaitOptions) client.ContainerWaitResult {
+				errCh := make(chan error, 1)
+				resCh := make(chan containertypes.WaitResponse, 1)
+				return client.ContainerWaitResult{Error: errCh, Result: resCh}
+			},
+			containerStopFunc: func(ctx context.Context, containerID string, options client.ContainerStopOptions) (client.ContainerStopResult, error) {
+				stopCalled = true
+				require.Equal(t, "container123", containerID)
+				return client.ContainerStopResult{}, nil
+			},
+		}
+
+		c := docker.NewClient(mock)
+		ctx, cancel := context.WithCancel(context.Background())
+
+		container, err := c.CreateContainer(ctx, createTestContainerOpts())
+		require.NoError(t, err)
+
+		cancel()
+
+		writer := newMockWriter()
+		code, err := container.Wait(ctx, writer)
+		require.NoError(t, err)
+		require.Equal(t, runtime.ExitCodeInterrupted, code)
+		require.True(t, stopCalled)
+		require.Contains(t, writer.String(), "Received signal, stopping container...")
+	})
+
+	t.Run("stops container and returns a timeout error when the timeout elapses", func(t *testing.T) {
+		var stopTimeout *int
+		mock := &mockDockerClient{
+			containerCreateFunc: func(ctx context.Context, options client.ContainerCreateOptions) (client.ContainerCreateResult, error) {
+				return client.ContainerCreateResult{ID: "container123"}, nil
+			},
+			containerWaitFunc: func(ctx context.Context, containerID string, options client.ContainerWaitOptions) client.ContainerWaitResult {
+				// The result never arrives
+				errCh := make(chan error, 1)
+				resCh := make(chan containertypes.WaitResponse, 1)
+				return client.ContainerWaitResult{Error: errCh, Result: resCh}
+			},
+			containerStopFunc: func(ctx context.Context, containerID string, options client.ContainerStopOptions) (client.ContainerStopResult, error) {
+				require.Equal(t, "container123", containerID)
+				stopTimeout = options.Timeout
+				return client.ContainerStopResult{}, nil
+			},
+		}
+
+		c := docker.NewClient(mock)
+		ctx := context.Background()
+
+		opts := createTestContainerOpts()
+		opts.Timeout = 10 * time.Millisecond
+		container, err := c.CreateContainer(ctx, opts)
+		require.NoError(t, err)
+
+		writer := newMockWriter()
+		_, err = container.Wait(ctx, writer)
+		require.ErrorIs(t, err, runtime.ErrTimeout)
+		require.ErrorContains(t, err, `container "test" did not exit within 10ms`)
+		require.NotNil(t, stopTimeout, "container should be stopped")
+		require.Equal(t, 10, *stopTimeout)
+		require.Contains(t, writer.String(), "Container did not exit within 10ms, stopping container...")
+	})
+
+	t.Run("keeps waiting while the restart policy restarts the container", func(t *testing.T) {
+		var conditions []containertypes.WaitCondition
+		mock := &mockDockerClient{
+			containerCreateFunc: func(ctx context.Context, options client.ContainerCreateOptions) (client.ContainerCreateResult, error) {
+				return client.ContainerCreateResult{ID: "container123"}, nil
+			},
+			containerWaitFunc: func(ctx context.Context, containerID string, options client.ContainerWaitOptions) client.ContainerWaitResult {
+				conditions = append(conditions, options.Condition)
+
+				errCh := make(chan error, 1)
+				resCh := make(chan containertypes.WaitResponse, 1)
+				resCh <- containertypes.WaitResponse{StatusCode: int64(len(conditions))}
+				return client.ContainerWaitResult{Error: errCh, Result: resCh}
+			},
+			containerInspectFunc: func(ctx context.Context, containerID string, options client.ContainerInspectOptions) (client.ContainerInspectResult, error) {
+				// The daemon gives up after the second failure
+				return client.ContainerInspectResult{Container: containertypes.InspectResponse{
+					State: &containertypes.State{Restarting: len(conditions) < 2},
+				}}, nil
+			},
+		}
+
+		c := docker.NewClient(mock)
+		ctx := context.Background()
+
+		opts := createTestContainerOpts()
+		opts.Restart = internal.RestartPolicy{Name: internal.RestartOnFailure, MaxRetries: 1}
+		container, err := c.CreateContainer(ctx, opts)
+		require.NoError(t, err)
+
+		writer := newMockWriter()
+		code, err := container.Wait(ctx, writer)
+		require.NoError(t, err)
+		require.Equal(t, 2, code)
+		require.Equal(t, []containertypes.WaitCondition{
+			containertypes.WaitConditionNotRunning,
+			containertypes.WaitConditionNextExit,
+		}, conditions)
+		require.Contains(t, writer.String(), "Container exited with status 1 and is being restarted")
+		require.Contains(t, writer.String(), "Container exited with status: 2")
+	})
+
+	t.Run("reports when the container was killed due to out-of-memory", func(t *testing.T) {
+		mock := &mockDockerClient{
+			containerCreateFunc: func(ctx context.Context, options client.ContainerCreateOptions) (client.ContainerCreateResult, error) {
+				return client.ContainerCreateResult{ID: "container123"}, nil
+			},
+			containerWaitFunc: func(ctx context.Context, containerID string, options client.ContainerWaitOptions) client.ContainerWaitResult {
+				errCh := make(chan error, 1)
+				resCh := make(chan containertypes.WaitResponse, 1)
+				resCh <- containertypes.WaitResponse{StatusCode: 137}
+				return client.ContainerWaitResult{Error: errCh, Result: resCh}
+			},
+			containerInspectFunc: func(ctx context.Context, containerID string, options client.ContainerInspectOptions) (client.ContainerInspectResult, error) {
+				return client.ContainerInspectResult{Container: containertypes.InspectResponse{
+					State: &containertypes.State{ExitCode: 137, OOMKilled: true},
+				}}, nil
+			},
+		}
+
+		c := docker.NewClient(mock)
+		ctx := context.Background()
+
+		container, err := c.CreateContainer(ctx, createTestContainerOpts())
+		require.NoError(t, err)
+
+		writer := newMockWriter()
+		code, err := container.Wait(ctx, writer)
+		require.NoError(t, err)
+		require.Equal(t, 137, code)
+		require.Contains(t, writer.String(), "Container was killed due to out-of-memory (exit status 137)\nTry raising the memory limit with --memory\n")
+		require.NotContains(t, writer.String(), "Container exited with status")
+	})
+
+	t.Run("warns when a process was killed due to out-of-memory but the command succeeded", func(t *testing.T) {
+		mock := &mockDockerClient{
+			containerCreateFunc: func(ctx context.Context, options client.ContainerCreateOptions) (client.ContainerCreateResult, error) {
+				return client.ContainerCreateResult{ID: "container123"}, nil
+			},
+			containerWaitFunc: func(ctx context.Context, containerID string, options client.ContainerWaitOptions) client.ContainerWaitResult {
+				errCh := make(chan error, 1)
+				resCh := make(chan containertypes.WaitResponse, 1)
+				resCh <- containertypes.WaitResponse{StatusCode: 0}
+				return client.ContainerWaitResult{Error: errCh, Result: resCh}
+			},
+			containerInspectFunc: func(ctx context.Context, containerID string, options client.ContainerInspectOptions) (client.ContainerInspectResult, error) {
+				return client.ContainerInspectResult{Container: containertypes.InspectResponse{
+					State: &containertypes.State{OOMKilled: true},
+				}}, nil
+			},
+		}
+
+		c := docker.NewClient(mock)
+		ctx := context.Background()
+
+		container, err := c.CreateContainer(ctx, createTestContainerOpts())
+		require.NoError(t, err)
+
+		writer := newMockWriter()
+		code, err := container.Wait(ctx, writer)
+		require.NoError(t, err)
+		require.Equal(t, 0, code)
+		require.Contains(t, writer.String(), "Container exited with status: 0")
+		require.Contains(t, writer.String(), "a process in the container was killed due to out-of-memory")
+	})
+}
+
+// TestContainerInspectWithMock tests Container.Inspect using a mock Docker client
+func TestContainerInspectWithMock(t *testing.T) {
+	create := func(t *testing.T, inspect func(ctx context.Context, containerID string, options client.ContainerInspectOptions) (client.ContainerInspectResult, error)) docker.Container {
+		t.Helper()
+
+		mock := &mockDockerClient{
+			containerCreateFunc: func(ctx context.Context, options client.ContainerCreateOptions) (client.ContainerCreateResult, error) {
+				return client.ContainerCreateResult{ID: "container123"}, nil
+			},
+			containerInspectFunc: inspect,
+		}
+
+		container, err := docker.NewClient(mock).CreateContainer(context.Background(), createTestContainerOpts())
+		require.NoError(t, err)
+		return container.(docker.Container)
+	}
+
+	t.Run("returns the state of the container", func(t *testing.T) {
+		var inspected string
+		container := create(t, func(ctx context.Context, containerID string, options client.ContainerInspectOptions) (client.ContainerInspectResult, error) {
+			inspected = containerID
+			return client.ContainerInspectResult{Container: containertypes.InspectResponse{
+				State: &containertypes.State{
+					Status:     containertypes.StateExited,
+					ExitCode:   137,
+					OOMKilled:  true,
+					StartedAt:  "2026-10-16T09:30:00.123456789Z",
+					FinishedAt: "2026-10-16T09:45:30Z",
+				},
+			}}, nil
+		})
+
+		state, err := container.Inspect(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, "container123", inspected)
+		require.Equal(t, docker.State{
+			Running:    false,
+			Restarting: false,
+			ExitCode:   137,
+			StartedAt:  time.Date(2026, time.October, 16, 9, 30, 0, 123456789, time.UTC),
+			FinishedAt: time.Date(2026, time.October, 16, 9, 45, 30, 0, time.UTC),
+			OOMKilled:  true,
+		}, state)
+	})
+
+	t.Run("returns zero times for a container that has not run", func(t *testing.T) {
+		container := create(t, func(ctx context.Context, containerID string, options client.ContainerInspectOptions) (client.ContainerInspectResult, error) {
+			return client.ContainerInspectResult{Container: containertypes.InspectResponse{
+				State: &containertypes.State{
+					Status:     containertypes.StateCreated,
+					StartedAt:  "0001-01-01T00:00:00Z",
+					FinishedAt: "",
+				},
+			}}, nil
+		})
+
+		state, err := container.Inspect(context.Background())
+		require.NoError(t, err)
+		require.True(t, state.StartedAt.IsZero())
+		require.True(t, state.FinishedAt.IsZero())
+	})
+
+	t.Run("fails when ContainerInspect returns error", func(t *testing.T) {
+		container := create(t, func(ctx context.Context, containerID string, options client.ContainerInspectOptions) (client.ContainerInspectResult, error) {
+			return client.ContainerInspectResult{}, errors.New("no such container")
+		})
+
+		_, err := container.Inspect(context.Background())
+		require.ErrorContains(t, err, `failed to inspect container "test": no such container`)
+	})
+
+	t.Run("fails when the daemon reports no state", func(t *testing.T) {
+		container := create(t, func(ctx context.Context, containerID string, options client.ContainerInspectOptions) (client.ContainerInspectResult, error) {
+			return client.ContainerInspectResult{}, nil
+		})
+
+		_, err := container.Inspect(context.Background())
+		require.ErrorContains(t, err, "the daemon reported no state")
+	})
+
+	t.Run("fails when a time is malformed", func(t *testing.T) {
+		container := create(t, func(ctx context.Context, containerID string, options client.ContainerInspectOptions) (client.ContainerInspectResult, error) {
+			return client.ContainerInspectResult{Container: containertypes.InspectResponse{
+				State: &containertypes.State{StartedAt: "yesterday"},
+			}}, nil
+		})
+
+		_, err := container.Inspect(context.Background())
+		require.ErrorContains(t, err, "invalid start time")
+	})
 }
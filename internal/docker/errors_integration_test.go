@@ -28,7 +28,7 @@ func TestDockerErrorCases(t *testing.T) {
 			writer := newMockWriter()
 			ctx := context.Background()
 
-			_, err := client.BuildImage(ctx, "/nonexistent/path/Dockerfile", "test:latest", writer)
+			_, err := client.BuildImage(ctx, runtime.BuildImageOptions{DockerfilePath: "/nonexistent/path/Dockerfile", ImageName: "test:latest"}, writer)
 			require.Error(t, err)
 			require.Contains(t, err.Error(), "failed to read Dockerfile")
 		})
@@ -37,7 +37,7 @@ func TestDockerErrorCases(t *testing.T) {
 			writer := newMockWriter()
 			ctx := context.Background()
 
-			_, err := client.BuildImage(ctx, "/path/that/does/not/exist/Dockerfile", "test:latest", writer)
+			_, err := client.BuildImage(ctx, runtime.BuildImageOptions{DockerfilePath: "/path/that/does/not/exist/Dockerfile", ImageName: "test:latest"}, writer)
 			require.Error(t, err)
 			require.Contains(t, err.Error(), "failed to read Dockerfile")
 		})
@@ -54,7 +54,7 @@ func TestDockerErrorCases(t *testing.T) {
 			writer := newMockWriter()
 			ctx := context.Background()
 
-			_, err = client.BuildImage(ctx, dockerfilePath, "test:latest", writer)
+			_, err = client.BuildImage(ctx, runtime.BuildImageOptions{DockerfilePath: dockerfilePath, ImageName: "test:latest"}, writer)
 			require.Error(t, err)
 		})
 
@@ -70,7 +70,7 @@ func TestDockerErrorCases(t *testing.T) {
 			writer := newMockWriter()
 			ctx := context.Background()
 
-			_, err = client.BuildImage(ctx, dockerfilePath, "test:latest", writer)
+			_, err = client.BuildImage(ctx, runtime.BuildImageOptions{DockerfilePath: dockerfilePath, ImageName: "test:latest"}, writer)
 			require.Error(t, err)
 		})
 
@@ -86,7 +86,7 @@ func TestDockerErrorCases(t *testing.T) {
 			writer := newMockWriter()
 			ctx := context.Background()
 
-			_, err = client.BuildImage(ctx, dockerfilePath, "INVALID_IMAGE_NAME:@#$", writer)
+			_, err = client.BuildImage(ctx, runtime.BuildImageOptions{DockerfilePath: dockerfilePath, ImageName: "INVALID_IMAGE_NAME:@#$"}, writer)
 			require.Error(t, err)
 		})
 
@@ -106,7 +106,7 @@ func TestDockerErrorCases(t *testing.T) {
 			writer := newMockWriter()
 			ctx := context.Background()
 
-			_, err = client.BuildImage(ctx, dockerfilePath, "test:latest", writer)
+			_, err = client.BuildImage(ctx, runtime.BuildImageOptions{DockerfilePath: dockerfilePath, ImageName: "test:latest"}, writer)
 			require.Error(t, err)
 			require.Contains(t, err.Error(), "failed to read Dockerfile")
 		})
@@ -124,7 +124,7 @@ func TestDockerErrorCases(t *testing.T) {
 			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
 			defer cancel()
 
-			_, err = client.BuildImage(ctx, dockerfilePath, "test-cancel:latest", writer)
+			_, err = client.BuildImage(ctx, runtime.BuildImageOptions{DockerfilePath: dockerfilePath, ImageName: "test-cancel:latest"}, writer)
 			require.Error(t, err)
 			require.True(t, err == context.DeadlineExceeded || err == context.Canceled ||
 				(err != nil && (err.Error() != "")),
@@ -313,7 +313,7 @@ func TestDockerErrorCases(t *testing.T) {
 			require.NoError(t, err)
 
 			writer := newMockWriter()
-			err = container.Attach(ctx, writer)
+			_, err = container.Attach(ctx, func() {}, writer)
 			require.Error(t, err)
 		})
 
@@ -327,7 +327,7 @@ func TestDockerErrorCases(t *testing.T) {
 			require.NoError(t, err)
 
 			writer := newMockWriter()
-			err = container.Wait(ctx, writer)
+			_, err = container.Wait(ctx, writer)
 			require.Error(t, err)
 		})
 
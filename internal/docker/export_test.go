@@ -0,0 +1,36 @@
+package docker
+
+import (
+	"context"
+	"io"
+
+	"github.com/docker/cli/cli/streams"
+	"github.com/ryanmoran/contagent/internal"
+	"github.com/ryanmoran/contagent/internal/runtime"
+)
+
+// TerminalInput exposes terminalInput for testing.
+type TerminalInput = terminalInput
+
+// TerminalOutput exposes terminalOutput for testing.
+type TerminalOutput = terminalOutput
+
+// AttachWithInput attaches like Attach, reading from in instead of the process's stdin
+// and discarding output, for testing.
+func (c Container) AttachWithInput(ctx context.Context, cancel context.CancelFunc, in TerminalInput, w internal.Writer) (runtime.Attachment, error) {
+	return c.attach(ctx, cancel, in, streams.NewOut(io.Discard), io.Discard, w)
+}
+
+// AttachWithTerminal attaches like Attach, using in and out instead of the process's
+// stdin and stdout, for testing.
+func (c Container) AttachWithTerminal(ctx context.Context, cancel context.CancelFunc, in TerminalInput, out TerminalOutput, w internal.Writer) (runtime.Attachment, error) {
+	return c.attach(ctx, cancel, in, out, io.Discard, w)
+}
+
+// Backoff exposes backoff for testing.
+var Backoff = backoff
+
+// SetJitter replaces the source of retry jitter for testing. A nil jitter disables it.
+func (t *TTY) SetJitter(jitter func() float64) {
+	t.jitter = jitter
+}
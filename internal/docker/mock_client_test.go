@@ -4,26 +4,43 @@ import (
 	"context"
 	"errors"
 	"io"
+	"iter"
+	"net"
+	"strings"
 
 	containertypes "github.com/moby/moby/api/types/container"
+	"github.com/moby/moby/api/types/jsonstream"
 	"github.com/moby/moby/client"
 )
 
 // mockDockerClient is a mock implementation of docker.DockerClient for testing
 type mockDockerClient struct {
 	imageBuildFunc        func(ctx context.Context, buildContext io.Reader, options client.ImageBuildOptions) (client.ImageBuildResult, error)
+	imageInspectFunc      func(ctx context.Context, imageID string, inspectOpts ...client.ImageInspectOption) (client.ImageInspectResult, error)
+	imageTagFunc          func(ctx context.Context, options client.ImageTagOptions) (client.ImageTagResult, error)
+	imagePullFunc         func(ctx context.Context, refStr string, options client.ImagePullOptions) (client.ImagePullResponse, error)
+	imageRemoveFunc       func(ctx context.Context, imageID string, options client.ImageRemoveOptions) (client.ImageRemoveResult, error)
 	containerInspectFunc  func(ctx context.Context, containerID string, options client.ContainerInspectOptions) (client.ContainerInspectResult, error)
 	containerCreateFunc   func(ctx context.Context, options client.ContainerCreateOptions) (client.ContainerCreateResult, error)
 	copyFromContainerFunc func(ctx context.Context, containerID string, options client.CopyFromContainerOptions) (client.CopyFromContainerResult, error)
 	containerStartFunc    func(ctx context.Context, containerID string, options client.ContainerStartOptions) (client.ContainerStartResult, error)
 	containerAttachFunc   func(ctx context.Context, containerID string, options client.ContainerAttachOptions) (client.ContainerAttachResult, error)
+	containerLogsFunc     func(ctx context.Context, containerID string, options client.ContainerLogsOptions) (client.ContainerLogsResult, error)
 	containerWaitFunc     func(ctx context.Context, containerID string, options client.ContainerWaitOptions) client.ContainerWaitResult
 	containerStopFunc     func(ctx context.Context, containerID string, options client.ContainerStopOptions) (client.ContainerStopResult, error)
+	containerKillFunc     func(ctx context.Context, containerID string, options client.ContainerKillOptions) (client.ContainerKillResult, error)
 	containerRemoveFunc   func(ctx context.Context, containerID string, options client.ContainerRemoveOptions) (client.ContainerRemoveResult, error)
 	containerResizeFunc   func(ctx context.Context, containerID string, options client.ContainerResizeOptions) (client.ContainerResizeResult, error)
+	execCreateFunc        func(ctx context.Context, containerID string, options client.ExecCreateOptions) (client.ExecCreateResult, error)
+	execAttachFunc        func(ctx context.Context, execID string, options client.ExecAttachOptions) (client.ExecAttachResult, error)
+	execInspectFunc       func(ctx context.Context, execID string, options client.ExecInspectOptions) (client.ExecInspectResult, error)
 	copyToContainerFunc   func(ctx context.Context, containerID string, options client.CopyToContainerOptions) (client.CopyToContainerResult, error)
 	pingFunc              func(ctx context.Context, options client.PingOptions) (client.PingResult, error)
+	dialHijackFunc        func(ctx context.Context, url, proto string, meta map[string][]string) (net.Conn, error)
 	containerListFunc     func(ctx context.Context, options client.ContainerListOptions) (client.ContainerListResult, error)
+	networkListFunc       func(ctx context.Context, options client.NetworkListOptions) (client.NetworkListResult, error)
+	networkCreateFunc     func(ctx context.Context, name string, options client.NetworkCreateOptions) (client.NetworkCreateResult, error)
+	networkRemoveFunc     func(ctx context.Context, networkID string, options client.NetworkRemoveOptions) (client.NetworkRemoveResult, error)
 	closeFunc             func() error
 }
 
@@ -34,6 +51,34 @@ func (m *mockDockerClient) ImageBuild(ctx context.Context, buildContext io.Reade
 	return client.ImageBuildResult{}, errors.New("not implemented")
 }
 
+func (m *mockDockerClient) ImageInspect(ctx context.Context, imageID string, inspectOpts ...client.ImageInspectOption) (client.ImageInspectResult, error) {
+	if m.imageInspectFunc != nil {
+		return m.imageInspectFunc(ctx, imageID, inspectOpts...)
+	}
+	return client.ImageInspectResult{}, errors.New("not implemented")
+}
+
+func (m *mockDockerClient) ImageTag(ctx context.Context, options client.ImageTagOptions) (client.ImageTagResult, error) {
+	if m.imageTagFunc != nil {
+		return m.imageTagFunc(ctx, options)
+	}
+	return client.ImageTagResult{}, errors.New("not implemented")
+}
+
+func (m *mockDockerClient) ImagePull(ctx context.Context, refStr string, options client.ImagePullOptions) (client.ImagePullResponse, error) {
+	if m.imagePullFunc != nil {
+		return m.imagePullFunc(ctx, refStr, options)
+	}
+	return nil, errors.New("not implemented")
+}
+
+func (m *mockDockerClient) ImageRemove(ctx context.Context, imageID string, options client.ImageRemoveOptions) (client.ImageRemoveResult, error) {
+	if m.imageRemoveFunc != nil {
+		return m.imageRemoveFunc(ctx, imageID, options)
+	}
+	return client.ImageRemoveResult{}, errors.New("not implemented")
+}
+
 func (m *mockDockerClient) ContainerInspect(ctx context.Context, containerID string, options client.ContainerInspectOptions) (client.ContainerInspectResult, error) {
 	if m.containerInspectFunc != nil {
 		return m.containerInspectFunc(ctx, containerID, options)
@@ -62,6 +107,13 @@ func (m *mockDockerClient) ContainerAttach(ctx context.Context, containerID stri
 	return client.ContainerAttachResult{}, errors.New("not implemented")
 }
 
+func (m *mockDockerClient) ContainerLogs(ctx context.Context, containerID string, options client.ContainerLogsOptions) (client.ContainerLogsResult, error) {
+	if m.containerLogsFunc != nil {
+		return m.containerLogsFunc(ctx, containerID, options)
+	}
+	return nil, errors.New("not implemented")
+}
+
 func (m *mockDockerClient) ContainerWait(ctx context.Context, containerID string, options client.ContainerWaitOptions) client.ContainerWaitResult {
 	if m.containerWaitFunc != nil {
 		return m.containerWaitFunc(ctx, containerID, options)
@@ -79,6 +131,13 @@ func (m *mockDockerClient) ContainerStop(ctx context.Context, containerID string
 	return client.ContainerStopResult{}, errors.New("not implemented")
 }
 
+func (m *mockDockerClient) ContainerKill(ctx context.Context, containerID string, options client.ContainerKillOptions) (client.ContainerKillResult, error) {
+	if m.containerKillFunc != nil {
+		return m.containerKillFunc(ctx, containerID, options)
+	}
+	return client.ContainerKillResult{}, errors.New("not implemented")
+}
+
 func (m *mockDockerClient) ContainerRemove(ctx context.Context, containerID string, options client.ContainerRemoveOptions) (client.ContainerRemoveResult, error) {
 	if m.containerRemoveFunc != nil {
 		return m.containerRemoveFunc(ctx, containerID, options)
@@ -107,6 +166,27 @@ func (m *mockDockerClient) CopyFromContainer(ctx context.Context, containerID st
 	return client.CopyFromContainerResult{}, errors.New("not implemented")
 }
 
+func (m *mockDockerClient) ExecCreate(ctx context.Context, containerID string, options client.ExecCreateOptions) (client.ExecCreateResult, error) {
+	if m.execCreateFunc != nil {
+		return m.execCreateFunc(ctx, containerID, options)
+	}
+	return client.ExecCreateResult{}, errors.New("not implemented")
+}
+
+func (m *mockDockerClient) ExecAttach(ctx context.Context, execID string, options client.ExecAttachOptions) (client.ExecAttachResult, error) {
+	if m.execAttachFunc != nil {
+		return m.execAttachFunc(ctx, execID, options)
+	}
+	return client.ExecAttachResult{}, errors.New("not implemented")
+}
+
+func (m *mockDockerClient) ExecInspect(ctx context.Context, execID string, options client.ExecInspectOptions) (client.ExecInspectResult, error) {
+	if m.execInspectFunc != nil {
+		return m.execInspectFunc(ctx, execID, options)
+	}
+	return client.ExecInspectResult{}, errors.New("not implemented")
+}
+
 func (m *mockDockerClient) Ping(ctx context.Context, options client.PingOptions) (client.PingResult, error) {
 	if m.pingFunc != nil {
 		return m.pingFunc(ctx, options)
@@ -114,6 +194,13 @@ func (m *mockDockerClient) Ping(ctx context.Context, options client.PingOptions)
 	return client.PingResult{}, errors.New("not implemented")
 }
 
+func (m *mockDockerClient) DialHijack(ctx context.Context, url, proto string, meta map[string][]string) (net.Conn, error) {
+	if m.dialHijackFunc != nil {
+		return m.dialHijackFunc(ctx, url, proto, meta)
+	}
+	return nil, errors.New("not implemented")
+}
+
 func (m *mockDockerClient) ContainerList(ctx context.Context, options client.ContainerListOptions) (client.ContainerListResult, error) {
 	if m.containerListFunc != nil {
 		return m.containerListFunc(ctx, options)
@@ -121,9 +208,47 @@ func (m *mockDockerClient) ContainerList(ctx context.Context, options client.Con
 	return client.ContainerListResult{}, errors.New("not implemented")
 }
 
+func (m *mockDockerClient) NetworkList(ctx context.Context, options client.NetworkListOptions) (client.NetworkListResult, error) {
+	if m.networkListFunc != nil {
+		return m.networkListFunc(ctx, options)
+	}
+	return client.NetworkListResult{}, errors.New("not implemented")
+}
+
+func (m *mockDockerClient) NetworkCreate(ctx context.Context, name string, options client.NetworkCreateOptions) (client.NetworkCreateResult, error) {
+	if m.networkCreateFunc != nil {
+		return m.networkCreateFunc(ctx, name, options)
+	}
+	return client.NetworkCreateResult{}, errors.New("not implemented")
+}
+
+func (m *mockDockerClient) NetworkRemove(ctx context.Context, networkID string, options client.NetworkRemoveOptions) (client.NetworkRemoveResult, error) {
+	if m.networkRemoveFunc != nil {
+		return m.networkRemoveFunc(ctx, networkID, options)
+	}
+	return client.NetworkRemoveResult{}, errors.New("not implemented")
+}
+
 func (m *mockDockerClient) Close() error {
 	if m.closeFunc != nil {
 		return m.closeFunc()
 	}
 	return nil
 }
+
+// mockPullResponse is a client.ImagePullResponse that replays canned pull output
+type mockPullResponse struct {
+	io.ReadCloser
+}
+
+func newMockPullResponse(output string) client.ImagePullResponse {
+	return mockPullResponse{ReadCloser: io.NopCloser(strings.NewReader(output))}
+}
+
+func (m mockPullResponse) JSONMessages(ctx context.Context) iter.Seq2[jsonstream.Message, error] {
+	return func(yield func(jsonstream.Message, error) bool) {}
+}
+
+func (m mockPullResponse) Wait(ctx context.Context) error {
+	return nil
+}
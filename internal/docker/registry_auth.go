@@ -0,0 +1,100 @@
+package docker
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/docker/cli/cli/config"
+	"github.com/moby/moby/api/types/registry"
+)
+
+// registryAuths holds registry credentials keyed by registry address, as found in
+// the auths section of a Docker config.json.
+type registryAuths map[string]registry.AuthConfig
+
+// loadRegistryAuths reads the credentials for every registry from the config.json in
+// dir, including those kept by credential helpers. Returns no credentials when dir is
+// empty or holds no config.json, and an error if the file is malformed or a credential
+// helper fails.
+func loadRegistryAuths(dir string) (registryAuths, error) {
+	if dir == "" {
+		return nil, nil
+	}
+
+	file, err := config.Load(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Docker config in %s: %w", dir, err)
+	}
+
+	credentials, err := file.GetAllCredentials()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read registry credentials from %s: %w\nCheck the credential helper configured in config.json", file.Filename, err)
+	}
+
+	auths := make(registryAuths, len(credentials))
+	for address, credential := range credentials {
+		auths[address] = registry.AuthConfig{
+			Username:      credential.Username,
+			Password:      credential.Password,
+			Auth:          credential.Auth,
+			ServerAddress: credential.ServerAddress,
+			IdentityToken: credential.IdentityToken,
+			RegistryToken: credential.RegistryToken,
+		}
+	}
+
+	return auths, nil
+}
+
+// encode returns the credentials for the registry that hosts ref, encoded for the
+// X-Registry-Auth header of a pull. Returns an empty string when there are none.
+func (a registryAuths) encode(ref string) string {
+	host := imageRegistry(ref)
+	for address, auth := range a {
+		if addressHost(address) != host {
+			continue
+		}
+
+		content, err := json.Marshal(auth)
+		if err != nil {
+			return ""
+		}
+		return base64.URLEncoding.EncodeToString(content)
+	}
+
+	return ""
+}
+
+// imageRegistry returns the hostname of the registry that hosts an image reference
+// such as "alpine:3.20" or "registry.example.com:5000/team/app", using "docker.io"
+// for Docker Hub.
+func imageRegistry(ref string) string {
+	host, _, found := strings.Cut(ref, "/")
+
+	// The first component is only a registry when it looks like a hostname, otherwise
+	// it is a Docker Hub namespace such as "library"
+	if !found || (!strings.ContainsAny(host, ".:") && host != "localhost") {
+		return "docker.io"
+	}
+	return canonicalHost(host)
+}
+
+// addressHost returns the hostname of a registry address as used for the keys of
+// the auths section of a Docker config.json, such as "https://index.docker.io/v1/"
+// or "registry.example.com".
+func addressHost(address string) string {
+	address = strings.TrimPrefix(strings.TrimPrefix(address, "https://"), "http://")
+	host, _, _ := strings.Cut(address, "/")
+	return canonicalHost(host)
+}
+
+// canonicalHost maps the alternative hostnames of Docker Hub to "docker.io".
+func canonicalHost(host string) string {
+	switch host {
+	case "index.docker.io", "registry-1.docker.io":
+		return "docker.io"
+	}
+	return host
+}
@@ -18,6 +18,10 @@ func (m *mockWriter) Printf(format string, v ...interface{}) {
 	m.buf.WriteString(sprintf(format, v...))
 }
 func (m *mockWriter) Println(v ...interface{}) { m.buf.WriteString(sprintln(v...)) }
+func (m *mockWriter) Debug(v ...interface{})   { m.buf.WriteString("Debug: " + sprintln(v...)) }
+func (m *mockWriter) Debugf(format string, v ...interface{}) {
+	m.buf.WriteString("Debug: " + sprintf(format, v...) + "\n")
+}
 func (m *mockWriter) Warning(v ...interface{}) { m.buf.WriteString("Warning: " + sprintln(v...)) }
 func (m *mockWriter) Warningf(format string, v ...interface{}) {
 	m.buf.WriteString("Warning: " + sprintf(format, v...) + "\n")
@@ -64,6 +68,8 @@ func toString(v interface{}) string {
 		return itoa64(val)
 	case error:
 		return val.Error()
+	case interface{ String() string }:
+		return val.String()
 	default:
 		return ""
 	}
@@ -2,56 +2,89 @@ package docker
 
 import (
 	"context"
+	"math/rand/v2"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
-	"github.com/docker/cli/cli/streams"
 	"github.com/moby/moby/client"
 	"github.com/ryanmoran/contagent/internal"
 )
 
+// maxRetryDelay caps the delay between resize retries, however many retries are made.
+const maxRetryDelay = time.Second
+
+// TerminalSizer reports the size of the local terminal, which *streams.Out implements.
+type TerminalSizer interface {
+	GetTtySize() (uint, uint)
+}
+
 type TTY struct {
 	client     DockerClient
-	out        *streams.Out
+	out        TerminalSizer
 	id         string
 	maxRetries int
 	retryDelay time.Duration
+	resync     time.Duration
+	jitter     func() float64
 	writer     internal.Writer
 	cancel     context.CancelFunc
+	last       *ttySize
+	stopped    chan struct{}
+}
+
+// ttySize is the size the container's TTY was last resized to, shared by the copies
+// of a TTY so that unchanged sizes are not sent again.
+type ttySize struct {
+	mu     sync.Mutex
+	height uint
+	width  uint
 }
 
 // NewTTY creates a TTY handler for monitoring and resizing the container's terminal.
 // The maxRetries parameter controls how many times to retry initial resize operations,
-// and retryDelay specifies the base delay between retries. The cancel function is called
-// if retries are exhausted to signal context cancellation instead of calling Fatal.
-func NewTTY(client DockerClient, out *streams.Out, id string, maxRetries int, retryDelay time.Duration, writer internal.Writer, cancel context.CancelFunc) TTY {
+// and retryDelay specifies the base delay between retries, which doubles with every retry
+// up to a cap and is randomized so that containers started together do not retry in
+// lockstep. When resync is positive, the
+// terminal size is also checked at that interval, for terminals that do not reliably
+// deliver SIGWINCH. The cancel function is called if retries are exhausted to signal
+// context cancellation instead of calling Fatal.
+func NewTTY(client DockerClient, out TerminalSizer, id string, maxRetries int, retryDelay, resync time.Duration, writer internal.Writer, cancel context.CancelFunc) TTY {
 	return TTY{
 		client:     client,
 		out:        out,
 		id:         id,
 		maxRetries: maxRetries,
 		retryDelay: retryDelay,
+		resync:     resync,
+		jitter:     rand.Float64,
 		writer:     writer,
 		cancel:     cancel,
+		last:       &ttySize{},
+		stopped:    make(chan struct{}),
 	}
 }
 
-// Monitor monitors the terminal for resize events (SIGWINCH) and automatically resizes
-// the container's TTY to match. If the initial resize fails, it retries with exponential
-// backoff up to the configured maximum retries. Returns nil after starting background
-// monitoring goroutines, or an error if the context is cancelled during setup.
+// Monitor monitors the terminal for resize events (SIGWINCH), and at the resync interval
+// when one is configured, and automatically resizes the container's TTY to match. If the
+// initial resize fails, it retries with exponential backoff and jitter up to the configured
+// maximum retries. Monitoring stops, and SIGWINCH is no longer relayed, once ctx is done;
+// Done reports when that has happened. Returns nil after starting background monitoring
+// goroutines, or an error if the context is cancelled during setup.
 func (t TTY) Monitor(ctx context.Context, cancel context.CancelFunc) error {
+	var wg sync.WaitGroup
+
 	err := t.Resize(ctx)
 	if err != nil {
-		go func() {
+		wg.Go(func() {
 			var err error
 			for retry := range t.maxRetries {
 				select {
 				case <-ctx.Done():
 					return
-				case <-time.After(time.Duration(retry+1) * t.retryDelay):
+				case <-time.After(backoff(retry, t.retryDelay, maxRetryDelay, t.jitter)):
 					if err = t.Resize(ctx); err == nil {
 						return
 					}
@@ -61,31 +94,74 @@ func (t TTY) Monitor(ctx context.Context, cancel context.CancelFunc) error {
 				t.writer.Warningf("failed to resize tty: %v", err)
 				cancel()
 			}
-		}()
+		})
 	}
 
 	sigchan := make(chan os.Signal, 1)
 	signal.Notify(sigchan, syscall.SIGWINCH)
-	go func() {
+	wg.Go(func() {
 		defer signal.Stop(sigchan)
+
+		// A nil channel never fires, so the size is only checked on signals without resync
+		var resync <-chan time.Time
+		if t.resync > 0 {
+			ticker := time.NewTicker(t.resync)
+			defer ticker.Stop()
+			resync = ticker.C
+		}
+
 		for {
 			select {
 			case <-ctx.Done():
 				return
 			case <-sigchan:
-				if err := t.Resize(ctx); err != nil {
-					t.writer.Warningf("failed to resize terminal: %v", err)
-				}
+			case <-resync:
+			}
+
+			if err := t.Resize(ctx); err != nil {
+				t.writer.Warningf("failed to resize terminal: %v", err)
 			}
 		}
+	})
+
+	go func() {
+		wg.Wait()
+		close(t.stopped)
 	}()
 
 	return nil
 }
 
+// Done returns a channel that is closed once the goroutines started by Monitor have
+// stopped. It is never closed if Monitor was not called.
+func (t TTY) Done() <-chan struct{} {
+	return t.stopped
+}
+
+// backoff returns the delay before the given retry, counting from zero: base doubled for
+// every earlier retry and capped at limit. When jitter is not nil, the delay is reduced by
+// up to half, scaled by the value in [0, 1) that jitter returns.
+func backoff(retry int, base, limit time.Duration, jitter func() float64) time.Duration {
+	delay := base
+	for range retry {
+		if delay >= limit {
+			break
+		}
+		delay *= 2
+	}
+	delay = min(delay, limit)
+
+	if jitter != nil {
+		delay -= time.Duration(jitter() * float64(delay/2))
+	}
+
+	return delay
+}
+
 // Resize resizes the container's TTY to match the current terminal dimensions.
-// Returns nil if the terminal has zero size (no resize needed) or if the resize succeeds.
-// Returns an error if the Docker API call fails.
+// Returns nil if the terminal has zero size or the size is unchanged since the last
+// resize (no resize needed), or if the resize succeeds. Returns an error if the Docker
+// API call fails.
 func (t TTY) Resize(ctx context.Context) error {
 	height, width := t.out.GetTtySize()
 
@@ -93,6 +169,13 @@ func (t TTY) Resize(ctx context.Context) error {
 		return nil
 	}
 
+	t.last.mu.Lock()
+	defer t.last.mu.Unlock()
+
+	if height == t.last.height && width == t.last.width {
+		return nil
+	}
+
 	_, err := t.client.ContainerResize(ctx, t.id, client.ContainerResizeOptions{
 		Height: height,
 		Width:  width,
@@ -100,6 +183,7 @@ func (t TTY) Resize(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
+	t.last.height, t.last.width = height, width
 
 	return nil
 }
@@ -3,6 +3,9 @@ package docker_test
 import (
 	"context"
 	"errors"
+	"math/rand/v2"
+	"net"
+	"sync"
 	"testing"
 	"time"
 
@@ -29,7 +32,7 @@ func TestTTYResizeWithMock(t *testing.T) {
 		out := streams.NewOut(nil)
 		writer := newMockWriter()
 
-		tty := docker.NewTTY(mock, out, "container123", 5, 100*time.Millisecond, writer, func() {})
+		tty := docker.NewTTY(mock, out, "container123", 5, 100*time.Millisecond, 0, writer, func() {})
 		ctx := context.Background()
 
 		// Resize will return nil if height and width are 0 (terminal not detected)
@@ -51,13 +54,54 @@ func TestTTYResizeWithMock(t *testing.T) {
 		out := streams.NewOut(nil)
 		writer := newMockWriter()
 
-		tty := docker.NewTTY(mock, out, "container123", 5, 100*time.Millisecond, writer, func() {})
+		tty := docker.NewTTY(mock, out, "container123", 5, 100*time.Millisecond, 0, writer, func() {})
 		ctx := context.Background()
 
 		// In test environment, this will return nil because TTY size is 0x0
 		err := tty.Resize(ctx)
 		require.NoError(t, err)
 	})
+
+	t.Run("skips resizing when the size is unchanged", func(t *testing.T) {
+		var resizes []client.ContainerResizeOptions
+		mock := &mockDockerClient{
+			containerResizeFunc: func(ctx context.Context, containerID string, options client.ContainerResizeOptions) (client.ContainerResizeResult, error) {
+				resizes = append(resizes, options)
+				return client.ContainerResizeResult{}, nil
+			},
+		}
+
+		out := &fakeTerminalSize{height: 24, width: 80}
+		tty := docker.NewTTY(mock, out, "container123", 5, 100*time.Millisecond, 0, newMockWriter(), func() {})
+
+		require.NoError(t, tty.Resize(context.Background()))
+		require.NoError(t, tty.Resize(context.Background()))
+		require.Equal(t, []client.ContainerResizeOptions{{Height: 24, Width: 80}}, resizes)
+
+		out.set(30, 100)
+		require.NoError(t, tty.Resize(context.Background()))
+		require.Len(t, resizes, 2)
+	})
+
+	t.Run("retries the same size after a failed resize", func(t *testing.T) {
+		attempts := 0
+		mock := &mockDockerClient{
+			containerResizeFunc: func(ctx context.Context, containerID string, options client.ContainerResizeOptions) (client.ContainerResizeResult, error) {
+				attempts++
+				if attempts == 1 {
+					return client.ContainerResizeResult{}, errors.New("not ready")
+				}
+				return client.ContainerResizeResult{}, nil
+			},
+		}
+
+		out := &fakeTerminalSize{height: 24, width: 80}
+		tty := docker.NewTTY(mock, out, "container123", 5, 100*time.Millisecond, 0, newMockWriter(), func() {})
+
+		require.ErrorContains(t, tty.Resize(context.Background()), "not ready")
+		require.NoError(t, tty.Resize(context.Background()))
+		require.Equal(t, 2, attempts)
+	})
 }
 
 // TestTTYMonitorWithMock tests TTY.Monitor using a mock Docker client
@@ -72,7 +116,7 @@ func TestTTYMonitorWithMock(t *testing.T) {
 		out := streams.NewOut(nil)
 		writer := newMockWriter()
 
-		tty := docker.NewTTY(mock, out, "container123", 5, 10*time.Millisecond, writer, func() {})
+		tty := docker.NewTTY(mock, out, "container123", 5, 10*time.Millisecond, 0, writer, func() {})
 		ctx := context.Background()
 
 		// Monitor starts a goroutine and returns immediately
@@ -98,7 +142,7 @@ func TestTTYMonitorWithMock(t *testing.T) {
 		out := streams.NewOut(nil)
 		writer := newMockWriter()
 
-		tty := docker.NewTTY(mock, out, "container123", 5, 10*time.Millisecond, writer, func() {})
+		tty := docker.NewTTY(mock, out, "container123", 5, 10*time.Millisecond, 0, writer, func() {})
 		ctx := context.Background()
 
 		err := tty.Monitor(ctx, func() {})
@@ -121,7 +165,7 @@ func TestTTYMonitorWithMock(t *testing.T) {
 		writer := newMockWriter()
 
 		maxRetries := 3
-		tty := docker.NewTTY(mock, out, "container123", maxRetries, 10*time.Millisecond, writer, func() {})
+		tty := docker.NewTTY(mock, out, "container123", maxRetries, 10*time.Millisecond, 0, writer, func() {})
 		ctx := context.Background()
 
 		err := tty.Monitor(ctx, func() {})
@@ -133,6 +177,153 @@ func TestTTYMonitorWithMock(t *testing.T) {
 		// The writer should have a fatal error message
 		// In test environment with 0x0 TTY, this won't be called
 	})
+
+	t.Run("stops once the context is done", func(t *testing.T) {
+		mock := &mockDockerClient{}
+		tty := docker.NewTTY(mock, streams.NewOut(nil), "container123", 5, 10*time.Millisecond, 10*time.Millisecond, newMockWriter(), func() {})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		require.NoError(t, tty.Monitor(ctx, func() {}))
+
+		select {
+		case <-tty.Done():
+			t.Fatal("monitoring stopped while the context was live")
+		case <-time.After(20 * time.Millisecond):
+		}
+
+		cancel()
+		select {
+		case <-tty.Done():
+		case <-time.After(5 * time.Second):
+			t.Fatal("monitoring did not stop after the context was done")
+		}
+	})
+}
+
+// fakeTerminalSize stands in for a terminal whose size can change without a signal.
+type fakeTerminalSize struct {
+	mu     sync.Mutex
+	height uint
+	width  uint
+}
+
+func (f *fakeTerminalSize) GetTtySize() (uint, uint) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.height, f.width
+}
+
+func (f *fakeTerminalSize) set(height, width uint) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.height, f.width = height, width
+}
+
+// TestTTYResyncWithMock tests the periodic TTY resize
+func TestTTYResyncWithMock(t *testing.T) {
+	var mu sync.Mutex
+	var resizes []client.ContainerResizeOptions
+	mock := &mockDockerClient{
+		containerResizeFunc: func(ctx context.Context, containerID string, options client.ContainerResizeOptions) (client.ContainerResizeResult, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			resizes = append(resizes, options)
+			return client.ContainerResizeResult{}, nil
+		},
+	}
+	calls := func() []client.ContainerResizeOptions {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]client.ContainerResizeOptions{}, resizes...)
+	}
+
+	out := &fakeTerminalSize{height: 24, width: 80}
+	tty := docker.NewTTY(mock, out, "container123", 5, 10*time.Millisecond, 10*time.Millisecond, newMockWriter(), func() {})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	require.NoError(t, tty.Monitor(ctx, cancel))
+	require.Equal(t, []client.ContainerResizeOptions{{Height: 24, Width: 80}}, calls())
+
+	// An unchanged size is not sent again
+	time.Sleep(50 * time.Millisecond)
+	require.Len(t, calls(), 1)
+
+	out.set(30, 100)
+	require.Eventually(t, func() bool {
+		return len(calls()) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, client.ContainerResizeOptions{Height: 30, Width: 100}, calls()[1])
+}
+
+// TestTTYBackoff tests the delay between TTY resize retries
+func TestTTYBackoff(t *testing.T) {
+	t.Run("doubles the delay up to the limit", func(t *testing.T) {
+		var delays []time.Duration
+		for retry := range 10 {
+			delays = append(delays, docker.Backoff(retry, 10*time.Millisecond, time.Second, nil))
+		}
+
+		require.Equal(t, []time.Duration{
+			10 * time.Millisecond,
+			20 * time.Millisecond,
+			40 * time.Millisecond,
+			80 * time.Millisecond,
+			160 * time.Millisecond,
+			320 * time.Millisecond,
+			640 * time.Millisecond,
+			time.Second,
+			time.Second,
+			time.Second,
+		}, delays)
+	})
+
+	t.Run("reduces the delay by up to half with jitter", func(t *testing.T) {
+		require.Equal(t, 40*time.Millisecond, docker.Backoff(2, 10*time.Millisecond, time.Second, func() float64 { return 0 }))
+		require.Equal(t, 30*time.Millisecond, docker.Backoff(2, 10*time.Millisecond, time.Second, func() float64 { return 0.5 }))
+
+		for retry := range 20 {
+			delay := docker.Backoff(retry, 10*time.Millisecond, time.Second, rand.Float64)
+			unjittered := docker.Backoff(retry, 10*time.Millisecond, time.Second, nil)
+			require.GreaterOrEqual(t, delay, unjittered/2)
+			require.LessOrEqual(t, delay, unjittered)
+			require.LessOrEqual(t, delay, time.Second)
+		}
+	})
+
+	t.Run("stops retrying after max retries", func(t *testing.T) {
+		var mu sync.Mutex
+		attempts := 0
+		mock := &mockDockerClient{
+			containerResizeFunc: func(ctx context.Context, containerID string, options client.ContainerResizeOptions) (client.ContainerResizeResult, error) {
+				mu.Lock()
+				defer mu.Unlock()
+				attempts++
+				return client.ContainerResizeResult{}, errors.New("persistent failure")
+			},
+		}
+
+		out := &fakeTerminalSize{height: 24, width: 80}
+		tty := docker.NewTTY(mock, out, "container123", 3, time.Millisecond, 0, newMockWriter(), func() {})
+		tty.SetJitter(nil)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		cancelled := make(chan struct{})
+		require.NoError(t, tty.Monitor(ctx, func() { close(cancelled) }))
+
+		select {
+		case <-cancelled:
+		case <-time.After(5 * time.Second):
+			t.Fatal("retries were not exhausted")
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		require.Equal(t, 4, attempts, "the initial attempt and 3 retries")
+	})
 }
 
 // TestTTYCreation tests NewTTY
@@ -142,7 +333,7 @@ func TestTTYCreation(t *testing.T) {
 		out := streams.NewOut(nil)
 		writer := newMockWriter()
 
-		tty := docker.NewTTY(mock, out, "container123", 10, 100*time.Millisecond, writer, func() {})
+		tty := docker.NewTTY(mock, out, "container123", 10, 100*time.Millisecond, 0, writer, func() {})
 
 		// We can't directly inspect the fields since they're private,
 		// but we can verify the TTY was created without panicking
@@ -177,6 +368,7 @@ func TestContainerResizeIntegration(t *testing.T) {
 			Volumes:     []string{},
 			WorkingDir:  "/app",
 			Network:     "some-network",
+			TTY:         true,
 			StopTimeout: 10,
 			TTYRetries:  10,
 			RetryDelay:  100 * time.Millisecond,
@@ -184,9 +376,48 @@ func TestContainerResizeIntegration(t *testing.T) {
 		require.NoError(t, err)
 
 		writer := newMockWriter()
-		_ = container.Attach(ctx, func() {}, writer) //nolint:errcheck // Test verifies resize behavior, not attach success
+		_, _ = container.Attach(ctx, func() {}, writer) //nolint:errcheck // Test verifies resize behavior, not attach success
 
 		// In test environment, resize is called but with 0x0 dimensions
 		// The resize call itself should not fail
 	})
+
+	t.Run("resize is skipped when no terminal is available", func(t *testing.T) {
+		server, conn := net.Pipe()
+		defer server.Close()
+
+		resizeCalled := false
+		var attachOptions client.ContainerAttachOptions
+		mock := &mockDockerClient{
+			containerCreateFunc: func(ctx context.Context, options client.ContainerCreateOptions) (client.ContainerCreateResult, error) {
+				return client.ContainerCreateResult{ID: "container123"}, nil
+			},
+			containerResizeFunc: func(ctx context.Context, containerID string, options client.ContainerResizeOptions) (client.ContainerResizeResult, error) {
+				resizeCalled = true
+				return client.ContainerResizeResult{}, nil
+			},
+			containerAttachFunc: func(ctx context.Context, containerID string, options client.ContainerAttachOptions) (client.ContainerAttachResult, error) {
+				attachOptions = options
+				return client.ContainerAttachResult{
+					HijackedResponse: client.NewHijackedResponse(conn, ""),
+				}, nil
+			},
+		}
+
+		c := docker.NewClient(mock)
+		ctx := context.Background()
+
+		opts := createTestContainerOpts()
+		opts.TTY = false
+		container, err := c.CreateContainer(ctx, opts)
+		require.NoError(t, err)
+
+		writer := newMockWriter()
+		_, err = container.Attach(ctx, func() {}, writer)
+		require.NoError(t, err)
+
+		require.True(t, attachOptions.Stream)
+		require.True(t, attachOptions.Stdin)
+		require.False(t, resizeCalled)
+	})
 }
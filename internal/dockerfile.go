@@ -0,0 +1,31 @@
+package internal
+
+import (
+	_ "embed"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// DefaultDockerfile is the Dockerfile the image is built from when none is configured.
+//
+//go:embed default.Dockerfile
+var DefaultDockerfile []byte
+
+// WriteDefaultDockerfile writes DefaultDockerfile to a new temporary directory, which also
+// serves as its empty build context. Returns the path of the Dockerfile and a function that
+// removes the directory, or an error if it cannot be written.
+func WriteDefaultDockerfile() (string, func() error, error) {
+	dir, err := os.MkdirTemp("", "contagent-dockerfile-*")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create directory for the default Dockerfile: %w\nCheck disk space and /tmp permissions", err)
+	}
+
+	path := filepath.Join(dir, "Dockerfile")
+	if err := os.WriteFile(path, DefaultDockerfile, 0600); err != nil {
+		os.RemoveAll(dir)
+		return "", nil, fmt.Errorf("failed to write the default Dockerfile: %w\nCheck disk space and /tmp permissions", err)
+	}
+
+	return path, func() error { return os.RemoveAll(dir) }, nil
+}
@@ -0,0 +1,13 @@
+package internal
+
+import "testing"
+
+// SetHostArch makes ParseConfig behave as if contagent ran on arch for the duration of
+// the test, for testing.
+func SetHostArch(t *testing.T, arch string) {
+	t.Helper()
+
+	original := hostArch
+	hostArch = arch
+	t.Cleanup(func() { hostArch = original })
+}
@@ -2,194 +2,396 @@ package git
 
 import (
 	"archive/tar"
-	"bufio"
+	"compress/gzip"
 	"errors"
 	"fmt"
 	"io"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"sort"
 	"strings"
 
+	"github.com/docker/go-units"
 	"github.com/ryanmoran/contagent/internal"
 )
 
+// defaultArchiveWarnSize is the size of the tracked files above which a warning is
+// printed when ArchiveOptions.WarnSize is zero.
+const defaultArchiveWarnSize = 100 << 20
+
 // ArchiveOptions holds the configuration for creating a git archive.
 type ArchiveOptions struct {
-	Path         string
-	Remote       string
-	Branch       string
-	GitUserName  string
-	GitUserEmail string
-	UID          int
-	GID          int
-	DestDir      string
+	Path              string
+	Ref               string
+	Remote            string
+	Branch            string
+	GitUserName       string
+	GitUserEmail      string
+	UID               int
+	GID               int
+	DestDir           string
+	Gzip              bool
+	IncludeSymlinks   bool
+	IncludeSubmodules bool
+	Stream            bool
+	NoGit             bool
+	MaxSize           int64
+	WarnSize          int64
+	SessionID         internal.SessionID
+	Cleanup           *internal.CleanupManager
+
+	// ResetBranch resets Branch to the archived commit when it already exists in the
+	// repository, such as after a previous session of the same name, instead of
+	// failing. The branch on the host is left as it is.
+	ResetBranch bool
+
+	// InsecureRemote skips the verification of the TLS certificate of Remote, for a
+	// server with a self-signed certificate. Other remotes are still verified.
+	InsecureRemote bool
+
+	// Git runs the git commands that prepare and archive the repository, ExecGit when
+	// nil.
+	Git Git
+}
+
+// gitRunner returns opts.Git, or ExecGit when it is nil.
+func (opts ArchiveOptions) gitRunner() Git {
+	if opts.Git == nil {
+		return ExecGit{}
+	}
+	return opts.Git
 }
 
 // FindRoot returns the root directory of the git repository containing path,
 // by running "git rev-parse --show-toplevel". Returns an error if path is not
 // inside a git repository.
 func FindRoot(path string) (string, error) {
-	cmd := exec.Command("git", "rev-parse", "--show-toplevel")
-	cmd.Dir = path
-	output, err := cmd.Output()
+	root, err := ExecGit{}.RevParse(path, "--show-toplevel")
 	if err != nil {
 		return "", fmt.Errorf("failed to get git root path from %q: %w\nEnsure you're in a git repository", path, err)
 	}
-	return strings.TrimSpace(string(output)), nil
+	return root, nil
 }
 
 // CreateArchive creates a tar archive of the Git repository at the specified path, configured
-// with the given remote URL and branch name. It checks out HEAD into a temporary directory,
-// configures the remote, creates a new branch, and archives the .git directory and all tracked
-// files. The git user name and email are configured in the temporary repository.
+// with the given remote URL and branch name. It checks out opts.Ref (HEAD when empty) into a
+// temporary directory, configures the remote, creates a new branch at that commit, and
+// archives the .git directory and all tracked files. The git user name and email are
+// configured in the temporary repository.
 //
 // opts.UID and opts.GID are applied to all tar headers so that extracted files are owned by
-// the correct container user.
+// the correct container user. User and group names are left empty, as they would take
+// precedence over the IDs when extracting and may name other users in the container.
 //
 // When opts.DestDir is non-empty, all archive paths are prefixed with DestDir and a root
 // directory entry is written first. This allows copying to the parent directory so Docker
 // creates DestDir as a new entry with the correct uid/gid ownership, rather than copying
 // into an already-existing root-owned directory.
 //
+// Submodules arrive as empty directories unless opts.IncludeSubmodules is set, in which case
+// every submodule is checked out at its recorded commit, using the metadata already present in
+// the source repository, and archived along with its .git entry. All submodules must be
+// initialized in the source repository.
+//
+// When opts.Path is a linked worktree, whose .git is a file pointing into another
+// repository, the metadata of that repository is copied instead, so that the archive holds
+// a regular repository.
+//
+// Symlinks are skipped unless opts.IncludeSymlinks is set, in which case they are archived
+// as symlink entries pointing at their original target.
+//
+// The checkout lives in a temporary directory named after opts.SessionID, so that the
+// checkouts of concurrent runs can be told apart. It is removed once the archive is
+// written, and its removal is also registered with opts.Cleanup when set, so that it is
+// removed when the run is interrupted before the archive is read to the end.
+//
+// When opts.Stream is set, the tracked files are streamed from "git archive" instead of
+// being checked out and walked, and the .git directory is a fresh repository holding only
+// the objects reachable from the commit instead of a copy of the whole .git directory. This
+// avoids writing the working tree to disk for large repositories; the repository in the
+// container then has no other branches, tags, or remotes. Submodules are not supported.
+//
+// When opts.NoGit is set, only the tracked files are archived, streamed from "git archive"
+// without a .git directory, so that the container gets no history, branch, or remote.
+// Remote, Branch, GitUserName, and GitUserEmail are then unused, and submodules are not
+// supported.
+//
+// The tracked files of the commit are added up before anything is archived, without the
+// content of submodules or the .git directory. CreateArchive returns an error when they
+// exceed opts.MaxSize, unless it is zero, and warns when they exceed opts.WarnSize, or
+// 100MiB when it is zero, as large files committed by accident make the copy slow.
+//
+// When opts.Gzip is set, the tar stream is gzip-compressed. The consumer must be able to
+// decompress it; Docker's copy API does so automatically.
+//
 // Returns an io.ReadCloser that streams the tar archive. The caller must close it to clean up
-// resources. Returns an error if the Git root cannot be determined, the temporary directory
-// cannot be created, .git copying fails, git operations fail, or archive creation fails.
+// resources. Returns an error immediately if git is not installed, the ref does not resolve
+// to a commit, the files exceed opts.MaxSize, or the temporary directory cannot be created.
+// Reading fails if .git copying fails, git operations fail, or archive creation fails.
 func CreateArchive(opts ArchiveOptions, w internal.Writer) (io.ReadCloser, error) {
-	tempDir, err := os.MkdirTemp("", "contagent-checkout-*")
+	if opts.Git == nil {
+		if _, err := exec.LookPath("git"); err != nil {
+			return nil, fmt.Errorf("git binary not found in PATH: %w\nInstall git or ensure it's in your PATH environment variable", err)
+		}
+	}
+	g := opts.gitRunner()
+
+	if (opts.Stream || opts.NoGit) && opts.IncludeSubmodules {
+		return nil, errors.New("submodules cannot be included in a streamed archive\nArchive without streaming to copy submodules")
+	}
+
+	commit, err := resolveRef(g, opts.Path, opts.Ref)
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := treeSize(g, opts.Path, commit, opts.IncludeSymlinks)
+	if err != nil {
+		return nil, err
+	}
+	if opts.MaxSize > 0 && size > opts.MaxSize {
+		return nil, fmt.Errorf("the files of commit %s add up to %s, over the limit of %s\nRemove large files from the commit, or raise --max-archive-size; 'git ls-tree -r -l %s | sort -k 4 -n' lists the largest files last", commit, units.BytesSize(float64(size)), units.BytesSize(float64(opts.MaxSize)), commit)
+	}
+
+	warnSize := opts.WarnSize
+	if warnSize == 0 {
+		warnSize = defaultArchiveWarnSize
+	}
+	if size > warnSize {
+		w.Warningf("the files of commit %s add up to %s, so copying them into the container may be slow\nCheck for large files committed by accident with 'git ls-tree -r -l %s | sort -k 4 -n'", commit, units.BytesSize(float64(size)), commit)
+	}
+
+	pattern := "contagent-checkout-*"
+	if opts.SessionID != "" {
+		pattern = "contagent-checkout-" + strings.TrimPrefix(string(opts.SessionID), "contagent-") + "-*"
+	}
+
+	tempDir, err := os.MkdirTemp("", pattern)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create temporary directory: %w\nCheck disk space and /tmp permissions", err)
 	}
+	if opts.Cleanup != nil {
+		opts.Cleanup.Add("checkout", func() error {
+			return os.RemoveAll(tempDir)
+		})
+	}
 
 	pr, pw := io.Pipe()
 
 	go func() {
-		tw := tar.NewWriter(pw)
+		var out io.Writer = pw
+		var gw *gzip.Writer
+		if opts.Gzip {
+			gw = gzip.NewWriter(pw)
+			out = gw
+		}
+		tw := tar.NewWriter(out)
+
+		build := buildArchive
+		switch {
+		case opts.NoGit:
+			build = filesArchive
+		case opts.Stream:
+			build = streamArchive
+		}
 
-		err := buildArchive(tw, opts, opts.Path, tempDir)
+		err := build(tw, opts, commit, tempDir)
 		if err != nil {
 			pw.CloseWithError(fmt.Errorf("failed to create git archive: %w", err))
-		} else {
-			err = tw.Close()
+			return
+		}
+
+		err = tw.Close()
+		if err != nil {
+			pw.CloseWithError(fmt.Errorf("failed to close tar writer: %w", err))
+			return
+		}
+
+		if gw != nil {
+			err = gw.Close()
 			if err != nil {
-				pw.CloseWithError(fmt.Errorf("failed to close tar writer: %w", err))
+				pw.CloseWithError(fmt.Errorf("failed to close gzip writer: %w", err))
+				return
 			}
-
-			pw.Close()
 		}
+
+		pw.Close()
 	}()
 
 	return &archiveCloser{pr: pr}, nil
 }
 
-// buildArchive performs the actual archive creation: copying .git, running git commands,
-// and writing all tracked files into the tar writer.
-func buildArchive(tw *tar.Writer, opts ArchiveOptions, gitRoot, tempRoot string) error {
-	defer os.RemoveAll(tempRoot) // Clean up temp directory
+// ResolveCommit returns the hash of the commit that ref, or HEAD when ref is empty,
+// names in the repository at path. Returns an error if the ref does not resolve to a
+// commit.
+func ResolveCommit(path, ref string) (string, error) {
+	return resolveRef(ExecGit{}, path, ref)
+}
 
-	src := filepath.Join(gitRoot, ".git")
-	dst := filepath.Join(tempRoot, ".git")
+// HasDirectory reports whether dir, relative to the root of the repository at path, is
+// a directory in the tree of ref, or HEAD when ref is empty. Returns an error if the
+// ref does not resolve to a commit.
+func HasDirectory(path, ref, dir string) (bool, error) {
+	g := ExecGit{}
+	commit, err := resolveRef(g, path, ref)
+	if err != nil {
+		return false, err
+	}
 
-	if err := copyDirectory(src, dst); err != nil {
-		return fmt.Errorf("failed to copy .git directory from %q to %q: %w\nCheck disk space and permissions", src, dst, err)
+	kind, err := g.CatFileType(path, commit+":"+filepath.ToSlash(dir))
+	if err != nil {
+		return false, nil
+	}
+	return kind == "tree", nil
+}
+
+// resolveRef resolves ref, or HEAD when ref is empty, to a commit hash in the repository
+// at path. Returns an error if the ref does not name a commit.
+func resolveRef(g Git, path, ref string) (string, error) {
+	if ref == "" {
+		ref = "HEAD"
 	}
 
-	cmd := exec.Command("git", "checkout", "HEAD", ".")
-	cmd.Dir = tempRoot
-	err := cmd.Run()
+	commit, err := g.RevParse(path, "--verify", "--quiet", "--end-of-options", ref+"^{commit}")
 	if err != nil {
-		return fmt.Errorf("failed to checkout HEAD in temporary repo: %w\nYou may have uncommitted changes or detached HEAD", err)
+		return "", fmt.Errorf("git ref %q does not resolve to a commit in %q: %w\nCheck the ref with 'git log %s', or commit your work if the repository is empty", ref, path, err, ref)
 	}
+	return commit, nil
+}
 
-	cmd = exec.Command("git", "remote", "remove", "origin")
-	cmd.Dir = tempRoot
-	err = cmd.Run()
+// treeSize returns the total size in bytes of the files in the tree of commit, in the
+// repository at path. Symlinks are only counted when symlinks is set, and submodules
+// are not counted.
+func treeSize(g Git, path, commit string, symlinks bool) (int64, error) {
+	entries, err := g.LsTree(path, commit)
 	if err != nil {
-		if exitError, ok := errors.AsType[*exec.ExitError](err); !ok || exitError.ExitCode() != 2 {
-			return fmt.Errorf("failed to remove remote \"origin\": %w", err)
+		return 0, fmt.Errorf("failed to list the files of commit %s: %w\nRepository may be corrupted", commit, err)
+	}
+
+	var total int64
+	for _, entry := range entries {
+		if entry.Type != "blob" {
+			continue
+		}
+		if entry.Mode == "120000" && !symlinks {
+			continue
 		}
+		total += entry.Size
 	}
+	return total, nil
+}
 
-	cmd = exec.Command("git", "remote", "add", "origin", opts.Remote) //nolint:gosec // args are controlled by internal config, not user input
-	cmd.Dir = tempRoot
-	err = cmd.Run()
+// resolveGitDirs returns the git directory of the repository at path along with the
+// common directory that holds its objects, refs, and config. They differ in a linked
+// worktree, whose .git is a file pointing at a directory of the main repository, and
+// both are the .git directory otherwise.
+func resolveGitDirs(g Git, path string) (string, string, error) {
+	output, err := g.RevParse(path, "--git-dir", "--git-common-dir")
 	if err != nil {
-		return fmt.Errorf("failed to add git remote %q: %w\nCheck that the URL is valid", opts.Remote, err)
+		return "", "", fmt.Errorf("failed to find git directory of %q: %w\nEnsure you're in a git repository", path, err)
 	}
 
-	cmd = exec.Command("git", "config", "user.email", opts.GitUserEmail) //nolint:gosec // args are controlled by internal config, not user input
-	cmd.Dir = tempRoot
-	err = cmd.Run()
-	if err != nil {
-		return fmt.Errorf("failed to configure git user.email to %q: %w", opts.GitUserEmail, err)
+	dirs := strings.Split(output, "\n")
+	if len(dirs) != 2 {
+		return "", "", fmt.Errorf("unexpected output from git rev-parse in %q: %q", path, output)
 	}
 
-	cmd = exec.Command("git", "config", "user.name", opts.GitUserName) //nolint:gosec // args are controlled by internal config, not user input
-	cmd.Dir = tempRoot
-	err = cmd.Run()
-	if err != nil {
-		return fmt.Errorf("failed to configure git user.name to %q: %w", opts.GitUserName, err)
+	// Relative paths are relative to path
+	for i, dir := range dirs {
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(path, dir)
+		}
+		dirs[i] = filepath.Clean(dir)
 	}
+	return dirs[0], dirs[1], nil
+}
+
+// buildArchive performs the actual archive creation: copying .git, running git commands,
+// and writing all tracked files of commit into the tar writer.
+func buildArchive(tw *tar.Writer, opts ArchiveOptions, commit, tempRoot string) error {
+	defer os.RemoveAll(tempRoot) // Clean up temp directory
 
-	cmd = exec.Command("git", "config", "push.autoSetupRemote", "true")
-	cmd.Dir = tempRoot
-	err = cmd.Run()
+	gitRoot := opts.Path
+	g := opts.gitRunner()
+
+	gitDir, commonDir, err := resolveGitDirs(g, gitRoot)
 	if err != nil {
-		return fmt.Errorf("failed to configure git push.autoSetupRemote: %w", err)
+		return err
 	}
 
-	cmd = exec.Command("git", "checkout", "-b", opts.Branch) //nolint:gosec // args are controlled by internal config, not user input
-	cmd.Dir = tempRoot
-	err = cmd.Run()
-	if err != nil {
-		return fmt.Errorf("failed to create and checkout branch %q: %w\nBranch may already exist", opts.Branch, err)
+	src := commonDir
+	dst := filepath.Join(tempRoot, ".git")
+
+	if err := copyDirectory(src, dst, opts.IncludeSymlinks); err != nil {
+		return fmt.Errorf("failed to copy .git directory from %q to %q: %w\nCheck disk space and permissions", src, dst, err)
 	}
 
-	prefix := func(name string) string {
-		if opts.DestDir == "" {
-			return name
-		}
-		return opts.DestDir + "/" + name
+	// The metadata of linked worktrees points at directories on the host, which the
+	// copy is not linked to
+	if err := os.RemoveAll(filepath.Join(dst, "worktrees")); err != nil {
+		return fmt.Errorf("failed to remove linked worktrees from temporary repo: %w", err)
 	}
 
-	if opts.DestDir != "" {
-		rootHeader := &tar.Header{
-			Name:     opts.DestDir + "/",
-			Mode:     0755,
-			Typeflag: tar.TypeDir,
-			Uid:      opts.UID,
-			Gid:      opts.GID,
+	if gitDir != commonDir {
+		// In a linked worktree the submodules keep their metadata with the worktree
+		modules := filepath.Join(gitDir, "modules")
+		if _, err := os.Stat(modules); err == nil {
+			if err := copyDirectory(modules, filepath.Join(dst, "modules"), opts.IncludeSymlinks); err != nil {
+				return fmt.Errorf("failed to copy submodule metadata from %q: %w\nCheck disk space and permissions", modules, err)
+			}
+		}
+
+		// Worktrees are often added to a bare repository, whose copy needs a working tree
+		if err := g.Config(tempRoot, "core.bare", "false"); err != nil {
+			return fmt.Errorf("failed to configure git core.bare: %w", err)
 		}
-		if err := tw.WriteHeader(rootHeader); err != nil {
-			return fmt.Errorf("failed to write root directory header: %w", err)
+	}
+
+	if err := g.Checkout(tempRoot, commit); err != nil {
+		return fmt.Errorf("failed to checkout %s in temporary repo: %w\nYou may have uncommitted changes or detached HEAD", commit, err)
+	}
+
+	if err := g.RemoteRemove(tempRoot, "origin"); err != nil {
+		return fmt.Errorf("failed to remove remote \"origin\": %w", err)
+	}
+
+	if err := configureRepository(g, tempRoot, opts); err != nil {
+		return err
+	}
+
+	if err := g.CheckoutBranch(tempRoot, opts.Branch, opts.ResetBranch); err != nil {
+		return fmt.Errorf("failed to create and checkout branch %q: %w\nBranch may already exist; use --reset-branch to reset it to the archived commit", opts.Branch, err)
+	}
+
+	var submodules []string
+	if opts.IncludeSubmodules {
+		submodules, err = checkoutSubmodules(g, gitRoot, tempRoot, opts.IncludeSymlinks)
+		if err != nil {
+			return err
 		}
 	}
 
-	if err := addDirectoryToArchive(tw, dst, prefix(".git"), opts.UID, opts.GID); err != nil {
+	prefix := archivePrefix(opts)
+	if err := writeRootHeader(tw, opts); err != nil {
+		return err
+	}
+
+	if err := addDirectoryToArchive(tw, dst, prefix(".git"), opts.UID, opts.GID, opts.IncludeSymlinks); err != nil {
 		return fmt.Errorf("failed to add .git directory: %w", err)
 	}
 
-	cmd = exec.Command("git", "ls-files")
-	cmd.Dir = tempRoot
-	output, err := cmd.Output()
+	filePaths, err := g.LsFiles(tempRoot, opts.IncludeSubmodules)
 	if err != nil {
 		return fmt.Errorf("failed to list git tracked files: %w\nRepository may be corrupted", err)
 	}
 
-	// Collect file paths and all unique parent directories
-	var filePaths []string
+	// Collect all unique parent directories
 	dirsSeen := make(map[string]bool)
 	var sortedDirs []string
 
-	scanner := bufio.NewScanner(strings.NewReader(string(output)))
-	for scanner.Scan() {
-		relPath := scanner.Text()
-		if relPath == "" {
-			continue
-		}
-		filePaths = append(filePaths, relPath)
-
+	for _, relPath := range filePaths {
 		// Collect all parent directories of this file
 		dir := filepath.Dir(relPath)
 		for dir != "." && dir != "/" {
@@ -202,10 +404,6 @@ func buildArchive(tw *tar.Writer, opts ArchiveOptions, gitRoot, tempRoot string)
 		}
 	}
 
-	if err := scanner.Err(); err != nil {
-		return fmt.Errorf("error reading git file list: %w", err)
-	}
-
 	// Sort so parent directories come before their children
 	sort.Strings(sortedDirs)
 
@@ -239,6 +437,17 @@ func buildArchive(tw *tar.Writer, opts ArchiveOptions, gitRoot, tempRoot string)
 		}
 
 		if info.Mode()&os.ModeSymlink != 0 {
+			if !opts.IncludeSymlinks {
+				continue
+			}
+
+			header, err := symlinkHeader(fullPath, prefix(relPath), info, opts.UID, opts.GID)
+			if err != nil {
+				return err
+			}
+			if err := tw.WriteHeader(header); err != nil {
+				return fmt.Errorf("failed to write symlink header for %s: %w", relPath, err)
+			}
 			continue
 		}
 
@@ -272,9 +481,300 @@ func buildArchive(tw *tar.Writer, opts ArchiveOptions, gitRoot, tempRoot string)
 		file.Close()
 	}
 
+	// Submodule .git entries point at their metadata under .git/modules, or are
+	// repositories of their own for submodules that were never absorbed
+	for _, path := range submodules {
+		relPath := path + "/.git"
+		fullPath := filepath.Join(tempRoot, relPath)
+		info, err := os.Lstat(fullPath)
+		if err != nil {
+			return fmt.Errorf("failed to find .git for submodule %q: %w", path, err)
+		}
+
+		if info.IsDir() {
+			if err := addDirectoryToArchive(tw, fullPath, prefix(relPath), opts.UID, opts.GID, opts.IncludeSymlinks); err != nil {
+				return fmt.Errorf("failed to add .git directory for submodule %q: %w", path, err)
+			}
+			continue
+		}
+
+		content, err := os.ReadFile(fullPath) //nolint:gosec // path is constructed from a controlled temp root
+		if err != nil {
+			return fmt.Errorf("failed to read .git file for submodule %q: %w", path, err)
+		}
+
+		header := &tar.Header{
+			Name:    prefix(relPath),
+			Mode:    int64(info.Mode()),
+			Size:    int64(len(content)),
+			ModTime: info.ModTime(),
+			Uid:     opts.UID,
+			Gid:     opts.GID,
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return fmt.Errorf("failed to write header for %s: %w", relPath, err)
+		}
+		if _, err := tw.Write(content); err != nil {
+			return fmt.Errorf("failed to write file %s: %w", relPath, err)
+		}
+	}
+
 	return nil
 }
 
+// configureRepository points the origin remote of the repository at dir to opts.Remote,
+// sets the git user name and email, and makes the first push set up the upstream branch.
+// With opts.InsecureRemote, the certificate of the remote is not verified.
+func configureRepository(g Git, dir string, opts ArchiveOptions) error {
+	if err := g.RemoteAdd(dir, "origin", opts.Remote); err != nil {
+		return fmt.Errorf("failed to add git remote %q: %w\nCheck that the URL is valid", opts.Remote, err)
+	}
+
+	if err := g.Config(dir, "user.email", opts.GitUserEmail); err != nil {
+		return fmt.Errorf("failed to configure git user.email to %q: %w", opts.GitUserEmail, err)
+	}
+
+	if err := g.Config(dir, "user.name", opts.GitUserName); err != nil {
+		return fmt.Errorf("failed to configure git user.name to %q: %w", opts.GitUserName, err)
+	}
+
+	if err := g.Config(dir, "push.autoSetupRemote", "true"); err != nil {
+		return fmt.Errorf("failed to configure git push.autoSetupRemote: %w", err)
+	}
+
+	// Like GIT_SSL_NO_VERIFY, but only for the remote rather than every server the
+	// repository talks to
+	if opts.InsecureRemote {
+		remote, err := url.Parse(opts.Remote)
+		if err != nil {
+			return fmt.Errorf("failed to parse git remote %q: %w", opts.Remote, err)
+		}
+		key := fmt.Sprintf("http.%s://%s/.sslVerify", remote.Scheme, remote.Host)
+		if err := g.Config(dir, key, "false"); err != nil {
+			return fmt.Errorf("failed to configure git %s: %w", key, err)
+		}
+	}
+
+	return nil
+}
+
+// streamArchive writes the tracked files of commit into the tar writer straight from
+// "git archive", without checking them out. The .git directory is a new repository in
+// tempRoot that fetches only the objects reachable from commit, with an index matching
+// the commit so that the working tree reads as clean.
+func streamArchive(tw *tar.Writer, opts ArchiveOptions, commit, tempRoot string) error {
+	defer os.RemoveAll(tempRoot) // Clean up temp directory
+
+	g := opts.gitRunner()
+
+	if err := g.Init(tempRoot); err != nil {
+		return fmt.Errorf("failed to create temporary repo: %w", err)
+	}
+
+	if err := g.Fetch(tempRoot, opts.Path, commit); err != nil {
+		return fmt.Errorf("failed to fetch %s into temporary repo: %w", commit, err)
+	}
+
+	if err := g.UpdateRef(tempRoot, "refs/heads/"+opts.Branch, commit); err != nil {
+		return fmt.Errorf("failed to create branch %q: %w\nCheck that the branch name is valid", opts.Branch, err)
+	}
+
+	if err := g.SymbolicRef(tempRoot, "HEAD", "refs/heads/"+opts.Branch); err != nil {
+		return fmt.Errorf("failed to checkout branch %q: %w", opts.Branch, err)
+	}
+
+	if err := g.ReadTree(tempRoot, commit); err != nil {
+		return fmt.Errorf("failed to write git index for %s: %w", commit, err)
+	}
+
+	if err := configureRepository(g, tempRoot, opts); err != nil {
+		return err
+	}
+
+	prefix := archivePrefix(opts)
+	if err := writeRootHeader(tw, opts); err != nil {
+		return err
+	}
+
+	if err := copyGitArchive(tw, tempRoot, commit, prefix, opts); err != nil {
+		return err
+	}
+
+	if err := addDirectoryToArchive(tw, filepath.Join(tempRoot, ".git"), prefix(".git"), opts.UID, opts.GID, opts.IncludeSymlinks); err != nil {
+		return fmt.Errorf("failed to add .git directory: %w", err)
+	}
+
+	return nil
+}
+
+// copyGitArchive archives commit in the repository at dir and copies the entries into the
+// tar writer, named with prefix and owned by opts.UID and opts.GID. Symlinks are skipped
+// unless opts.IncludeSymlinks is set.
+func copyGitArchive(tw *tar.Writer, dir, commit string, prefix func(string) string, opts ArchiveOptions) error {
+	// The export-ignore and export-subst attributes would drop or rewrite files that a
+	// checkout keeps as they are, so they are turned off while the archive is written
+	attributes := filepath.Join(dir, ".git", "info", "attributes")
+	if err := os.MkdirAll(filepath.Dir(attributes), 0755); err != nil {
+		return fmt.Errorf("failed to create git info directory: %w", err)
+	}
+	if err := os.WriteFile(attributes, []byte("* -export-ignore -export-subst\n"), 0644); err != nil { //nolint:gosec // the repository is readable by the container user
+		return fmt.Errorf("failed to write git attributes: %w", err)
+	}
+	defer os.Remove(attributes)
+
+	archive, err := opts.gitRunner().Archive(dir, commit)
+	if err != nil {
+		return fmt.Errorf("failed to run git archive: %w", err)
+	}
+
+	copyErr := copyArchiveEntries(tw, tar.NewReader(archive), prefix, opts)
+	if err := archive.Close(); err != nil {
+		return fmt.Errorf("git archive of %s failed: %w", commit, err)
+	}
+	return copyErr
+}
+
+// filesArchive writes only the tracked files of commit into the tar writer, without a
+// .git directory. "git archive" runs in a new repository in tempRoot that borrows the
+// objects of the repository at opts.Path, so that nothing is copied or checked out.
+func filesArchive(tw *tar.Writer, opts ArchiveOptions, commit, tempRoot string) error {
+	defer os.RemoveAll(tempRoot) // Clean up temp directory
+
+	g := opts.gitRunner()
+
+	_, commonDir, err := resolveGitDirs(g, opts.Path)
+	if err != nil {
+		return err
+	}
+
+	if err := g.Init(tempRoot); err != nil {
+		return fmt.Errorf("failed to create temporary repo: %w", err)
+	}
+
+	alternates := filepath.Join(tempRoot, ".git", "objects", "info", "alternates")
+	if err := os.WriteFile(alternates, []byte(filepath.Join(commonDir, "objects")+"\n"), 0600); err != nil {
+		return fmt.Errorf("failed to link the objects of %q: %w", opts.Path, err)
+	}
+
+	if err := writeRootHeader(tw, opts); err != nil {
+		return err
+	}
+
+	return copyGitArchive(tw, tempRoot, commit, archivePrefix(opts), opts)
+}
+
+// archivePrefix returns a function that places archive paths under opts.DestDir.
+func archivePrefix(opts ArchiveOptions) func(string) string {
+	return func(name string) string {
+		if opts.DestDir == "" {
+			return name
+		}
+		return opts.DestDir + "/" + name
+	}
+}
+
+// writeRootHeader writes the entry of opts.DestDir, owned by opts.UID and opts.GID, when
+// it is set.
+func writeRootHeader(tw *tar.Writer, opts ArchiveOptions) error {
+	if opts.DestDir == "" {
+		return nil
+	}
+
+	rootHeader := &tar.Header{
+		Name:     opts.DestDir + "/",
+		Mode:     0755,
+		Typeflag: tar.TypeDir,
+		Uid:      opts.UID,
+		Gid:      opts.GID,
+	}
+	if err := tw.WriteHeader(rootHeader); err != nil {
+		return fmt.Errorf("failed to write root directory header: %w", err)
+	}
+	return nil
+}
+
+// copyArchiveEntries copies the entries read from tr into tw, renamed with prefix and
+// owned by opts.UID and opts.GID.
+func copyArchiveEntries(tw *tar.Writer, tr *tar.Reader, prefix func(string) string, opts ArchiveOptions) error {
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read git archive: %w", err)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeXGlobalHeader:
+			// Holds the commit ID, which the .git directory records already
+			continue
+		case tar.TypeSymlink:
+			if !opts.IncludeSymlinks {
+				continue
+			}
+		}
+
+		entry := &tar.Header{
+			Name:     prefix(header.Name),
+			Linkname: header.Linkname,
+			Mode:     header.Mode,
+			Size:     header.Size,
+			ModTime:  header.ModTime,
+			Typeflag: header.Typeflag,
+			Uid:      opts.UID,
+			Gid:      opts.GID,
+		}
+		if err := tw.WriteHeader(entry); err != nil {
+			return fmt.Errorf("failed to write header for %s: %w", header.Name, err)
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			return fmt.Errorf("failed to write file %s: %w", header.Name, err)
+		}
+	}
+}
+
+// checkoutSubmodules checks out every submodule of the repository at gitRoot inside the
+// temporary repository at tempRoot, recursively. The submodule metadata under .git/modules
+// has already been copied, so no network access is needed; submodules that keep their
+// repository inside the working tree have it copied over first. Returns the paths of all
+// submodules relative to the repository root, or an error naming the first submodule that
+// has not been initialized.
+func checkoutSubmodules(g Git, gitRoot, tempRoot string, symlinks bool) ([]string, error) {
+	submodules, err := g.Submodules(gitRoot)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list git submodules: %w", err)
+	}
+
+	var paths []string
+	for _, submodule := range submodules {
+		path := submodule.Path
+		if !submodule.Initialized {
+			return nil, fmt.Errorf("submodule %q is not initialized\nRun 'git submodule update --init --recursive' before starting contagent", path)
+		}
+
+		src := filepath.Join(gitRoot, path, ".git")
+		if info, err := os.Lstat(src); err == nil && info.IsDir() {
+			dst := filepath.Join(tempRoot, path, ".git")
+			if err := copyDirectory(src, dst, symlinks); err != nil {
+				return nil, fmt.Errorf("failed to copy .git directory for submodule %q: %w", path, err)
+			}
+		}
+
+		paths = append(paths, path)
+	}
+
+	if len(paths) == 0 {
+		return nil, nil
+	}
+
+	if err := g.SubmoduleUpdate(tempRoot); err != nil {
+		return nil, fmt.Errorf("failed to check out git submodules: %w", err)
+	}
+
+	return paths, nil
+}
+
 // archiveCloser wraps the pipe reader to ensure proper cleanup
 type archiveCloser struct {
 	pr *io.PipeReader
@@ -288,15 +788,34 @@ func (a *archiveCloser) Close() error {
 	return a.pr.Close()
 }
 
-// walkDir walks a directory tree, skipping symlinks, and calls visitor for each entry.
-// visitor receives the relative path, file info, and absolute path of each entry.
-func walkDir(root string, visitor func(relPath string, info os.FileInfo, absPath string) error) error {
+// symlinkHeader builds a tar header for the symlink at absPath, preserving its target.
+func symlinkHeader(absPath, name string, info os.FileInfo, uid, gid int) (*tar.Header, error) {
+	target, err := os.Readlink(absPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read symlink %s: %w", absPath, err)
+	}
+
+	return &tar.Header{
+		Name:     name,
+		Linkname: target,
+		Mode:     int64(info.Mode().Perm()),
+		ModTime:  info.ModTime(),
+		Typeflag: tar.TypeSymlink,
+		Uid:      uid,
+		Gid:      gid,
+	}, nil
+}
+
+// walkDir walks a directory tree and calls visitor for each entry. Symlinks are skipped
+// unless symlinks is set. visitor receives the relative path, file info, and absolute
+// path of each entry.
+func walkDir(root string, symlinks bool, visitor func(relPath string, info os.FileInfo, absPath string) error) error {
 	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
 
-		if info.Mode()&os.ModeSymlink != 0 {
+		if info.Mode()&os.ModeSymlink != 0 && !symlinks {
 			return nil
 		}
 
@@ -309,11 +828,19 @@ func walkDir(root string, visitor func(relPath string, info os.FileInfo, absPath
 	})
 }
 
-func addDirectoryToArchive(tw *tar.Writer, srcDir, tarPath string, uid, gid int) error {
-	return walkDir(srcDir, func(relPath string, info os.FileInfo, absPath string) error {
+func addDirectoryToArchive(tw *tar.Writer, srcDir, tarPath string, uid, gid int, symlinks bool) error {
+	return walkDir(srcDir, symlinks, func(relPath string, info os.FileInfo, absPath string) error {
 		fullTarPath := filepath.Join(tarPath, relPath)
 		fullTarPath = strings.ReplaceAll(fullTarPath, "\\", "/")
 
+		if info.Mode()&os.ModeSymlink != 0 {
+			header, err := symlinkHeader(absPath, fullTarPath, info, uid, gid)
+			if err != nil {
+				return err
+			}
+			return tw.WriteHeader(header)
+		}
+
 		if info.IsDir() {
 			header := &tar.Header{
 				Name:     fullTarPath + "/",
@@ -353,10 +880,18 @@ func addDirectoryToArchive(tw *tar.Writer, srcDir, tarPath string, uid, gid int)
 	})
 }
 
-func copyDirectory(src, dst string) error {
-	return walkDir(src, func(relPath string, info os.FileInfo, absPath string) error {
+func copyDirectory(src, dst string, symlinks bool) error {
+	return walkDir(src, symlinks, func(relPath string, info os.FileInfo, absPath string) error {
 		dstPath := filepath.Join(dst, relPath)
 
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Readlink(absPath)
+			if err != nil {
+				return fmt.Errorf("failed to read symlink %s: %w", absPath, err)
+			}
+			return os.Symlink(target, dstPath)
+		}
+
 		if info.IsDir() {
 			return os.MkdirAll(dstPath, info.Mode())
 		}
@@ -2,6 +2,7 @@ package git_test
 
 import (
 	"archive/tar"
+	"compress/gzip"
 	"fmt"
 	"io"
 	"os"
@@ -9,6 +10,7 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/ryanmoran/contagent/internal"
 	"github.com/ryanmoran/contagent/internal/git"
@@ -160,9 +162,8 @@ func TestCreateArchive(t *testing.T) {
 		defer os.RemoveAll(dir)
 
 		// CreateArchive no longer calls FindRoot; callers must pass the git root.
-		// The error is deferred: CreateArchive returns a reader, but reading fails
-		// when buildArchive cannot find the .git directory.
-		reader, err := git.CreateArchive(git.ArchiveOptions{
+		// Resolving the ref fails up front outside of a repository.
+		_, err = git.CreateArchive(git.ArchiveOptions{
 			Path:         dir,
 			Remote:       "http://example.com",
 			Branch:       "branch",
@@ -172,10 +173,33 @@ func TestCreateArchive(t *testing.T) {
 			GID:          0,
 			DestDir:      "",
 		}, internal.NewStandardWriter())
+		require.ErrorContains(t, err, `git ref "HEAD" does not resolve to a commit`)
+	})
+
+	t.Run("fails when git is not installed", func(t *testing.T) {
+		dir := t.TempDir()
+		cmd := exec.Command("git", "init")
+		cmd.Dir = dir
+		require.NoError(t, cmd.Run())
+
+		// Nothing is created before git is found
+		tempDir := t.TempDir()
+		t.Setenv("TMPDIR", tempDir)
+		t.Setenv("PATH", t.TempDir())
+
+		_, err := git.CreateArchive(git.ArchiveOptions{
+			Path:         dir,
+			Remote:       "http://example.com",
+			Branch:       "branch",
+			GitUserName:  "user",
+			GitUserEmail: "user@example.com",
+		}, internal.NewStandardWriter())
+		require.ErrorContains(t, err, "git binary not found in PATH")
+		require.ErrorContains(t, err, "Install git or ensure it's in your PATH environment variable")
+
+		entries, err := os.ReadDir(tempDir)
 		require.NoError(t, err)
-		defer reader.Close()
-		_, err = io.ReadAll(reader)
-		require.ErrorContains(t, err, "failed to copy .git directory")
+		require.Empty(t, entries)
 	})
 
 	t.Run("handles repository with no initial remote", func(t *testing.T) {
@@ -289,6 +313,70 @@ func TestCreateArchive(t *testing.T) {
 		require.False(t, files["link.txt"], "should not contain symlink")
 	})
 
+	t.Run("includes symlinks when requested", func(t *testing.T) {
+		dir := t.TempDir()
+
+		cmd := exec.Command("git", "init")
+		cmd.Dir = dir
+		require.NoError(t, cmd.Run())
+
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "regular.txt"), []byte("regular\n"), 0600))
+		require.NoError(t, os.Symlink("regular.txt", filepath.Join(dir, "link.txt")))
+
+		cmd = exec.Command("git", "add", ".")
+		cmd.Dir = dir
+		require.NoError(t, cmd.Run())
+
+		cmd = exec.Command("git", "commit", "-m", "commit")
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=Test User",
+			"GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=Test User",
+			"GIT_COMMITTER_EMAIL=test@example.com",
+		)
+		require.NoError(t, cmd.Run())
+
+		// Symlinks inside .git, such as shared hooks, are preserved too
+		require.NoError(t, os.Symlink("../../regular.txt", filepath.Join(dir, ".git", "hooks", "pre-commit")))
+
+		reader, err := git.CreateArchive(git.ArchiveOptions{
+			Path:            dir,
+			Remote:          "http://example.com",
+			Branch:          "branch",
+			GitUserName:     "user",
+			GitUserEmail:    "user@example.com",
+			UID:             0,
+			GID:             0,
+			DestDir:         "",
+			IncludeSymlinks: true,
+		}, internal.NewStandardWriter())
+		require.NoError(t, err)
+		defer reader.Close()
+
+		tr := tar.NewReader(reader)
+		headers := make(map[string]*tar.Header)
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+			headers[header.Name] = header
+		}
+
+		require.Contains(t, headers, "regular.txt")
+		require.Equal(t, byte(tar.TypeReg), headers["regular.txt"].Typeflag)
+
+		require.Contains(t, headers, "link.txt")
+		require.Equal(t, byte(tar.TypeSymlink), headers["link.txt"].Typeflag)
+		require.Equal(t, "regular.txt", headers["link.txt"].Linkname)
+
+		require.Contains(t, headers, ".git/hooks/pre-commit")
+		require.Equal(t, byte(tar.TypeSymlink), headers[".git/hooks/pre-commit"].Typeflag)
+		require.Equal(t, "../../regular.txt", headers[".git/hooks/pre-commit"].Linkname)
+	})
+
 	t.Run("archives from subdirectory of git repo", func(t *testing.T) {
 		// Create git repo
 		dir, err := os.MkdirTemp("", "git-subdir-test")
@@ -362,8 +450,8 @@ func TestCreateArchive(t *testing.T) {
 		cmd.Dir = dir
 		require.NoError(t, cmd.Run())
 
-		// Create archive from empty repo - returns reader but will error when reading
-		reader, err := git.CreateArchive(git.ArchiveOptions{
+		// An empty repository has no HEAD commit to archive
+		_, err = git.CreateArchive(git.ArchiveOptions{
 			Path:         dir,
 			Remote:       "http://example.com",
 			Branch:       "branch",
@@ -373,15 +461,132 @@ func TestCreateArchive(t *testing.T) {
 			GID:          0,
 			DestDir:      "",
 		}, internal.NewStandardWriter())
-		// CreateArchive returns immediately with a reader, error happens in goroutine
-		require.NoError(t, err)
-		if reader != nil {
-			defer reader.Close()
-			// Try to read - should get error from the goroutine
-			_, err = io.ReadAll(reader)
-			require.Error(t, err)
-			require.ErrorContains(t, err, "failed to checkout HEAD in temporary repo")
+		require.ErrorContains(t, err, `git ref "HEAD" does not resolve to a commit`)
+	})
+
+	t.Run("places every entry under the DestDir prefix", func(t *testing.T) {
+		dir := t.TempDir()
+
+		cmd := exec.Command("git", "init")
+		cmd.Dir = dir
+		require.NoError(t, cmd.Run())
+
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, "src"), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "src", "main.go"), []byte("package main\n"), 0600))
+
+		cmd = exec.Command("git", "add", ".")
+		cmd.Dir = dir
+		require.NoError(t, cmd.Run())
+
+		cmd = exec.Command("git", "commit", "-m", "commit")
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=Test User",
+			"GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=Test User",
+			"GIT_COMMITTER_EMAIL=test@example.com",
+		)
+		require.NoError(t, cmd.Run())
+
+		reader, err := git.CreateArchive(git.ArchiveOptions{
+			Path:         dir,
+			Remote:       "http://example.com",
+			Branch:       "branch",
+			GitUserName:  "user",
+			GitUserEmail: "user@example.com",
+			UID:          0,
+			GID:          0,
+			DestDir:      "workspace",
+		}, internal.NewStandardWriter())
+		require.NoError(t, err)
+		defer reader.Close()
+
+		tr := tar.NewReader(reader)
+		var names []string
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+			names = append(names, header.Name)
 		}
+
+		require.Equal(t, "workspace/", names[0], "root directory entry should come first")
+		for _, name := range names {
+			require.True(t, strings.HasPrefix(name, "workspace/"), "entry %q should be under workspace/", name)
+		}
+		require.Contains(t, names, "workspace/src/")
+		require.Contains(t, names, "workspace/src/main.go")
+		require.Contains(t, names, "workspace/.git/HEAD")
+	})
+
+	t.Run("gzips the archive when requested", func(t *testing.T) {
+		dir := t.TempDir()
+
+		cmd := exec.Command("git", "init")
+		cmd.Dir = dir
+		require.NoError(t, cmd.Run())
+
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "script.sh"), []byte("#!/bin/bash\necho test\n"), 0755)) //nolint:gosec // G306: Testing executable permissions
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "data.txt"), []byte("data\n"), 0600))
+
+		cmd = exec.Command("git", "add", ".")
+		cmd.Dir = dir
+		require.NoError(t, cmd.Run())
+
+		cmd = exec.Command("git", "commit", "-m", "commit")
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=Test User",
+			"GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=Test User",
+			"GIT_COMMITTER_EMAIL=test@example.com",
+		)
+		require.NoError(t, cmd.Run())
+
+		reader, err := git.CreateArchive(git.ArchiveOptions{
+			Path:         dir,
+			Remote:       "http://example.com",
+			Branch:       "branch",
+			GitUserName:  "user",
+			GitUserEmail: "user@example.com",
+			UID:          0,
+			GID:          0,
+			DestDir:      "app",
+			Gzip:         true,
+		}, internal.NewStandardWriter())
+		require.NoError(t, err)
+		defer reader.Close()
+
+		gr, err := gzip.NewReader(reader)
+		require.NoError(t, err)
+
+		tr := tar.NewReader(gr)
+		files := make(map[string]string)
+		modes := make(map[string]int64)
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+
+			modes[header.Name] = header.Mode
+			if header.Typeflag == tar.TypeReg {
+				content, err := io.ReadAll(tr)
+				require.NoError(t, err)
+				files[header.Name] = string(content)
+			}
+		}
+		require.NoError(t, gr.Close())
+
+		require.Equal(t, "#!/bin/bash\necho test\n", files["app/script.sh"])
+		require.Equal(t, "data\n", files["app/data.txt"])
+		require.Contains(t, files, "app/.git/config")
+
+		require.NotZero(t, modes["app/script.sh"]&0111, "script.sh should have executable bit")
+		require.Zero(t, modes["app/data.txt"]&0111, "data.txt should not have executable bit")
 	})
 }
 
@@ -986,6 +1191,53 @@ func TestArchiveOwnership(t *testing.T) {
 		}
 		require.Greater(t, gitEntries, 0, "archive should contain .git entries")
 	})
+
+	t.Run("applies a chosen owner without user or group names", func(t *testing.T) {
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "root.txt"), []byte("root\n"), 0600))
+		for _, args := range [][]string{{"init"}, {"add", "."}, {"commit", "-m", "commit"}} {
+			cmd := exec.Command("git", args...)
+			cmd.Dir = dir
+			cmd.Env = append(os.Environ(),
+				"GIT_AUTHOR_NAME=Test User",
+				"GIT_AUTHOR_EMAIL=test@example.com",
+				"GIT_COMMITTER_NAME=Test User",
+				"GIT_COMMITTER_EMAIL=test@example.com",
+			)
+			output, err := cmd.CombinedOutput()
+			require.NoError(t, err, string(output))
+		}
+
+		for _, mode := range []string{"checkout", "stream", "no-git"} {
+			reader, err := git.CreateArchive(git.ArchiveOptions{
+				Path:         dir,
+				Remote:       "http://example.com",
+				Branch:       "branch",
+				GitUserName:  "user",
+				GitUserEmail: "user@example.com",
+				UID:          2000,
+				GID:          3000,
+				DestDir:      "app",
+				Stream:       mode == "stream",
+				NoGit:        mode == "no-git",
+			}, internal.NewStandardWriter())
+			require.NoError(t, err)
+
+			tr := tar.NewReader(reader)
+			for {
+				header, err := tr.Next()
+				if err == io.EOF {
+					break
+				}
+				require.NoError(t, err)
+				require.Equal(t, 2000, header.Uid, "%s: header %q should have Uid 2000", mode, header.Name)
+				require.Equal(t, 3000, header.Gid, "%s: header %q should have Gid 3000", mode, header.Name)
+				require.Empty(t, header.Uname, "%s: header %q should have no user name", mode, header.Name)
+				require.Empty(t, header.Gname, "%s: header %q should have no group name", mode, header.Name)
+			}
+			require.NoError(t, reader.Close())
+		}
+	})
 }
 
 func TestAddDirectoryToArchive(t *testing.T) {
@@ -1101,3 +1353,911 @@ func TestAddDirectoryToArchive(t *testing.T) {
 		}
 	})
 }
+
+func TestArchiveSubmodules(t *testing.T) {
+	run := func(t *testing.T, dir string, args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-c", "protocol.file.allow=always"}, args...)...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=Test User",
+			"GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=Test User",
+			"GIT_COMMITTER_EMAIL=test@example.com",
+		)
+		output, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(output))
+	}
+
+	setup := func(t *testing.T) string {
+		t.Helper()
+
+		lib := t.TempDir()
+		run(t, lib, "init")
+		require.NoError(t, os.WriteFile(filepath.Join(lib, "lib.txt"), []byte("library\n"), 0600))
+		run(t, lib, "add", ".")
+		run(t, lib, "commit", "-m", "lib")
+
+		dir := t.TempDir()
+		run(t, dir, "init")
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "main.txt"), []byte("main\n"), 0600))
+		run(t, dir, "submodule", "add", lib, "vendor/lib")
+		run(t, dir, "add", ".")
+		run(t, dir, "commit", "-m", "main")
+
+		return dir
+	}
+
+	archive := func(t *testing.T, dir string, submodules bool) (map[string]string, error) {
+		t.Helper()
+
+		reader, err := git.CreateArchive(git.ArchiveOptions{
+			Path:              dir,
+			Remote:            "http://example.com",
+			Branch:            "branch",
+			GitUserName:       "user",
+			GitUserEmail:      "user@example.com",
+			UID:               0,
+			GID:               0,
+			DestDir:           "app",
+			IncludeSubmodules: submodules,
+		}, internal.NewStandardWriter())
+		require.NoError(t, err)
+		defer reader.Close()
+
+		tr := tar.NewReader(reader)
+		files := make(map[string]string)
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				return files, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+
+			content, err := io.ReadAll(tr)
+			require.NoError(t, err)
+			files[header.Name] = string(content)
+		}
+	}
+
+	t.Run("includes checked-out submodule content when requested", func(t *testing.T) {
+		files, err := archive(t, setup(t), true)
+		require.NoError(t, err)
+
+		require.Equal(t, "main\n", files["app/main.txt"])
+		require.Equal(t, "library\n", files["app/vendor/lib/lib.txt"])
+		require.Contains(t, files, "app/vendor/lib/")
+		require.Equal(t, "gitdir: ../../.git/modules/vendor/lib\n", files["app/vendor/lib/.git"])
+		require.Contains(t, files, "app/.git/modules/vendor/lib/HEAD")
+	})
+
+	t.Run("leaves submodules empty by default", func(t *testing.T) {
+		files, err := archive(t, setup(t), false)
+		require.NoError(t, err)
+
+		require.Contains(t, files, "app/main.txt")
+		require.NotContains(t, files, "app/vendor/lib/lib.txt")
+		require.NotContains(t, files, "app/vendor/lib/.git")
+	})
+
+	t.Run("returns an error for uninitialized submodules", func(t *testing.T) {
+		clone := filepath.Join(t.TempDir(), "clone")
+		run(t, setup(t), "clone", ".", clone)
+
+		_, err := archive(t, clone, true)
+		require.ErrorContains(t, err, `submodule "vendor/lib" is not initialized`)
+	})
+}
+
+func TestArchiveRef(t *testing.T) {
+	run := func(t *testing.T, dir string, args ...string) string {
+		t.Helper()
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=Test User",
+			"GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=Test User",
+			"GIT_COMMITTER_EMAIL=test@example.com",
+		)
+		output, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(output))
+		return strings.TrimSpace(string(output))
+	}
+
+	setup := func(t *testing.T) string {
+		t.Helper()
+
+		dir := t.TempDir()
+		run(t, dir, "init")
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "version.txt"), []byte("v1\n"), 0600))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "removed.txt"), []byte("gone later\n"), 0600))
+		run(t, dir, "add", ".")
+		run(t, dir, "commit", "-m", "v1")
+		run(t, dir, "tag", "v1")
+
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "version.txt"), []byte("v2\n"), 0600))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "added.txt"), []byte("new\n"), 0600))
+		run(t, dir, "rm", "removed.txt")
+		run(t, dir, "add", ".")
+		run(t, dir, "commit", "-m", "v2")
+
+		return dir
+	}
+
+	t.Run("archives the files of the given ref", func(t *testing.T) {
+		dir := setup(t)
+
+		reader, err := git.CreateArchive(git.ArchiveOptions{
+			Path:         dir,
+			Ref:          "v1",
+			Remote:       "http://example.com",
+			Branch:       "contagent/test",
+			GitUserName:  "user",
+			GitUserEmail: "user@example.com",
+			UID:          0,
+			GID:          0,
+			DestDir:      "app",
+		}, internal.NewStandardWriter())
+		require.NoError(t, err)
+		defer reader.Close()
+
+		extracted := t.TempDir()
+		tr := tar.NewReader(reader)
+		files := make(map[string]string)
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+
+			target := filepath.Join(extracted, header.Name) //nolint:gosec // G305: archive is produced by the test
+			if header.Typeflag == tar.TypeDir {
+				require.NoError(t, os.MkdirAll(target, 0755))
+				continue
+			}
+			content, err := io.ReadAll(tr)
+			require.NoError(t, err)
+			require.NoError(t, os.MkdirAll(filepath.Dir(target), 0755))
+			require.NoError(t, os.WriteFile(target, content, 0600))
+			files[header.Name] = string(content)
+		}
+
+		require.Equal(t, "v1\n", files["app/version.txt"])
+		require.Equal(t, "gone later\n", files["app/removed.txt"])
+		require.NotContains(t, files, "app/added.txt")
+
+		// The session branch starts at the requested commit
+		app := filepath.Join(extracted, "app")
+		require.Equal(t, "contagent/test", run(t, app, "rev-parse", "--abbrev-ref", "HEAD"))
+		require.Equal(t, run(t, dir, "rev-parse", "v1"), run(t, app, "rev-parse", "HEAD"))
+		require.Empty(t, run(t, app, "status", "--porcelain"))
+	})
+
+	t.Run("returns an error when the ref does not resolve", func(t *testing.T) {
+		_, err := git.CreateArchive(git.ArchiveOptions{
+			Path:         setup(t),
+			Ref:          "does-not-exist",
+			Remote:       "http://example.com",
+			Branch:       "branch",
+			GitUserName:  "user",
+			GitUserEmail: "user@example.com",
+			UID:          0,
+			GID:          0,
+			DestDir:      "",
+		}, internal.NewStandardWriter())
+		require.ErrorContains(t, err, `git ref "does-not-exist" does not resolve to a commit`)
+	})
+}
+
+func TestHasDirectory(t *testing.T) {
+	run := func(t *testing.T, dir string, args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=Test User",
+			"GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=Test User",
+			"GIT_COMMITTER_EMAIL=test@example.com",
+		)
+		output, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(output))
+	}
+
+	dir := t.TempDir()
+	run(t, dir, "init")
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "src", "pkg"), 0700))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "src", "pkg", "main.go"), []byte("package main\n"), 0600))
+	run(t, dir, "add", ".")
+	run(t, dir, "commit", "-m", "v1")
+	run(t, dir, "tag", "v1")
+
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "docs"), 0700))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "docs", "README.md"), []byte("docs\n"), 0600))
+	run(t, dir, "add", ".")
+	run(t, dir, "commit", "-m", "v2")
+
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "scratch"), 0700))
+
+	t.Run("finds directories of the commit", func(t *testing.T) {
+		for _, path := range []string{"src", filepath.Join("src", "pkg"), "docs"} {
+			found, err := git.HasDirectory(dir, "", path)
+			require.NoError(t, err)
+			require.True(t, found, "path %q", path)
+		}
+	})
+
+	t.Run("does not find untracked directories or files", func(t *testing.T) {
+		for _, path := range []string{"scratch", filepath.Join("src", "pkg", "main.go"), "missing"} {
+			found, err := git.HasDirectory(dir, "", path)
+			require.NoError(t, err)
+			require.False(t, found, "path %q", path)
+		}
+	})
+
+	t.Run("looks in the tree of the given ref", func(t *testing.T) {
+		found, err := git.HasDirectory(dir, "v1", "docs")
+		require.NoError(t, err)
+		require.False(t, found)
+	})
+
+	t.Run("fails when the ref does not resolve", func(t *testing.T) {
+		_, err := git.HasDirectory(dir, "no-such-ref", "src")
+		require.ErrorContains(t, err, `git ref "no-such-ref" does not resolve to a commit`)
+	})
+}
+
+func TestResolveCommit(t *testing.T) {
+	run := func(t *testing.T, dir string, args ...string) string {
+		t.Helper()
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=Test User",
+			"GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=Test User",
+			"GIT_COMMITTER_EMAIL=test@example.com",
+		)
+		output, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(output))
+		return strings.TrimSpace(string(output))
+	}
+
+	dir := t.TempDir()
+	run(t, dir, "init")
+	run(t, dir, "commit", "--allow-empty", "-m", "v1")
+	run(t, dir, "tag", "-a", "v1", "-m", "v1")
+	v1 := run(t, dir, "rev-parse", "HEAD")
+	run(t, dir, "commit", "--allow-empty", "-m", "v2")
+	v2 := run(t, dir, "rev-parse", "HEAD")
+
+	commit, err := git.ResolveCommit(dir, "")
+	require.NoError(t, err)
+	require.Equal(t, v2, commit)
+
+	// Annotated tags resolve to the commit they point at, not the tag object
+	commit, err = git.ResolveCommit(dir, "v1")
+	require.NoError(t, err)
+	require.Equal(t, v1, commit)
+
+	_, err = git.ResolveCommit(dir, "no-such-ref")
+	require.ErrorContains(t, err, `git ref "no-such-ref" does not resolve to a commit`)
+}
+
+func TestArchiveCheckoutDirectory(t *testing.T) {
+	dir := t.TempDir()
+	for _, args := range [][]string{{"init"}, {"commit", "--allow-empty", "-m", "initial commit"}} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=Test User",
+			"GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=Test User",
+			"GIT_COMMITTER_EMAIL=test@example.com",
+		)
+		output, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(output))
+	}
+
+	// Checkouts are created in a directory of their own to find them
+	tempDir := t.TempDir()
+	t.Setenv("TMPDIR", tempDir)
+
+	cleanup := internal.NewCleanupManager()
+	reader, err := git.CreateArchive(git.ArchiveOptions{
+		Path:      dir,
+		Remote:    "http://example.com/repo.git",
+		Branch:    "contagent/1a2b3c4d",
+		SessionID: "contagent-1a2b3c4d",
+		Cleanup:   cleanup,
+	}, internal.NewStandardWriter())
+	require.NoError(t, err)
+
+	checkouts, err := filepath.Glob(filepath.Join(tempDir, "contagent-checkout-1a2b3c4d-*"))
+	require.NoError(t, err)
+	require.Len(t, checkouts, 1, "the checkout directory should be named after the session")
+
+	// The archive is abandoned without being read, as when a run is interrupted
+	cleanup.Execute()
+	require.NoError(t, reader.Close())
+
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(checkouts[0])
+		return os.IsNotExist(err)
+	}, 5*time.Second, 10*time.Millisecond, "the checkout directory should be removed on cleanup")
+}
+
+// archiveEntry is the part of an archived file that must not depend on how the archive
+// was created.
+type archiveEntry struct {
+	Typeflag   byte
+	Executable bool
+	Linkname   string
+	Content    string
+}
+
+// readArchive reads every entry of an archive outside of the .git directory, keyed by name.
+func readArchive(t testing.TB, reader io.Reader) map[string]archiveEntry {
+	t.Helper()
+
+	entries := make(map[string]archiveEntry)
+	tr := tar.NewReader(reader)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+
+		if strings.HasPrefix(header.Name, "app/.git/") {
+			continue
+		}
+
+		content, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		entries[header.Name] = archiveEntry{
+			Typeflag:   header.Typeflag,
+			Executable: header.Typeflag == tar.TypeReg && header.Mode&0100 != 0,
+			Linkname:   header.Linkname,
+			Content:    string(content),
+		}
+	}
+	return entries
+}
+
+func TestStreamArchive(t *testing.T) {
+	run := func(t *testing.T, dir string, args ...string) string {
+		t.Helper()
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=Test User",
+			"GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=Test User",
+			"GIT_COMMITTER_EMAIL=test@example.com",
+		)
+		output, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(output))
+		return strings.TrimSpace(string(output))
+	}
+
+	setup := func(t *testing.T) string {
+		t.Helper()
+
+		dir := t.TempDir()
+		run(t, dir, "init")
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, "cmd", "tool"), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "README.md"), []byte("readme\n"), 0600))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "cmd", "tool", "main.go"), []byte("package main\n"), 0600))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "build.sh"), []byte("#!/bin/sh\n"), 0700)) //nolint:gosec // the script is executable on purpose
+		require.NoError(t, os.Symlink("README.md", filepath.Join(dir, "link.md")))
+
+		// Attributes that only apply to archives must not change what is copied
+		require.NoError(t, os.WriteFile(filepath.Join(dir, ".gitattributes"), []byte("ignored.txt export-ignore\nversion.txt export-subst\n"), 0600))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "ignored.txt"), []byte("still copied\n"), 0600))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "version.txt"), []byte("$Format:%H$\n"), 0600))
+
+		run(t, dir, "add", ".")
+		run(t, dir, "commit", "-m", "initial commit")
+		run(t, dir, "branch", "other")
+
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "README.md"), []byte("readme v2\n"), 0600))
+		run(t, dir, "commit", "-am", "second commit")
+
+		// Uncommitted changes are not copied
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "README.md"), []byte("uncommitted\n"), 0600))
+
+		return dir
+	}
+
+	options := func(dir string) git.ArchiveOptions {
+		return git.ArchiveOptions{
+			Path:         dir,
+			Remote:       "http://example.com/repo.git",
+			Branch:       "contagent/test",
+			GitUserName:  "user",
+			GitUserEmail: "user@example.com",
+			UID:          1001,
+			GID:          1001,
+			DestDir:      "app",
+		}
+	}
+
+	t.Run("archives the same files as a checkout", func(t *testing.T) {
+		dir := setup(t)
+
+		for _, symlinks := range []bool{false, true} {
+			for _, ref := range []string{"", "other"} {
+				opts := options(dir)
+				opts.Ref = ref
+				opts.IncludeSymlinks = symlinks
+
+				reader, err := git.CreateArchive(opts, internal.NewStandardWriter())
+				require.NoError(t, err)
+				expected := readArchive(t, reader)
+				require.NoError(t, reader.Close())
+
+				opts.Stream = true
+				reader, err = git.CreateArchive(opts, internal.NewStandardWriter())
+				require.NoError(t, err)
+				actual := readArchive(t, reader)
+				require.NoError(t, reader.Close())
+
+				require.Equal(t, expected, actual, "ref %q with symlinks %t", ref, symlinks)
+				require.Contains(t, actual, "app/ignored.txt")
+				require.Equal(t, "$Format:%H$\n", actual["app/version.txt"].Content)
+				require.True(t, actual["app/build.sh"].Executable)
+			}
+		}
+	})
+
+	t.Run("sets the ownership of every entry", func(t *testing.T) {
+		opts := options(setup(t))
+		opts.Stream = true
+		opts.IncludeSymlinks = true
+
+		reader, err := git.CreateArchive(opts, internal.NewStandardWriter())
+		require.NoError(t, err)
+		defer reader.Close()
+
+		tr := tar.NewReader(reader)
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+			require.True(t, strings.HasPrefix(header.Name, "app/"), "entry %q should be under app/", header.Name)
+			require.Equal(t, 1001, header.Uid, "uid of %s", header.Name)
+			require.Equal(t, 1001, header.Gid, "gid of %s", header.Name)
+		}
+	})
+
+	t.Run("sets up a repository that can push the session branch", func(t *testing.T) {
+		dir := setup(t)
+		remote := t.TempDir()
+		run(t, remote, "init", "--bare")
+
+		opts := options(dir)
+		opts.Remote = remote
+		opts.Stream = true
+		opts.IncludeSymlinks = true
+
+		reader, err := git.CreateArchive(opts, internal.NewStandardWriter())
+		require.NoError(t, err)
+		defer reader.Close()
+
+		extracted := t.TempDir()
+		tr := tar.NewReader(reader)
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+
+			target := filepath.Join(extracted, header.Name) //nolint:gosec // G305: archive is produced by the test
+			if header.Typeflag == tar.TypeDir {
+				require.NoError(t, os.MkdirAll(target, 0755))
+				continue
+			}
+			require.NoError(t, os.MkdirAll(filepath.Dir(target), 0755))
+			if header.Typeflag == tar.TypeSymlink {
+				require.NoError(t, os.Symlink(header.Linkname, target))
+				continue
+			}
+			content, err := io.ReadAll(tr)
+			require.NoError(t, err)
+			require.NoError(t, os.WriteFile(target, content, os.FileMode(header.Mode))) //nolint:gosec // G115: modes come from the archive
+		}
+
+		app := filepath.Join(extracted, "app")
+		require.Equal(t, "contagent/test", run(t, app, "rev-parse", "--abbrev-ref", "HEAD"))
+		require.Equal(t, run(t, dir, "rev-parse", "HEAD"), run(t, app, "rev-parse", "HEAD"))
+		require.Empty(t, run(t, app, "status", "--porcelain"))
+		require.Equal(t, "user", run(t, app, "config", "user.name"))
+		require.Equal(t, "user@example.com", run(t, app, "config", "user.email"))
+		require.NoFileExists(t, filepath.Join(app, ".git", "info", "attributes"))
+
+		// Only the history of the commit is copied
+		require.Equal(t, "2", run(t, app, "rev-list", "--count", "HEAD"))
+		require.Equal(t, "contagent/test", run(t, app, "for-each-ref", "--format=%(refname:short)", "refs/heads"))
+
+		require.NoError(t, os.WriteFile(filepath.Join(app, "new.txt"), []byte("new\n"), 0600))
+		run(t, app, "add", "new.txt")
+		run(t, app, "commit", "-m", "work in the container")
+		run(t, app, "push")
+		require.Equal(t, run(t, app, "rev-parse", "HEAD"), run(t, remote, "rev-parse", "contagent/test"))
+	})
+
+	t.Run("returns an error when submodules are included", func(t *testing.T) {
+		opts := options(setup(t))
+		opts.Stream = true
+		opts.IncludeSubmodules = true
+
+		_, err := git.CreateArchive(opts, internal.NewStandardWriter())
+		require.ErrorContains(t, err, "submodules cannot be included in a streamed archive")
+	})
+}
+
+func BenchmarkCreateArchive(b *testing.B) {
+	dir := b.TempDir()
+	for i := range 200 {
+		pkg := filepath.Join(dir, fmt.Sprintf("pkg%d", i))
+		require.NoError(b, os.MkdirAll(pkg, 0755))
+		for j := range 50 {
+			content := strings.Repeat(fmt.Sprintf("line %d of file %d\n", j, i), 50)
+			require.NoError(b, os.WriteFile(filepath.Join(pkg, fmt.Sprintf("file%d.txt", j)), []byte(content), 0600))
+		}
+	}
+	// The automatic gc after the commit runs in the foreground, so that it does not pack
+	// objects while they are being copied
+	for _, args := range [][]string{{"init"}, {"add", "."}, {"-c", "gc.autoDetach=false", "commit", "-m", "initial commit"}} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=Test User",
+			"GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=Test User",
+			"GIT_COMMITTER_EMAIL=test@example.com",
+		)
+		output, err := cmd.CombinedOutput()
+		require.NoError(b, err, string(output))
+	}
+
+	for _, stream := range []bool{false, true} {
+		name := "checkout"
+		if stream {
+			name = "stream"
+		}
+
+		b.Run(name, func(b *testing.B) {
+			for b.Loop() {
+				reader, err := git.CreateArchive(git.ArchiveOptions{
+					Path:         dir,
+					Remote:       "http://example.com/repo.git",
+					Branch:       "contagent/bench",
+					GitUserName:  "user",
+					GitUserEmail: "user@example.com",
+					DestDir:      "app",
+					Stream:       stream,
+				}, internal.NewStandardWriter())
+				require.NoError(b, err)
+				_, err = io.Copy(io.Discard, reader)
+				require.NoError(b, err)
+				require.NoError(b, reader.Close())
+			}
+		})
+	}
+}
+
+func TestArchiveWorktree(t *testing.T) {
+	run := func(t *testing.T, dir string, args ...string) string {
+		t.Helper()
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=Test User",
+			"GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=Test User",
+			"GIT_COMMITTER_EMAIL=test@example.com",
+		)
+		output, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(output))
+		return strings.TrimSpace(string(output))
+	}
+
+	// commit adds a commit to the worktree at dir, so that it differs from the main one
+	commit := func(t *testing.T, dir string) {
+		t.Helper()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "feature.txt"), []byte("feature\n"), 0600))
+		run(t, dir, "add", "feature.txt")
+		run(t, dir, "commit", "-m", "feature")
+	}
+
+	setups := map[string]func(t *testing.T) string{
+		"linked worktree": func(t *testing.T) string {
+			main := t.TempDir()
+			run(t, main, "init")
+			require.NoError(t, os.WriteFile(filepath.Join(main, "README.md"), []byte("readme\n"), 0600))
+			run(t, main, "add", ".")
+			run(t, main, "commit", "-m", "initial commit")
+
+			worktree := filepath.Join(t.TempDir(), "feature")
+			run(t, main, "worktree", "add", "-b", "feature", worktree)
+			commit(t, worktree)
+			return worktree
+		},
+		"worktree of a bare repository": func(t *testing.T) string {
+			source := t.TempDir()
+			run(t, source, "init")
+			require.NoError(t, os.WriteFile(filepath.Join(source, "README.md"), []byte("readme\n"), 0600))
+			run(t, source, "add", ".")
+			run(t, source, "commit", "-m", "initial commit")
+
+			bare := t.TempDir()
+			run(t, bare, "clone", "--bare", source, ".")
+
+			worktree := filepath.Join(t.TempDir(), "feature")
+			run(t, bare, "worktree", "add", "-b", "feature", worktree)
+			commit(t, worktree)
+			return worktree
+		},
+	}
+
+	for name, setup := range setups {
+		for _, stream := range []bool{false, true} {
+			t.Run(fmt.Sprintf("archives a valid repository from a %s (stream %t)", name, stream), func(t *testing.T) {
+				worktree := setup(t)
+
+				reader, err := git.CreateArchive(git.ArchiveOptions{
+					Path:         worktree,
+					Remote:       "http://example.com/repo.git",
+					Branch:       "contagent/test",
+					GitUserName:  "user",
+					GitUserEmail: "user@example.com",
+					DestDir:      "app",
+					Stream:       stream,
+				}, internal.NewStandardWriter())
+				require.NoError(t, err)
+				defer reader.Close()
+
+				extracted := t.TempDir()
+				tr := tar.NewReader(reader)
+				for {
+					header, err := tr.Next()
+					if err == io.EOF {
+						break
+					}
+					require.NoError(t, err)
+
+					target := filepath.Join(extracted, header.Name) //nolint:gosec // G305: archive is produced by the test
+					if header.Typeflag == tar.TypeDir {
+						require.NoError(t, os.MkdirAll(target, 0755))
+						continue
+					}
+					content, err := io.ReadAll(tr)
+					require.NoError(t, err)
+					require.NoError(t, os.MkdirAll(filepath.Dir(target), 0755))
+					require.NoError(t, os.WriteFile(target, content, 0600))
+				}
+
+				app := filepath.Join(extracted, "app")
+				require.DirExists(t, filepath.Join(app, ".git"))
+				require.NoDirExists(t, filepath.Join(app, ".git", "worktrees"))
+				require.FileExists(t, filepath.Join(app, "feature.txt"))
+
+				require.Empty(t, run(t, app, "status", "--porcelain"))
+				require.Equal(t, "contagent/test", run(t, app, "rev-parse", "--abbrev-ref", "HEAD"))
+				require.Equal(t, run(t, worktree, "rev-parse", "HEAD"), run(t, app, "rev-parse", "HEAD"))
+				require.Equal(t, "false", run(t, app, "rev-parse", "--is-bare-repository"))
+			})
+		}
+	}
+}
+
+func TestArchiveSize(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "small.txt"), []byte("small\n"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "large.bin"), make([]byte, 2<<20), 0600))
+	for _, args := range [][]string{{"init"}, {"add", "."}, {"commit", "-m", "add a large file"}} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=Test User",
+			"GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=Test User",
+			"GIT_COMMITTER_EMAIL=test@example.com",
+		)
+		output, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(output))
+	}
+
+	options := func() git.ArchiveOptions {
+		return git.ArchiveOptions{
+			Path:         dir,
+			Remote:       "http://example.com/repo.git",
+			Branch:       "contagent/test",
+			GitUserName:  "user",
+			GitUserEmail: "user@example.com",
+		}
+	}
+
+	t.Run("warns when the files exceed the warning size", func(t *testing.T) {
+		opts := options()
+		opts.WarnSize = 1 << 20
+
+		var stderr strings.Builder
+		reader, err := git.CreateArchive(opts, internal.NewCustomWriter(io.Discard, &stderr))
+		require.NoError(t, err)
+		_, err = io.Copy(io.Discard, reader)
+		require.NoError(t, err)
+		require.NoError(t, reader.Close())
+
+		require.Contains(t, stderr.String(), "Warning: the files of commit")
+		require.Contains(t, stderr.String(), "add up to 2MiB, so copying them into the container may be slow")
+	})
+
+	t.Run("does not warn below the warning size", func(t *testing.T) {
+		var stderr strings.Builder
+		reader, err := git.CreateArchive(options(), internal.NewCustomWriter(io.Discard, &stderr))
+		require.NoError(t, err)
+		require.NoError(t, reader.Close())
+
+		require.Empty(t, stderr.String())
+	})
+
+	t.Run("returns an error when the files exceed the maximum size", func(t *testing.T) {
+		opts := options()
+		opts.MaxSize = 1 << 20
+
+		_, err := git.CreateArchive(opts, internal.NewDiscardWriter())
+		require.ErrorContains(t, err, "add up to 2MiB, over the limit of 1MiB")
+	})
+
+	t.Run("archives files within the maximum size", func(t *testing.T) {
+		opts := options()
+		opts.MaxSize = 3 << 20
+
+		reader, err := git.CreateArchive(opts, internal.NewDiscardWriter())
+		require.NoError(t, err)
+		_, err = io.Copy(io.Discard, reader)
+		require.NoError(t, err)
+		require.NoError(t, reader.Close())
+	})
+}
+
+func TestArchiveNoGit(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "subdir"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "test.txt"), []byte("test content\n"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "subdir", "nested.txt"), []byte("nested content\n"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".gitattributes"), []byte("test.txt export-ignore\n"), 0600))
+	for _, args := range [][]string{{"init"}, {"add", "."}, {"commit", "-m", "initial commit"}} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=Test User",
+			"GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=Test User",
+			"GIT_COMMITTER_EMAIL=test@example.com",
+		)
+		output, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(output))
+	}
+
+	reader, err := git.CreateArchive(git.ArchiveOptions{
+		Path:    dir,
+		UID:     1001,
+		GID:     1001,
+		DestDir: "app",
+		NoGit:   true,
+	}, internal.NewStandardWriter())
+	require.NoError(t, err)
+	defer reader.Close()
+
+	files := make(map[string]string)
+	tr := tar.NewReader(reader)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		require.Equal(t, 1001, header.Uid, "uid of %s", header.Name)
+		require.Equal(t, 1001, header.Gid, "gid of %s", header.Name)
+
+		content, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		files[header.Name] = string(content)
+	}
+
+	for name := range files {
+		require.False(t, strings.HasPrefix(name, "app/.git/"), "archive should not contain %s", name)
+	}
+	require.Contains(t, files, "app/")
+	require.Contains(t, files, "app/subdir/")
+	require.Equal(t, "test content\n", files["app/test.txt"])
+	require.Equal(t, "nested content\n", files["app/subdir/nested.txt"])
+
+	// The source repository is left as it was
+	require.NoFileExists(t, filepath.Join(dir, ".git", "info", "attributes"))
+	require.NoFileExists(t, filepath.Join(dir, ".git", "objects", "info", "alternates"))
+}
+
+func TestArchiveResetBranch(t *testing.T) {
+	run := func(t *testing.T, dir string, args ...string) string {
+		t.Helper()
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=Test User",
+			"GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=Test User",
+			"GIT_COMMITTER_EMAIL=test@example.com",
+		)
+		output, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(output))
+		return strings.TrimSpace(string(output))
+	}
+
+	// The session branch of a previous run with the same name was fetched back into
+	// the repository, which has moved on since
+	dir := t.TempDir()
+	run(t, dir, "init")
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "test.txt"), []byte("first\n"), 0600))
+	run(t, dir, "add", ".")
+	run(t, dir, "commit", "-m", "first commit")
+	run(t, dir, "branch", "contagent/rerun")
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "test.txt"), []byte("second\n"), 0600))
+	run(t, dir, "commit", "-am", "second commit")
+	head := run(t, dir, "rev-parse", "HEAD")
+
+	archive := func(reset bool) (map[string]string, error) {
+		reader, err := git.CreateArchive(git.ArchiveOptions{
+			Path:         dir,
+			Remote:       "http://example.com/repo.git",
+			Branch:       "contagent/rerun",
+			GitUserName:  "user",
+			GitUserEmail: "user@example.com",
+			DestDir:      "app",
+			ResetBranch:  reset,
+		}, internal.NewDiscardWriter())
+		require.NoError(t, err)
+		defer reader.Close()
+
+		files := make(map[string]string)
+		tr := tar.NewReader(reader)
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				return files, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+
+			content, err := io.ReadAll(tr)
+			require.NoError(t, err)
+			files[header.Name] = string(content)
+		}
+	}
+
+	_, err := archive(false)
+	require.ErrorContains(t, err, "use --reset-branch")
+
+	for range 2 {
+		files, err := archive(true)
+		require.NoError(t, err)
+		require.Equal(t, "ref: refs/heads/contagent/rerun\n", files["app/.git/HEAD"])
+		require.Equal(t, head+"\n", files["app/.git/refs/heads/contagent/rerun"])
+		require.Equal(t, "second\n", files["app/test.txt"])
+	}
+
+	// The branch on the host is left as it was
+	require.NotEqual(t, head, run(t, dir, "rev-parse", "contagent/rerun"))
+}
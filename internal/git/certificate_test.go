@@ -0,0 +1,68 @@
+package git_test
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ryanmoran/contagent/internal/git"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateCertificate(t *testing.T) {
+	certificate, err := git.GenerateCertificate("127.0.0.1", "localhost")
+	require.NoError(t, err)
+
+	leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+	require.NoError(t, err)
+	require.Equal(t, []string{"localhost"}, leaf.DNSNames)
+	require.Len(t, leaf.IPAddresses, 1)
+	require.True(t, leaf.IPAddresses[0].Equal(net.ParseIP("127.0.0.1")))
+	require.Equal(t, []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}, leaf.ExtKeyUsage)
+	require.NoError(t, leaf.VerifyHostname("localhost"))
+}
+
+func TestLoadCertificate(t *testing.T) {
+	// writePEM writes a generated certificate and its key as PEM files
+	writePEM := func(t *testing.T) (string, string) {
+		t.Helper()
+
+		certificate, err := git.GenerateCertificate("localhost")
+		require.NoError(t, err)
+
+		key, err := x509.MarshalPKCS8PrivateKey(certificate.PrivateKey)
+		require.NoError(t, err)
+
+		dir := t.TempDir()
+		certFile, keyFile := filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+		require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate.Certificate[0]}), 0600))
+		require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}), 0600))
+		return certFile, keyFile
+	}
+
+	t.Run("loads a certificate and its key", func(t *testing.T) {
+		certFile, keyFile := writePEM(t)
+
+		certificate, err := git.LoadCertificate(certFile, keyFile)
+		require.NoError(t, err)
+		require.Len(t, certificate.Certificate, 1)
+	})
+
+	t.Run("returns an error when the key does not match the certificate", func(t *testing.T) {
+		certFile, _ := writePEM(t)
+		_, otherKey := writePEM(t)
+
+		_, err := git.LoadCertificate(certFile, otherKey)
+		require.ErrorContains(t, err, "failed to load TLS certificate "+certFile)
+	})
+
+	t.Run("returns an error when a file is missing", func(t *testing.T) {
+		_, keyFile := writePEM(t)
+
+		_, err := git.LoadCertificate(filepath.Join(t.TempDir(), "missing.crt"), keyFile)
+		require.ErrorContains(t, err, "Check that both files exist")
+	})
+}
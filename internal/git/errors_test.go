@@ -1,6 +1,7 @@
 package git_test
 
 import (
+	"context"
 	"io"
 	"os"
 	"os/exec"
@@ -22,7 +23,7 @@ func TestGitServerErrorCases(t *testing.T) {
 
 			// NewServer no longer validates that the path is a git repo;
 			// callers (e.g. main.go) are expected to resolve the git root first.
-			server, err := git.NewServer(dir, internal.NewStandardWriter())
+			server, err := git.NewServer(context.Background(), dir, git.ServerOptions{}, internal.NewStandardWriter())
 			require.NoError(t, err)
 			server.Close()
 		})
@@ -30,7 +31,7 @@ func TestGitServerErrorCases(t *testing.T) {
 		t.Run("non-existent directory", func(t *testing.T) {
 			// NewServer no longer validates path existence upfront.
 			// The server will start but fail when handling requests.
-			server, err := git.NewServer("/nonexistent/path/to/repo", internal.NewStandardWriter())
+			server, err := git.NewServer(context.Background(), "/nonexistent/path/to/repo", git.ServerOptions{}, internal.NewStandardWriter())
 			if err == nil {
 				server.Close()
 			}
@@ -63,13 +64,13 @@ func TestGitServerErrorCases(t *testing.T) {
 			require.NoError(t, os.Chdir(subDir))
 
 			// Try to create server with relative path
-			_, err = git.NewServer("..", internal.NewStandardWriter())
+			_, err = git.NewServer(context.Background(), "..", git.ServerOptions{}, internal.NewStandardWriter())
 			require.NoError(t, err) // Should succeed with relative path resolution
 		})
 	})
 
 	t.Run("Server operation error cases", func(t *testing.T) {
-		t.Run("double close doesn't panic", func(t *testing.T) {
+		t.Run("double close returns nil", func(t *testing.T) {
 			dir, err := os.MkdirTemp("", "git-close-test")
 			require.NoError(t, err)
 			defer os.RemoveAll(dir)
@@ -78,14 +79,15 @@ func TestGitServerErrorCases(t *testing.T) {
 			cmd.Dir = dir
 			require.NoError(t, cmd.Run())
 
-			server, err := git.NewServer(dir, internal.NewStandardWriter())
+			server, err := git.NewServer(context.Background(), dir, git.ServerOptions{}, internal.NewStandardWriter())
 			require.NoError(t, err)
 
 			err = server.Close()
 			require.NoError(t, err)
 
-			// Second close should not panic (may return error)
-			_ = server.Close()
+			// Close is idempotent, so cleanup and deferred closes can both call it
+			require.NoError(t, server.Close())
+			require.NoError(t, server.Shutdown(context.Background()))
 		})
 	})
 }
@@ -99,8 +101,8 @@ func TestGitArchiveErrorCases(t *testing.T) {
 			defer os.RemoveAll(dir)
 
 			// CreateArchive no longer calls FindRoot; callers must pass the git root.
-			// The error is deferred to read time when buildArchive fails to find .git.
-			reader, err := git.CreateArchive(git.ArchiveOptions{
+			// Resolving the ref fails up front outside of a repository.
+			_, err = git.CreateArchive(git.ArchiveOptions{
 				Path:         dir,
 				Remote:       "http://example.com",
 				Branch:       "branch",
@@ -110,16 +112,12 @@ func TestGitArchiveErrorCases(t *testing.T) {
 				GID:          0,
 				DestDir:      "",
 			}, internal.NewStandardWriter())
-			require.NoError(t, err)
-			defer reader.Close()
-			_, err = io.ReadAll(reader)
 			require.Error(t, err)
-			require.Contains(t, err.Error(), "failed to copy .git directory")
+			require.Contains(t, err.Error(), "does not resolve to a commit")
 		})
 
 		t.Run("non-existent directory", func(t *testing.T) {
-			// Error is deferred to read time.
-			reader, err := git.CreateArchive(git.ArchiveOptions{
+			_, err := git.CreateArchive(git.ArchiveOptions{
 				Path:         "/nonexistent/path",
 				Remote:       "http://example.com",
 				Branch:       "branch",
@@ -129,9 +127,6 @@ func TestGitArchiveErrorCases(t *testing.T) {
 				GID:          0,
 				DestDir:      "",
 			}, internal.NewStandardWriter())
-			require.NoError(t, err)
-			defer reader.Close()
-			_, err = io.ReadAll(reader)
 			require.Error(t, err)
 		})
 
@@ -145,7 +140,7 @@ func TestGitArchiveErrorCases(t *testing.T) {
 			cmd.Dir = dir
 			require.NoError(t, cmd.Run())
 
-			reader, err := git.CreateArchive(git.ArchiveOptions{
+			_, err = git.CreateArchive(git.ArchiveOptions{
 				Path:         dir,
 				Remote:       "http://example.com",
 				Branch:       "branch",
@@ -155,14 +150,8 @@ func TestGitArchiveErrorCases(t *testing.T) {
 				GID:          0,
 				DestDir:      "",
 			}, internal.NewStandardWriter())
-			require.NoError(t, err) // Returns immediately
-			if reader != nil {
-				defer reader.Close()
-				// Error occurs when reading
-				_, err = io.ReadAll(reader)
-				require.Error(t, err)
-				require.Contains(t, err.Error(), "failed to checkout HEAD in temporary repo")
-			}
+			require.Error(t, err)
+			require.Contains(t, err.Error(), `git ref "HEAD" does not resolve to a commit`)
 		})
 
 		t.Run("directory with permission denied", func(t *testing.T) {
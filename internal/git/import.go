@@ -0,0 +1,119 @@
+package git
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// ImportBranch fetches branch into the repository at path from a copy of another
+// repository's .git directory, such as the one copied out of a container. The content
+// must be a tar archive whose entries are rooted at ".git/". The branch is only
+// fast-forwarded, never overwritten.
+//
+// Returns false without changing the repository when the branch has no commits that
+// the repository does not already have, for example because nothing was committed in
+// the container. Returns an error if the archive cannot be extracted, the branch does
+// not exist in it, or the fetch fails.
+func ImportBranch(path string, content io.Reader, branch string) (bool, error) {
+	tempDir, err := os.MkdirTemp("", "contagent-import-*")
+	if err != nil {
+		return false, fmt.Errorf("failed to create temporary directory: %w\nCheck disk space and /tmp permissions", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	if err := extractArchive(content, tempDir); err != nil {
+		return false, fmt.Errorf("failed to extract git repository: %w", err)
+	}
+
+	source := filepath.Join(tempDir, ".git")
+	ref := "refs/heads/" + branch
+
+	cmd := exec.Command("git", "--git-dir", source, "rev-parse", "--verify", "--quiet", ref) //nolint:gosec // args are controlled by internal config, not user input
+	output, err := cmd.Output()
+	if err != nil {
+		return false, fmt.Errorf("branch %q not found in the container's repository: %w\nThe branch may have been renamed or deleted", branch, err)
+	}
+	commit := strings.TrimSpace(string(output))
+
+	// The commit is already present when nothing new was committed, or when it was
+	// pushed through the git server already
+	cmd = exec.Command("git", "cat-file", "-e", commit+"^{commit}") //nolint:gosec // commit is a hash produced by git rev-parse
+	cmd.Dir = path
+	if err := cmd.Run(); err == nil {
+		return false, nil
+	}
+
+	cmd = exec.Command("git", "fetch", "--no-tags", source, ref+":"+ref) //nolint:gosec // args are controlled by internal config, not user input
+	cmd.Dir = path
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return false, fmt.Errorf("failed to fetch branch %q: %w\n%s", branch, err, output)
+	}
+
+	return true, nil
+}
+
+// extractArchive writes the directories, regular files, and symlinks of a tar archive
+// below dst. The archive comes from the container, where arbitrary code runs, so every
+// write goes through an os.Root that refuses paths leaving dst, including through
+// symlinks, and symlinks whose target would leave dst are rejected.
+func extractArchive(content io.Reader, dst string) error {
+	root, err := os.OpenRoot(dst)
+	if err != nil {
+		return fmt.Errorf("failed to open destination %s: %w", dst, err)
+	}
+	defer root.Close()
+
+	tr := tar.NewReader(content)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read archive: %w", err)
+		}
+
+		name := filepath.FromSlash(header.Name)
+		if !filepath.IsLocal(name) {
+			return fmt.Errorf("archive entry %q is outside of the destination", header.Name)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := root.MkdirAll(name, 0755); err != nil {
+				return fmt.Errorf("failed to create directory %s: %w", header.Name, err)
+			}
+		case tar.TypeReg:
+			if err := root.MkdirAll(filepath.Dir(name), 0755); err != nil {
+				return fmt.Errorf("failed to create directory for %s: %w", header.Name, err)
+			}
+			file, err := root.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(header.Mode).Perm()|0600) //nolint:gosec // G115: tar modes fit in a FileMode
+			if err != nil {
+				return fmt.Errorf("failed to create file %s: %w", header.Name, err)
+			}
+			_, err = io.Copy(file, tr) //nolint:gosec // G110: the size is bounded by what the container can write
+			file.Close()
+			if err != nil {
+				return fmt.Errorf("failed to write file %s: %w", header.Name, err)
+			}
+		case tar.TypeSymlink:
+			// The target is resolved relative to the directory of the link
+			target := filepath.FromSlash(header.Linkname)
+			if filepath.IsAbs(target) || !filepath.IsLocal(filepath.Join(filepath.Dir(name), target)) {
+				return fmt.Errorf("archive entry %q links to %q, outside of the destination", header.Name, header.Linkname)
+			}
+			if err := root.MkdirAll(filepath.Dir(name), 0755); err != nil {
+				return fmt.Errorf("failed to create directory for %s: %w", header.Name, err)
+			}
+			if err := root.Symlink(header.Linkname, name); err != nil {
+				return fmt.Errorf("failed to create symlink %s: %w", header.Name, err)
+			}
+		}
+	}
+}
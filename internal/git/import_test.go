@@ -0,0 +1,148 @@
+package git_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ryanmoran/contagent/internal/git"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImportBranch(t *testing.T) {
+	run := func(t *testing.T, dir string, args ...string) string {
+		t.Helper()
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=Test User",
+			"GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=Test User",
+			"GIT_COMMITTER_EMAIL=test@example.com",
+		)
+		output, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(output))
+		return strings.TrimSpace(string(output))
+	}
+
+	// setup returns a host repository and a clone of it standing in for the
+	// container's repository, with the session branch checked out.
+	setup := func(t *testing.T) (string, string) {
+		t.Helper()
+
+		host := t.TempDir()
+		run(t, host, "init")
+		require.NoError(t, os.WriteFile(filepath.Join(host, "file.txt"), []byte("host\n"), 0600))
+		run(t, host, "add", ".")
+		run(t, host, "commit", "-m", "initial")
+
+		container := filepath.Join(t.TempDir(), "app")
+		run(t, host, "clone", host, container)
+		run(t, container, "checkout", "-b", "contagent/test")
+
+		return host, container
+	}
+
+	// archive tars up the .git directory of dir the way the runtimes copy it out.
+	archive := func(t *testing.T, dir string) *bytes.Buffer {
+		t.Helper()
+		cmd := exec.Command("tar", "cf", "-", "-C", dir, ".git")
+		output, err := cmd.Output()
+		require.NoError(t, err)
+		return bytes.NewBuffer(output)
+	}
+
+	t.Run("fetches new commits onto the host branch", func(t *testing.T) {
+		host, container := setup(t)
+		require.NoError(t, os.WriteFile(filepath.Join(container, "file.txt"), []byte("container\n"), 0600))
+		run(t, container, "commit", "-am", "from container")
+		commit := run(t, container, "rev-parse", "HEAD")
+
+		fetched, err := git.ImportBranch(host, archive(t, container), "contagent/test")
+		require.NoError(t, err)
+		require.True(t, fetched)
+
+		require.Equal(t, commit, run(t, host, "rev-parse", "refs/heads/contagent/test"))
+		require.Equal(t, "container", run(t, host, "show", "contagent/test:file.txt"))
+	})
+
+	t.Run("reports when the branch has no new commits", func(t *testing.T) {
+		host, container := setup(t)
+
+		fetched, err := git.ImportBranch(host, archive(t, container), "contagent/test")
+		require.NoError(t, err)
+		require.False(t, fetched)
+
+		cmd := exec.Command("git", "rev-parse", "--verify", "--quiet", "refs/heads/contagent/test")
+		cmd.Dir = host
+		require.Error(t, cmd.Run(), "the branch should not be created")
+	})
+
+	t.Run("returns an error when the branch does not exist", func(t *testing.T) {
+		host, container := setup(t)
+
+		_, err := git.ImportBranch(host, archive(t, container), "contagent/missing")
+		require.ErrorContains(t, err, `branch "contagent/missing" not found`)
+	})
+
+	t.Run("rejects entries outside of the destination", func(t *testing.T) {
+		host, _ := setup(t)
+
+		var buffer bytes.Buffer
+		tw := tar.NewWriter(&buffer)
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: "../escape", Mode: 0600, Size: 0}))
+		require.NoError(t, tw.Close())
+
+		_, err := git.ImportBranch(host, &buffer, "contagent/test")
+		require.ErrorContains(t, err, `archive entry "../escape" is outside of the destination`)
+	})
+
+	t.Run("rejects symlinks that lead outside of the destination", func(t *testing.T) {
+		host, _ := setup(t)
+		outside := t.TempDir()
+
+		var buffer bytes.Buffer
+		tw := tar.NewWriter(&buffer)
+		require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeSymlink, Name: ".git/x", Linkname: outside}))
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: ".git/x/.bashrc", Mode: 0600, Size: 4}))
+		_, err := tw.Write([]byte("evil"))
+		require.NoError(t, err)
+		require.NoError(t, tw.Close())
+
+		_, err = git.ImportBranch(host, &buffer, "contagent/test")
+		require.ErrorContains(t, err, `archive entry ".git/x" links to`)
+		require.NoFileExists(t, filepath.Join(outside, ".bashrc"))
+
+		buffer.Reset()
+		tw = tar.NewWriter(&buffer)
+		require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeSymlink, Name: ".git/hooks/x", Linkname: "../../.."}))
+		require.NoError(t, tw.Close())
+
+		_, err = git.ImportBranch(host, &buffer, "contagent/test")
+		require.ErrorContains(t, err, `archive entry ".git/hooks/x" links to "../../.."`)
+	})
+
+	t.Run("refuses to write through symlinks that chain outside of the destination", func(t *testing.T) {
+		host, _ := setup(t)
+		name := "contagent-escape-" + filepath.Base(t.TempDir())
+
+		// Each target looks local on its own, but ".git/l" is the destination itself,
+		// so "m" points at the directory that holds it
+		var buffer bytes.Buffer
+		tw := tar.NewWriter(&buffer)
+		require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeSymlink, Name: ".git/l", Linkname: ".."}))
+		require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeSymlink, Name: ".git/l/m", Linkname: ".."}))
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: "m/" + name, Mode: 0600, Size: 4}))
+		_, err := tw.Write([]byte("evil"))
+		require.NoError(t, err)
+		require.NoError(t, tw.Close())
+
+		_, err = git.ImportBranch(host, &buffer, "contagent/test")
+		require.ErrorContains(t, err, "path escapes from parent")
+		require.NoFileExists(t, filepath.Join(os.TempDir(), name))
+	})
+}
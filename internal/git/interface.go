@@ -0,0 +1,340 @@
+package git
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Git is an interface that wraps the git commands used to prepare and archive the
+// repository that CreateArchive copies into the container. Each method runs in the repository at dir.
+// This allows for dependency injection and testing with mocks.
+//
+// ExecGit, which runs the git binary, implements this interface and is used when
+// ArchiveOptions.Git is nil.
+//
+// Usage:
+//
+//	// Production code: run the git binary
+//	reader, err := git.CreateArchive(git.ArchiveOptions{Path: root}, w)
+//
+//	// Test code: inject a mock
+//	type mockGit struct{}
+//	func (m *mockGit) RevParse(...) { /* mock implementation */ }
+//	// ... implement other methods ...
+//	reader, err := git.CreateArchive(git.ArchiveOptions{Path: root, Git: &mockGit{}}, w)
+type Git interface {
+	// RevParse runs "git rev-parse" with args and returns its output without the
+	// trailing newline.
+	RevParse(dir string, args ...string) (string, error)
+	// LsTree lists the files in the tree of commit, recursively.
+	LsTree(dir, commit string) ([]TreeEntry, error)
+	// Checkout checks out commit with a detached HEAD, discarding local changes.
+	Checkout(dir, commit string) error
+	// RemoteRemove removes the remote called name. Removing a remote that does not
+	// exist is not an error.
+	RemoteRemove(dir, name string) error
+	// RemoteAdd adds a remote called name that points at url.
+	RemoteAdd(dir, name, url string) error
+	// Config sets the repository config key to value.
+	Config(dir, key, value string) error
+	// CheckoutBranch creates branch at HEAD and checks it out. An existing branch is
+	// an error unless reset is set, in which case it is reset to HEAD.
+	CheckoutBranch(dir, branch string, reset bool) error
+	// LsFiles lists the tracked files, including those of submodules when
+	// recurseSubmodules is set.
+	LsFiles(dir string, recurseSubmodules bool) ([]string, error)
+	// Init creates an empty repository.
+	Init(dir string) error
+	// Fetch fetches commit, and the objects reachable from it, from the repository at
+	// source, without tags.
+	Fetch(dir, source, commit string) error
+	// UpdateRef points ref at commit.
+	UpdateRef(dir, ref, commit string) error
+	// SymbolicRef points the symbolic ref name, such as HEAD, at ref.
+	SymbolicRef(dir, name, ref string) error
+	// ReadTree replaces the index with the tree of commit.
+	ReadTree(dir, commit string) error
+	// Archive streams a tar archive of the tree of commit. Closing the reader waits for
+	// the archive to be written and returns its error.
+	Archive(dir, commit string) (io.ReadCloser, error)
+	// CatFileType returns the type of object, such as "tree" or "blob".
+	CatFileType(dir, object string) (string, error)
+	// Submodules lists the submodules, recursively.
+	Submodules(dir string) ([]Submodule, error)
+	// SubmoduleUpdate checks out every initialized submodule at its recorded commit,
+	// recursively.
+	SubmoduleUpdate(dir string) error
+}
+
+// TreeEntry is a file in the tree of a commit, as listed by "git ls-tree -l".
+type TreeEntry struct {
+	Mode string
+	Type string
+	Size int64
+	Path string
+}
+
+// Submodule is a submodule of a repository, as listed by "git submodule status".
+type Submodule struct {
+	Path        string
+	Initialized bool
+}
+
+// ExecGit implements Git by running the git binary.
+type ExecGit struct{}
+
+// RevParse runs "git rev-parse" with args and returns its output without the trailing
+// newline.
+func (ExecGit) RevParse(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"rev-parse"}, args...)...)
+	cmd.Dir = dir
+	output, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// LsTree lists the files in the tree of commit, recursively. Submodules are listed as
+// entries of type "commit" with a size of zero.
+func (ExecGit) LsTree(dir, commit string) ([]TreeEntry, error) {
+	cmd := exec.Command("git", "ls-tree", "-r", "-l", "-z", "--full-tree", commit) //nolint:gosec // commit is a resolved hash
+	cmd.Dir = dir
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []TreeEntry
+	for line := range strings.SplitSeq(string(output), "\x00") {
+		// Each line is "<mode> <type> <object> <size>\t<path>", with a size of "-"
+		// for submodules
+		info, path, found := strings.Cut(line, "\t")
+		if !found {
+			continue
+		}
+		fields := strings.Fields(info)
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("unexpected entry %q", line)
+		}
+
+		var size int64
+		if fields[3] != "-" {
+			size, err = strconv.ParseInt(fields[3], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("unexpected size %q of %s: %w", fields[3], path, err)
+			}
+		}
+		entries = append(entries, TreeEntry{Mode: fields[0], Type: fields[1], Size: size, Path: path})
+	}
+	return entries, nil
+}
+
+// Checkout checks out commit with a detached HEAD, discarding local changes.
+func (ExecGit) Checkout(dir, commit string) error {
+	cmd := exec.Command("git", "checkout", "--force", "--detach", commit)
+	cmd.Dir = dir
+	return cmd.Run()
+}
+
+// RemoteRemove removes the remote called name. Removing a remote that does not exist
+// is not an error.
+func (ExecGit) RemoteRemove(dir, name string) error {
+	cmd := exec.Command("git", "remote", "remove", name)
+	cmd.Dir = dir
+	err := cmd.Run()
+	if exitError, ok := errors.AsType[*exec.ExitError](err); ok && exitError.ExitCode() == 2 {
+		return nil
+	}
+	return err
+}
+
+// RemoteAdd adds a remote called name that points at url.
+func (ExecGit) RemoteAdd(dir, name, url string) error {
+	cmd := exec.Command("git", "remote", "add", name, url) //nolint:gosec // args are controlled by internal config, not user input
+	cmd.Dir = dir
+	return cmd.Run()
+}
+
+// Config sets the repository config key to value.
+func (ExecGit) Config(dir, key, value string) error {
+	cmd := exec.Command("git", "config", key, value) //nolint:gosec // args are controlled by internal config, not user input
+	cmd.Dir = dir
+	return cmd.Run()
+}
+
+// CheckoutBranch creates branch at HEAD and checks it out. An existing branch is an
+// error unless reset is set, in which case it is reset to HEAD.
+func (ExecGit) CheckoutBranch(dir, branch string, reset bool) error {
+	create := "-b"
+	if reset {
+		create = "-B"
+	}
+	cmd := exec.Command("git", "checkout", create, branch) //nolint:gosec // args are controlled by internal config, not user input
+	cmd.Dir = dir
+	return cmd.Run()
+}
+
+// LsFiles lists the tracked files, including those of submodules when
+// recurseSubmodules is set.
+func (ExecGit) LsFiles(dir string, recurseSubmodules bool) ([]string, error) {
+	args := []string{"ls-files"}
+	if recurseSubmodules {
+		args = append(args, "--recurse-submodules")
+	}
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	scanner := bufio.NewScanner(strings.NewReader(string(output)))
+	for scanner.Scan() {
+		if path := scanner.Text(); path != "" {
+			paths = append(paths, path)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return paths, nil
+}
+
+// Init creates an empty repository.
+func (ExecGit) Init(dir string) error {
+	cmd := exec.Command("git", "init", "--quiet")
+	cmd.Dir = dir
+	return runWithOutput(cmd)
+}
+
+// Fetch fetches commit, and the objects reachable from it, from the repository at
+// source, without tags.
+func (ExecGit) Fetch(dir, source, commit string) error {
+	cmd := exec.Command("git", "fetch", "--quiet", "--no-tags", "--", source, commit) //nolint:gosec // commit is a resolved hash
+	cmd.Dir = dir
+	return runWithOutput(cmd)
+}
+
+// UpdateRef points ref at commit.
+func (ExecGit) UpdateRef(dir, ref, commit string) error {
+	cmd := exec.Command("git", "update-ref", ref, commit) //nolint:gosec // args are controlled by internal config, not user input
+	cmd.Dir = dir
+	return cmd.Run()
+}
+
+// SymbolicRef points the symbolic ref name, such as HEAD, at ref.
+func (ExecGit) SymbolicRef(dir, name, ref string) error {
+	cmd := exec.Command("git", "symbolic-ref", name, ref) //nolint:gosec // args are controlled by internal config, not user input
+	cmd.Dir = dir
+	return cmd.Run()
+}
+
+// ReadTree replaces the index with the tree of commit.
+func (ExecGit) ReadTree(dir, commit string) error {
+	cmd := exec.Command("git", "read-tree", commit) //nolint:gosec // commit is a resolved hash
+	cmd.Dir = dir
+	return cmd.Run()
+}
+
+// Archive streams a tar archive of the tree of commit, with the permissions a checkout
+// gets under the usual umask. Closing the reader waits for "git archive" to exit and
+// returns its error along with what it printed.
+func (ExecGit) Archive(dir, commit string) (io.ReadCloser, error) {
+	// A umask of 022 gives the same permissions as a checkout with the usual umask
+	cmd := exec.Command("git", "-c", "tar.umask=022", "archive", "--format=tar", commit) //nolint:gosec // commit is a resolved hash
+	cmd.Dir = dir
+	stderr := &strings.Builder{}
+	cmd.Stderr = stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &archiveReader{stdout: stdout, cmd: cmd, stderr: stderr}, nil
+}
+
+// CatFileType returns the type of object, such as "tree" or "blob".
+func (ExecGit) CatFileType(dir, object string) (string, error) {
+	cmd := exec.Command("git", "cat-file", "-t", object) //nolint:gosec // object names a resolved commit
+	cmd.Dir = dir
+	output, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// Submodules lists the submodules, recursively.
+func (ExecGit) Submodules(dir string) ([]Submodule, error) {
+	cmd := exec.Command("git", "submodule", "status", "--recursive")
+	cmd.Dir = dir
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+
+	var submodules []Submodule
+	scanner := bufio.NewScanner(strings.NewReader(string(output)))
+	for scanner.Scan() {
+		// Each line is "<state><sha1> <path>[ (<describe>)]"
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		fields := strings.Fields(line[1:])
+		if len(fields) < 2 {
+			continue
+		}
+		submodules = append(submodules, Submodule{Path: fields[1], Initialized: line[0] != '-'})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return submodules, nil
+}
+
+// SubmoduleUpdate checks out every initialized submodule at its recorded commit,
+// recursively.
+func (ExecGit) SubmoduleUpdate(dir string) error {
+	cmd := exec.Command("git", "submodule", "update", "--recursive")
+	cmd.Dir = dir
+	return runWithOutput(cmd)
+}
+
+// runWithOutput runs cmd and returns an error that includes its output when it fails.
+func runWithOutput(cmd *exec.Cmd) error {
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%w\n%s", err, output)
+	}
+	return nil
+}
+
+// archiveReader reads the output of "git archive" and waits for it to exit on Close.
+type archiveReader struct {
+	stdout io.ReadCloser
+	cmd    *exec.Cmd
+	stderr *strings.Builder
+}
+
+func (r *archiveReader) Read(p []byte) (int, error) {
+	return r.stdout.Read(p)
+}
+
+func (r *archiveReader) Close() error {
+	// Drain the output so that git archive can exit
+	io.Copy(io.Discard, r.stdout) //nolint:errcheck // the error of git archive is reported instead
+
+	if err := r.cmd.Wait(); err != nil {
+		return fmt.Errorf("%w\n%s", err, strings.TrimSpace(r.stderr.String()))
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+package git_test
+
+import (
+	"github.com/ryanmoran/contagent/internal/git"
+)
+
+// Compile-time checks that ExecGit and the mock implement the Git interface
+var (
+	_ git.Git = git.ExecGit{}
+	_ git.Git = (*mockGit)(nil)
+)
@@ -0,0 +1,148 @@
+package git_test
+
+import (
+	"errors"
+	"io"
+
+	"github.com/ryanmoran/contagent/internal/git"
+)
+
+// mockGit is a mock implementation of git.Git for testing
+type mockGit struct {
+	revParseFunc        func(dir string, args ...string) (string, error)
+	lsTreeFunc          func(dir, commit string) ([]git.TreeEntry, error)
+	checkoutFunc        func(dir, commit string) error
+	remoteRemoveFunc    func(dir, name string) error
+	remoteAddFunc       func(dir, name, url string) error
+	configFunc          func(dir, key, value string) error
+	checkoutBranchFunc  func(dir, branch string, reset bool) error
+	lsFilesFunc         func(dir string, recurseSubmodules bool) ([]string, error)
+	initFunc            func(dir string) error
+	fetchFunc           func(dir, source, commit string) error
+	updateRefFunc       func(dir, ref, commit string) error
+	symbolicRefFunc     func(dir, name, ref string) error
+	readTreeFunc        func(dir, commit string) error
+	archiveFunc         func(dir, commit string) (io.ReadCloser, error)
+	catFileTypeFunc     func(dir, object string) (string, error)
+	submodulesFunc      func(dir string) ([]git.Submodule, error)
+	submoduleUpdateFunc func(dir string) error
+}
+
+func (m *mockGit) RevParse(dir string, args ...string) (string, error) {
+	if m.revParseFunc != nil {
+		return m.revParseFunc(dir, args...)
+	}
+	return "", errors.New("not implemented")
+}
+
+func (m *mockGit) LsTree(dir, commit string) ([]git.TreeEntry, error) {
+	if m.lsTreeFunc != nil {
+		return m.lsTreeFunc(dir, commit)
+	}
+	return nil, errors.New("not implemented")
+}
+
+func (m *mockGit) Checkout(dir, commit string) error {
+	if m.checkoutFunc != nil {
+		return m.checkoutFunc(dir, commit)
+	}
+	return errors.New("not implemented")
+}
+
+func (m *mockGit) RemoteRemove(dir, name string) error {
+	if m.remoteRemoveFunc != nil {
+		return m.remoteRemoveFunc(dir, name)
+	}
+	return errors.New("not implemented")
+}
+
+func (m *mockGit) RemoteAdd(dir, name, url string) error {
+	if m.remoteAddFunc != nil {
+		return m.remoteAddFunc(dir, name, url)
+	}
+	return errors.New("not implemented")
+}
+
+func (m *mockGit) Config(dir, key, value string) error {
+	if m.configFunc != nil {
+		return m.configFunc(dir, key, value)
+	}
+	return errors.New("not implemented")
+}
+
+func (m *mockGit) CheckoutBranch(dir, branch string, reset bool) error {
+	if m.checkoutBranchFunc != nil {
+		return m.checkoutBranchFunc(dir, branch, reset)
+	}
+	return errors.New("not implemented")
+}
+
+func (m *mockGit) LsFiles(dir string, recurseSubmodules bool) ([]string, error) {
+	if m.lsFilesFunc != nil {
+		return m.lsFilesFunc(dir, recurseSubmodules)
+	}
+	return nil, errors.New("not implemented")
+}
+
+func (m *mockGit) Init(dir string) error {
+	if m.initFunc != nil {
+		return m.initFunc(dir)
+	}
+	return errors.New("not implemented")
+}
+
+func (m *mockGit) Fetch(dir, source, commit string) error {
+	if m.fetchFunc != nil {
+		return m.fetchFunc(dir, source, commit)
+	}
+	return errors.New("not implemented")
+}
+
+func (m *mockGit) UpdateRef(dir, ref, commit string) error {
+	if m.updateRefFunc != nil {
+		return m.updateRefFunc(dir, ref, commit)
+	}
+	return errors.New("not implemented")
+}
+
+func (m *mockGit) SymbolicRef(dir, name, ref string) error {
+	if m.symbolicRefFunc != nil {
+		return m.symbolicRefFunc(dir, name, ref)
+	}
+	return errors.New("not implemented")
+}
+
+func (m *mockGit) ReadTree(dir, commit string) error {
+	if m.readTreeFunc != nil {
+		return m.readTreeFunc(dir, commit)
+	}
+	return errors.New("not implemented")
+}
+
+func (m *mockGit) Archive(dir, commit string) (io.ReadCloser, error) {
+	if m.archiveFunc != nil {
+		return m.archiveFunc(dir, commit)
+	}
+	return nil, errors.New("not implemented")
+}
+
+func (m *mockGit) CatFileType(dir, object string) (string, error) {
+	if m.catFileTypeFunc != nil {
+		return m.catFileTypeFunc(dir, object)
+	}
+	return "", errors.New("not implemented")
+}
+
+func (m *mockGit) Submodules(dir string) ([]git.Submodule, error) {
+	if m.submodulesFunc != nil {
+		return m.submodulesFunc(dir)
+	}
+	return nil, errors.New("not implemented")
+}
+
+func (m *mockGit) SubmoduleUpdate(dir string) error {
+	if m.submoduleUpdateFunc != nil {
+		return m.submoduleUpdateFunc(dir)
+	}
+	return errors.New("not implemented")
+}
@@ -1,103 +1,391 @@
 package git
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
 	"net"
 	"net/http"
 	"net/http/cgi" //nolint:gosec // G504: CVE-2016-5386 only affects Go < 1.6.3, we use Go 1.26
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ryanmoran/contagent/internal"
 )
 
+// ServerUsername is the user name clients authenticate as when the server requires
+// credentials. Only the password is secret.
+const ServerUsername = "contagent"
+
+// DefaultServerAddress is the address the server binds to when none is given.
+const DefaultServerAddress = "127.0.0.1"
+
+// ipv6LoopbackAddress is the address the server also binds to when none is given and
+// the host supports IPv6.
+const ipv6LoopbackAddress = "::1"
+
+// DefaultLogPrefix precedes the messages of git and the CGI handler unless
+// ServerOptions.LogPrefix gives another prefix.
+const DefaultLogPrefix = "[GIT SERVER] "
+
+// HealthPath answers 200 OK whenever the server is up, without credentials.
+const HealthPath = "/healthz"
+
+// readyPollInterval is how often WaitReady checks whether the server is up.
+const readyPollInterval = 10 * time.Millisecond
+
+// contextShutdownTimeout bounds how long a server whose context is done waits for
+// in-flight requests before closing their connections.
+const contextShutdownTimeout = 10 * time.Second
+
 type Server struct {
 	server   *http.Server
-	listener net.Listener
+	address  string
 	port     int
-	writer   internal.Writer
+	password string
+	https    bool
+
+	// stats is shared across copies of this Server value.
+	stats *serverStats
+
+	// stop ensures the server is closed or shut down only once across copies of
+	// this Server value.
+	stop *sync.Once
+}
+
+// ServerOptions configures the server started by NewServer.
+type ServerOptions struct {
+	// Address is the address the server listens on. When empty, the server listens on
+	// DefaultServerAddress and, where the host supports IPv6, on the IPv6 loopback
+	// address at the same port.
+	Address string
+
+	// Authenticate requires HTTP Basic auth using ServerUsername and a randomly
+	// generated password, so that other processes reachable over the host network
+	// cannot read or push to the repository. Use URL to build a URL that carries the
+	// credentials.
+	Authenticate bool
+
+	// Certificate makes the server serve HTTPS with the certificate instead of HTTP,
+	// such as one from GenerateCertificate or LoadCertificate.
+	Certificate *tls.Certificate
+
+	// ReadOnly only allows fetching: pushes are rejected with 403 Forbidden.
+	ReadOnly bool
+
+	// LogPrefix precedes the messages of git and the CGI handler, such as one from
+	// SessionLogPrefix. DefaultLogPrefix is used when it is empty.
+	LogPrefix string
 }
 
-// NewServer creates and starts a Git HTTP server that serves the repository at the specified path.
-// The server listens on a random port on localhost and uses git-http-backend CGI to handle
-// Git protocol requests. It enables push and pull operations. Returns a Server handle or an error
-// if the path is invalid, not a Git repository, the TCP listener cannot be created, or git is not
-// found in PATH. The server starts immediately in a background goroutine.
-func NewServer(path string, w internal.Writer) (Server, error) {
+// NewServer creates and starts a Git HTTP server that serves the repository at the specified path,
+// configured by opts. The server listens on a random port and uses git-http-backend CGI to handle
+// Git protocol requests. It enables push and pull operations unless opts.ReadOnly is set. The
+// server starts immediately in a background goroutine and shuts down once ctx is done, such as
+// when contagent is interrupted, giving in-flight requests a short time to finish. Returns a
+// Server handle or an error if the path is invalid, the TCP listener cannot be created, or git
+// is not found in PATH.
+func NewServer(ctx context.Context, path string, opts ServerOptions, w internal.Writer) (Server, error) {
+	var password string
+	if opts.Authenticate {
+		token := make([]byte, 16)
+		if _, err := rand.Read(token); err != nil {
+			return Server{}, fmt.Errorf("failed to generate git server password: %w", err)
+		}
+		password = hex.EncodeToString(token)
+	}
+
 	var err error
 	path, err = filepath.Abs(path)
 	if err != nil {
 		return Server{}, fmt.Errorf("failed to resolve absolute path for %q: %w\nCheck that the path exists and is accessible", path, err)
 	}
 
-	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	// git is resolved before listening, so that no listener is left open when it is missing
+	git, err := exec.LookPath("git")
 	if err != nil {
-		return Server{}, fmt.Errorf("failed to create TCP listener on localhost: %w\nAnother process may be using network resources", err)
+		return Server{}, fmt.Errorf("git binary not found in PATH: %w\nInstall git or ensure it's in your PATH environment variable", err)
 	}
 
-	git, err := exec.LookPath("git")
+	address := opts.Address
+	if address == "" {
+		address = DefaultServerAddress
+	}
+
+	listener, err := net.Listen("tcp", net.JoinHostPort(address, "0"))
 	if err != nil {
-		return Server{}, fmt.Errorf("git binary not found in PATH: %w\nInstall git or ensure it's in your PATH environment variable", err)
+		return Server{}, fmt.Errorf("failed to create TCP listener on %s: %w\nCheck that the address belongs to a local interface and is not in use", address, err)
+	}
+
+	host, portString, err := net.SplitHostPort(listener.Addr().String())
+	if err != nil {
+		listener.Close()
+		return Server{}, fmt.Errorf("failed to split listener host/port: %w", err)
+	}
+
+	port, err := strconv.ParseInt(portString, 10, 64)
+	if err != nil {
+		listener.Close()
+		return Server{}, fmt.Errorf("failed to parse listener port: %w", err)
+	}
+
+	// By default the server also listens on the IPv6 loopback address at the same port,
+	// so that it is reached whichever address family localhost resolves to. Hosts
+	// without IPv6, or with the port taken, are served over IPv4 only
+	listeners := []net.Listener{listener}
+	if opts.Address == "" {
+		ipv6, err := net.Listen("tcp", net.JoinHostPort(ipv6LoopbackAddress, portString))
+		if err != nil {
+			w.Debugf("Git server: not listening on %s: %v", ipv6LoopbackAddress, err)
+		} else {
+			listeners = append(listeners, ipv6)
+		}
+	}
+
+	// Pushes are served by the receive-pack service, which git-http-backend only runs
+	// when it is enabled
+	receivePack := "http.receivepack"
+	if opts.ReadOnly {
+		receivePack = "http.receivepack=false"
+	}
+
+	logPrefix := opts.LogPrefix
+	if logPrefix == "" {
+		logPrefix = DefaultLogPrefix
 	}
 
 	mux := http.NewServeMux()
-	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/", func(rw http.ResponseWriter, r *http.Request) {
+		// Messages from git and the CGI handler are output like the rest of contagent's,
+		// so they follow --quiet and --log-file
+		logger := log.New(w.GetWriter(), logPrefix, 0)
+
+		env := []string{
+			"GIT_PROJECT_ROOT=" + path,
+			"PATH_INFO=" + r.URL.Path,
+			"QUERY_STRING=" + r.URL.RawQuery,
+			"REQUEST_METHOD=" + r.Method,
+			"GIT_HTTP_EXPORT_ALL=true",
+			"GIT_HTTP_ALLOW_REPACK=true",
+			"GIT_HTTP_VERBOSE=1",
+			"SSH_AUTH_SOCK=" + os.Getenv("SSH_AUTH_SOCK"),
+		}
+		if !opts.ReadOnly {
+			env = append(env, "GIT_HTTP_ALLOW_PUSH=true")
+		}
+
 		h := &cgi.Handler{
 			Path: git,
 			Args: []string{
-				"-c", "http.receivepack",
+				"-c", receivePack,
 				"http-backend",
 			},
-			Dir: path,
-			Env: []string{
-				"GIT_PROJECT_ROOT=" + path,
-				"PATH_INFO=" + r.URL.Path,
-				"QUERY_STRING=" + r.URL.RawQuery,
-				"REQUEST_METHOD=" + r.Method,
-				"GIT_HTTP_EXPORT_ALL=true",
-				"GIT_HTTP_ALLOW_REPACK=true",
-				"GIT_HTTP_ALLOW_PUSH=true",
-				"GIT_HTTP_VERBOSE=1",
-				"SSH_AUTH_SOCK=" + os.Getenv("SSH_AUTH_SOCK"),
-			},
-			Logger: log.New(os.Stdout, "[GIT SERVER] ", 0),
-			Stderr: os.Stderr,
+			Dir:    path,
+			Env:    env,
+			Logger: logger,
+			Stderr: logWriter{logger},
 		}
 
-		h.ServeHTTP(w, r)
+		h.ServeHTTP(rw, r)
+	})
+
+	var handler http.Handler = mux
+	if opts.ReadOnly {
+		handler = rejectPush(handler)
+	}
+	if password != "" {
+		handler = basicAuth(ServerUsername, password, handler)
+	}
+
+	stats := &serverStats{}
+	handler = logRequests(stats, w, handler)
+
+	// The health check bypasses authentication and is not counted, as it does not
+	// touch the repository
+	root := http.NewServeMux()
+	root.HandleFunc("GET "+HealthPath, func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusOK)
 	})
+	root.Handle("/", handler)
 
 	server := &http.Server{
-		Handler:           mux,
+		Handler:           root,
 		ReadHeaderTimeout: 10 * time.Second, // Prevent Slowloris attacks
 	}
 
-	go func() {
-		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
-			w.Warningf("Git server error: %v", err)
+	if opts.Certificate != nil {
+		server.TLSConfig = &tls.Config{
+			Certificates: []tls.Certificate{*opts.Certificate},
+			MinVersion:   tls.VersionTLS12,
 		}
-	}()
-
-	_, portString, err := net.SplitHostPort(listener.Addr().String())
-	if err != nil {
-		return Server{}, fmt.Errorf("failed to split listener host/port: %w", err)
 	}
 
-	port, err := strconv.ParseInt(portString, 10, 64)
-	if err != nil {
-		return Server{}, fmt.Errorf("failed to parse listener port: %w", err)
+	serve := server.Serve
+	if opts.Certificate != nil {
+		serve = func(listener net.Listener) error { return server.ServeTLS(listener, "", "") }
+	}
+	for _, listener := range listeners {
+		go func() {
+			if err := serve(listener); err != nil && err != http.ErrServerClosed {
+				w.Warningf("Git server error: %v", err)
+			}
+		}()
 	}
 
-	return Server{
-		listener: listener,
+	s := Server{
 		server:   server,
+		address:  host,
 		port:     int(port),
-		writer:   w,
-	}, nil
+		password: password,
+		https:    opts.Certificate != nil,
+		stats:    stats,
+		stop:     &sync.Once{},
+	}
+
+	context.AfterFunc(ctx, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), contextShutdownTimeout)
+		defer cancel()
+		if err := s.Shutdown(ctx); err != nil {
+			w.Warningf("%v", err)
+		}
+	})
+
+	return s, nil
+}
+
+// logWriter writes each message, such as a line git writes to stderr, to a logger so
+// that it carries the logger's prefix.
+type logWriter struct {
+	logger *log.Logger
+}
+
+func (l logWriter) Write(p []byte) (int, error) {
+	l.logger.Print(string(p))
+	return len(p), nil
+}
+
+// SessionLogPrefix returns a log prefix that names the session, such as
+// "[GIT SERVER contagent-1a2b3c4d] ", to tell apart the servers of concurrent sessions.
+func SessionLogPrefix(id internal.SessionID) string {
+	return fmt.Sprintf("[GIT SERVER %s] ", id)
+}
+
+// ServerStats counts the requests handled by a server.
+type ServerStats struct {
+	// Requests counts every request, including those that failed.
+	Requests int64
+
+	// Fetches counts the clones and fetches that the server answered.
+	Fetches int64
+
+	// Pushes counts the pushes that the server answered.
+	Pushes int64
+
+	// Errors counts the requests answered with an error status. The 401 Unauthorized
+	// challenges that precede the credentials of every client are not counted.
+	Errors int64
+}
+
+// serverStats holds the counters of ServerStats, updated as requests are served.
+type serverStats struct {
+	requests atomic.Int64
+	fetches  atomic.Int64
+	pushes   atomic.Int64
+	errors   atomic.Int64
+}
+
+// Stats returns the number of requests the server has handled so far.
+func (s Server) Stats() ServerStats {
+	return ServerStats{
+		Requests: s.stats.requests.Load(),
+		Fetches:  s.stats.fetches.Load(),
+		Pushes:   s.stats.pushes.Load(),
+		Errors:   s.stats.errors.Load(),
+	}
+}
+
+// logRequests writes the method, path, status, and duration of each request at debug
+// level, and counts the requests in stats. Every clone, fetch, or push starts by
+// requesting the refs advertised for its service, so those requests count operations.
+func logRequests(stats *serverStats, w internal.Writer, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: rw, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		w.Debugf("Git server: %s %s %d in %s", r.Method, r.URL.Path, recorder.status, time.Since(start).Round(time.Microsecond))
+
+		stats.requests.Add(1)
+		switch {
+		case recorder.status == http.StatusUnauthorized:
+		case recorder.status >= http.StatusBadRequest:
+			stats.errors.Add(1)
+		case strings.HasSuffix(r.URL.Path, "/info/refs"):
+			switch r.URL.Query().Get("service") {
+			case "git-upload-pack":
+				stats.fetches.Add(1)
+			case "git-receive-pack":
+				stats.pushes.Add(1)
+			}
+		}
+	})
+}
+
+// statusRecorder records the status of the response written through it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap lets http.ResponseController reach the underlying ResponseWriter.
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// rejectPush rejects the requests of a push, which use the git-receive-pack service,
+// with 403 Forbidden.
+func rejectPush(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/git-receive-pack") || r.URL.Query().Get("service") == "git-receive-pack" {
+			http.Error(rw, "the repository is read-only, pushing is disabled", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// basicAuth rejects requests that do not carry the given Basic auth credentials.
+func basicAuth(username, password string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok ||
+			subtle.ConstantTimeCompare([]byte(user), []byte(username)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(pass), []byte(password)) != 1 {
+			rw.Header().Set("WWW-Authenticate", `Basic realm="contagent"`)
+			http.Error(rw, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(rw, r)
+	})
 }
 
 // Port returns the TCP port number that the Git server is listening on.
@@ -105,8 +393,98 @@ func (s Server) Port() int {
 	return s.port
 }
 
-// Close stops the Git HTTP server which closes the TCP listener.
+// Address returns the IP address that the Git server is listening on.
+func (s Server) Address() string {
+	return s.address
+}
+
+// URL returns the clone URL of the served repository as reached through hostAlias, such
+// as host.docker.internal from inside a container. It includes the credentials when the
+// server requires authentication, and uses https when the server serves HTTPS.
+func (s Server) URL(hostAlias string) string {
+	remote := url.URL{
+		Scheme: s.scheme(),
+		Host:   net.JoinHostPort(hostAlias, strconv.Itoa(s.port)),
+		Path:   "/.git",
+	}
+	if s.password != "" {
+		remote.User = url.UserPassword(ServerUsername, s.password)
+	}
+	return remote.String()
+}
+
+// scheme returns the URL scheme the server is reached with.
+func (s Server) scheme() string {
+	if s.https {
+		return "https"
+	}
+	return "http"
+}
+
+// WaitReady polls the health endpoint of the server until it answers. Returns an error
+// if ctx is done first.
+func (s Server) WaitReady(ctx context.Context) error {
+	health := s.scheme() + "://" + net.JoinHostPort(s.address, strconv.Itoa(s.port)) + HealthPath
+
+	// Only the liveness of this server is checked, whose certificate may be self-signed
+	// or issued for the container's host alias rather than the address it listens on
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, //nolint:gosec // G402: see above
+	}}
+	defer client.CloseIdleConnections()
+
+	ticker := time.NewTicker(readyPollInterval)
+	defer ticker.Stop()
+
+	for {
+		request, err := http.NewRequestWithContext(ctx, http.MethodGet, health, nil)
+		if err != nil {
+			return fmt.Errorf("failed to check git server health: %w", err)
+		}
+
+		response, err := client.Do(request)
+		if err == nil {
+			response.Body.Close()
+			if response.StatusCode == http.StatusOK {
+				return nil
+			}
+			err = fmt.Errorf("unexpected status %s", response.Status)
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("git server at %s is not ready: %w (last check: %v)", health, ctx.Err(), err)
+		case <-ticker.C:
+		}
+	}
+}
+
+// Close stops the Git HTTP server which closes the TCP listeners.
 // Returns an error if the server or listener cannot be closed cleanly.
+// In-flight requests are dropped; prefer Shutdown when a push may be in progress.
+// Only the first call to Close or Shutdown has an effect; later calls return nil.
 func (s Server) Close() error {
-	return s.server.Close()
+	var err error
+	s.stop.Do(func() {
+		err = s.server.Close()
+	})
+	return err
+}
+
+// Shutdown stops accepting new connections and waits for in-flight requests, such as a
+// push from the container, to finish. If ctx expires first, the remaining connections
+// are closed forcefully and the context error is returned. Only the first call to Close
+// or Shutdown has an effect; later calls return nil.
+func (s Server) Shutdown(ctx context.Context) error {
+	var err error
+	s.stop.Do(func() {
+		err = s.server.Shutdown(ctx)
+		if err != nil {
+			err = errors.Join(
+				fmt.Errorf("git server did not shut down gracefully: %w", err),
+				s.server.Close(),
+			)
+		}
+	})
+	return err
 }
@@ -1,12 +1,20 @@
 package git_test
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/ryanmoran/contagent/internal"
 	"github.com/ryanmoran/contagent/internal/git"
@@ -14,7 +22,7 @@ import (
 )
 
 func TestServer(t *testing.T) {
-	setup := func(t *testing.T) (git.Server, string) {
+	setup := func(t *testing.T, opts git.ServerOptions) (git.Server, string) {
 		dir, err := os.MkdirTemp("", "git-server-test")
 		require.NoError(t, err)
 		t.Cleanup(func() {
@@ -49,7 +57,7 @@ func TestServer(t *testing.T) {
 		err = cmd.Run()
 		require.NoError(t, err)
 
-		server, err := git.NewServer(dir, internal.NewStandardWriter())
+		server, err := git.NewServer(context.Background(), dir, opts, internal.NewStandardWriter())
 		require.NoError(t, err)
 		t.Cleanup(func() {
 			server.Close()
@@ -59,7 +67,7 @@ func TestServer(t *testing.T) {
 	}
 
 	t.Run("allows fetch and push", func(t *testing.T) {
-		server, remoteDir := setup(t)
+		server, remoteDir := setup(t, git.ServerOptions{})
 
 		dir, err := os.MkdirTemp("", "git-client-test")
 		require.NoError(t, err)
@@ -107,4 +115,411 @@ func TestServer(t *testing.T) {
 		require.NoError(t, err)
 		require.Equal(t, "modified content\n", string(content))
 	})
+
+	t.Run("allows clone and push over HTTPS", func(t *testing.T) {
+		certificate, err := git.GenerateCertificate("127.0.0.1", "localhost")
+		require.NoError(t, err)
+
+		server, remoteDir := setup(t, git.ServerOptions{
+			Authenticate: true,
+			Certificate:  &certificate,
+		})
+
+		remote := server.URL("127.0.0.1")
+		require.True(t, strings.HasPrefix(remote, "https://"), remote)
+
+		// The self-signed certificate is rejected unless verification is skipped
+		dir := t.TempDir()
+		cmd := exec.Command("git", "clone", remote, filepath.Join(dir, "verified")) //nolint:gosec // G204: Test with controlled input
+		output, err := cmd.CombinedOutput()
+		require.Error(t, err, string(output))
+
+		clone := filepath.Join(dir, "clone")
+		cmd = exec.Command("git", "-c", "http.sslVerify=false", "clone", remote, clone) //nolint:gosec // G204: Test with controlled input
+		output, err = cmd.CombinedOutput()
+		require.NoError(t, err, string(output))
+
+		content, err := os.ReadFile(filepath.Join(clone, "test.txt"))
+		require.NoError(t, err)
+		require.Equal(t, "initial content\n", string(content))
+
+		require.NoError(t, os.WriteFile(filepath.Join(clone, "test.txt"), []byte("modified over https\n"), 0600))
+		cmd = exec.Command("git", "-c", "user.name=Other User", "-c", "user.email=other@example.com", "commit", "-am", "modify test file")
+		cmd.Dir = clone
+		output, err = cmd.CombinedOutput()
+		require.NoError(t, err, string(output))
+
+		cmd = exec.Command("git", "-c", "http.sslVerify=false", "push", "origin", "HEAD")
+		cmd.Dir = clone
+		output, err = cmd.CombinedOutput()
+		require.NoError(t, err, string(output))
+
+		content, err = os.ReadFile(filepath.Join(remoteDir, "test.txt"))
+		require.NoError(t, err)
+		require.Equal(t, "modified over https\n", string(content))
+	})
+
+	t.Run("allows clone but rejects push when read-only", func(t *testing.T) {
+		server, remoteDir := setup(t, git.ServerOptions{ReadOnly: true})
+
+		clone := t.TempDir()
+		cmd := exec.Command("git", "clone", server.URL("127.0.0.1"), clone) //nolint:gosec // G204: Test with controlled input
+		output, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(output))
+
+		require.NoError(t, os.WriteFile(filepath.Join(clone, "test.txt"), []byte("modified content\n"), 0600))
+		cmd = exec.Command("git", "-c", "user.name=Other User", "-c", "user.email=other@example.com", "commit", "-am", "modify test file")
+		cmd.Dir = clone
+		output, err = cmd.CombinedOutput()
+		require.NoError(t, err, string(output))
+
+		cmd = exec.Command("git", "push", "origin", "HEAD")
+		cmd.Dir = clone
+		output, err = cmd.CombinedOutput()
+		require.Error(t, err)
+		require.Contains(t, string(output), "403")
+
+		content, err := os.ReadFile(filepath.Join(remoteDir, "test.txt"))
+		require.NoError(t, err)
+		require.Equal(t, "initial content\n", string(content))
+
+		// The receive-pack service itself is rejected, not only its advertisement
+		response, err := http.Post(fmt.Sprintf("http://127.0.0.1:%d/.git/git-receive-pack", server.Port()), "application/x-git-receive-pack-request", nil) //nolint:gosec // G107: Test with controlled input
+		require.NoError(t, err)
+		response.Body.Close()
+		require.Equal(t, http.StatusForbidden, response.StatusCode)
+	})
+
+	t.Run("counts fetches, pushes, and errors", func(t *testing.T) {
+		server, _ := setup(t, git.ServerOptions{Authenticate: true})
+		require.Equal(t, git.ServerStats{}, server.Stats())
+
+		clone := t.TempDir()
+		cmd := exec.Command("git", "clone", server.URL("127.0.0.1"), clone) //nolint:gosec // G204: Test with controlled input
+		output, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(output))
+
+		stats := server.Stats()
+		require.Equal(t, int64(1), stats.Fetches)
+		require.Zero(t, stats.Pushes)
+		require.Zero(t, stats.Errors, "the 401 challenge before the credentials is not an error")
+		require.GreaterOrEqual(t, stats.Requests, int64(2))
+
+		require.NoError(t, os.WriteFile(filepath.Join(clone, "test.txt"), []byte("modified content\n"), 0600))
+		cmd = exec.Command("git", "-c", "user.name=Other User", "-c", "user.email=other@example.com", "commit", "-am", "modify test file")
+		cmd.Dir = clone
+		output, err = cmd.CombinedOutput()
+		require.NoError(t, err, string(output))
+
+		cmd = exec.Command("git", "push", "origin", "HEAD")
+		cmd.Dir = clone
+		output, err = cmd.CombinedOutput()
+		require.NoError(t, err, string(output))
+
+		cmd = exec.Command("git", "fetch", "origin", "does-not-exist")
+		cmd.Dir = clone
+		_, err = cmd.CombinedOutput()
+		require.Error(t, err)
+
+		stats = server.Stats()
+		require.Equal(t, int64(2), stats.Fetches)
+		require.Equal(t, int64(1), stats.Pushes)
+
+		// Requests with credentials for a repository that does not exist fail
+		remote, err := url.Parse(server.URL("127.0.0.1"))
+		require.NoError(t, err)
+		remote.Path = "/missing/info/refs"
+		response, err := http.Get(remote.String() + "?service=git-upload-pack") //nolint:gosec // G107: Test with controlled input
+		require.NoError(t, err)
+		response.Body.Close()
+		require.Equal(t, http.StatusNotFound, response.StatusCode)
+		require.Equal(t, int64(1), server.Stats().Errors)
+	})
+
+	t.Run("answers health checks without credentials", func(t *testing.T) {
+		server, _ := setup(t, git.ServerOptions{Authenticate: true})
+
+		response, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d%s", server.Port(), git.HealthPath)) //nolint:gosec // G107: Test with controlled input
+		require.NoError(t, err)
+		response.Body.Close()
+		require.Equal(t, http.StatusOK, response.StatusCode)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		require.NoError(t, server.WaitReady(ctx))
+
+		// Health checks do not count as git requests
+		require.Equal(t, git.ServerStats{}, server.Stats())
+	})
+
+	t.Run("waits for an HTTPS server to be ready", func(t *testing.T) {
+		certificate, err := git.GenerateCertificate("127.0.0.1")
+		require.NoError(t, err)
+
+		server, err := git.NewServer(context.Background(), t.TempDir(), git.ServerOptions{Certificate: &certificate}, internal.NewStandardWriter())
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			server.Close()
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		require.NoError(t, server.WaitReady(ctx))
+	})
+
+	t.Run("returns an error when the server is not ready before the context is done", func(t *testing.T) {
+		server, _ := setup(t, git.ServerOptions{})
+		require.NoError(t, server.Close())
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		err := server.WaitReady(ctx)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		require.ErrorContains(t, err, "is not ready")
+	})
+
+	t.Run("requires credentials when authenticated", func(t *testing.T) {
+		server, _ := setup(t, git.ServerOptions{Authenticate: true})
+
+		remote := server.URL("127.0.0.1")
+		remoteURL, err := url.Parse(remote)
+		require.NoError(t, err)
+		require.Equal(t, git.ServerUsername, remoteURL.User.Username())
+		password, ok := remoteURL.User.Password()
+		require.True(t, ok)
+		require.Len(t, password, 32)
+
+		infoRefs := fmt.Sprintf("http://127.0.0.1:%d/.git/info/refs?service=git-upload-pack", server.Port())
+
+		response, err := http.Get(infoRefs) //nolint:gosec // G107: Test with controlled input
+		require.NoError(t, err)
+		response.Body.Close()
+		require.Equal(t, http.StatusUnauthorized, response.StatusCode)
+		require.Equal(t, `Basic realm="contagent"`, response.Header.Get("WWW-Authenticate"))
+
+		request, err := http.NewRequest(http.MethodGet, infoRefs, nil)
+		require.NoError(t, err)
+		request.SetBasicAuth(git.ServerUsername, "wrong-password")
+		response, err = http.DefaultClient.Do(request)
+		require.NoError(t, err)
+		response.Body.Close()
+		require.Equal(t, http.StatusUnauthorized, response.StatusCode)
+
+		request.SetBasicAuth(git.ServerUsername, password)
+		response, err = http.DefaultClient.Do(request)
+		require.NoError(t, err)
+		response.Body.Close()
+		require.Equal(t, http.StatusOK, response.StatusCode)
+
+		dir := t.TempDir()
+		cmd := exec.Command("git", "clone", remote, dir) //nolint:gosec // G204: Test with controlled input
+		output, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(output))
+
+		content, err := os.ReadFile(filepath.Join(dir, "test.txt"))
+		require.NoError(t, err)
+		require.Equal(t, "initial content\n", string(content))
+	})
+
+	t.Run("builds the clone URL without credentials when unauthenticated", func(t *testing.T) {
+		server, _ := setup(t, git.ServerOptions{})
+
+		require.Equal(t, fmt.Sprintf("http://host.docker.internal:%d/.git", server.Port()), server.URL("host.docker.internal"))
+	})
+
+	t.Run("builds the clone URL with credentials when authenticated", func(t *testing.T) {
+		server, _ := setup(t, git.ServerOptions{Authenticate: true})
+
+		remote, err := url.Parse(server.URL("host.docker.internal"))
+		require.NoError(t, err)
+		password, _ := remote.User.Password()
+
+		require.Equal(t, fmt.Sprintf("http://%s:%s@host.docker.internal:%d/.git", git.ServerUsername, password, server.Port()), remote.String())
+		require.Regexp(t, `^[0-9a-f]{32}$`, password)
+	})
+
+	t.Run("builds the clone URL for IPv6 host aliases", func(t *testing.T) {
+		server, _ := setup(t, git.ServerOptions{})
+
+		require.Equal(t, fmt.Sprintf("http://[fd00::1]:%d/.git", server.Port()), server.URL("fd00::1"))
+	})
+
+	t.Run("binds to the given address on a random port", func(t *testing.T) {
+		dir := t.TempDir()
+
+		server, err := git.NewServer(context.Background(), dir, git.ServerOptions{Address: "127.0.0.1"}, internal.NewStandardWriter())
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			server.Close()
+		})
+
+		require.Equal(t, "127.0.0.1", server.Address())
+		require.NotZero(t, server.Port())
+
+		conn, err := net.Dial("tcp", net.JoinHostPort(server.Address(), strconv.Itoa(server.Port())))
+		require.NoError(t, err)
+		conn.Close()
+	})
+
+	t.Run("stops accepting connections once the context is done", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		server, err := git.NewServer(ctx, t.TempDir(), git.ServerOptions{}, internal.NewStandardWriter())
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			server.Close()
+		})
+
+		address := net.JoinHostPort(server.Address(), strconv.Itoa(server.Port()))
+		conn, err := net.Dial("tcp", address)
+		require.NoError(t, err)
+		conn.Close()
+
+		cancel()
+
+		require.Eventually(t, func() bool {
+			conn, err := net.Dial("tcp", address)
+			if err != nil {
+				return true
+			}
+			conn.Close()
+			return false
+		}, 5*time.Second, 10*time.Millisecond)
+
+		// The server has already been shut down, so closing it again has no effect
+		require.NoError(t, server.Close())
+	})
+
+	// requestMissing requests a repository that does not exist, for which git writes
+	// "Not a git repository" to stderr, and returns the output of the server.
+	requestMissing := func(t *testing.T, server git.Server, output *syncBuffer) string {
+		t.Helper()
+
+		response, err := http.Get(fmt.Sprintf("http://%s:%d/missing/info/refs?service=git-upload-pack", server.Address(), server.Port())) //nolint:gosec // G107: Test with controlled input
+		require.NoError(t, err)
+		response.Body.Close()
+		require.Equal(t, http.StatusNotFound, response.StatusCode)
+
+		// git may still be writing to stderr after the response has been sent
+		require.Eventually(t, func() bool {
+			return strings.Contains(output.String(), "Not a git repository")
+		}, 5*time.Second, 10*time.Millisecond, output.String())
+		return output.String()
+	}
+
+	t.Run("logs requests and git output through the writer", func(t *testing.T) {
+		var output syncBuffer
+		w := internal.NewCustomWriter(&output, &output)
+		w.SetLevel(internal.LogLevelDebug)
+
+		server, err := git.NewServer(context.Background(), t.TempDir(), git.ServerOptions{}, w)
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			server.Close()
+		})
+
+		logs := requestMissing(t, server, &output)
+		require.Regexp(t, `Debug: Git server: GET /missing/info/refs 404 in [0-9.]+[µm]?s`, logs)
+		require.Regexp(t, `(?m)^\[GIT SERVER\] Not a git repository: '.*/missing'$`, logs)
+	})
+
+	t.Run("prefixes git output with the session ID", func(t *testing.T) {
+		var output syncBuffer
+		options := git.ServerOptions{LogPrefix: git.SessionLogPrefix("contagent-1a2b3c4d")}
+		server, err := git.NewServer(context.Background(), t.TempDir(), options, internal.NewCustomWriter(&output, &output))
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			server.Close()
+		})
+
+		logs := requestMissing(t, server, &output)
+		require.Regexp(t, `(?m)^\[GIT SERVER contagent-1a2b3c4d\] Not a git repository: '.*/missing'$`, logs)
+	})
+
+	// skipWithoutIPv6 skips tests that need the IPv6 loopback address on hosts, such as
+	// some containers, that do not support it.
+	skipWithoutIPv6 := func(t *testing.T) {
+		t.Helper()
+
+		listener, err := net.Listen("tcp", "[::1]:0")
+		if err != nil {
+			t.Skipf("IPv6 is not supported: %v", err)
+		}
+		listener.Close()
+	}
+
+	t.Run("allows clone over IPv6", func(t *testing.T) {
+		skipWithoutIPv6(t)
+
+		server, _ := setup(t, git.ServerOptions{Address: "::1"})
+		require.Equal(t, "::1", server.Address())
+
+		remote := server.URL(server.Address())
+		require.Equal(t, fmt.Sprintf("http://[::1]:%d/.git", server.Port()), remote)
+
+		clone := t.TempDir()
+		cmd := exec.Command("git", "clone", remote, clone) //nolint:gosec // G204: Test with controlled input
+		output, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(output))
+
+		content, err := os.ReadFile(filepath.Join(clone, "test.txt"))
+		require.NoError(t, err)
+		require.Equal(t, "initial content\n", string(content))
+	})
+
+	t.Run("also listens on the IPv6 loopback address by default", func(t *testing.T) {
+		skipWithoutIPv6(t)
+
+		server, _ := setup(t, git.ServerOptions{})
+		require.Equal(t, git.DefaultServerAddress, server.Address())
+
+		clone := t.TempDir()
+		cmd := exec.Command("git", "clone", server.URL("::1"), clone) //nolint:gosec // G204: Test with controlled input
+		output, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(output))
+
+		// Both listeners stop with the server
+		require.NoError(t, server.Close())
+		_, err = net.Dial("tcp", net.JoinHostPort("::1", strconv.Itoa(server.Port())))
+		require.Error(t, err)
+	})
+
+	t.Run("binds to the loopback address by default", func(t *testing.T) {
+		server, _ := setup(t, git.ServerOptions{})
+
+		require.Equal(t, git.DefaultServerAddress, server.Address())
+	})
+
+	t.Run("returns an error for an address that is not local", func(t *testing.T) {
+		// 192.0.2.0/24 is reserved for documentation and never assigned to an interface
+		_, err := git.NewServer(context.Background(), t.TempDir(), git.ServerOptions{Address: "192.0.2.1"}, internal.NewStandardWriter())
+		require.ErrorContains(t, err, "failed to create TCP listener on 192.0.2.1")
+	})
+
+	t.Run("returns an error when git is not in PATH", func(t *testing.T) {
+		t.Setenv("PATH", "")
+
+		_, err := git.NewServer(context.Background(), t.TempDir(), git.ServerOptions{}, internal.NewStandardWriter())
+		require.ErrorContains(t, err, "git binary not found in PATH")
+	})
+}
+
+// syncBuffer is a bytes.Buffer that can be written by the server's goroutines while the
+// test reads it.
+type syncBuffer struct {
+	mu     sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buffer.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buffer.String()
 }
@@ -0,0 +1,109 @@
+package git
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerShutdown(t *testing.T) {
+	// setup serves handler the same way NewServer does, so that shutdown can be
+	// observed with a request that stays in flight for as long as the test needs.
+	setup := func(t *testing.T, handler http.HandlerFunc) (Server, string) {
+		t.Helper()
+
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		server := &http.Server{
+			Handler:           handler,
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		go server.Serve(listener) //nolint:errcheck // Serve returns ErrServerClosed on shutdown
+
+		t.Cleanup(func() {
+			server.Close()
+		})
+
+		return Server{server: server, stop: &sync.Once{}}, fmt.Sprintf("http://%s/", listener.Addr())
+	}
+
+	type result struct {
+		body string
+		err  error
+	}
+
+	get := func(url string) <-chan result {
+		results := make(chan result, 1)
+		go func() {
+			response, err := http.Get(url) //nolint:gosec // G107: Test with controlled input
+			if err != nil {
+				results <- result{err: err}
+				return
+			}
+			defer response.Body.Close()
+
+			body, err := io.ReadAll(response.Body)
+			results <- result{body: string(body), err: err}
+		}()
+		return results
+	}
+
+	t.Run("waits for in-flight requests to finish", func(t *testing.T) {
+		started := make(chan struct{})
+		server, url := setup(t, func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			time.Sleep(200 * time.Millisecond)
+			fmt.Fprint(w, "pushed")
+		})
+
+		results := get(url)
+		<-started
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		begin := time.Now()
+		require.NoError(t, server.Shutdown(ctx))
+		require.GreaterOrEqual(t, time.Since(begin), 100*time.Millisecond, "shutdown should wait for the request")
+
+		res := <-results
+		require.NoError(t, res.err)
+		require.Equal(t, "pushed", res.body)
+	})
+
+	t.Run("closes remaining connections when the deadline expires", func(t *testing.T) {
+		started := make(chan struct{})
+		release := make(chan struct{})
+		defer close(release)
+
+		server, url := setup(t, func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			<-release
+		})
+
+		results := get(url)
+		<-started
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		err := server.Shutdown(ctx)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		require.ErrorContains(t, err, "git server did not shut down gracefully")
+
+		select {
+		case res := <-results:
+			require.Error(t, res.err, "the in-flight request should be cut off")
+		case <-time.After(5 * time.Second):
+			t.Fatal("request was not cut off after the shutdown deadline")
+		}
+	})
+}
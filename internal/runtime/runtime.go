@@ -2,15 +2,28 @@ package runtime
 
 import (
 	"context"
+	"errors"
 	"io"
 	"time"
 
 	"github.com/ryanmoran/contagent/internal"
 )
 
+// ExitCodeInterrupted is the exit code reported when the container is stopped
+// in response to a signal, following the shell convention of 128+SIGINT.
+const ExitCodeInterrupted = 130
+
+// ErrTimeout is returned by Container.Wait when the container is stopped because it
+// ran for longer than the configured timeout.
+var ErrTimeout = errors.New("container timed out")
+
 // Image represents a container image.
 type Image struct {
 	Name string
+
+	// CacheTag is the reference that identifies the image by its build inputs, for
+	// runtimes that reuse images built from unchanged inputs.
+	CacheTag string
 }
 
 // ImageUser represents the default user for a container image.
@@ -19,23 +32,189 @@ type ImageUser struct {
 	GID int
 }
 
+// Attachment is a handle to the I/O forwarding started by Container.Attach. The zero
+// Attachment has nothing to wait for.
+type Attachment struct {
+	done    <-chan struct{}
+	restore func()
+}
+
+// NewAttachment returns an Attachment that is finished once done is closed, which must
+// only happen after the container's output has been written and the terminal restored.
+// restore is called if waiting is abandoned, so that the terminal is not left in raw
+// mode; it may be nil.
+func NewAttachment(done <-chan struct{}, restore func()) Attachment {
+	return Attachment{done: done, restore: restore}
+}
+
+// Wait blocks until the container's output has been forwarded in full and the terminal
+// restored. If ctx is done first, it restores the terminal and returns the context error,
+// as the remaining output may then be lost.
+func (a Attachment) Wait(ctx context.Context) error {
+	if a.done == nil {
+		return nil
+	}
+
+	select {
+	case <-a.done:
+		return nil
+	case <-ctx.Done():
+		if a.restore != nil {
+			a.restore()
+		}
+		return ctx.Err()
+	}
+}
+
+// BuildImageOptions bundles the configuration for building an image.
+type BuildImageOptions struct {
+	// DockerfilePath is the path of the Dockerfile to build.
+	DockerfilePath string
+
+	// ContextDir is the directory sent as the build context, the directory containing
+	// the Dockerfile when empty.
+	ContextDir string
+
+	// Target names the stage of a multi-stage Dockerfile to build; the last stage is
+	// built when empty.
+	Target string
+
+	// ImageName is the name the built image is tagged with.
+	ImageName internal.ImageName
+
+	// BuildArgs set the values of the Dockerfile's ARG instructions.
+	BuildArgs map[string]string
+
+	// Labels are added to the image.
+	Labels map[string]string
+
+	// ForceRebuild skips reusing a previously built image for unchanged inputs.
+	ForceRebuild bool
+
+	// NoCache is like ForceRebuild, and also builds every step without reusing cached
+	// layers.
+	NoCache bool
+
+	// KeepIntermediate keeps the containers that the classic builder runs for each step
+	// instead of removing them once the build succeeds, so that the layers of a build
+	// can be inspected.
+	KeepIntermediate bool
+
+	// PullPolicy controls when base images are pulled; empty behaves like
+	// internal.PullPolicyMissing.
+	PullPolicy internal.PullPolicy
+
+	// Progress controls how the build output is shown; empty behaves like
+	// internal.BuildProgressPlain.
+	Progress internal.BuildProgress
+
+	// DockerConfig is the directory of the Docker config.json that credentials for
+	// private base images are read from; no credentials are sent when it is empty.
+	DockerConfig string
+
+	// Secrets are exposed to RUN --mount=type=secret instructions without being stored
+	// in the image.
+	Secrets []internal.BuildSecret
+
+	// Platform is the OS/ARCH[/VARIANT] platform the image is built for, the host's
+	// when empty.
+	Platform string
+}
+
 // CreateContainerOptions bundles the configuration for creating a container.
 type CreateContainerOptions struct {
-	SessionID   internal.SessionID
-	Image       Image
-	Args        internal.Command
-	Env         internal.Environment
-	Volumes     []string
-	WorkingDir  string
-	Network     string
+	// SessionID names the container, and is the value of its internal.SessionLabel
+	// label.
+	SessionID internal.SessionID
+
+	// Image is the image the container is created from.
+	Image Image
+
+	// Args is the command the container runs.
+	Args internal.Command
+
+	// Entrypoint overrides the image's entrypoint when non-nil, and resets it when empty.
+	Entrypoint []string
+
+	// Env holds the KEY=VALUE environment variables of the container.
+	Env internal.Environment
+
+	// Volumes are HOST:CONTAINER[:MODE] binds, or a container path alone for an
+	// anonymous volume.
+	Volumes []string
+
+	// WorkingDir is the directory the command runs in.
+	WorkingDir string
+
+	// User overrides the image's default user; Container.InspectUser reports it once set.
+	User string
+
+	// Network is the network the container joins.
+	Network string
+
+	// ExtraHosts adds NAME:IP host aliases.
+	ExtraHosts []string
+
+	// NoGateway drops the runtime's own alias for the host where the runtime adds one.
+	NoGateway bool
+
+	// Labels are added to the container alongside the internal.SessionLabel label.
+	Labels map[string]string
+
+	// Platform is the OS/ARCH[/VARIANT] platform of the image to run, the host's when
+	// empty.
+	Platform string
+
+	// ReadOnly mounts the root filesystem read only.
+	ReadOnly bool
+
+	// Tmpfs maps container paths to the options of tmpfs mounts.
+	Tmpfs map[string]string
+
+	// Ports are published from the container on the host.
+	Ports []internal.PortMapping
+
+	// TTY allocates a terminal for the container.
+	TTY bool
+
+	// NoStdin creates the container without stdin, for runs that do not attach to it.
+	NoStdin bool
+
+	// Memory limits the memory of the container in bytes; zero means no limit.
+	Memory int64
+
+	// CPUs limits the number of CPUs the container may use; zero means no limit.
+	CPUs float64
+
+	// StopTimeout is the number of seconds the container is given to exit when it is
+	// stopped before it is killed.
 	StopTimeout int
-	TTYRetries  int
-	RetryDelay  time.Duration
+
+	// TTYRetries is how many times resizing the TTY is retried while the container
+	// starts, waiting RetryDelay, backed off, between retries.
+	TTYRetries int
+	RetryDelay time.Duration
+
+	// TTYResync is the interval at which the TTY size is rechecked regardless of resize
+	// signals; zero only resizes on signals.
+	TTYResync time.Duration
+
+	// Timeout bounds how long Container.Wait waits for the container to exit; zero means
+	// no limit.
+	Timeout time.Duration
+
+	// Restart is the policy with which the runtime restarts the container when its
+	// command exits; Container.Wait follows the container across those restarts.
+	Restart internal.RestartPolicy
+
+	// Keep leaves the container in place once its command exits, so a container that
+	// fails to stop is killed rather than removed.
+	Keep bool
 }
 
 // Runtime is the interface that container runtimes must implement.
 type Runtime interface {
-	BuildImage(ctx context.Context, dockerfilePath string, imageName internal.ImageName, w internal.Writer) (Image, error)
+	BuildImage(ctx context.Context, opts BuildImageOptions, w internal.Writer) (Image, error)
 	CreateContainer(ctx context.Context, opts CreateContainerOptions) (Container, error)
 	HostAddress() string
 	Close() error
@@ -45,8 +224,9 @@ type Runtime interface {
 type Container interface {
 	InspectUser(ctx context.Context) (ImageUser, error)
 	CopyTo(ctx context.Context, content io.Reader, path string) error
+	CopyFrom(ctx context.Context, srcPath string) (io.ReadCloser, error)
 	Start(ctx context.Context) error
-	Attach(ctx context.Context, cancel context.CancelFunc, w internal.Writer) error
-	Wait(ctx context.Context, w internal.Writer) error
+	Attach(ctx context.Context, cancel context.CancelFunc, w internal.Writer) (Attachment, error)
+	Wait(ctx context.Context, w internal.Writer) (int, error)
 	ForceRemove(ctx context.Context) error
 }
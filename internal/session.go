@@ -3,16 +3,35 @@ package internal
 import (
 	"fmt"
 	"math/rand/v2"
+	"strings"
 )
 
 type Session struct {
-	id int64
+	id   uint32
+	name string
 }
 
-// GenerateSession creates a new session with a random numeric identifier.
-// The session is used to generate unique container names and branch names.
+// GenerateSession creates a new session with a random 32-bit identifier.
+// The session is used to generate unique container names and branch names,
+// so the identifier space is wide enough to make collisions negligible.
 func GenerateSession() Session {
-	return Session{id: rand.Int64N(10000)}
+	return Session{id: rand.Uint32()}
+}
+
+// NewSession creates a session named name, so that its container is named
+// "contagent-<name>" and its branch "contagent/<name>", or generates a random
+// session when name is empty. Returns an error if name cannot be used in a
+// container name or a branch name.
+func NewSession(name string) (Session, error) {
+	if name == "" {
+		return GenerateSession(), nil
+	}
+
+	if err := validateSessionName(name); err != nil {
+		return Session{}, err
+	}
+
+	return Session{name: name}, nil
 }
 
 // String returns the string representation of the session, equivalent to calling ID().
@@ -20,14 +39,38 @@ func (s Session) String() string {
 	return string(s.ID())
 }
 
-// ID returns the session identifier in the format "contagent-<number>".
+// ID returns the session identifier in the format "contagent-<token>", where
+// token is the session name, or the identifier as 8 lowercase hex digits for a
+// generated session.
 // This is used as the Docker container name.
 func (s Session) ID() SessionID {
-	return SessionID(fmt.Sprintf("contagent-%d", s.id))
+	return SessionID("contagent-" + s.token())
 }
 
-// Branch returns the Git branch name in the format "contagent/<number>".
-// This branch is created in the container's repository for isolated work.
+// Branch returns the Git branch name in the format "contagent/<token>", using
+// the same token as ID. This branch is created in the container's repository
+// for isolated work.
 func (s Session) Branch() string {
-	return fmt.Sprintf("contagent/%d", s.id)
+	return "contagent/" + s.token()
+}
+
+func (s Session) token() string {
+	if s.name != "" {
+		return s.name
+	}
+	return fmt.Sprintf("%08x", s.id)
+}
+
+// validateSessionName checks that a session name fits both the Docker container name
+// it is part of and a single component of a git branch name.
+func validateSessionName(name string) error {
+	if SessionID("contagent-"+name).Valid() != nil {
+		return fmt.Errorf("invalid name %q: must contain only letters, digits, underscores, periods, or dashes\nFor example: --name fix-login", name)
+	}
+
+	if strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".") || strings.HasSuffix(name, ".lock") || strings.Contains(name, "..") {
+		return fmt.Errorf("invalid name %q: must not start or end with a period, end with .lock, or contain \"..\", as it is used in a git branch name\nFor example: --name fix-login", name)
+	}
+
+	return nil
 }
@@ -1,7 +1,7 @@
 package internal_test
 
 import (
-	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -19,36 +19,69 @@ func TestSession(t *testing.T) {
 	t.Run("GenerateSession", func(t *testing.T) {
 		t.Run("generates unique sessions", func(t *testing.T) {
 			sessions := make(map[string]struct{})
-			iterations := 1000
+			iterations := 10000
 
 			for range iterations {
 				session := setup(t)
 				sessions[session.String()] = struct{}{}
 			}
 
-			require.Greater(t, float64(len(sessions)), float64(iterations)*0.9, "expected high uniqueness in session generation")
+			// With a 32-bit space the expected number of collisions over 10000
+			// sessions is about 0.01, so allow at most one.
+			require.GreaterOrEqual(t, len(sessions), iterations-1, "expected near-total uniqueness in session generation")
 		})
 
-		t.Run("generates session IDs within valid range", func(t *testing.T) {
+		t.Run("generates session IDs with a hex token", func(t *testing.T) {
 			for range 100 {
 				session := setup(t)
 
-				require.Regexp(t, `^contagent-\d+$`, session.String())
-				require.Regexp(t, `^contagent/\d+$`, session.Branch())
+				require.Regexp(t, `^contagent-[0-9a-f]{8}$`, session.String())
+				require.Regexp(t, `^contagent/[0-9a-f]{8}$`, session.Branch())
 			}
 		})
 
-		t.Run("session ID is less than 10000", func(t *testing.T) {
+		t.Run("branch uses the same token as the session ID", func(t *testing.T) {
 			for range 100 {
 				session := setup(t)
-				sessionStr := session.String()
-
-				var id int64
-				n, err := fmt.Sscanf(sessionStr, "contagent-%d", &id)
-				require.NoError(t, err)
-				require.Equal(t, 1, n)
-				require.GreaterOrEqual(t, id, int64(0))
-				require.Less(t, id, int64(10000))
+
+				token := strings.TrimPrefix(session.String(), "contagent-")
+				require.Equal(t, "contagent/"+token, session.Branch())
+			}
+		})
+	})
+
+	t.Run("NewSession", func(t *testing.T) {
+		t.Run("uses the name for the session ID and branch", func(t *testing.T) {
+			session, err := internal.NewSession("fix-login_2.0")
+			require.NoError(t, err)
+
+			require.Equal(t, internal.SessionID("contagent-fix-login_2.0"), session.ID())
+			require.Equal(t, "contagent/fix-login_2.0", session.Branch())
+			require.NoError(t, session.ID().Valid())
+		})
+
+		t.Run("generates a random session when the name is empty", func(t *testing.T) {
+			first, err := internal.NewSession("")
+			require.NoError(t, err)
+			second, err := internal.NewSession("")
+			require.NoError(t, err)
+
+			require.Regexp(t, `^contagent-[0-9a-f]{8}$`, first.String())
+			require.Regexp(t, `^contagent/[0-9a-f]{8}$`, first.Branch())
+			require.NotEqual(t, first.ID(), second.ID())
+		})
+
+		t.Run("rejects names that are not valid container names", func(t *testing.T) {
+			for _, name := range []string{"my feature", "team/feature", "feature:1", "feature!"} {
+				_, err := internal.NewSession(name)
+				require.ErrorContains(t, err, "must contain only letters, digits, underscores, periods, or dashes", "name %q", name)
+			}
+		})
+
+		t.Run("rejects names that are not valid branch names", func(t *testing.T) {
+			for _, name := range []string{".feature", "feature.", "feature.lock", "fix..login"} {
+				_, err := internal.NewSession(name)
+				require.ErrorContains(t, err, "as it is used in a git branch name", "name %q", name)
 			}
 		})
 	})
@@ -1,13 +1,184 @@
 package internal
 
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
 // SessionID represents a unique session identifier for a container.
 type SessionID string
 
+var containerName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]+$`)
+
+// Valid checks that the session ID can be used as a container name, which Docker
+// requires to start with a letter or digit followed by at least one letter, digit,
+// underscore, period, or dash.
+func (id SessionID) Valid() error {
+	if !containerName.MatchString(string(id)) {
+		return fmt.Errorf("invalid container name %q: must start with a letter or digit, followed by one or more letters, digits, underscores, periods, or dashes\nFor example: contagent-1a2b3c4d", id)
+	}
+	return nil
+}
+
+// SessionLabel is the container label that carries the session ID, so containers
+// started by contagent can be found, e.g. with docker ps --filter label=contagent.session.
+const SessionLabel = "contagent.session"
+
+// CommitLabel is the image label that carries the hash of the commit the session was
+// started from, recording which code the image was built for.
+const CommitLabel = "contagent.commit"
+
 // ImageName represents a Docker image name.
 type ImageName string
 
+var (
+	imagePathComponent = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*$`)
+	imageRegistryHost  = regexp.MustCompile(`^[a-zA-Z0-9](?:[a-zA-Z0-9-]*[a-zA-Z0-9])?(?:\.[a-zA-Z0-9](?:[a-zA-Z0-9-]*[a-zA-Z0-9])?)*(?::[0-9]+)?$`)
+	imageTag           = regexp.MustCompile(`^[\w][\w.-]{0,127}$`)
+	imageDigest        = regexp.MustCompile(`@[a-z0-9]+(?:[.+_-][a-z0-9]+)*:[0-9a-fA-F]{32,}$`)
+)
+
+// Validate checks that the name is a valid Docker image reference of the form
+// [REGISTRY[:PORT]/]REPOSITORY[:TAG], so a malformed name is reported before any
+// image is built. Digests are rejected, as the built image is tagged with the name.
+func (n ImageName) Validate() error {
+	name := string(n)
+	if name == "" {
+		return fmt.Errorf("invalid image name: name is empty\nFor example: --image-name contagent:latest")
+	}
+
+	if imageDigest.MatchString(name) {
+		return fmt.Errorf("invalid image name %q: must not include a digest, as the built image is tagged with the name\nFor example: --image-name contagent:latest", name)
+	}
+
+	repository := name
+	slash := strings.LastIndex(name, "/")
+	if colon := strings.LastIndex(name, ":"); colon > slash {
+		repository = name[:colon]
+		if tag := name[colon+1:]; !imageTag.MatchString(tag) {
+			return fmt.Errorf("invalid image name %q: tag %q must be up to 128 letters, digits, underscores, periods, or dashes, and must not start with a period or dash\nFor example: --image-name contagent:latest", name, tag)
+		}
+	}
+
+	if len(repository) > 255 {
+		return fmt.Errorf("invalid image name %q: repository must not be longer than 255 characters", name)
+	}
+
+	// The first component is a registry when it looks like a hostname, as in
+	// registry.example.com:5000/team/agent
+	components := strings.Split(repository, "/")
+	if first := components[0]; len(components) > 1 && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		if !imageRegistryHost.MatchString(first) {
+			return fmt.Errorf("invalid image name %q: registry %q is not a valid hostname\nFor example: --image-name registry.example.com:5000/team/agent:latest", name, first)
+		}
+		components = components[1:]
+	}
+
+	for _, component := range components {
+		if imagePathComponent.MatchString(component) {
+			continue
+		}
+		if strings.ToLower(component) != component {
+			return fmt.Errorf("invalid image name %q: repository must be lowercase\nFor example: --image-name contagent:latest", name)
+		}
+		return fmt.Errorf("invalid image name %q: repository component %q must contain only lowercase letters and digits separated by periods, underscores, or dashes\nFor example: --image-name contagent:latest", name, component)
+	}
+
+	return nil
+}
+
+// PullPolicy controls when the base images of an image build are pulled.
+type PullPolicy string
+
+const (
+	// PullPolicyAlways pulls the base images on every build.
+	PullPolicyAlways PullPolicy = "always"
+	// PullPolicyMissing pulls the base images that are not present locally.
+	PullPolicyMissing PullPolicy = "missing"
+	// PullPolicyNever never pulls, failing the build when a base image is not present locally.
+	PullPolicyNever PullPolicy = "never"
+)
+
+// BuildProgress controls how the output of an image build is shown.
+type BuildProgress string
+
+const (
+	// BuildProgressAuto condenses the build output to a line per build step, only
+	// showing the full output of a step when it fails.
+	BuildProgressAuto BuildProgress = "auto"
+	// BuildProgressPlain shows the full build output.
+	BuildProgressPlain BuildProgress = "plain"
+)
+
+// BuildSecret is a secret exposed to an image build for RUN --mount=type=secret,id=ID
+// instructions, read from the file at Source without being stored in the image.
+type BuildSecret struct {
+	ID     string
+	Source string
+}
+
+// RestartPolicy controls whether the container is restarted when its command exits.
+// MaxRetries limits the restarts of RestartOnFailure; zero means no limit.
+type RestartPolicy struct {
+	Name       string
+	MaxRetries int
+}
+
+const (
+	// RestartNo never restarts the container.
+	RestartNo = "no"
+	// RestartOnFailure restarts the container when its command exits with a non-zero status.
+	RestartOnFailure = "on-failure"
+	// RestartAlways restarts the container whenever its command exits.
+	RestartAlways = "always"
+	// RestartUnlessStopped restarts the container whenever its command exits, unless it was stopped.
+	RestartUnlessStopped = "unless-stopped"
+)
+
+// Enabled reports whether the policy restarts the container at all.
+func (p RestartPolicy) Enabled() bool {
+	return p.Name != "" && p.Name != RestartNo
+}
+
+// Ownership is the numeric owner of the repository files copied into the container.
+type Ownership struct {
+	UID int
+	GID int
+}
+
 // Command represents the command and arguments to execute in the container.
 type Command []string
 
 // Environment represents environment variables to pass to the container.
 type Environment []string
+
+// DefaultSensitivePattern matches the names of environment variables whose values are
+// masked in output, such as ANTHROPIC_API_KEY or GITHUB_TOKEN.
+const DefaultSensitivePattern = `(?i)(API_?KEY|TOKEN|SECRET|PASSWORD)`
+
+// Redacted returns the environment with the values of the variables whose names match
+// sensitive replaced by "<redacted>", so that it can be shown without leaking secrets.
+func (e Environment) Redacted(sensitive *regexp.Regexp) Environment {
+	redacted := make(Environment, 0, len(e))
+	for _, variable := range e {
+		name, _, _ := strings.Cut(variable, "=")
+		if sensitive.MatchString(name) {
+			variable = name + "=<redacted>"
+		}
+		redacted = append(redacted, variable)
+	}
+	return redacted
+}
+
+// PortMapping publishes a container port on the host.
+type PortMapping struct {
+	HostPort      uint16
+	ContainerPort uint16
+	Protocol      string
+}
+
+// String returns the mapping in the "host:container/protocol" form.
+func (p PortMapping) String() string {
+	return fmt.Sprintf("%d:%d/%s", p.HostPort, p.ContainerPort, p.Protocol)
+}
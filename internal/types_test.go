@@ -0,0 +1,118 @@
+package internal_test
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/ryanmoran/contagent/internal"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImageName(t *testing.T) {
+	t.Run("Validate", func(t *testing.T) {
+		t.Run("accepts valid names", func(t *testing.T) {
+			for _, name := range []internal.ImageName{
+				"contagent:latest",
+				"contagent",
+				"team/agent_v2:1.0-rc.1",
+				"library/my--image__name",
+				"localhost/agent",
+				"localhost:5000/agent:dev",
+				"registry.example.com:5000/team/agent:v1.2.3",
+				"Registry.Example.com/agent:Latest",
+			} {
+				require.NoError(t, name.Validate(), "name %q", name)
+			}
+		})
+
+		t.Run("rejects invalid names", func(t *testing.T) {
+			for name, message := range map[internal.ImageName]string{
+				"":                       "name is empty",
+				"INVALID_IMAGE_NAME:@#$": `tag "@#$"`,
+				"Contagent:latest":       "repository must be lowercase",
+				"team/Agent":             "repository must be lowercase",
+				"contagent:":             `tag ""`,
+				"contagent:.hidden":      `tag ".hidden"`,
+				internal.ImageName("contagent:" + strings.Repeat("a", 129)): "tag",
+				"agent!":              `repository component "agent!"`,
+				"-agent":              `repository component "-agent"`,
+				"team//agent":         `repository component ""`,
+				"reg_istry.com/agent": `registry "reg_istry.com"`,
+				internal.ImageName("contagent@sha256:" + strings.Repeat("a", 64)): "must not include a digest",
+			} {
+				err := name.Validate()
+				require.Error(t, err, "name %q", name)
+				require.ErrorContains(t, err, message)
+			}
+		})
+	})
+}
+
+func TestSessionID(t *testing.T) {
+	t.Run("Valid", func(t *testing.T) {
+		t.Run("accepts valid names", func(t *testing.T) {
+			for _, id := range []internal.SessionID{
+				internal.GenerateSession().ID(),
+				"contagent-1a2b3c4d",
+				"my_agent.dev",
+				"Agent-01",
+				"9lives",
+			} {
+				require.NoError(t, id.Valid(), "id %q", id)
+			}
+		})
+
+		t.Run("rejects invalid names", func(t *testing.T) {
+			for _, id := range []internal.SessionID{
+				"",
+				"a",
+				"-agent",
+				".agent",
+				"_agent",
+				"my agent",
+				"team/agent",
+				"agent:dev",
+			} {
+				err := id.Valid()
+				require.Error(t, err, "id %q", id)
+				require.ErrorContains(t, err, "invalid container name")
+			}
+		})
+	})
+}
+
+func TestEnvironmentRedacted(t *testing.T) {
+	env := internal.Environment{
+		"TERM=xterm-256color",
+		"COLORTERM=truecolor",
+		"SSH_AUTH_SOCK=/run/host-services/ssh-auth.sock",
+		"ANTHROPIC_API_KEY=sk-ant-secret",
+		"OPENAI_APIKEY=sk-secret",
+		"GITHUB_TOKEN=ghp_secret",
+		"AWS_SECRET_ACCESS_KEY=aws-secret",
+		"db_password=hunter2",
+		"EMPTY=",
+	}
+
+	require.Equal(t, internal.Environment{
+		"TERM=xterm-256color",
+		"COLORTERM=truecolor",
+		"SSH_AUTH_SOCK=/run/host-services/ssh-auth.sock",
+		"ANTHROPIC_API_KEY=<redacted>",
+		"OPENAI_APIKEY=<redacted>",
+		"GITHUB_TOKEN=<redacted>",
+		"AWS_SECRET_ACCESS_KEY=<redacted>",
+		"db_password=<redacted>",
+		"EMPTY=",
+	}, env.Redacted(regexp.MustCompile(internal.DefaultSensitivePattern)))
+
+	t.Run("with a custom pattern", func(t *testing.T) {
+		redacted := env.Redacted(regexp.MustCompile(`^TERM$`))
+		require.Equal(t, "TERM=<redacted>", redacted[0])
+		require.Equal(t, "ANTHROPIC_API_KEY=sk-ant-secret", redacted[3])
+	})
+
+	// The environment itself is left as it was
+	require.Equal(t, "ANTHROPIC_API_KEY=sk-ant-secret", env[3])
+}
@@ -1,9 +1,37 @@
 package internal
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// LogFormatText selects the human-readable StandardWriter.
+	LogFormatText = "text"
+
+	// LogFormatJSON selects the JSONWriter, which emits one JSON object per line.
+	LogFormatJSON = "json"
+)
+
+// LogLevel controls which messages a Writer emits. Messages below the
+// configured level are discarded; warnings and fatal errors are always emitted.
+type LogLevel int
+
+const (
+	// LogLevelDebug emits everything, including Debug output (--verbose).
+	LogLevelDebug LogLevel = iota - 1
+
+	// LogLevelInfo emits normal output but not Debug output. This is the default.
+	LogLevelInfo
+
+	// LogLevelWarning emits only warnings and fatal errors (--quiet).
+	LogLevelWarning
 )
 
 // Writer provides methods for output operations that library code needs.
@@ -19,6 +47,12 @@ type Writer interface {
 	// Println writes a message with a newline to the output stream.
 	Println(v ...interface{})
 
+	// Debug writes a diagnostic message that is only shown in verbose mode.
+	Debug(v ...interface{})
+
+	// Debugf writes a formatted diagnostic message that is only shown in verbose mode.
+	Debugf(format string, v ...interface{})
+
 	// Warning writes a warning message to the output stream.
 	Warning(v ...interface{})
 
@@ -33,21 +67,26 @@ type Writer interface {
 	// Implementation should handle cleanup and termination appropriately.
 	Fatalf(format string, v ...interface{})
 
-	// GetWriter returns the underlying io.Writer for direct writing.
+	// GetWriter returns the underlying io.Writer for direct writing. Output written
+	// to it is treated like Print and is discarded when normal output is suppressed.
 	GetWriter() io.Writer
 }
 
 // StandardWriter implements Writer using standard output/error streams.
 type StandardWriter struct {
-	out io.Writer
-	err io.Writer
+	out   io.Writer
+	err   io.Writer
+	level LogLevel
+	now   func() time.Time
 }
 
 // NewStandardWriter creates a Writer that outputs to stdout and stderr.
 func NewStandardWriter() *StandardWriter {
 	return &StandardWriter{
-		out: os.Stdout,
-		err: os.Stderr,
+		out:   os.Stdout,
+		err:   os.Stderr,
+		level: LogLevelInfo,
+		now:   time.Now,
 	}
 }
 
@@ -55,30 +94,71 @@ func NewStandardWriter() *StandardWriter {
 // The out stream is used for normal output, while err is used for warnings and fatal errors.
 func NewCustomWriter(out, err io.Writer) *StandardWriter {
 	return &StandardWriter{
-		out: out,
-		err: err,
+		out:   out,
+		err:   err,
+		level: LogLevelInfo,
+		now:   time.Now,
 	}
 }
 
+// SetLevel sets the minimum level of messages the writer emits.
+func (w *StandardWriter) SetLevel(level LogLevel) {
+	w.level = level
+}
+
+// SetTimestamps enables or disables prefixing each output line with an RFC3339
+// timestamp. This applies to both streams, including output written via GetWriter.
+func (w *StandardWriter) SetTimestamps(enabled bool) {
+	clock := func() time.Time { return w.now() }
+	w.out = timestamped(w.out, enabled, clock)
+	w.err = timestamped(w.err, enabled, clock)
+}
+
 // Print writes a message to the output stream without adding a newline.
 func (w *StandardWriter) Print(v ...interface{}) {
+	if w.level > LogLevelInfo {
+		return
+	}
 	fmt.Fprint(w.out, v...)
 }
 
 // Printf writes a formatted message to the output stream.
 func (w *StandardWriter) Printf(format string, v ...interface{}) {
+	if w.level > LogLevelInfo {
+		return
+	}
 	fmt.Fprintf(w.out, format, v...)
 }
 
 // Println writes a message with a newline to the output stream.
 func (w *StandardWriter) Println(v ...interface{}) {
+	if w.level > LogLevelInfo {
+		return
+	}
 	fmt.Fprintln(w.out, v...)
 }
 
+// Debug writes a message to the error stream with a "Debug: " prefix when the
+// level is LogLevelDebug.
+func (w *StandardWriter) Debug(v ...interface{}) {
+	if w.level > LogLevelDebug {
+		return
+	}
+	fmt.Fprint(w.err, "Debug: "+fmt.Sprintln(v...))
+}
+
+// Debugf writes a formatted message to the error stream with a "Debug: " prefix when
+// the level is LogLevelDebug.
+func (w *StandardWriter) Debugf(format string, v ...interface{}) {
+	if w.level > LogLevelDebug {
+		return
+	}
+	fmt.Fprintf(w.err, "Debug: "+format+"\n", v...)
+}
+
 // Warning writes a warning message to the error stream with a "Warning: " prefix.
 func (w *StandardWriter) Warning(v ...interface{}) {
-	fmt.Fprint(w.err, "Warning: ")
-	fmt.Fprintln(w.err, v...)
+	fmt.Fprint(w.err, "Warning: "+fmt.Sprintln(v...))
 }
 
 // Warningf writes a formatted warning message to the error stream with a "Warning: " prefix.
@@ -88,17 +168,340 @@ func (w *StandardWriter) Warningf(format string, v ...interface{}) {
 
 // Fatal writes an error message to the error stream and exits the program with status 1.
 func (w *StandardWriter) Fatal(v ...interface{}) {
-	fmt.Fprintln(w.err, v...)
+	w.writeFatal(fmt.Sprintln(v...))
 	os.Exit(1)
 }
 
 // Fatalf writes a formatted error message to the error stream and exits the program with status 1.
 func (w *StandardWriter) Fatalf(format string, v ...interface{}) {
-	fmt.Fprintf(w.err, format+"\n", v...)
+	w.writeFatal(fmt.Sprintf(format+"\n", v...))
 	os.Exit(1)
 }
 
-// GetWriter returns the underlying io.Writer for direct writing to the output stream.
+// writeFatal writes a fatal error message to the error stream without exiting.
+func (w *StandardWriter) writeFatal(message string) {
+	fmt.Fprint(w.err, message)
+}
+
+// GetWriter returns the underlying io.Writer for direct writing to the output stream,
+// or io.Discard when normal output is suppressed.
 func (w *StandardWriter) GetWriter() io.Writer {
+	if w.level > LogLevelInfo {
+		return io.Discard
+	}
 	return w.out
 }
+
+// timestamped wraps out in a timestampWriter when enabled, or unwraps it otherwise.
+func timestamped(out io.Writer, enabled bool, now func() time.Time) io.Writer {
+	if t, ok := out.(*timestampWriter); ok {
+		out = t.out
+	}
+	if !enabled {
+		return out
+	}
+	return &timestampWriter{mu: sync.Mutex{}, out: out, now: now, midLine: false}
+}
+
+// timestampWriter prefixes every line written through it with the current time.
+// Writes that do not end in a newline leave the line open, so the next write
+// continues it without another prefix. Writes are serialized, as the build output,
+// the git server, and the main goroutine write concurrently.
+type timestampWriter struct {
+	mu      sync.Mutex
+	out     io.Writer
+	now     func() time.Time
+	midLine bool
+}
+
+func (t *timestampWriter) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var written int
+	for len(p) > 0 {
+		if !t.midLine {
+			if _, err := fmt.Fprintf(t.out, "%s ", t.now().Format(time.RFC3339)); err != nil {
+				return written, err
+			}
+		}
+
+		line := p
+		if i := bytes.IndexByte(p, '\n'); i >= 0 {
+			line = p[:i+1]
+		}
+
+		n, err := t.out.Write(line)
+		written += n
+		if err != nil {
+			return written, err
+		}
+
+		t.midLine = line[len(line)-1] != '\n'
+		p = p[len(line):]
+	}
+	return written, nil
+}
+
+// JSONWriter implements Writer by emitting one JSON object per message, suitable
+// for machine-readable logs in CI. Each object has a level, message, and time field.
+type JSONWriter struct {
+	mu    sync.Mutex
+	out   io.Writer
+	level LogLevel
+	now   func() time.Time
+	exit  func(int)
+}
+
+// jsonEntry is the structure of each line written by JSONWriter.
+type jsonEntry struct {
+	Level   string `json:"level"`
+	Message string `json:"message"`
+	Time    string `json:"time"`
+}
+
+// NewJSONWriter creates a Writer that emits JSON lines to out.
+func NewJSONWriter(out io.Writer) *JSONWriter {
+	return &JSONWriter{
+		mu:    sync.Mutex{},
+		out:   out,
+		level: LogLevelInfo,
+		now:   time.Now,
+		exit:  os.Exit,
+	}
+}
+
+// SetLevel sets the minimum level of entries the writer emits.
+func (w *JSONWriter) SetLevel(level LogLevel) {
+	w.level = level
+}
+
+// Print writes an "info" entry with the message formatted as by fmt.Sprint.
+func (w *JSONWriter) Print(v ...interface{}) {
+	w.write(LogLevelInfo, "info", fmt.Sprint(v...))
+}
+
+// Printf writes an "info" entry with the message formatted as by fmt.Sprintf.
+func (w *JSONWriter) Printf(format string, v ...interface{}) {
+	w.write(LogLevelInfo, "info", fmt.Sprintf(format, v...))
+}
+
+// Println writes an "info" entry with the message formatted as by fmt.Sprintln.
+func (w *JSONWriter) Println(v ...interface{}) {
+	w.write(LogLevelInfo, "info", fmt.Sprintln(v...))
+}
+
+// Debug writes a "debug" entry when the level is LogLevelDebug.
+func (w *JSONWriter) Debug(v ...interface{}) {
+	w.write(LogLevelDebug, "debug", fmt.Sprintln(v...))
+}
+
+// Debugf writes a formatted "debug" entry when the level is LogLevelDebug.
+func (w *JSONWriter) Debugf(format string, v ...interface{}) {
+	w.write(LogLevelDebug, "debug", fmt.Sprintf(format, v...))
+}
+
+// Warning writes a "warning" entry.
+func (w *JSONWriter) Warning(v ...interface{}) {
+	w.write(LogLevelWarning, "warning", fmt.Sprintln(v...))
+}
+
+// Warningf writes a formatted "warning" entry.
+func (w *JSONWriter) Warningf(format string, v ...interface{}) {
+	w.write(LogLevelWarning, "warning", fmt.Sprintf(format, v...))
+}
+
+// Fatal writes a "fatal" entry and exits the program with status 1.
+func (w *JSONWriter) Fatal(v ...interface{}) {
+	w.writeFatal(fmt.Sprintln(v...))
+	w.exit(1)
+}
+
+// Fatalf writes a formatted "fatal" entry and exits the program with status 1.
+func (w *JSONWriter) Fatalf(format string, v ...interface{}) {
+	w.writeFatal(fmt.Sprintf(format, v...))
+	w.exit(1)
+}
+
+// writeFatal writes a "fatal" entry without exiting.
+func (w *JSONWriter) writeFatal(message string) {
+	w.write(LogLevelWarning, "fatal", message)
+}
+
+// GetWriter returns an io.Writer that emits each line written to it as an "info"
+// entry, so streamed output such as image builds stays one JSON object per line.
+func (w *JSONWriter) GetWriter() io.Writer {
+	return jsonLineWriter{w: w}
+}
+
+// write encodes a single entry unless its level is below the writer's level.
+// Trailing newlines are trimmed from the message since the entry itself is
+// newline-terminated.
+func (w *JSONWriter) write(level LogLevel, name, message string) {
+	if level < w.level {
+		return
+	}
+
+	entry, err := json.Marshal(jsonEntry{
+		Level:   name,
+		Message: strings.TrimRight(message, "\n"),
+		Time:    w.now().UTC().Format(time.RFC3339Nano),
+	})
+	if err != nil {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	fmt.Fprintf(w.out, "%s\n", entry)
+}
+
+// jsonLineWriter adapts raw output into JSONWriter entries, one per line.
+type jsonLineWriter struct {
+	w *JSONWriter
+}
+
+func (l jsonLineWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	for _, line := range bytes.Split(bytes.TrimRight(p, "\n"), []byte("\n")) {
+		l.w.write(LogLevelInfo, "info", string(bytes.TrimRight(line, "\r")))
+	}
+	return len(p), nil
+}
+
+// DiscardWriter is a Writer that drops all output. Unlike the other writers, Fatal and
+// Fatalf do not exit, so it is safe to use in tests.
+type DiscardWriter struct{}
+
+// NewDiscardWriter creates a Writer that drops all output.
+func NewDiscardWriter() DiscardWriter {
+	return DiscardWriter{}
+}
+
+func (DiscardWriter) Print(v ...interface{})                   {}
+func (DiscardWriter) Printf(format string, v ...interface{})   {}
+func (DiscardWriter) Println(v ...interface{})                 {}
+func (DiscardWriter) Debug(v ...interface{})                   {}
+func (DiscardWriter) Debugf(format string, v ...interface{})   {}
+func (DiscardWriter) Warning(v ...interface{})                 {}
+func (DiscardWriter) Warningf(format string, v ...interface{}) {}
+func (DiscardWriter) Fatal(v ...interface{})                   {}
+func (DiscardWriter) Fatalf(format string, v ...interface{})   {}
+
+// GetWriter returns io.Discard.
+func (DiscardWriter) GetWriter() io.Writer {
+	return io.Discard
+}
+
+func (DiscardWriter) writeFatal(message string) {}
+
+// fatalWriter is implemented by the writers of this package, so that MultiWriter can
+// write a fatal error to each of them and exit only once.
+type fatalWriter interface {
+	writeFatal(message string)
+}
+
+// MultiWriter is a Writer that forwards every call to several Writers.
+type MultiWriter struct {
+	writers []Writer
+	exit    func(int)
+}
+
+// NewMultiWriter creates a Writer that forwards every call to each of writers, in order.
+func NewMultiWriter(writers ...Writer) *MultiWriter {
+	return &MultiWriter{
+		writers: writers,
+		exit:    os.Exit,
+	}
+}
+
+// Print forwards to Print of each writer.
+func (m *MultiWriter) Print(v ...interface{}) {
+	for _, w := range m.writers {
+		w.Print(v...)
+	}
+}
+
+// Printf forwards to Printf of each writer.
+func (m *MultiWriter) Printf(format string, v ...interface{}) {
+	for _, w := range m.writers {
+		w.Printf(format, v...)
+	}
+}
+
+// Println forwards to Println of each writer.
+func (m *MultiWriter) Println(v ...interface{}) {
+	for _, w := range m.writers {
+		w.Println(v...)
+	}
+}
+
+// Debug forwards to Debug of each writer.
+func (m *MultiWriter) Debug(v ...interface{}) {
+	for _, w := range m.writers {
+		w.Debug(v...)
+	}
+}
+
+// Debugf forwards to Debugf of each writer.
+func (m *MultiWriter) Debugf(format string, v ...interface{}) {
+	for _, w := range m.writers {
+		w.Debugf(format, v...)
+	}
+}
+
+// Warning forwards to Warning of each writer.
+func (m *MultiWriter) Warning(v ...interface{}) {
+	for _, w := range m.writers {
+		w.Warning(v...)
+	}
+}
+
+// Warningf forwards to Warningf of each writer.
+func (m *MultiWriter) Warningf(format string, v ...interface{}) {
+	for _, w := range m.writers {
+		w.Warningf(format, v...)
+	}
+}
+
+// Fatal writes an error message to each writer and exits the program with status 1.
+func (m *MultiWriter) Fatal(v ...interface{}) {
+	m.writeFatal(fmt.Sprintln(v...))
+	m.exit(1)
+}
+
+// Fatalf writes a formatted error message to each writer and exits the program with status 1.
+func (m *MultiWriter) Fatalf(format string, v ...interface{}) {
+	m.writeFatal(fmt.Sprintf(format+"\n", v...))
+	m.exit(1)
+}
+
+// writeFatal writes a fatal error message to each writer without exiting. Writers from
+// outside this package can only be reached through their Fatal, which may exit, so it
+// is called after every other writer has been written to.
+func (m *MultiWriter) writeFatal(message string) {
+	var others []Writer
+	for _, w := range m.writers {
+		if fw, ok := w.(fatalWriter); ok {
+			fw.writeFatal(message)
+		} else {
+			others = append(others, w)
+		}
+	}
+
+	for _, w := range others {
+		w.Fatal(strings.TrimSuffix(message, "\n"))
+	}
+}
+
+// GetWriter returns an io.Writer that writes to the GetWriter of each writer.
+func (m *MultiWriter) GetWriter() io.Writer {
+	writers := make([]io.Writer, 0, len(m.writers))
+	for _, w := range m.writers {
+		writers = append(writers, w.GetWriter())
+	}
+	return io.MultiWriter(writers...)
+}
@@ -0,0 +1,313 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStandardWriter(t *testing.T) {
+	setup := func(t *testing.T, level LogLevel) (*StandardWriter, *bytes.Buffer, *bytes.Buffer) {
+		t.Helper()
+
+		var out, err bytes.Buffer
+		w := NewCustomWriter(&out, &err)
+		w.SetLevel(level)
+
+		return w, &out, &err
+	}
+
+	emit := func(w *StandardWriter) {
+		w.Print("print\n")
+		w.Printf("printf %d\n", 1)
+		w.Println("println")
+		fmt.Fprint(w.GetWriter(), "stream\n")
+		w.Debug("debug")
+		w.Debugf("debugf %d", 2)
+		w.Warning("warning")
+		w.Warningf("warningf %d", 3)
+	}
+
+	t.Run("shows normal output and warnings at the info level", func(t *testing.T) {
+		w, out, err := setup(t, LogLevelInfo)
+		emit(w)
+
+		require.Equal(t, "print\nprintf 1\nprintln\nstream\n", out.String())
+		require.Equal(t, "Warning: warning\nWarning: warningf 3\n", err.String())
+	})
+
+	t.Run("also shows debug output at the debug level", func(t *testing.T) {
+		w, out, err := setup(t, LogLevelDebug)
+		emit(w)
+
+		require.Equal(t, "print\nprintf 1\nprintln\nstream\n", out.String())
+		require.Equal(t, "Debug: debug\nDebug: debugf 2\nWarning: warning\nWarning: warningf 3\n", err.String())
+	})
+
+	t.Run("only shows warnings at the warning level", func(t *testing.T) {
+		w, out, err := setup(t, LogLevelWarning)
+		emit(w)
+
+		require.Empty(t, out.String())
+		require.Equal(t, "Warning: warning\nWarning: warningf 3\n", err.String())
+	})
+
+	t.Run("prefixes each line with a timestamp when enabled", func(t *testing.T) {
+		w, out, err := setup(t, LogLevelInfo)
+		w.now = func() time.Time { return time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC) }
+		w.SetTimestamps(true)
+
+		w.Print("partial ")
+		w.Print("line\n")
+		w.Printf("two\nlines\n")
+		w.Println("done")
+		fmt.Fprint(w.GetWriter(), "Step 1/2\nStep 2/2\n")
+		w.Warning("careful")
+
+		require.Equal(t, "2024-01-02T03:04:05Z partial line\n"+
+			"2024-01-02T03:04:05Z two\n"+
+			"2024-01-02T03:04:05Z lines\n"+
+			"2024-01-02T03:04:05Z done\n"+
+			"2024-01-02T03:04:05Z Step 1/2\n"+
+			"2024-01-02T03:04:05Z Step 2/2\n", out.String())
+		require.Equal(t, "2024-01-02T03:04:05Z Warning: careful\n", err.String())
+	})
+
+	t.Run("prefixes the lines of concurrent writers", func(t *testing.T) {
+		w, out, _ := setup(t, LogLevelInfo)
+		w.now = func() time.Time { return time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC) }
+		w.SetTimestamps(true)
+
+		var wg sync.WaitGroup
+		for range 4 {
+			wg.Go(func() {
+				for range 100 {
+					w.Println("line")
+				}
+			})
+		}
+		wg.Wait()
+
+		require.Equal(t, strings.Repeat("2024-01-02T03:04:05Z line\n", 400), out.String())
+	})
+
+	t.Run("stops prefixing when timestamps are disabled again", func(t *testing.T) {
+		w, out, _ := setup(t, LogLevelInfo)
+		w.now = func() time.Time { return time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC) }
+		w.SetTimestamps(true)
+		w.SetTimestamps(true)
+
+		w.Println("stamped")
+		w.SetTimestamps(false)
+		w.Println("plain")
+
+		require.Equal(t, "2024-01-02T03:04:05Z stamped\nplain\n", out.String())
+	})
+
+	t.Run("defaults to the info level", func(t *testing.T) {
+		var out, err bytes.Buffer
+		emit(NewCustomWriter(&out, &err))
+
+		require.Equal(t, "print\nprintf 1\nprintln\nstream\n", out.String())
+		require.Equal(t, "Warning: warning\nWarning: warningf 3\n", err.String())
+	})
+}
+
+func TestJSONWriter(t *testing.T) {
+	setup := func(t *testing.T) (*JSONWriter, *bytes.Buffer, *int) {
+		t.Helper()
+
+		var buffer bytes.Buffer
+		exitCode := -1
+
+		w := NewJSONWriter(&buffer)
+		w.now = func() time.Time { return time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC) }
+		w.exit = func(code int) { exitCode = code }
+
+		return w, &buffer, &exitCode
+	}
+
+	decode := func(t *testing.T, output string) []jsonEntry {
+		t.Helper()
+
+		var entries []jsonEntry
+		for _, line := range strings.Split(strings.TrimSuffix(output, "\n"), "\n") {
+			require.True(t, json.Valid([]byte(line)), "invalid JSON line: %q", line)
+
+			var entry jsonEntry
+			require.NoError(t, json.Unmarshal([]byte(line), &entry))
+			entries = append(entries, entry)
+		}
+		return entries
+	}
+
+	t.Run("emits one JSON object per call", func(t *testing.T) {
+		w, buffer, _ := setup(t)
+
+		w.Print("hello ", "world")
+		w.Printf("building %s\n", "image")
+		w.Println("done")
+
+		require.Equal(t, []jsonEntry{
+			{Level: "info", Message: "hello world", Time: "2024-01-02T03:04:05Z"},
+			{Level: "info", Message: "building image", Time: "2024-01-02T03:04:05Z"},
+			{Level: "info", Message: "done", Time: "2024-01-02T03:04:05Z"},
+		}, decode(t, buffer.String()))
+	})
+
+	t.Run("sets the warning level", func(t *testing.T) {
+		w, buffer, _ := setup(t)
+
+		w.Warning("disk", "low")
+		w.Warningf("retrying in %d seconds", 5)
+
+		require.Equal(t, []jsonEntry{
+			{Level: "warning", Message: "disk low", Time: "2024-01-02T03:04:05Z"},
+			{Level: "warning", Message: "retrying in 5 seconds", Time: "2024-01-02T03:04:05Z"},
+		}, decode(t, buffer.String()))
+	})
+
+	t.Run("sets the fatal level and exits", func(t *testing.T) {
+		w, buffer, exitCode := setup(t)
+
+		w.Fatal("boom")
+		require.Equal(t, 1, *exitCode)
+
+		*exitCode = -1
+		w.Fatalf("failed: %s", "bad thing")
+		require.Equal(t, 1, *exitCode)
+
+		require.Equal(t, []jsonEntry{
+			{Level: "fatal", Message: "boom", Time: "2024-01-02T03:04:05Z"},
+			{Level: "fatal", Message: "failed: bad thing", Time: "2024-01-02T03:04:05Z"},
+		}, decode(t, buffer.String()))
+	})
+
+	t.Run("escapes quotes and embedded newlines", func(t *testing.T) {
+		w, buffer, _ := setup(t)
+
+		w.Printf("say %q\nthen stop", "hi")
+
+		require.Equal(t, []jsonEntry{
+			{Level: "info", Message: "say \"hi\"\nthen stop", Time: "2024-01-02T03:04:05Z"},
+		}, decode(t, buffer.String()))
+	})
+
+	t.Run("filters entries below the configured level", func(t *testing.T) {
+		levels := func(output string) []string {
+			var names []string
+			for _, entry := range decode(t, output) {
+				names = append(names, entry.Level)
+			}
+			return names
+		}
+
+		for level, expected := range map[LogLevel][]string{
+			LogLevelDebug:   {"debug", "info", "info", "warning", "fatal"},
+			LogLevelInfo:    {"info", "info", "warning", "fatal"},
+			LogLevelWarning: {"warning", "fatal"},
+		} {
+			w, buffer, _ := setup(t)
+			w.SetLevel(level)
+
+			w.Debug("debug")
+			w.Print("print")
+			fmt.Fprint(w.GetWriter(), "stream\n")
+			w.Warning("warning")
+			w.Fatal("fatal")
+
+			require.Equal(t, expected, levels(buffer.String()), "level %d", level)
+		}
+	})
+
+	t.Run("GetWriter emits each written line as an entry", func(t *testing.T) {
+		w, buffer, _ := setup(t)
+
+		output := []byte("Step 1/2 : FROM alpine\r\nStep 2/2 : RUN true\n")
+		n, err := w.GetWriter().Write(output)
+		require.NoError(t, err)
+		require.Equal(t, len(output), n)
+
+		require.Equal(t, []jsonEntry{
+			{Level: "info", Message: "Step 1/2 : FROM alpine", Time: "2024-01-02T03:04:05Z"},
+			{Level: "info", Message: "Step 2/2 : RUN true", Time: "2024-01-02T03:04:05Z"},
+		}, decode(t, buffer.String()))
+	})
+}
+
+func TestDiscardWriter(t *testing.T) {
+	var w Writer = NewDiscardWriter()
+
+	// None of the methods write anywhere or exit the test binary
+	w.Print("print")
+	w.Printf("printf %d", 1)
+	w.Println("println")
+	w.Debug("debug")
+	w.Debugf("debugf %d", 2)
+	w.Warning("warning")
+	w.Warningf("warningf %d", 3)
+	w.Fatal("fatal")
+	w.Fatalf("fatalf %d", 4)
+
+	n, err := fmt.Fprint(w.GetWriter(), "stream")
+	require.NoError(t, err)
+	require.Equal(t, 6, n)
+	require.Equal(t, io.Discard, w.GetWriter())
+}
+
+func TestMultiWriter(t *testing.T) {
+	setup := func(t *testing.T) (*MultiWriter, []*bytes.Buffer, *int) {
+		t.Helper()
+
+		var buffers []*bytes.Buffer
+		var writers []Writer
+		for range 2 {
+			var buffer bytes.Buffer
+			buffers = append(buffers, &buffer)
+			writers = append(writers, NewCustomWriter(&buffer, &buffer))
+		}
+
+		exits := 0
+		w := NewMultiWriter(writers...)
+		w.exit = func(code int) {
+			require.Equal(t, 1, code)
+			exits++
+		}
+
+		return w, buffers, &exits
+	}
+
+	t.Run("forwards every call to each writer", func(t *testing.T) {
+		w, buffers, _ := setup(t)
+
+		w.Print("print\n")
+		w.Printf("printf %d\n", 1)
+		w.Println("println")
+		w.Debug("hidden")
+		w.Warning("warning")
+		w.Warningf("warningf %d", 2)
+		fmt.Fprint(w.GetWriter(), "stream\n")
+
+		expected := "print\nprintf 1\nprintln\nWarning: warning\nWarning: warningf 2\nstream\n"
+		require.Equal(t, expected, buffers[0].String())
+		require.Equal(t, expected, buffers[1].String())
+	})
+
+	t.Run("writes fatal errors to each writer and exits once", func(t *testing.T) {
+		w, buffers, exits := setup(t)
+
+		w.Fatal("fatal")
+		w.Fatalf("fatalf %d", 3)
+
+		require.Equal(t, "fatal\nfatalf 3\n", buffers[0].String())
+		require.Equal(t, "fatal\nfatalf 3\n", buffers[1].String())
+		require.Equal(t, 2, *exits)
+	})
+}
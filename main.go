@@ -1,26 +1,40 @@
 package main
 
 import (
+	"cmp"
 	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"sort"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/moby/term"
 	"github.com/ryanmoran/contagent/internal"
 	"github.com/ryanmoran/contagent/internal/apple"
+	"github.com/ryanmoran/contagent/internal/config"
 	"github.com/ryanmoran/contagent/internal/docker"
 	"github.com/ryanmoran/contagent/internal/git"
 	"github.com/ryanmoran/contagent/internal/runtime"
 )
 
 func main() {
+	// Attaching puts the terminal into raw mode, which a panic must not leave behind
+	restoreTerminal := saveTerminal(os.Stdin.Fd())
+
 	var exitCode int
 	defer func() {
 		if r := recover(); r != nil {
+			restoreTerminal()
 			log.Printf("panic occurred: %v", r)
 			exitCode = 1
 		}
@@ -29,24 +43,57 @@ func main() {
 		}
 	}()
 
-	if err := run(os.Args, os.Environ()); err != nil {
-		log.Println(err)
-		exitCode = 1
+	code, err := run(os.Args, os.Environ(), os.Stdout, os.Stderr)
+	if err != nil {
+		var usageErr *config.UsageError
+		if errors.As(err, &usageErr) {
+			if errors.Is(err, flag.ErrHelp) {
+				fmt.Fprint(os.Stdout, usageErr.Usage)
+				return
+			}
+			log.Println(err)
+			fmt.Fprint(os.Stderr, usageErr.Usage)
+		} else {
+			log.Println(err)
+		}
+		code = 1
+	}
+	exitCode = code
+}
+
+// saveTerminal records the state of the terminal at fd and returns a function that
+// restores it. The function does nothing when fd is not a terminal.
+func saveTerminal(fd uintptr) func() {
+	if !term.IsTerminal(fd) {
+		return func() {}
+	}
+
+	state, err := term.SaveState(fd)
+	if err != nil {
+		return func() {}
+	}
+
+	return func() {
+		term.RestoreTerminal(fd, state) //nolint:errcheck // best effort while exiting
 	}
 }
 
-func run(args, env []string) error {
+// run executes the full contagent workflow and returns the exit code of the
+// command run inside the container. Cleanup runs before run returns, so the
+// caller may exit the process immediately with the returned code. Output is
+// written to stdout and stderr.
+func run(args, env []string, stdout, stderr io.Writer) (int, error) {
 	cleanup := internal.NewCleanupManager()
 	defer cleanup.Execute()
 
 	workingDirectory, err := os.Getwd()
 	if err != nil {
-		return fmt.Errorf("failed to get current working directory: %w\nThis is a system error - check file system permissions", err)
+		return 0, fmt.Errorf("failed to get current working directory: %w\nThis is a system error - check file system permissions", err)
 	}
 
 	config, err := internal.ParseConfig(args[1:], env, workingDirectory)
 	if err != nil {
-		return fmt.Errorf("failed to parse configuration: %w", err)
+		return 0, fmt.Errorf("failed to parse configuration: %w", err)
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -59,34 +106,49 @@ func run(args, env []string) error {
 		cancel()
 	}()
 
-	w := internal.NewStandardWriter()
+	w := newWriter(config, stdout, stderr)
 
-	session := internal.GenerateSession()
+	if config.PrintConfig {
+		data, err := config.JSON()
+		if err != nil {
+			return 0, fmt.Errorf("failed to encode configuration: %w", err)
+		}
+		w.Printf("%s\n", data)
+		return 0, nil
+	}
+
+	session, err := internal.NewSession(config.Name)
+	if err != nil {
+		return 0, err
+	}
+
+	if config.LogFile != "" {
+		path := strings.ReplaceAll(config.LogFile, "{session}", string(session.ID()))
+		file, err := openLogFile(path)
+		if err != nil {
+			return 0, err
+		}
+		cleanup.Add("log-file", file.Close)
+
+		w = internal.NewMultiWriter(w, newWriter(config, file, file))
+	}
 
 	gitRoot, err := git.FindRoot(workingDirectory)
 	if err != nil {
-		return fmt.Errorf("not a git repository: %w", err)
+		return 0, fmt.Errorf("not a git repository: %w", err)
 	}
 
 	relPath, err := filepath.Rel(gitRoot, workingDirectory)
 	if err != nil {
-		return fmt.Errorf("failed to compute relative path from git root: %w", err)
+		return 0, fmt.Errorf("failed to compute relative path from git root: %w", err)
 	}
 
-	// containerWorkingDir is where the container starts. When running from a
-	// subdirectory, it is config.WorkingDir + relPath. The archive always
-	// extracts to config.WorkingDir so the git root path in the container
-	// remains stable regardless of where contagent was invoked.
-	containerWorkingDir := config.WorkingDir
-	if relPath != "." {
-		containerWorkingDir = filepath.Join(config.WorkingDir, relPath)
-	}
+	containerWorkingDir := resolveContainerWorkingDir(config, gitRoot, relPath, w)
 
-	remote, err := git.NewServer(gitRoot, w)
-	if err != nil {
-		return fmt.Errorf("failed to start git server in directory %q: %w", gitRoot, err)
+	if config.DryRun {
+		printPlan(config, session, containerWorkingDir, w)
+		return 0, nil
 	}
-	cleanup.Add("git-server", remote.Close)
 
 	// Create runtime based on config
 	var rt runtime.Runtime
@@ -96,26 +158,115 @@ func run(args, env []string) error {
 	case "docker":
 		dockerClient, err := docker.NewDefaultClient()
 		if err != nil {
-			return fmt.Errorf("failed to create docker client: %w\nMake sure Docker is installed and running (try 'docker ps')", err)
+			return 0, fmt.Errorf("failed to create docker client: %w\nMake sure Docker is installed and running (try 'docker ps')", err)
 		}
 		rt = dockerClient
 	default:
-		return fmt.Errorf("unknown runtime: %q\nSupported runtimes: docker, apple", config.Runtime)
+		return 0, fmt.Errorf("unknown runtime: %q\nSupported runtimes: docker, apple", config.Runtime)
 	}
 	cleanup.Add("runtime", rt.Close)
 
-	// Validate that Dockerfile path is provided
-	if config.DockerfilePath == "" {
-		return fmt.Errorf("dockerfile path is required but not specified\n" +
-			"Specify it using:\n" +
-			"  - CLI flag: --dockerfile ./Dockerfile\n" +
-			"  - Config file: Add 'dockerfile: ./Dockerfile' to .contagent.yaml\n" +
-			"See .contagent.example.yaml for more details")
+	// Check that the daemon is reachable before any work, so that the failure is
+	// reported up front rather than in the middle of the image build.
+	if dockerClient, ok := rt.(docker.Client); ok {
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		err := dockerClient.Healthcheck(ctx)
+		cancel()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	// Containers of earlier runs that were killed before they could clean up are
+	// found by their session label.
+	if config.Reap {
+		dockerClient, ok := rt.(docker.Client)
+		if !ok {
+			w.Warningf("--reap is not supported by the %s runtime, skipping", config.Runtime)
+		} else if err := dockerClient.PruneOrphans(ctx, session.ID(), w); err != nil {
+			return 0, fmt.Errorf("failed to remove orphaned containers: %w", err)
+		}
+	}
+
+	// Docker fails to create a container on a network that does not exist, so a
+	// named network is created for the session and removed again afterwards.
+	if dockerClient, ok := rt.(docker.Client); ok {
+		created, err := dockerClient.EnsureNetwork(ctx, config.Network)
+		if err != nil {
+			return 0, fmt.Errorf("failed to prepare network %q: %w", config.Network, err)
+		}
+		if created {
+			w.Printf("Created network %s\n", config.Network)
+			cleanup.AddIndependent("network", func() error {
+				ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+				defer cancel()
+				return dockerClient.RemoveNetwork(ctx, config.Network)
+			})
+		}
+	}
+
+	// Without .git in the container there is nothing to push, so no server is needed.
+	// It is started after the runtime so that, being independent of the resources
+	// removed after the container, it shuts down alongside them.
+	var remote git.Server
+	if !config.NoGit {
+		options, err := gitServerOptions(config)
+		if err != nil {
+			return 0, err
+		}
+		options.LogPrefix = git.SessionLogPrefix(session.ID())
+
+		remote, err = git.NewServer(ctx, gitRoot, options, w)
+		if err != nil {
+			return 0, fmt.Errorf("failed to start git server in directory %q: %w", gitRoot, err)
+		}
+		cleanup.AddIndependent("git-server", func() error {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			err := remote.Shutdown(ctx)
+
+			stats := remote.Stats()
+			w.Debugf("Git server handled %d requests: %d fetches, %d pushes, %d errors", stats.Requests, stats.Fetches, stats.Pushes, stats.Errors)
+			return err
+		})
+
+		// Confirm the server is up before the container is pointed at it
+		err = func() error {
+			ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+			defer cancel()
+			return remote.WaitReady(ctx)
+		}()
+		if err != nil {
+			return 0, err
+		}
 	}
 
-	image, err := rt.BuildImage(ctx, config.DockerfilePath, config.ImageName, w)
+	dockerfilePath, err := resolveDockerfile(cleanup, config, w)
 	if err != nil {
-		return fmt.Errorf("failed to build image %q from %q: %w", config.ImageName, config.DockerfilePath, err)
+		return 0, err
+	}
+
+	image, err := rt.BuildImage(ctx, runtime.BuildImageOptions{
+		DockerfilePath:   dockerfilePath,
+		ContextDir:       config.BuildContext,
+		Target:           config.BuildTarget,
+		ImageName:        config.ImageName,
+		BuildArgs:        config.BuildArgs,
+		Labels:           imageLabels(config, gitRoot, session.ID(), w),
+		ForceRebuild:     config.ForceRebuild,
+		NoCache:          config.NoCache,
+		KeepIntermediate: config.KeepIntermediate,
+		PullPolicy:       config.PullPolicy,
+		Progress:         config.Progress,
+		DockerConfig:     config.DockerConfig,
+		Secrets:          config.Secrets,
+		Platform:         config.Platform,
+	}, w)
+	if err != nil {
+		return 0, fmt.Errorf("failed to build image %q from %q: %w", config.ImageName, dockerfilePath, err)
+	}
+	if config.RmImage {
+		addImageCleanup(cleanup, rt, image, config, w)
 	}
 
 	container, err := rt.CreateContainer(
@@ -124,67 +275,431 @@ func run(args, env []string) error {
 			SessionID:   session.ID(),
 			Image:       image,
 			Args:        config.Args,
+			Entrypoint:  config.Entrypoint,
 			Env:         config.Env,
 			Volumes:     config.Volumes,
 			WorkingDir:  containerWorkingDir,
+			User:        config.User,
 			Network:     config.Network,
+			ExtraHosts:  config.ExtraHosts,
+			NoGateway:   config.NoGateway,
+			Labels:      config.Labels,
+			Platform:    config.Platform,
+			ReadOnly:    config.ReadOnly,
+			Tmpfs:       config.Tmpfs,
+			Ports:       config.Ports,
+			TTY:         !config.NoTTY && !config.NoAttach && term.IsTerminal(os.Stdin.Fd()),
+			NoStdin:     config.NoAttach,
+			Memory:      config.Memory,
+			CPUs:        config.CPUs,
 			StopTimeout: config.StopTimeout,
 			TTYRetries:  config.TTYRetries,
 			RetryDelay:  config.RetryDelay,
+			TTYResync:   config.TTYResync,
+			Timeout:     config.Timeout,
+			Restart:     config.Restart,
+			Keep:        config.Keep,
 		},
 	)
 	if err != nil {
-		return fmt.Errorf("failed to create container %q from image %q: %w", session.ID(), image.Name, err)
+		return 0, fmt.Errorf("failed to create container %q from image %q: %w", session.ID(), image.Name, err)
 	}
-	cleanup.Add("container", func() error {
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
-		return container.ForceRemove(ctx)
-	})
+	addContainerCleanup(cleanup, container, session.ID(), config, w)
 
-	imageUser, err := container.InspectUser(ctx)
+	owner, err := archiveOwner(ctx, container, config)
 	if err != nil {
-		return fmt.Errorf("failed to inspect user for image %q: %w", image.Name, err)
+		return 0, fmt.Errorf("failed to inspect user for image %q: %w", image.Name, err)
 	}
 
 	// DestDir and CopyTo work in tandem: DestDir is the final path component of
 	// config.WorkingDir, and CopyTo receives its parent. Together they cause the
 	// archive to be extracted at exactly config.WorkingDir in the container.
 	// Both must remain derived from the same config.WorkingDir value.
+	//
+	// Docker only copies into volumes when the root filesystem is read only, so
+	// the archive is then extracted into the working directory's volume itself.
+	//
+	// The Docker daemon decompresses archives itself, so the archive is gzipped
+	// for it. Apple Container extracts with the image's tar, which may not
+	// support compressed input.
+	destDir, copyDir := filepath.Base(config.WorkingDir), filepath.Dir(config.WorkingDir)
+	if config.ReadOnly {
+		destDir, copyDir = "", config.WorkingDir
+	}
+
+	var remoteURL string
+	if !config.NoGit {
+		remoteURL = remote.URL(gitHost(rt.HostAddress(), config))
+	}
+
 	archive, err := git.CreateArchive(git.ArchiveOptions{
-		Path:         gitRoot,
-		Remote:       fmt.Sprintf("http://%s:%d", rt.HostAddress(), remote.Port()),
-		Branch:       session.Branch(),
-		GitUserName:  config.GitUser.Name,
-		GitUserEmail: config.GitUser.Email,
-		UID:          imageUser.UID,
-		GID:          imageUser.GID,
-		DestDir:      filepath.Base(config.WorkingDir),
+		Path:              gitRoot,
+		Ref:               config.Ref,
+		Remote:            remoteURL,
+		Branch:            session.Branch(),
+		SessionID:         session.ID(),
+		Cleanup:           cleanup,
+		GitUserName:       config.GitUser.Name,
+		GitUserEmail:      config.GitUser.Email,
+		UID:               owner.UID,
+		GID:               owner.GID,
+		DestDir:           destDir,
+		Gzip:              config.Runtime == "docker",
+		IncludeSymlinks:   config.Symlinks,
+		IncludeSubmodules: config.Submodules,
+		Stream:            config.Stream,
+		NoGit:             config.NoGit,
+		MaxSize:           config.MaxArchiveSize,
+		ResetBranch:       config.ResetBranch,
+		InsecureRemote:    config.GitTLS && config.GitTLSCert == "",
 	}, w)
 	if err != nil {
-		return fmt.Errorf("failed to create git archive from %q on branch %q: %w", workingDirectory, session.Branch(), err)
+		return 0, fmt.Errorf("failed to create git archive from %q on branch %q: %w", workingDirectory, session.Branch(), err)
 	}
 	cleanup.Add("archive", archive.Close)
 
-	err = container.CopyTo(ctx, archive, filepath.Dir(config.WorkingDir))
+	err = container.CopyTo(ctx, archive, copyDir)
 	if err != nil {
-		return fmt.Errorf("failed to copy git archive to container %q: %w", session.ID(), err)
+		return 0, fmt.Errorf("failed to copy git archive to container %q: %w", session.ID(), err)
 	}
 
 	err = container.Start(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to start container %q: %w", session.ID(), err)
+		return 0, fmt.Errorf("failed to start container %q: %w", session.ID(), err)
+	}
+
+	attachment, err := attachContainer(ctx, cancel, container, config, w)
+	if err != nil {
+		return 0, fmt.Errorf("failed to attach to container %q: %w\nThis may indicate a TTY configuration issue", session.ID(), err)
+	}
+
+	code, err := container.Wait(ctx, w)
+
+	// The container's last output may still be on its way to the terminal, which
+	// stays in raw mode until it has been written
+	func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := attachment.Wait(ctx); err != nil {
+			w.Warningf("container output may be incomplete: %v", err)
+		}
+	}()
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to wait for container %q: %w", session.ID(), err)
+	}
+
+	if config.AutoPush && code == 0 {
+		err = fetchBranch(ctx, container, gitRoot, filepath.Join(config.WorkingDir, ".git"), session.Branch(), w)
+		if err != nil {
+			return 0, fmt.Errorf("failed to fetch branch %q from container %q: %w", session.Branch(), session.ID(), err)
+		}
+	}
+
+	return code, nil
+}
+
+// logStreamer is implemented by containers whose output can be streamed from their logs.
+type logStreamer interface {
+	Logs(ctx context.Context, w internal.Writer, follow bool) error
+}
+
+// attachContainer attaches the terminal to the container. With config.NoAttach, the
+// container is not attached to; its output is streamed from the logs instead where the
+// runtime supports it, and the returned Attachment finishes once the log stream ends.
+func attachContainer(ctx context.Context, cancel context.CancelFunc, container runtime.Container, config internal.Config, w internal.Writer) (runtime.Attachment, error) {
+	if !config.NoAttach {
+		return container.Attach(ctx, cancel, w)
+	}
+
+	done := make(chan struct{})
+	streamer, ok := container.(logStreamer)
+	if !ok {
+		close(done)
+		return runtime.NewAttachment(done, nil), nil
+	}
+
+	go func() {
+		defer close(done)
+		if err := streamer.Logs(ctx, w, true); err != nil && ctx.Err() == nil {
+			w.Warningf("failed to stream container output: %v", err)
+		}
+	}()
+
+	return runtime.NewAttachment(done, nil), nil
+}
+
+// resolveDockerfile returns the path of the Dockerfile to build the image from. Without a
+// configured Dockerfile, the embedded default is written to a temporary directory that
+// cleanup removes.
+func resolveDockerfile(cleanup *internal.CleanupManager, config internal.Config, w internal.Writer) (string, error) {
+	if config.DockerfilePath != "" {
+		return config.DockerfilePath, nil
+	}
+
+	path, remove, err := internal.WriteDefaultDockerfile()
+	if err != nil {
+		return "", err
+	}
+	cleanup.AddIndependent("default-dockerfile", remove)
+
+	w.Println("No Dockerfile configured, building the default image (set one with --dockerfile)")
+	return path, nil
+}
+
+// resolveContainerWorkingDir returns where the container starts. When running from a
+// subdirectory, it is config.WorkingDir + relPath. The archive always extracts to
+// config.WorkingDir so the git root path in the container remains stable regardless
+// of where contagent was invoked.
+//
+// A subdirectory that is not part of the archived commit, such as an untracked
+// directory or one missing at config.Ref, would be an empty directory in the
+// container, so a warning is printed and the container starts at config.WorkingDir
+// instead. When the ref cannot be resolved the subdirectory is kept, leaving the
+// archive to report the error.
+func resolveContainerWorkingDir(config internal.Config, gitRoot, relPath string, w internal.Writer) string {
+	if relPath == "." {
+		return config.WorkingDir
+	}
+
+	found, err := git.HasDirectory(gitRoot, config.Ref, relPath)
+	if err == nil && !found {
+		w.Warningf("%s is not part of the archived commit, so the container starts in %s instead of an empty directory", relPath, config.WorkingDir)
+		return config.WorkingDir
+	}
+
+	return filepath.Join(config.WorkingDir, relPath)
+}
+
+// addContainerCleanup registers the removal of container with cleanup. With --keep, the
+// container is left in place and cleanup prints how to inspect and remove it instead.
+func addContainerCleanup(cleanup *internal.CleanupManager, container runtime.Container, name internal.SessionID, config internal.Config, w internal.Writer) {
+	if config.Keep {
+		shell, remove := "docker exec -it %s /bin/sh", "docker rm -f %s"
+		if config.Runtime == "apple" {
+			shell, remove = "container exec --tty --interactive %s /bin/sh", "container delete --force %s"
+		}
+
+		cleanup.Add("container", func() error {
+			w.Printf("Kept container %s for inspection\n", name)
+			w.Printf("  Open a shell: "+shell+"\n", name)
+			w.Printf("  Remove it:    "+remove+"\n", name)
+			return nil
+		})
+		return
+	}
+
+	cleanup.Add("container", func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		return container.ForceRemove(ctx)
+	})
+}
+
+// imageLabels returns the labels for the built image: the configured labels plus the
+// session label and, when the ref resolves, the commit the session starts from.
+func imageLabels(config internal.Config, gitRoot string, id internal.SessionID, w internal.Writer) map[string]string {
+	labels := make(map[string]string, len(config.ImageLabels)+2)
+	for key, value := range config.ImageLabels {
+		labels[key] = value
+	}
+	labels[internal.SessionLabel] = string(id)
+
+	// An unresolvable ref fails the archive with a clearer error later on
+	commit, err := git.ResolveCommit(gitRoot, config.Ref)
+	if err != nil {
+		w.Debugf("Not labeling the image with its commit: %v", err)
+		return labels
+	}
+	labels[internal.CommitLabel] = commit
+
+	return labels
+}
+
+// addImageCleanup registers the removal of image with cleanup. It is registered before
+// the container, so that it runs once the container has been removed. An image that
+// other containers use is left in place.
+func addImageCleanup(cleanup *internal.CleanupManager, rt runtime.Runtime, image runtime.Image, config internal.Config, w internal.Writer) {
+	dockerClient, ok := rt.(docker.Client)
+	if !ok {
+		w.Warningf("--rm-image is not supported by the %s runtime, skipping", config.Runtime)
+		return
 	}
 
-	err = container.Attach(ctx, cancel, w)
+	cleanup.AddIndependent("image", func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		err := dockerClient.RemoveImage(ctx, image)
+		if errors.Is(err, docker.ErrImageInUse) {
+			w.Printf("Kept image %s, as other containers use it\n", image.Name)
+			return nil
+		}
+		return err
+	})
+}
+
+// fetchBranch copies the repository at gitDir out of the container and fetches branch
+// from it into the host repository at gitRoot.
+func fetchBranch(ctx context.Context, container runtime.Container, gitRoot, gitDir, branch string, w internal.Writer) error {
+	content, err := container.CopyFrom(ctx, gitDir)
 	if err != nil {
-		return fmt.Errorf("failed to attach to container %q: %w\nThis may indicate a TTY configuration issue", session.ID(), err)
+		return err
 	}
+	defer content.Close()
 
-	err = container.Wait(ctx, w)
+	fetched, err := git.ImportBranch(gitRoot, content, branch)
 	if err != nil {
-		return fmt.Errorf("failed to wait for container %q: %w", session.ID(), err)
+		return err
+	}
+
+	if fetched {
+		w.Printf("Fetched branch %s into %s\n", branch, gitRoot)
+	} else {
+		w.Printf("No new commits on branch %s\n", branch)
 	}
 
 	return nil
 }
+
+// archiveOwner returns the owner of the repository files copied into the container:
+// the --chown override when set, and the user the container runs as otherwise.
+func archiveOwner(ctx context.Context, container runtime.Container, config internal.Config) (runtime.ImageUser, error) {
+	if config.Chown != nil {
+		return runtime.ImageUser{UID: config.Chown.UID, GID: config.Chown.GID}, nil
+	}
+	return container.InspectUser(ctx)
+}
+
+// gitHost returns the hostname the container uses to reach the git server. This is
+// address, the runtime's host address, unless the automatic gateway alias is disabled
+// and the address is not mapped explicitly, in which case the first alias for
+// host-gateway is used instead.
+func gitHost(address string, config internal.Config) string {
+	if !config.NoGateway {
+		return address
+	}
+
+	alias := ""
+	for _, host := range config.ExtraHosts {
+		name, ip, _ := strings.Cut(host, ":")
+		if name == address {
+			return address
+		}
+		if ip == "host-gateway" && alias == "" {
+			alias = name
+		}
+	}
+	if alias == "" {
+		return address
+	}
+
+	return alias
+}
+
+// printPlan describes what a run with config would do, without contacting the container
+// runtime or starting the git server. Only the names of environment variables are shown,
+// as their values may be secrets, and they are sorted so the plan is stable.
+func printPlan(config internal.Config, session internal.Session, workingDir string, w internal.Writer) {
+	address := docker.HostAlias
+	if config.Runtime == "apple" {
+		address = apple.HostAlias
+	}
+
+	var names []string
+	for _, variable := range config.Env {
+		name, _, _ := strings.Cut(variable, "=")
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	field := func(name string, values ...string) {
+		if len(values) == 0 || (len(values) == 1 && values[0] == "") {
+			values = []string{"(none)"}
+		}
+		w.Printf("  %-12s %s\n", name+":", values[0])
+		for _, value := range values[1:] {
+			w.Printf("  %-12s %s\n", "", value)
+		}
+	}
+
+	w.Println("Dry run, nothing will be built or started:")
+	field("Runtime", config.Runtime)
+	field("Image", string(config.ImageName))
+	field("Dockerfile", cmp.Or(config.DockerfilePath, "(default)"))
+	field("Command", strings.Join(config.Args, " "))
+	field("Working dir", workingDir)
+	field("Environment", names...)
+	field("Volumes", config.Volumes...)
+	field("Network", config.Network)
+	if config.NoGit {
+		field("Git remote", "(none, --no-git)")
+		field("Branch", "(none, --no-git)")
+	} else {
+		scheme := "http"
+		if config.GitTLS {
+			scheme = "https"
+		}
+		remote := fmt.Sprintf("%s://%s/.git", scheme, net.JoinHostPort(gitHost(address, config), "<port>"))
+		if config.GitReadOnly {
+			remote += " (read-only)"
+		}
+		field("Git remote", remote)
+		field("Branch", session.Branch())
+	}
+}
+
+// gitServerOptions returns the options of the git server, which requires credentials,
+// rejects pushes with --git-read-only, and, with --git-tls, serves HTTPS with the given
+// certificate or a self-signed one.
+func gitServerOptions(config internal.Config) (git.ServerOptions, error) {
+	options := git.ServerOptions{Address: config.GitServerAddr, Authenticate: true, ReadOnly: config.GitReadOnly}
+	if !config.GitTLS {
+		return options, nil
+	}
+
+	var certificate tls.Certificate
+	var err error
+	if config.GitTLSCert != "" {
+		certificate, err = git.LoadCertificate(config.GitTLSCert, config.GitTLSKey)
+	} else {
+		certificate, err = git.GenerateCertificate(cmp.Or(config.GitServerAddr, git.DefaultServerAddress), "localhost")
+	}
+	if err != nil {
+		return git.ServerOptions{}, err
+	}
+
+	options.Certificate = &certificate
+	return options, nil
+}
+
+// openLogFile creates the log file at path, along with its directory, for appending.
+// The file is only readable by the current user, as the output may be sensitive.
+func openLogFile(path string) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create log directory for %q: %w", path, err)
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600) //nolint:gosec // G304: the path is chosen by the user
+	if err != nil {
+		return nil, fmt.Errorf("failed to open log file %q: %w\nCheck that the path is writable", path, err)
+	}
+
+	return file, nil
+}
+
+// newWriter returns the Writer for the configured log format and level, writing
+// to stdout and stderr. JSON entries always carry a time field, so timestamps only
+// apply to text output.
+func newWriter(config internal.Config, stdout, stderr io.Writer) internal.Writer {
+	if config.LogFormat == internal.LogFormatJSON {
+		w := internal.NewJSONWriter(stdout)
+		w.SetLevel(config.LogLevel)
+		return w
+	}
+
+	w := internal.NewCustomWriter(stdout, stderr)
+	w.SetLevel(config.LogLevel)
+	w.SetTimestamps(config.Timestamps)
+	return w
+}
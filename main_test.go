@@ -0,0 +1,448 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ryanmoran/contagent/internal"
+	"github.com/ryanmoran/contagent/internal/git"
+	"github.com/ryanmoran/contagent/internal/runtime"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunDryRun(t *testing.T) {
+	dir := t.TempDir()
+	output, err := exec.Command("git", "init", dir).CombinedOutput()
+	require.NoError(t, err, string(output))
+	t.Chdir(dir)
+
+	// The plan must not need a Docker daemon
+	t.Setenv("DOCKER_HOST", "unix:///nonexistent/docker.sock")
+
+	var stdout, stderr bytes.Buffer
+	code, err := run([]string{
+		"contagent",
+		"--dry-run",
+		"--runtime", "docker",
+		"--dockerfile", "./Dockerfile",
+		"--env", "SECRET=hunter2",
+		"--env", "API_URL=https://example.com",
+		"--volume", "/data:/data",
+		"--network", "my-network",
+		"some-program", "--flag",
+	}, []string{"HOME=" + dir, "TERM=some-term"}, &stdout, &stderr)
+	require.NoError(t, err)
+	require.Equal(t, 0, code)
+	require.Empty(t, stderr.String())
+
+	lines := strings.Split(strings.TrimSuffix(stdout.String(), "\n"), "\n")
+	require.Len(t, lines, 17)
+	require.Equal(t, []string{
+		"Dry run, nothing will be built or started:",
+		"  Runtime:     docker",
+		"  Image:       contagent:latest",
+		"  Dockerfile:  ./Dockerfile",
+		"  Command:     some-program --flag",
+		"  Working dir: /app",
+		"  Environment: API_URL",
+		"               COLORTERM",
+		"               SECRET",
+		"               SSH_AUTH_SOCK",
+		"               TERM",
+		"  Volumes:     /var/run/docker.sock:/var/run/docker.sock",
+		"               /run/host-services/ssh-auth.sock:/run/host-services/ssh-auth.sock",
+		"               /data:/data",
+		"  Network:     my-network",
+		"  Git remote:  http://host.docker.internal:<port>/.git",
+	}, lines[:16])
+	require.Regexp(t, `^  Branch:      contagent/[0-9a-f]{8}$`, lines[16])
+	require.NotContains(t, stdout.String(), "hunter2")
+}
+
+func TestRunDryRunNoGit(t *testing.T) {
+	dir := t.TempDir()
+	output, err := exec.Command("git", "init", dir).CombinedOutput()
+	require.NoError(t, err, string(output))
+	t.Chdir(dir)
+
+	var stdout, stderr bytes.Buffer
+	code, err := run([]string{
+		"contagent",
+		"--dry-run",
+		"--runtime", "docker",
+		"--no-git",
+		"some-program",
+	}, []string{"HOME=" + dir, "TERM=some-term"}, &stdout, &stderr)
+	require.NoError(t, err)
+	require.Equal(t, 0, code)
+
+	require.Contains(t, stdout.String(), "  Git remote:  (none, --no-git)\n")
+	require.Contains(t, stdout.String(), "  Branch:      (none, --no-git)\n")
+}
+
+func TestRunPrintConfig(t *testing.T) {
+	// The configuration can be printed outside of a git repository
+	dir := t.TempDir()
+	t.Chdir(dir)
+
+	var stdout, stderr bytes.Buffer
+	code, err := run([]string{
+		"contagent",
+		"--print-config",
+		"--runtime", "docker",
+		"--memory", "1g",
+		"some-program",
+	}, []string{"HOME=" + dir, "TERM=some-term", "ANTHROPIC_API_KEY=sk-ant-secret"}, &stdout, &stderr)
+	require.NoError(t, err)
+	require.Equal(t, 0, code)
+	require.Empty(t, stderr.String())
+
+	var config internal.Config
+	require.NoError(t, json.Unmarshal(stdout.Bytes(), &config))
+	require.Equal(t, "docker", config.Runtime)
+	require.Equal(t, int64(1<<30), config.Memory)
+	require.Contains(t, config.Env, "ANTHROPIC_API_KEY=<redacted>")
+	require.NotContains(t, stdout.String(), "sk-ant-secret")
+}
+
+func TestRunLogFile(t *testing.T) {
+	dir := t.TempDir()
+	output, err := exec.Command("git", "init", dir).CombinedOutput()
+	require.NoError(t, err, string(output))
+	t.Chdir(dir)
+
+	logs := filepath.Join(t.TempDir(), "logs")
+
+	var stdout, stderr bytes.Buffer
+	code, err := run([]string{
+		"contagent",
+		"--dry-run",
+		"--runtime", "docker",
+		"--log-file", filepath.Join(logs, "{session}.log"),
+		"some-program",
+	}, []string{"HOME=" + dir, "TERM=some-term"}, &stdout, &stderr)
+	require.NoError(t, err)
+	require.Equal(t, 0, code)
+
+	paths, err := filepath.Glob(filepath.Join(logs, "contagent-*.log"))
+	require.NoError(t, err)
+	require.Len(t, paths, 1)
+
+	content, err := os.ReadFile(paths[0])
+	require.NoError(t, err)
+	require.Equal(t, stdout.String(), string(content))
+	require.Contains(t, string(content), "Dry run, nothing will be built or started:")
+	require.Contains(t, string(content), "  Command:     some-program")
+}
+
+func TestRunDefaultLogFile(t *testing.T) {
+	dir := t.TempDir()
+	output, err := exec.Command("git", "init", dir).CombinedOutput()
+	require.NoError(t, err, string(output))
+	t.Chdir(dir)
+
+	home := t.TempDir()
+
+	var stdout, stderr bytes.Buffer
+	code, err := run([]string{
+		"contagent",
+		"--dry-run",
+		"--runtime", "docker",
+		"some-program",
+	}, []string{"HOME=" + home, "TERM=some-term"}, &stdout, &stderr)
+	require.NoError(t, err)
+	require.Equal(t, 0, code)
+
+	paths, err := filepath.Glob(filepath.Join(home, ".contagent", "logs", "contagent-*.log"))
+	require.NoError(t, err)
+	require.Len(t, paths, 1)
+
+	content, err := os.ReadFile(paths[0])
+	require.NoError(t, err)
+	require.Equal(t, stdout.String(), string(content))
+}
+
+// removableContainer is a runtime.Container that records whether it was removed.
+type removableContainer struct {
+	runtime.Container
+	removed bool
+}
+
+func (c *removableContainer) ForceRemove(ctx context.Context) error {
+	c.removed = true
+	return nil
+}
+
+func TestAddContainerCleanup(t *testing.T) {
+	t.Run("removes the container", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		container := &removableContainer{}
+
+		cleanup := internal.NewCleanupManager()
+		addContainerCleanup(cleanup, container, "contagent-1234abcd", internal.Config{Runtime: "docker"}, newWriter(internal.Config{}, &stdout, &stderr))
+		cleanup.Execute()
+
+		require.True(t, container.removed)
+		require.Empty(t, stdout.String())
+	})
+
+	t.Run("keeps the container with --keep", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		container := &removableContainer{}
+
+		cleanup := internal.NewCleanupManager()
+		addContainerCleanup(cleanup, container, "contagent-1234abcd", internal.Config{Runtime: "docker", Keep: true}, newWriter(internal.Config{}, &stdout, &stderr))
+		cleanup.Execute()
+
+		require.False(t, container.removed)
+		require.Equal(t, strings.Join([]string{
+			"Kept container contagent-1234abcd for inspection",
+			"  Open a shell: docker exec -it contagent-1234abcd /bin/sh",
+			"  Remove it:    docker rm -f contagent-1234abcd",
+			"",
+		}, "\n"), stdout.String())
+	})
+
+	t.Run("prints Apple Container commands for the apple runtime", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		container := &removableContainer{}
+
+		cleanup := internal.NewCleanupManager()
+		addContainerCleanup(cleanup, container, "contagent-1234abcd", internal.Config{Runtime: "apple", Keep: true}, newWriter(internal.Config{}, &stdout, &stderr))
+		cleanup.Execute()
+
+		require.False(t, container.removed)
+		require.Contains(t, stdout.String(), "  Open a shell: container exec --tty --interactive contagent-1234abcd /bin/sh\n")
+		require.Contains(t, stdout.String(), "  Remove it:    container delete --force contagent-1234abcd\n")
+	})
+}
+
+func TestResolveDockerfile(t *testing.T) {
+	t.Run("builds from the embedded Dockerfile when none is configured", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		cleanup := internal.NewCleanupManager()
+
+		path, err := resolveDockerfile(cleanup, internal.Config{}, newWriter(internal.Config{}, &stdout, &stderr))
+		require.NoError(t, err)
+
+		content, err := os.ReadFile(path)
+		require.NoError(t, err)
+		require.Equal(t, internal.DefaultDockerfile, content)
+		require.Contains(t, string(content), "FROM ")
+		require.Contains(t, stdout.String(), "No Dockerfile configured, building the default image")
+
+		cleanup.Execute()
+		_, err = os.Stat(filepath.Dir(path))
+		require.ErrorIs(t, err, os.ErrNotExist, "the default Dockerfile should be removed")
+	})
+
+	t.Run("uses the configured Dockerfile", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+
+		path, err := resolveDockerfile(internal.NewCleanupManager(), internal.Config{DockerfilePath: "./Dockerfile"}, newWriter(internal.Config{}, &stdout, &stderr))
+		require.NoError(t, err)
+		require.Equal(t, "./Dockerfile", path)
+		require.Empty(t, stdout.String())
+	})
+}
+
+func TestResolveContainerWorkingDir(t *testing.T) {
+	dir := t.TempDir()
+	git := func(args ...string) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=Test User",
+			"GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=Test User",
+			"GIT_COMMITTER_EMAIL=test@example.com",
+		)
+		output, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(output))
+	}
+	git("init")
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "src"), 0700))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "src", "main.go"), []byte("package main\n"), 0600))
+	git("add", ".")
+	git("commit", "-m", "initial")
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "scratch"), 0700))
+
+	config := internal.Config{WorkingDir: "/workspace"}
+
+	t.Run("starts in the working directory from the repository root", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+
+		workingDir := resolveContainerWorkingDir(config, dir, ".", newWriter(config, &stdout, &stderr))
+		require.Equal(t, "/workspace", workingDir)
+		require.Empty(t, stderr.String())
+	})
+
+	t.Run("starts in the matching subdirectory when it is archived", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+
+		workingDir := resolveContainerWorkingDir(config, dir, "src", newWriter(config, &stdout, &stderr))
+		require.Equal(t, "/workspace/src", workingDir)
+		require.Empty(t, stderr.String())
+	})
+
+	t.Run("warns and starts in the working directory when the subdirectory is not archived", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+
+		workingDir := resolveContainerWorkingDir(config, dir, "scratch", newWriter(config, &stdout, &stderr))
+		require.Equal(t, "/workspace", workingDir)
+		require.Contains(t, stderr.String(), "Warning: scratch is not part of the archived commit, so the container starts in /workspace")
+	})
+}
+
+func TestImageLabels(t *testing.T) {
+	dir := t.TempDir()
+	run := func(args ...string) string {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=Test User",
+			"GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=Test User",
+			"GIT_COMMITTER_EMAIL=test@example.com",
+		)
+		output, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(output))
+		return strings.TrimSpace(string(output))
+	}
+	run("init")
+	run("commit", "--allow-empty", "-m", "initial")
+	commit := run("rev-parse", "HEAD")
+
+	t.Run("labels the image with the session, its commit, and the configured labels", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		config := internal.Config{ImageLabels: map[string]string{"team": "platform"}}
+
+		labels := imageLabels(config, dir, "contagent-test", newWriter(config, &stdout, &stderr))
+		require.Equal(t, map[string]string{
+			"team":              "platform",
+			"contagent.session": "contagent-test",
+			"contagent.commit":  commit,
+		}, labels)
+	})
+
+	t.Run("leaves out the commit when the ref does not resolve", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		config := internal.Config{Ref: "no-such-ref"}
+
+		labels := imageLabels(config, dir, "contagent-test", newWriter(config, &stdout, &stderr))
+		require.Equal(t, map[string]string{"contagent.session": "contagent-test"}, labels)
+	})
+}
+
+// attachableContainer is a runtime.Container that records whether it was attached to and
+// writes logs when they are streamed.
+type attachableContainer struct {
+	runtime.Container
+	attached bool
+	logs     string
+	follow   bool
+}
+
+func (c *attachableContainer) Attach(ctx context.Context, cancel context.CancelFunc, w internal.Writer) (runtime.Attachment, error) {
+	c.attached = true
+	done := make(chan struct{})
+	close(done)
+	return runtime.NewAttachment(done, nil), nil
+}
+
+func (c *attachableContainer) Logs(ctx context.Context, w internal.Writer, follow bool) error {
+	c.follow = follow
+	w.Print(c.logs)
+	return nil
+}
+
+func TestAttachContainer(t *testing.T) {
+	t.Run("attaches to the container", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		container := &attachableContainer{logs: "some output\n"}
+
+		attachment, err := attachContainer(context.Background(), func() {}, container, internal.Config{}, newWriter(internal.Config{}, &stdout, &stderr))
+		require.NoError(t, err)
+		require.NoError(t, attachment.Wait(context.Background()))
+
+		require.True(t, container.attached)
+		require.Empty(t, stdout.String())
+	})
+
+	t.Run("streams the logs instead of attaching with --attach=false", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		container := &attachableContainer{logs: "some output\n"}
+		config := internal.Config{NoAttach: true}
+
+		attachment, err := attachContainer(context.Background(), func() {}, container, config, newWriter(config, &stdout, &stderr))
+		require.NoError(t, err)
+		require.NoError(t, attachment.Wait(context.Background()))
+
+		require.False(t, container.attached)
+		require.True(t, container.follow)
+		require.Equal(t, "some output\n", stdout.String())
+	})
+}
+
+// userContainer is a runtime.Container whose user is known.
+type userContainer struct {
+	runtime.Container
+	user runtime.ImageUser
+}
+
+func (c userContainer) InspectUser(ctx context.Context) (runtime.ImageUser, error) {
+	return c.user, nil
+}
+
+func TestArchiveOwner(t *testing.T) {
+	container := userContainer{user: runtime.ImageUser{UID: 1001, GID: 1002}}
+
+	t.Run("uses the container user", func(t *testing.T) {
+		owner, err := archiveOwner(context.Background(), container, internal.Config{})
+		require.NoError(t, err)
+		require.Equal(t, runtime.ImageUser{UID: 1001, GID: 1002}, owner)
+	})
+
+	t.Run("uses the --chown override", func(t *testing.T) {
+		owner, err := archiveOwner(context.Background(), container, internal.Config{Chown: &internal.Ownership{UID: 0, GID: 500}})
+		require.NoError(t, err)
+		require.Equal(t, runtime.ImageUser{UID: 0, GID: 500}, owner)
+	})
+}
+
+func TestGitServerOptions(t *testing.T) {
+	t.Run("serves HTTP without --git-tls", func(t *testing.T) {
+		options, err := gitServerOptions(internal.Config{GitServerAddr: "172.17.0.1"})
+		require.NoError(t, err)
+		require.Equal(t, git.ServerOptions{Address: "172.17.0.1", Authenticate: true}, options)
+	})
+
+	t.Run("rejects pushes with --git-read-only", func(t *testing.T) {
+		options, err := gitServerOptions(internal.Config{GitReadOnly: true})
+		require.NoError(t, err)
+		require.True(t, options.ReadOnly)
+	})
+
+	t.Run("serves HTTPS with a self-signed certificate", func(t *testing.T) {
+		options, err := gitServerOptions(internal.Config{GitTLS: true})
+		require.NoError(t, err)
+		require.True(t, options.Authenticate)
+		require.NotNil(t, options.Certificate)
+	})
+
+	t.Run("returns an error when the certificate cannot be loaded", func(t *testing.T) {
+		dir := t.TempDir()
+		_, err := gitServerOptions(internal.Config{
+			GitTLS:     true,
+			GitTLSCert: filepath.Join(dir, "server.crt"),
+			GitTLSKey:  filepath.Join(dir, "server.key"),
+		})
+		require.ErrorContains(t, err, "failed to load TLS certificate")
+	})
+}